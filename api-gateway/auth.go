@@ -0,0 +1,293 @@
+package main
+
+import (
+	"HL_online_shop/common/access"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+var (
+	ErrMissingToken = errors.New("missing bearer token")
+	ErrInvalidToken = errors.New("invalid token")
+)
+
+// AuthConfig holds the keys tokens are verified with. A token signed with
+// HS256 is checked against HS256Secret and one signed with RS256 against
+// RS256Key; an algorithm without a key is rejected.
+type AuthConfig struct {
+	HS256Secret []byte
+	RS256Key    *rsa.PublicKey
+	// Audience must be one of the aud values of a token.
+	Audience string
+	// Issuer, if set, must match the iss claim.
+	Issuer string
+	// Leeway allows for clock skew when checking exp and nbf.
+	Leeway time.Duration
+	// InternalToken is sent to the backend services, which refuse requests
+	// without it.
+	InternalToken []byte
+}
+
+var authConfig *AuthConfig
+
+// loadAuthConfig reads the JWT settings and the internal token from the
+// environment. At least one key must be configured.
+func loadAuthConfig() (*AuthConfig, error) {
+	cfg := &AuthConfig{
+		HS256Secret: []byte(os.Getenv("JWT_HS256_SECRET")),
+		Audience:    os.Getenv("JWT_AUDIENCE"),
+		Issuer:      os.Getenv("JWT_ISSUER"),
+		Leeway:      30 * time.Second,
+	}
+	var err error
+	if cfg.InternalToken, err = access.ReadInternalToken(); err != nil {
+		return nil, err
+	}
+	if cfg.Audience == "" {
+		cfg.Audience = "hl-online-shop"
+	}
+	if s := os.Getenv("JWT_LEEWAY"); s != "" {
+		leeway, err := time.ParseDuration(s)
+		if err != nil || leeway < 0 {
+			return nil, fmt.Errorf("invalid JWT_LEEWAY %q", s)
+		}
+		cfg.Leeway = leeway
+	}
+	if len(cfg.HS256Secret) > 0 && len(cfg.HS256Secret) < 32 {
+		return nil, errors.New("JWT_HS256_SECRET must be at least 32 bytes")
+	}
+	if path := os.Getenv("JWT_RS256_PUBLIC_KEY_FILE"); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("JWT_RS256_PUBLIC_KEY_FILE: %w", err)
+		}
+		key, err := parseRSAPublicKey(data)
+		if err != nil {
+			return nil, fmt.Errorf("JWT_RS256_PUBLIC_KEY_FILE: %w", err)
+		}
+		cfg.RS256Key = key
+	}
+	if len(cfg.HS256Secret) == 0 && cfg.RS256Key == nil {
+		return nil, errors.New("set JWT_HS256_SECRET or JWT_RS256_PUBLIC_KEY_FILE")
+	}
+	return cfg, nil
+}
+
+// parseRSAPublicKey reads a PEM encoded RSA public key in PKIX or PKCS #1
+// form.
+func parseRSAPublicKey(data []byte) (*rsa.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM data found")
+	}
+	if block.Type == "RSA PUBLIC KEY" {
+		return x509.ParsePKCS1PublicKey(block.Bytes)
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	rsaKey, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return nil, errors.New("not an RSA public key")
+	}
+	return rsaKey, nil
+}
+
+// Claims are the registered and shop-specific claims of a token.
+type Claims struct {
+	Subject   string   `json:"sub"`
+	Role      string   `json:"role"`
+	Issuer    string   `json:"iss"`
+	Audience  audience `json:"aud"`
+	ExpiresAt *float64 `json:"exp"`
+	NotBefore *float64 `json:"nbf"`
+	// MFA is set by the users service if the user signed in with a second
+	// factor, which admins must do to use their rights.
+	MFA bool `json:"mfa"`
+}
+
+// audience is the aud claim, which is either a string or an array of strings.
+type audience []string
+
+func (a *audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = audience{single}
+		return nil
+	}
+	var many []string
+	if err := json.Unmarshal(data, &many); err != nil {
+		return errors.New("aud must be a string or an array of strings")
+	}
+	*a = many
+	return nil
+}
+
+func (a audience) contains(value string) bool {
+	for _, v := range a {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// verifyToken checks the signature of a compact JWS and validates its claims
+// at now.
+func (cfg *AuthConfig) verifyToken(token string, now time.Time) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%w: malformed token", ErrInvalidToken)
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("%w: header: %v", ErrInvalidToken, err)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: signature: %v", ErrInvalidToken, err)
+	}
+	signingInput := parts[0] + "." + parts[1]
+
+	// Алгоритм определяет ключ, так что токен HS256 нельзя подписать открытым ключом RS256
+	switch {
+	case header.Alg == "HS256" && len(cfg.HS256Secret) > 0:
+		mac := hmac.New(sha256.New, cfg.HS256Secret)
+		mac.Write([]byte(signingInput))
+		if !hmac.Equal(signature, mac.Sum(nil)) {
+			return nil, fmt.Errorf("%w: bad signature", ErrInvalidToken)
+		}
+	case header.Alg == "RS256" && cfg.RS256Key != nil:
+		digest := sha256.Sum256([]byte(signingInput))
+		if err := rsa.VerifyPKCS1v15(cfg.RS256Key, crypto.SHA256, digest[:], signature); err != nil {
+			return nil, fmt.Errorf("%w: bad signature", ErrInvalidToken)
+		}
+	default:
+		return nil, fmt.Errorf("%w: unsupported algorithm %q", ErrInvalidToken, header.Alg)
+	}
+
+	var claims Claims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("%w: claims: %v", ErrInvalidToken, err)
+	}
+	if err := cfg.validateClaims(&claims, now); err != nil {
+		return nil, err
+	}
+	return &claims, nil
+}
+
+func (cfg *AuthConfig) validateClaims(claims *Claims, now time.Time) error {
+	if claims.Subject == "" {
+		return fmt.Errorf("%w: missing sub", ErrInvalidToken)
+	}
+	if claims.ExpiresAt == nil {
+		return fmt.Errorf("%w: missing exp", ErrInvalidToken)
+	}
+	if now.Add(-cfg.Leeway).After(numericDate(*claims.ExpiresAt)) {
+		return fmt.Errorf("%w: token has expired", ErrInvalidToken)
+	}
+	if claims.NotBefore != nil && now.Add(cfg.Leeway).Before(numericDate(*claims.NotBefore)) {
+		return fmt.Errorf("%w: token is not valid yet", ErrInvalidToken)
+	}
+	if !claims.Audience.contains(cfg.Audience) {
+		return fmt.Errorf("%w: token is not for audience %s", ErrInvalidToken, cfg.Audience)
+	}
+	if cfg.Issuer != "" && claims.Issuer != cfg.Issuer {
+		return fmt.Errorf("%w: unexpected issuer %q", ErrInvalidToken, claims.Issuer)
+	}
+	return nil
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// numericDate converts seconds since the epoch, which may have a fraction, to
+// a time.
+func numericDate(seconds float64) time.Time {
+	whole, fraction := math.Modf(seconds)
+	return time.Unix(int64(whole), int64(fraction*float64(time.Second)))
+}
+
+// authenticate enforces the policy of the matched route: it verifies the
+// bearer token of requests to protected routes, checks the caller's access and
+// forwards its subject and role to the backend services in the trusted
+// headers. Copies of the trusted headers sent by the client are always removed.
+func authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.Header.Del(access.HeaderUserID)
+		r.Header.Del(access.HeaderUserRole)
+		r.Header.Del(access.HeaderInternalToken)
+		r.Header.Del(access.HeaderAnonymous)
+		policy, ok := routePolicyOf(r)
+		if !ok {
+			access.WriteError(w, http.StatusForbidden, "no access policy for this route")
+			return
+		}
+		if policy.access == accessPublic {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		claims, err := bearerClaims(r)
+		if err != nil {
+			log.Printf("rejected %s %s: %v", r.Method, r.URL.Path, err)
+			if errors.Is(err, ErrMissingToken) {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+			} else {
+				w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			}
+			access.WriteError(w, http.StatusUnauthorized, err.Error())
+			return
+		}
+		if claims.Role == access.RoleAdmin && !claims.MFA {
+			// Администратор без второго фактора работает как клиент, пока не подключит 2FA
+			if policy.access == accessAdmin {
+				log.Printf("denied %s %s to admin %s without two-factor authentication", r.Method, r.URL.Path, claims.Subject)
+				access.WriteError(w, http.StatusForbidden, "admins must sign in with two-factor authentication")
+				return
+			}
+			claims.Role = access.RoleClient
+		}
+		if err := policy.authorize(r, claims); err != nil {
+			log.Printf("denied %s %s to user %s: %v", r.Method, r.URL.Path, claims.Subject, err)
+			access.WriteError(w, http.StatusForbidden, err.Error())
+			return
+		}
+		r.Header.Set(access.HeaderUserID, claims.Subject)
+		if claims.Role != "" {
+			r.Header.Set(access.HeaderUserRole, claims.Role)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func bearerClaims(r *http.Request) (*Claims, error) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") || token == "" {
+		return nil, ErrMissingToken
+	}
+	return authConfig.verifyToken(strings.TrimSpace(token), time.Now())
+}
@@ -0,0 +1,352 @@
+package main
+
+import (
+	"HL_online_shop/common/access"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+var (
+	testSecret = []byte("0123456789abcdef0123456789abcdef")
+	testNow    = time.Unix(1700000000, 0)
+)
+
+func testRSAKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// signToken builds a compact JWS. key is a []byte secret for HS256 and an
+// *rsa.PrivateKey for RS256; any other algorithm gets an empty signature.
+func signToken(t *testing.T, alg string, key interface{}, claims map[string]interface{}) string {
+	t.Helper()
+	segment := func(v interface{}) string {
+		data, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return base64.RawURLEncoding.EncodeToString(data)
+	}
+	input := segment(map[string]string{"alg": alg, "typ": "JWT"}) + "." + segment(claims)
+
+	var signature []byte
+	switch alg {
+	case "HS256":
+		mac := hmac.New(sha256.New, key.([]byte))
+		mac.Write([]byte(input))
+		signature = mac.Sum(nil)
+	case "RS256":
+		digest := sha256.Sum256([]byte(input))
+		var err error
+		signature, err = rsa.SignPKCS1v15(rand.Reader, key.(*rsa.PrivateKey), crypto.SHA256, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return input + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+// testClaims returns valid claims with the given changes; a nil value removes
+// the claim.
+func testClaims(changes map[string]interface{}) map[string]interface{} {
+	claims := map[string]interface{}{
+		"sub":  "42",
+		"role": access.RoleClient,
+		"iss":  "hl-users",
+		"aud":  "hl-online-shop",
+		"exp":  testNow.Add(time.Hour).Unix(),
+	}
+	for name, value := range changes {
+		if value == nil {
+			delete(claims, name)
+		} else {
+			claims[name] = value
+		}
+	}
+	return claims
+}
+
+func TestVerifyToken(t *testing.T) {
+	rsaKey := testRSAKey(t)
+	otherKey := testRSAKey(t)
+	publicPEM, err := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicPEM = pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicPEM})
+
+	both := &AuthConfig{HS256Secret: testSecret, RS256Key: &rsaKey.PublicKey, Audience: "hl-online-shop", Issuer: "hl-users", Leeway: 30 * time.Second}
+	hsOnly := &AuthConfig{HS256Secret: testSecret, Audience: "hl-online-shop", Leeway: 30 * time.Second}
+	rsOnly := &AuthConfig{RS256Key: &rsaKey.PublicKey, Audience: "hl-online-shop", Leeway: 30 * time.Second}
+
+	tests := []struct {
+		name  string
+		cfg   *AuthConfig
+		token string
+		ok    bool
+	}{
+		{"HS256", both, signToken(t, "HS256", testSecret, testClaims(nil)), true},
+		{"RS256", both, signToken(t, "RS256", rsaKey, testClaims(nil)), true},
+		{"HS256 only", hsOnly, signToken(t, "HS256", testSecret, testClaims(nil)), true},
+		{"RS256 only", rsOnly, signToken(t, "RS256", rsaKey, testClaims(nil)), true},
+		{"HS256 wrong secret", both, signToken(t, "HS256", []byte("fedcba9876543210fedcba9876543210"), testClaims(nil)), false},
+		{"RS256 wrong key", both, signToken(t, "RS256", otherKey, testClaims(nil)), false},
+		{"RS256 without key", hsOnly, signToken(t, "RS256", rsaKey, testClaims(nil)), false},
+		{"HS256 without secret", rsOnly, signToken(t, "HS256", testSecret, testClaims(nil)), false},
+
+		// alg=none и подмена алгоритма
+		{"alg none", both, signToken(t, "none", nil, testClaims(nil)), false},
+		{"alg None", both, signToken(t, "None", nil, testClaims(nil)), false},
+		{"alg empty", both, signToken(t, "", nil, testClaims(nil)), false},
+		{"HS256 signed with RSA public key", rsOnly, signToken(t, "HS256", publicPEM, testClaims(nil)), false},
+		{"HS256 signed with RSA public key DER", rsOnly, signToken(t, "HS256", x509.MarshalPKCS1PublicKey(&rsaKey.PublicKey), testClaims(nil)), false},
+		{"RS256 header with HMAC signature", both, func() string {
+			hs := signToken(t, "HS256", testSecret, testClaims(nil))
+			rs := signToken(t, "RS256", rsaKey, testClaims(nil))
+			return rs[:strings.LastIndex(rs, ".")] + hs[strings.LastIndex(hs, "."):]
+		}(), false},
+
+		{"malformed", both, "not-a-token", false},
+		{"two segments", both, "eyJhbGciOiJIUzI1NiJ9.e30", false},
+		{"bad signature encoding", both, signToken(t, "HS256", testSecret, testClaims(nil)) + "!", false},
+
+		{"missing sub", both, signToken(t, "HS256", testSecret, testClaims(map[string]interface{}{"sub": nil})), false},
+		{"missing exp", both, signToken(t, "HS256", testSecret, testClaims(map[string]interface{}{"exp": nil})), false},
+		{"expired", both, signToken(t, "HS256", testSecret, testClaims(map[string]interface{}{"exp": testNow.Add(-time.Minute).Unix()})), false},
+		{"expired within leeway", both, signToken(t, "HS256", testSecret, testClaims(map[string]interface{}{"exp": testNow.Add(-10 * time.Second).Unix()})), true},
+		{"fractional exp", both, signToken(t, "HS256", testSecret, testClaims(map[string]interface{}{"exp": float64(testNow.Unix()) + 0.5})), true},
+		{"not yet valid", both, signToken(t, "HS256", testSecret, testClaims(map[string]interface{}{"nbf": testNow.Add(time.Minute).Unix()})), false},
+		{"nbf within leeway", both, signToken(t, "HS256", testSecret, testClaims(map[string]interface{}{"nbf": testNow.Add(10 * time.Second).Unix()})), true},
+		{"nbf in the past", both, signToken(t, "HS256", testSecret, testClaims(map[string]interface{}{"nbf": testNow.Add(-time.Hour).Unix()})), true},
+		{"aud array", both, signToken(t, "HS256", testSecret, testClaims(map[string]interface{}{"aud": []string{"other", "hl-online-shop"}})), true},
+		{"aud other", both, signToken(t, "HS256", testSecret, testClaims(map[string]interface{}{"aud": "other"})), false},
+		{"aud array without shop", both, signToken(t, "HS256", testSecret, testClaims(map[string]interface{}{"aud": []string{"other"}})), false},
+		{"aud missing", both, signToken(t, "HS256", testSecret, testClaims(map[string]interface{}{"aud": nil})), false},
+		{"aud number", both, signToken(t, "HS256", testSecret, testClaims(map[string]interface{}{"aud": 1})), false},
+		{"iss other", both, signToken(t, "HS256", testSecret, testClaims(map[string]interface{}{"iss": "someone"})), false},
+		{"iss missing", both, signToken(t, "HS256", testSecret, testClaims(map[string]interface{}{"iss": nil})), false},
+		{"iss not checked", hsOnly, signToken(t, "HS256", testSecret, testClaims(map[string]interface{}{"iss": "someone"})), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims, err := tt.cfg.verifyToken(tt.token, testNow)
+			if !tt.ok {
+				if !errors.Is(err, ErrInvalidToken) {
+					t.Fatalf("got %v, want ErrInvalidToken", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if claims.Subject != "42" || claims.Role != access.RoleClient {
+				t.Errorf("got sub %q, role %q", claims.Subject, claims.Role)
+			}
+		})
+	}
+}
+
+func TestParseRSAPublicKey(t *testing.T) {
+	key := testRSAKey(t)
+	pkix, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, block := range []*pem.Block{
+		{Type: "PUBLIC KEY", Bytes: pkix},
+		{Type: "RSA PUBLIC KEY", Bytes: x509.MarshalPKCS1PublicKey(&key.PublicKey)},
+	} {
+		parsed, err := parseRSAPublicKey(pem.EncodeToMemory(block))
+		if err != nil {
+			t.Fatalf("%s: %v", block.Type, err)
+		}
+		if !parsed.Equal(&key.PublicKey) {
+			t.Errorf("%s: parsed a different key", block.Type)
+		}
+	}
+	if _, err := parseRSAPublicKey([]byte("not PEM")); err == nil {
+		t.Error("parsed data without PEM")
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	saved := authConfig
+	t.Cleanup(func() { authConfig = saved })
+	authConfig = &AuthConfig{HS256Secret: testSecret, Audience: "hl-online-shop", Leeway: 30 * time.Second}
+
+	var forwarded http.Header
+	var reached bool
+	echo := func(w http.ResponseWriter, r *http.Request) {
+		reached = true
+		forwarded = r.Header.Clone()
+	}
+	r := mux.NewRouter()
+	r.Use(authenticate)
+	r.HandleFunc("/health", echo).Methods("GET")
+	r.HandleFunc("/orders", echo).Methods("GET")
+	r.HandleFunc("/orders/{id}", echo).Methods("GET")
+	r.HandleFunc("/users/{id}", echo).Methods("GET")
+	r.HandleFunc("/unlisted", echo).Methods("GET")
+
+	// Токены проверяются по реальным часам, поэтому exp задаётся от time.Now()
+	token := func(changes map[string]interface{}) string {
+		changes["exp"] = time.Now().Add(time.Hour).Unix()
+		return "Bearer " + signToken(t, "HS256", testSecret, testClaims(changes))
+	}
+	client := token(map[string]interface{}{})
+	admin := token(map[string]interface{}{"sub": "1", "role": access.RoleAdmin, "mfa": true})
+	adminWithoutMFA := token(map[string]interface{}{"sub": "1", "role": access.RoleAdmin})
+
+	tests := []struct {
+		name          string
+		path          string
+		authorization string
+		status        int
+		userID        string
+		role          string
+	}{
+		{"public without token", "/health", "", http.StatusOK, "", ""},
+		{"public with token", "/health", client, http.StatusOK, "", ""},
+		{"missing token", "/orders/5", "", http.StatusUnauthorized, "", ""},
+		{"not a bearer token", "/orders/5", "Basic dXNlcjpwYXNz", http.StatusUnauthorized, "", ""},
+		{"invalid token", "/orders/5", "Bearer abc.def.ghi", http.StatusUnauthorized, "", ""},
+		{"expired token", "/orders/5", "Bearer " + signToken(t, "HS256", testSecret, testClaims(map[string]interface{}{"exp": time.Now().Add(-time.Hour).Unix()})), http.StatusUnauthorized, "", ""},
+		{"alg none", "/orders/5", "Bearer " + signToken(t, "none", nil, testClaims(map[string]interface{}{"exp": time.Now().Add(time.Hour).Unix()})), http.StatusUnauthorized, "", ""},
+		{"client", "/orders/5", client, http.StatusOK, "42", access.RoleClient},
+		{"client on admin route", "/orders", client, http.StatusForbidden, "", ""},
+		{"client on own user", "/users/42", client, http.StatusOK, "42", access.RoleClient},
+		{"client on other user", "/users/7", client, http.StatusForbidden, "", ""},
+		{"admin", "/orders", admin, http.StatusOK, "1", access.RoleAdmin},
+		{"admin without MFA on admin route", "/orders", adminWithoutMFA, http.StatusForbidden, "", ""},
+		{"admin without MFA acts as client", "/users/1", adminWithoutMFA, http.StatusOK, "1", access.RoleClient},
+		{"admin without MFA on other user", "/users/7", adminWithoutMFA, http.StatusForbidden, "", ""},
+		{"route without policy", "/unlisted", admin, http.StatusForbidden, "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reached, forwarded = false, nil
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			// Клиент пытается выдать себя за администратора
+			req.Header.Set(access.HeaderUserID, "1")
+			req.Header.Set(access.HeaderUserRole, access.RoleAdmin)
+			req.Header.Set(access.HeaderInternalToken, "guessed")
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			rec := httptest.NewRecorder()
+			r.ServeHTTP(rec, req)
+
+			if rec.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", rec.Code, tt.status, rec.Body.String())
+			}
+			if tt.status == http.StatusUnauthorized && rec.Header().Get("WWW-Authenticate") == "" {
+				t.Error("401 without WWW-Authenticate")
+			}
+			if reached != (tt.status == http.StatusOK) {
+				t.Fatalf("handler reached: %v", reached)
+			}
+			if !reached {
+				return
+			}
+			if got := forwarded.Get(access.HeaderUserID); got != tt.userID {
+				t.Errorf("%s %q, want %q", access.HeaderUserID, got, tt.userID)
+			}
+			if got := forwarded.Get(access.HeaderUserRole); got != tt.role {
+				t.Errorf("%s %q, want %q", access.HeaderUserRole, got, tt.role)
+			}
+			if got := forwarded.Get(access.HeaderInternalToken); got != "" {
+				t.Errorf("%s %q passed on from the client", access.HeaderInternalToken, got)
+			}
+		})
+	}
+}
+
+// TestProxyRequestAnonymous checks that a request forwarded without a user is
+// marked anonymous. The services take the internal token without a user for a
+// call from another service, which may act for every user.
+func TestProxyRequestAnonymous(t *testing.T) {
+	saved := authConfig
+	t.Cleanup(func() { authConfig = saved })
+	authConfig = &AuthConfig{
+		HS256Secret:   testSecret,
+		Audience:      "hl-online-shop",
+		Leeway:        30 * time.Second,
+		InternalToken: []byte("internal-token-of-at-least-32-bytes"),
+	}
+
+	var received http.Header
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.Header.Clone()
+	}))
+	t.Cleanup(backend.Close)
+
+	proxy := func(w http.ResponseWriter, r *http.Request) {
+		proxyRequest(w, r, backend.URL+r.URL.Path)
+	}
+	r := mux.NewRouter()
+	r.Use(authenticate)
+	r.HandleFunc("/products", proxy).Methods("GET")
+	r.HandleFunc("/orders/{id}", proxy).Methods("GET")
+
+	client := "Bearer " + signToken(t, "HS256", testSecret, testClaims(map[string]interface{}{"exp": time.Now().Add(time.Hour).Unix()}))
+	tests := []struct {
+		name          string
+		path          string
+		authorization string
+		userID        string
+		anonymous     bool
+	}{
+		{"public route", "/products", "", "", true},
+		{"public route with a token", "/products", client, "", true},
+		{"signed-in route", "/orders/5", client, "42", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			received = nil
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			// Отметку ставит только шлюз
+			req.Header.Set(access.HeaderAnonymous, "from the client")
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			rec := httptest.NewRecorder()
+			r.ServeHTTP(rec, req)
+
+			if rec.Code != http.StatusOK || received == nil {
+				t.Fatalf("status %d, backend reached: %v", rec.Code, received != nil)
+			}
+			if got := received.Get(access.HeaderInternalToken); got != string(authConfig.InternalToken) {
+				t.Errorf("%s %q", access.HeaderInternalToken, got)
+			}
+			if got := received.Get(access.HeaderUserID); got != tt.userID {
+				t.Errorf("%s %q, want %q", access.HeaderUserID, got, tt.userID)
+			}
+			want := ""
+			if tt.anonymous {
+				want = "true"
+			}
+			if got := received.Get(access.HeaderAnonymous); got != want {
+				t.Errorf("%s %q, want %q", access.HeaderAnonymous, got, want)
+			}
+		})
+	}
+}
@@ -15,18 +15,45 @@ const docTemplate = `{
     "host": "{{.Host}}",
     "basePath": "{{.BasePath}}",
     "paths": {
-        "/health": {
-            "get": {
+        "/auth/login": {
+            "post": {
+                "description": "Sign in with email and password and get an access token and a refresh token. If the user has two-factor authentication, the response only has mfa_required and an mfa_token to finish signing in at /auth/login/mfa. Admins without two-factor authentication get tokens with mfa_enrollment_required, which only have the rights of a client until they enrol. Failed attempts are limited per account and per IP address; over the limit the response is 429 with Retry-After.",
+                "consumes": [
+                    "application/json"
+                ],
                 "produces": [
-                    "text/plain"
+                    "application/json"
                 ],
                 "tags": [
-                    "Health"
+                    "auth"
+                ],
+                "summary": "Sign in",
+                "parameters": [
+                    {
+                        "description": "Credentials",
+                        "name": "credentials",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.LoginRequest"
+                        }
+                    }
                 ],
-                "summary": "Health check",
                 "responses": {
                     "200": {
                         "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.TokenResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Invalid email or password",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "429": {
+                        "description": "Too many failed attempts",
                         "schema": {
                             "type": "string"
                         }
@@ -34,91 +61,145 @@ const docTemplate = `{
                 }
             }
         },
-        "/orders": {
-            "get": {
-                "description": "Get all orders",
+        "/auth/login/mfa": {
+            "post": {
+                "description": "Exchange the mfa_token from /auth/login and a code of the authenticator app, or a recovery code, for an access token and a refresh token. The mfa_token expires after 5 minutes and works once. Wrong codes count as failed logins of the account.",
+                "consumes": [
+                    "application/json"
+                ],
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "orders"
+                    "auth"
+                ],
+                "summary": "Finish signing in with two-factor authentication",
+                "parameters": [
+                    {
+                        "description": "Token from the first step and a code",
+                        "name": "login",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.MFALoginRequest"
+                        }
+                    }
                 ],
-                "summary": "Get all orders",
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "type": "array",
-                            "items": {
-                                "$ref": "#/definitions/main.Order"
-                            }
+                            "$ref": "#/definitions/main.TokenResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Invalid code or expired token",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "429": {
+                        "description": "Too many failed attempts",
+                        "schema": {
+                            "type": "string"
                         }
                     }
                 }
-            },
+            }
+        },
+        "/auth/logout": {
             "post": {
-                "description": "Create a new order",
+                "description": "Revoke a refresh token. The access token stays valid until it expires, which is why it is short-lived.",
                 "consumes": [
                     "application/json"
                 ],
-                "produces": [
+                "tags": [
+                    "auth"
+                ],
+                "summary": "Sign out",
+                "parameters": [
+                    {
+                        "description": "Refresh token",
+                        "name": "token",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.RefreshRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "No Content"
+                    }
+                }
+            }
+        },
+        "/auth/password-reset": {
+            "post": {
+                "description": "Set a new password with the token from the reset email. The token works once and expires after PASSWORD_RESET_TTL. Every refresh token of the user is revoked, and the address is verified since the user received the email.",
+                "consumes": [
                     "application/json"
                 ],
                 "tags": [
-                    "orders"
+                    "auth"
                 ],
-                "summary": "Create an order",
+                "summary": "Reset the password",
                 "parameters": [
                     {
-                        "description": "Create order",
-                        "name": "order",
+                        "description": "Token from the email and new password",
+                        "name": "reset",
                         "in": "body",
                         "required": true,
                         "schema": {
-                            "$ref": "#/definitions/main.Order"
+                            "$ref": "#/definitions/main.ResetPasswordRequest"
                         }
                     }
                 ],
                 "responses": {
-                    "201": {
-                        "description": "Created",
+                    "204": {
+                        "description": "No Content"
+                    },
+                    "400": {
+                        "description": "Invalid, used or expired token",
                         "schema": {
-                            "$ref": "#/definitions/main.Order"
+                            "type": "string"
                         }
                     }
                 }
             }
         },
-        "/orders/{id}": {
-            "get": {
-                "description": "Get an order by ID",
-                "produces": [
+        "/auth/password-reset/request": {
+            "post": {
+                "description": "Email a link to set a new password. Earlier links stop working. The response is the same whether or not the address is registered, and at most 3 emails an hour are sent to an address.",
+                "consumes": [
                     "application/json"
                 ],
                 "tags": [
-                    "orders"
+                    "auth"
                 ],
-                "summary": "Get an order by ID",
+                "summary": "Request a password reset",
                 "parameters": [
                     {
-                        "type": "integer",
-                        "description": "Order ID",
-                        "name": "id",
-                        "in": "path",
-                        "required": true
+                        "description": "Address of the account",
+                        "name": "email",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.EmailRequest"
+                        }
                     }
                 ],
                 "responses": {
-                    "200": {
-                        "description": "OK",
-                        "schema": {
-                            "$ref": "#/definitions/main.Order"
-                        }
+                    "202": {
+                        "description": "Accepted"
                     }
                 }
-            },
-            "put": {
-                "description": "Update an order by ID",
+            }
+        },
+        "/auth/refresh": {
+            "post": {
+                "description": "Exchange a refresh token for a new access token and a new refresh token. Each refresh token can be used once; using a revoked one again revokes every session of the user, since the token was probably stolen.",
                 "consumes": [
                     "application/json"
                 ],
@@ -126,24 +207,17 @@ const docTemplate = `{
                     "application/json"
                 ],
                 "tags": [
-                    "orders"
+                    "auth"
                 ],
-                "summary": "Update an order by ID",
+                "summary": "Refresh the access token",
                 "parameters": [
                     {
-                        "type": "integer",
-                        "description": "Order ID",
-                        "name": "id",
-                        "in": "path",
-                        "required": true
-                    },
-                    {
-                        "description": "Update order",
-                        "name": "order",
+                        "description": "Refresh token",
+                        "name": "token",
                         "in": "body",
                         "required": true,
                         "schema": {
-                            "$ref": "#/definitions/main.Order"
+                            "$ref": "#/definitions/main.RefreshRequest"
                         }
                     }
                 ],
@@ -151,32 +225,51 @@ const docTemplate = `{
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/main.Order"
+                            "$ref": "#/definitions/main.TokenResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Invalid or expired refresh token",
+                        "schema": {
+                            "type": "string"
                         }
                     }
                 }
-            },
-            "delete": {
-                "description": "Delete an order by ID",
+            }
+        },
+        "/auth/register": {
+            "post": {
+                "description": "Create a client account that can sign in with its email and password. Passwords are stored as bcrypt hashes. A verification email is sent to the address; orders can only be placed once it is verified.",
+                "consumes": [
+                    "application/json"
+                ],
                 "produces": [
-                    "text/plain"
+                    "application/json"
                 ],
                 "tags": [
-                    "orders"
+                    "auth"
                 ],
-                "summary": "Delete an order by ID",
+                "summary": "Register a customer",
                 "parameters": [
                     {
-                        "type": "integer",
-                        "description": "Order ID",
-                        "name": "id",
-                        "in": "path",
-                        "required": true
+                        "description": "New account",
+                        "name": "user",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.RegisterRequest"
+                        }
                     }
                 ],
                 "responses": {
-                    "200": {
-                        "description": "Deleted",
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "$ref": "#/definitions/main.User"
+                        }
+                    },
+                    "409": {
+                        "description": "Email already registered",
                         "schema": {
                             "type": "string"
                         }
@@ -184,91 +277,125 @@ const docTemplate = `{
                 }
             }
         },
-        "/payments": {
-            "get": {
-                "description": "Get all payments",
-                "produces": [
+        "/auth/verify-email": {
+            "post": {
+                "description": "Confirm the address with the token from the verification email. A token works once, expires after EMAIL_VERIFICATION_TTL and does not verify an address the user changed to afterwards.",
+                "consumes": [
                     "application/json"
                 ],
                 "tags": [
-                    "payments"
+                    "auth"
+                ],
+                "summary": "Verify an email address",
+                "parameters": [
+                    {
+                        "description": "Token from the email",
+                        "name": "token",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.VerifyEmailRequest"
+                        }
+                    }
                 ],
-                "summary": "Get all payments",
                 "responses": {
-                    "200": {
-                        "description": "OK",
+                    "204": {
+                        "description": "No Content"
+                    },
+                    "400": {
+                        "description": "Invalid, used or expired token",
                         "schema": {
-                            "type": "array",
-                            "items": {
-                                "$ref": "#/definitions/main.Payment"
-                            }
+                            "type": "string"
                         }
                     }
                 }
-            },
+            }
+        },
+        "/auth/verify-email/resend": {
             "post": {
-                "description": "Create a new payment using API ePayment.kz",
+                "description": "Send a new verification link to an unverified address. Earlier links stop working. The response is the same whether or not the address is registered, and at most 3 emails an hour are sent to an address.",
                 "consumes": [
                     "application/json"
                 ],
-                "produces": [
-                    "application/json"
-                ],
                 "tags": [
-                    "payments"
+                    "auth"
                 ],
-                "summary": "Create a payment",
+                "summary": "Send the verification email again",
                 "parameters": [
                     {
-                        "description": "Create payment",
-                        "name": "payment",
+                        "description": "Address to verify",
+                        "name": "email",
                         "in": "body",
                         "required": true,
                         "schema": {
-                            "$ref": "#/definitions/main.PaymentRequest"
+                            "$ref": "#/definitions/main.EmailRequest"
                         }
                     }
                 ],
                 "responses": {
-                    "201": {
-                        "description": "Created",
-                        "schema": {
-                            "$ref": "#/definitions/main.Payment"
-                        }
+                    "202": {
+                        "description": "Accepted"
                     }
                 }
             }
         },
-        "/payments/{id}": {
+        "/checkout": {
             "get": {
-                "description": "Get a payment by ID",
+                "description": "Search checkouts by order, user or status, newest first. The checkout of an order shows how far it got.",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "payments"
+                    "checkout"
                 ],
-                "summary": "Get a payment by ID",
+                "summary": "Search checkouts by order, user or status",
                 "parameters": [
                     {
                         "type": "integer",
-                        "description": "Payment ID",
-                        "name": "id",
-                        "in": "path",
-                        "required": true
+                        "description": "Order ID",
+                        "name": "order_id",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "User ID",
+                        "name": "user_id",
+                        "in": "query"
+                    },
+                    {
+                        "enum": [
+                            "running",
+                            "requires_action",
+                            "compensating",
+                            "completed",
+                            "failed"
+                        ],
+                        "type": "string",
+                        "description": "Checkout status",
+                        "name": "status",
+                        "in": "query"
                     }
                 ],
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/main.Payment"
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/main.Checkout"
+                            }
+                        }
+                    },
+                    "403": {
+                        "description": "Another user's checkouts",
+                        "schema": {
+                            "$ref": "#/definitions/access.ErrorResponse"
                         }
                     }
                 }
             },
-            "put": {
-                "description": "Update a payment by ID",
+            "post": {
+                "description": "Place an order and pay for it in one request. The checkout validates the user, whose email address must be verified, prices the cart, creates the order, reserves stock, charges the card and confirms the order. If a step fails, the steps already done are undone: the payment is refunded, the stock released and the order cancelled. The checkout state is saved after every step and resumed after a restart. Card data is never stored, so a checkout interrupted before the card was charged can only be resumed with a saved payment method.",
                 "consumes": [
                     "application/json"
                 ],
@@ -276,49 +403,68 @@ const docTemplate = `{
                     "application/json"
                 ],
                 "tags": [
-                    "payments"
+                    "checkout"
                 ],
-                "summary": "Update a payment by ID",
+                "summary": "Check out a cart",
                 "parameters": [
                     {
-                        "type": "integer",
-                        "description": "Payment ID",
-                        "name": "id",
-                        "in": "path",
-                        "required": true
-                    },
-                    {
-                        "description": "Update payment",
-                        "name": "payment",
+                        "description": "Cart and card",
+                        "name": "checkout",
                         "in": "body",
                         "required": true,
                         "schema": {
-                            "$ref": "#/definitions/main.Payment"
+                            "$ref": "#/definitions/main.CheckoutRequest"
                         }
+                    },
+                    {
+                        "type": "string",
+                        "description": "Unique key that makes the request safe to retry",
+                        "name": "Idempotency-Key",
+                        "in": "header"
                     }
                 ],
                 "responses": {
-                    "200": {
-                        "description": "OK",
+                    "201": {
+                        "description": "Completed",
                         "schema": {
-                            "$ref": "#/definitions/main.Payment"
+                            "$ref": "#/definitions/main.Checkout"
                         }
-                    }
-                }
-            },
-            "delete": {
-                "description": "Delete a payment by ID",
-                "produces": [
-                    "text/plain"
+                    },
+                    "202": {
+                        "description": "Waiting for 3-D Secure (see action_url) or for the payment outcome",
+                        "schema": {
+                            "$ref": "#/definitions/main.Checkout"
+                        }
+                    },
+                    "403": {
+                        "description": "The checkout is for another user",
+                        "schema": {
+                            "$ref": "#/definitions/access.ErrorResponse"
+                        }
+                    },
+                    "422": {
+                        "description": "Failed and compensated, the reason is in error",
+                        "schema": {
+                            "$ref": "#/definitions/main.Checkout"
+                        }
+                    }
+                }
+            }
+        },
+        "/checkout/{id}": {
+            "get": {
+                "description": "Get the status of a checkout and the history of its steps",
+                "produces": [
+                    "application/json"
                 ],
                 "tags": [
-                    "payments"
+                    "checkout"
                 ],
-                "summary": "Delete a payment by ID",
+                "summary": "Get a checkout by ID",
                 "parameters": [
                     {
                         "type": "integer",
-                        "description": "Payment ID",
+                        "description": "Checkout ID",
                         "name": "id",
                         "in": "path",
                         "required": true
@@ -326,7 +472,19 @@ const docTemplate = `{
                 ],
                 "responses": {
                     "200": {
-                        "description": "Deleted",
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.Checkout"
+                        }
+                    },
+                    "403": {
+                        "description": "The checkout belongs to another user",
+                        "schema": {
+                            "$ref": "#/definitions/access.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Checkout not found",
                         "schema": {
                             "type": "string"
                         }
@@ -334,91 +492,81 @@ const docTemplate = `{
                 }
             }
         },
-        "/products": {
+        "/currency-rates": {
             "get": {
-                "description": "Get all products",
+                "description": "Get how many units of each currency one unit of the base currency is worth",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "products"
+                    "currency-rates"
                 ],
-                "summary": "Get all products",
+                "summary": "Get the exchange rates",
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
                             "type": "array",
                             "items": {
-                                "$ref": "#/definitions/main.Product"
+                                "$ref": "#/definitions/main.CurrencyRate"
                             }
                         }
                     }
                 }
-            },
+            }
+        },
+        "/currency-rates/import": {
             "post": {
-                "description": "Create a new product",
+                "description": "Create or replace exchange rates from a CSV file with currency,rate lines, e.g. USD,0.0021. A header line is allowed. Either every rate of the file is saved or none is.",
                 "consumes": [
-                    "application/json"
+                    "text/csv"
                 ],
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "products"
+                    "currency-rates"
                 ],
-                "summary": "Create a product",
+                "summary": "Import exchange rates from CSV",
                 "parameters": [
                     {
-                        "description": "Create product",
-                        "name": "product",
+                        "description": "CSV file",
+                        "name": "rates",
                         "in": "body",
                         "required": true,
                         "schema": {
-                            "$ref": "#/definitions/main.Product"
-                        }
-                    }
-                ],
-                "responses": {
-                    "201": {
-                        "description": "Created",
-                        "schema": {
-                            "$ref": "#/definitions/main.Product"
+                            "type": "string"
                         }
                     }
-                }
-            }
-        },
-        "/products/{id}": {
-            "get": {
-                "description": "Get a product by ID",
-                "produces": [
-                    "application/json"
-                ],
-                "tags": [
-                    "products"
-                ],
-                "summary": "Get a product by ID",
-                "parameters": [
-                    {
-                        "type": "integer",
-                        "description": "Product ID",
-                        "name": "id",
-                        "in": "path",
-                        "required": true
-                    }
                 ],
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/main.Product"
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/main.CurrencyRate"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid line",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "403": {
+                        "description": "Only admins",
+                        "schema": {
+                            "$ref": "#/definitions/access.ErrorResponse"
                         }
                     }
                 }
-            },
+            }
+        },
+        "/currency-rates/{currency}": {
             "put": {
-                "description": "Update a product by ID",
+                "description": "Set how many units of a currency one unit of the base currency is worth. Orders placed before keep the rate they were priced with.",
                 "consumes": [
                     "application/json"
                 ],
@@ -426,24 +574,24 @@ const docTemplate = `{
                     "application/json"
                 ],
                 "tags": [
-                    "products"
+                    "currency-rates"
                 ],
-                "summary": "Update a product by ID",
+                "summary": "Set an exchange rate",
                 "parameters": [
                     {
-                        "type": "integer",
-                        "description": "Product ID",
-                        "name": "id",
+                        "type": "string",
+                        "description": "Currency code",
+                        "name": "currency",
                         "in": "path",
                         "required": true
                     },
                     {
-                        "description": "Update product",
-                        "name": "product",
+                        "description": "Rate",
+                        "name": "rate",
                         "in": "body",
                         "required": true,
                         "schema": {
-                            "$ref": "#/definitions/main.Product"
+                            "$ref": "#/definitions/main.CurrencyRate"
                         }
                     }
                 ],
@@ -451,25 +599,31 @@ const docTemplate = `{
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/main.Product"
+                            "$ref": "#/definitions/main.CurrencyRate"
+                        }
+                    },
+                    "403": {
+                        "description": "Only admins",
+                        "schema": {
+                            "$ref": "#/definitions/access.ErrorResponse"
                         }
                     }
                 }
             },
             "delete": {
-                "description": "Delete a product by ID",
+                "description": "Delete an exchange rate. Products without a price in the currency can no longer be ordered in it.",
                 "produces": [
                     "text/plain"
                 ],
                 "tags": [
-                    "products"
+                    "currency-rates"
                 ],
-                "summary": "Delete a product by ID",
+                "summary": "Delete an exchange rate",
                 "parameters": [
                     {
-                        "type": "integer",
-                        "description": "Product ID",
-                        "name": "id",
+                        "type": "string",
+                        "description": "Currency code",
+                        "name": "currency",
                         "in": "path",
                         "required": true
                     }
@@ -480,111 +634,62 @@ const docTemplate = `{
                         "schema": {
                             "type": "string"
                         }
-                    }
-                }
-            }
-        },
-        "/search/orders": {
-            "get": {
-                "description": "Search orders by user or status",
-                "produces": [
-                    "application/json"
-                ],
-                "tags": [
-                    "orders"
-                ],
-                "summary": "Search orders by user or status",
-                "parameters": [
-                    {
-                        "type": "integer",
-                        "description": "User ID",
-                        "name": "user",
-                        "in": "query"
                     },
-                    {
-                        "type": "string",
-                        "description": "Order Status",
-                        "name": "status",
-                        "in": "query"
-                    }
-                ],
-                "responses": {
-                    "200": {
-                        "description": "OK",
+                    "403": {
+                        "description": "Only admins",
                         "schema": {
-                            "type": "array",
-                            "items": {
-                                "$ref": "#/definitions/main.Order"
-                            }
+                            "$ref": "#/definitions/access.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Rate not found",
+                        "schema": {
+                            "type": "string"
                         }
                     }
                 }
             }
         },
-        "/search/payments": {
+        "/health": {
             "get": {
-                "description": "Search payments by user, order, or status",
                 "produces": [
-                    "application/json"
+                    "text/plain"
                 ],
                 "tags": [
-                    "payments"
-                ],
-                "summary": "Search payments by user, order, or status",
-                "parameters": [
-                    {
-                        "type": "integer",
-                        "description": "User ID",
-                        "name": "user",
-                        "in": "query"
-                    },
-                    {
-                        "type": "integer",
-                        "description": "Order ID",
-                        "name": "order",
-                        "in": "query"
-                    },
-                    {
-                        "type": "string",
-                        "description": "Payment Status",
-                        "name": "status",
-                        "in": "query"
-                    }
+                    "Health"
                 ],
+                "summary": "Health check",
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "type": "array",
-                            "items": {
-                                "$ref": "#/definitions/main.Payment"
-                            }
+                            "type": "string"
                         }
                     }
                 }
             }
         },
-        "/search/products": {
+        "/ledger/balances": {
             "get": {
-                "description": "Search products by name or category",
+                "description": "Get the debits, credits and balance of every ledger account per currency in minor units. Balance is debits minus credits, so the balances of each currency sum to zero.",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "products"
+                    "ledger"
                 ],
-                "summary": "Search products by name or category",
+                "summary": "Get account balances",
                 "parameters": [
                     {
                         "type": "string",
-                        "description": "Product Name",
-                        "name": "name",
+                        "description": "Account, e.g. assets:provider_clearing",
+                        "name": "account",
                         "in": "query"
                     },
                     {
                         "type": "string",
-                        "description": "Product Category",
-                        "name": "category",
+                        "description": "Currency code",
+                        "name": "currency",
                         "in": "query"
                     }
                 ],
@@ -594,34 +699,47 @@ const docTemplate = `{
                         "schema": {
                             "type": "array",
                             "items": {
-                                "$ref": "#/definitions/main.Product"
+                                "$ref": "#/definitions/main.AccountBalance"
                             }
                         }
+                    },
+                    "403": {
+                        "description": "Only admins",
+                        "schema": {
+                            "$ref": "#/definitions/access.ErrorResponse"
+                        }
                     }
                 }
             }
         },
-        "/search/users": {
+        "/ledger/journal": {
             "get": {
-                "description": "Search users by name or role",
+                "description": "Get ledger entries with their lines, newest first",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "users"
+                    "ledger"
                 ],
-                "summary": "Search users by name or role",
+                "summary": "Get the journal",
                 "parameters": [
                     {
-                        "type": "string",
-                        "description": "Name",
-                        "name": "name",
+                        "type": "integer",
+                        "description": "Payment ID",
+                        "name": "payment_id",
                         "in": "query"
                     },
                     {
                         "type": "string",
-                        "description": "Role",
-                        "name": "role",
+                        "description": "Only entries that touch this account",
+                        "name": "account",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "default": 100,
+                        "description": "Maximum number of entries",
+                        "name": "limit",
                         "in": "query"
                     }
                 ],
@@ -631,37 +749,49 @@ const docTemplate = `{
                         "schema": {
                             "type": "array",
                             "items": {
-                                "$ref": "#/definitions/main.User"
+                                "$ref": "#/definitions/main.LedgerEntry"
                             }
                         }
+                    },
+                    "403": {
+                        "description": "Only admins",
+                        "schema": {
+                            "$ref": "#/definitions/access.ErrorResponse"
+                        }
                     }
                 }
             }
         },
-        "/users": {
+        "/orders": {
             "get": {
-                "description": "Get all users",
+                "description": "Get all orders",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "users"
+                    "orders"
                 ],
-                "summary": "Get all users",
+                "summary": "Get all orders",
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
                             "type": "array",
                             "items": {
-                                "$ref": "#/definitions/main.User"
+                                "$ref": "#/definitions/main.Order"
                             }
                         }
+                    },
+                    "403": {
+                        "description": "Only admins",
+                        "schema": {
+                            "$ref": "#/definitions/access.ErrorResponse"
+                        }
                     }
                 }
             },
             "post": {
-                "description": "Create a new user",
+                "description": "Create a new order for a user with a verified email address. Item prices and the total are calculated from the products service in the order currency and stock is reserved for every item. Prices converted from the base currency keep the exchange rate of this moment.",
                 "consumes": [
                     "application/json"
                 ],
@@ -669,44 +799,68 @@ const docTemplate = `{
                     "application/json"
                 ],
                 "tags": [
-                    "users"
+                    "orders"
                 ],
-                "summary": "Create a user",
+                "summary": "Create an order",
                 "parameters": [
                     {
-                        "description": "Create user",
-                        "name": "user",
+                        "description": "Create order",
+                        "name": "order",
                         "in": "body",
                         "required": true,
                         "schema": {
-                            "$ref": "#/definitions/main.User"
+                            "$ref": "#/definitions/main.Order"
                         }
+                    },
+                    {
+                        "type": "string",
+                        "description": "Unique key that makes the request safe to retry",
+                        "name": "Idempotency-Key",
+                        "in": "header"
                     }
                 ],
                 "responses": {
                     "201": {
                         "description": "Created",
                         "schema": {
-                            "$ref": "#/definitions/main.User"
+                            "$ref": "#/definitions/main.Order"
+                        }
+                    },
+                    "403": {
+                        "description": "The order is for another user, or the user has not verified the email address",
+                        "schema": {
+                            "$ref": "#/definitions/access.ErrorResponse"
+                        }
+                    },
+                    "409": {
+                        "description": "Insufficient stock or the exchange rate changed, retry",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "422": {
+                        "description": "User or product not found, no exchange rate for the currency or Idempotency-Key reused for a different request",
+                        "schema": {
+                            "type": "string"
                         }
                     }
                 }
             }
         },
-        "/users/{id}": {
+        "/orders/{id}": {
             "get": {
-                "description": "Get a user by ID",
+                "description": "Get an order by ID",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "users"
+                    "orders"
                 ],
-                "summary": "Get a user by ID",
+                "summary": "Get an order by ID",
                 "parameters": [
                     {
                         "type": "integer",
-                        "description": "User ID",
+                        "description": "Order ID",
                         "name": "id",
                         "in": "path",
                         "required": true
@@ -716,13 +870,19 @@ const docTemplate = `{
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/main.User"
+                            "$ref": "#/definitions/main.Order"
+                        }
+                    },
+                    "403": {
+                        "description": "The order belongs to another user",
+                        "schema": {
+                            "$ref": "#/definitions/access.ErrorResponse"
                         }
                     }
                 }
             },
             "put": {
-                "description": "Update a user by ID",
+                "description": "Update an order by ID. The status cannot be changed here, use the transitions endpoint instead.",
                 "consumes": [
                     "application/json"
                 ],
@@ -730,24 +890,24 @@ const docTemplate = `{
                     "application/json"
                 ],
                 "tags": [
-                    "users"
+                    "orders"
                 ],
-                "summary": "Update a user by ID",
+                "summary": "Update an order by ID",
                 "parameters": [
                     {
                         "type": "integer",
-                        "description": "User ID",
+                        "description": "Order ID",
                         "name": "id",
                         "in": "path",
                         "required": true
                     },
                     {
-                        "description": "Update user",
-                        "name": "user",
+                        "description": "Update order",
+                        "name": "order",
                         "in": "body",
                         "required": true,
                         "schema": {
-                            "$ref": "#/definitions/main.User"
+                            "$ref": "#/definitions/main.Order"
                         }
                     }
                 ],
@@ -755,24 +915,30 @@ const docTemplate = `{
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/main.User"
+                            "$ref": "#/definitions/main.Order"
+                        }
+                    },
+                    "403": {
+                        "description": "The order belongs to another user",
+                        "schema": {
+                            "$ref": "#/definitions/access.ErrorResponse"
                         }
                     }
                 }
             },
             "delete": {
-                "description": "Delete a user by ID",
+                "description": "Delete an order by ID",
                 "produces": [
-                    "application/json"
+                    "text/plain"
                 ],
                 "tags": [
-                    "users"
+                    "orders"
                 ],
-                "summary": "Delete a user by ID",
+                "summary": "Delete an order by ID",
                 "parameters": [
                     {
                         "type": "integer",
-                        "description": "User ID",
+                        "description": "Order ID",
                         "name": "id",
                         "in": "path",
                         "required": true
@@ -784,90 +950,2878 @@ const docTemplate = `{
                         "schema": {
                             "type": "string"
                         }
+                    },
+                    "403": {
+                        "description": "The order belongs to another user",
+                        "schema": {
+                            "$ref": "#/definitions/access.ErrorResponse"
+                        }
                     }
                 }
             }
-        }
-    },
-    "definitions": {
-        "main.Order": {
-            "type": "object",
-            "required": [
-                "products",
-                "status",
-                "total_price",
-                "user_id"
-            ],
-            "properties": {
-                "id": {
-                    "type": "integer",
-                    "readOnly": true,
-                    "example": 1
-                },
-                "order_date": {
-                    "type": "string",
-                    "readOnly": true,
-                    "example": "2023-07-20T15:04:05Z"
-                },
-                "products": {
-                    "type": "array",
-                    "items": {
-                        "type": "integer"
+        },
+        "/orders/{id}/transitions": {
+            "get": {
+                "description": "Get all status transitions of an order, oldest first",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "orders"
+                ],
+                "summary": "Get the status history of an order",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Order ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
                     }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/main.OrderStatusHistory"
+                            }
+                        }
+                    },
+                    "403": {
+                        "description": "The order belongs to another user",
+                        "schema": {
+                            "$ref": "#/definitions/access.ErrorResponse"
+                        }
+                    }
+                }
+            },
+            "post": {
+                "description": "Move an order to a new status. Only transitions allowed by the order lifecycle are accepted.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "orders"
+                ],
+                "summary": "Change the status of an order",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Order ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Transition",
+                        "name": "transition",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.TransitionRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.Order"
+                        }
+                    },
+                    "403": {
+                        "description": "The order belongs to another user, or a client tried a status other than cancelled",
+                        "schema": {
+                            "$ref": "#/definitions/access.ErrorResponse"
+                        }
+                    },
+                    "409": {
+                        "description": "Illegal transition",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/payments": {
+            "get": {
+                "description": "Get all payments",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "payments"
+                ],
+                "summary": "Get all payments",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/main.Payment"
+                            }
+                        }
+                    },
+                    "403": {
+                        "description": "Only admins",
+                        "schema": {
+                            "$ref": "#/definitions/access.ErrorResponse"
+                        }
+                    }
+                }
+            },
+            "post": {
+                "description": "Create a new payment using the configured payment provider. The card is given either as card data or as a saved payment method of the user (payment_method_id); with save_card the card is saved once it is accepted. The amount must match the order total. The order is moved to awaiting_payment before the card is charged and to paid after a successful payment.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "payments"
+                ],
+                "summary": "Create a payment",
+                "parameters": [
+                    {
+                        "description": "Create payment",
+                        "name": "payment",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.PaymentRequest"
+                        }
+                    },
+                    {
+                        "type": "string",
+                        "description": "Unique key that makes the request safe to retry; a retry returns the original response instead of charging the card again",
+                        "name": "Idempotency-Key",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "$ref": "#/definitions/main.Payment"
+                        }
+                    },
+                    "403": {
+                        "description": "The payment is for another user",
+                        "schema": {
+                            "$ref": "#/definitions/access.ErrorResponse"
+                        }
+                    },
+                    "409": {
+                        "description": "Order cannot be paid",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "422": {
+                        "description": "Amount does not match the order or Idempotency-Key reused for a different request",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/payments/authorize": {
+            "post": {
+                "description": "Reserve the order total on the card without charging it. The order is moved to paid once the amount is authorized. The payment has to be captured with /payments/{id}/capture before expires_at, otherwise it is voided automatically.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "payments"
+                ],
+                "summary": "Authorize a payment",
+                "parameters": [
+                    {
+                        "description": "Authorize payment",
+                        "name": "payment",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.PaymentRequest"
+                        }
+                    },
+                    {
+                        "type": "string",
+                        "description": "Unique key that makes the request safe to retry; a retry returns the original response instead of authorizing the card again",
+                        "name": "Idempotency-Key",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.Payment"
+                        }
+                    },
+                    "403": {
+                        "description": "The payment is for another user",
+                        "schema": {
+                            "$ref": "#/definitions/access.ErrorResponse"
+                        }
+                    },
+                    "409": {
+                        "description": "Order cannot be paid",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "422": {
+                        "description": "Amount does not match the order or Idempotency-Key reused for a different request",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/payments/callbacks/epay": {
+            "post": {
+                "description": "Receives the payment result ePay posts to postLink. The callback must carry the secret_hash issued with the payment. A successful result is confirmed with ePay before the payment and its order are marked as paid. Repeated callbacks are safe.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "callbacks"
+                ],
+                "summary": "ePay payment callback",
+                "parameters": [
+                    {
+                        "description": "Payment result",
+                        "name": "callback",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.EpayCallback"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.Payment"
+                        }
+                    },
+                    "401": {
+                        "description": "Invalid callback signature",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "404": {
+                        "description": "Payment not found",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/payments/callbacks/epay/fail": {
+            "post": {
+                "description": "Receives the result ePay posts to failurePostLink and marks the payment as unsuccessful. A payment that has already succeeded is not changed.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "callbacks"
+                ],
+                "summary": "ePay failed payment callback",
+                "parameters": [
+                    {
+                        "description": "Payment result",
+                        "name": "callback",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.EpayCallback"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.Payment"
+                        }
+                    },
+                    "401": {
+                        "description": "Invalid callback signature",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "404": {
+                        "description": "Payment not found",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/payments/reconciliation": {
+            "get": {
+                "description": "Get the differences between payments and the provider found by reconciliation, newest first. Discrepancies reconciliation fixed on its own are resolved.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "reconciliation"
+                ],
+                "summary": "Get reconciliation discrepancies",
+                "parameters": [
+                    {
+                        "type": "boolean",
+                        "description": "Only resolved or only open discrepancies",
+                        "name": "resolved",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/main.PaymentDiscrepancy"
+                            }
+                        }
+                    },
+                    "403": {
+                        "description": "Only admins",
+                        "schema": {
+                            "$ref": "#/definitions/access.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/payments/{id}": {
+            "get": {
+                "description": "Get a payment by ID",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "payments"
+                ],
+                "summary": "Get a payment by ID",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Payment ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.Payment"
+                        }
+                    },
+                    "403": {
+                        "description": "The payment belongs to another user",
+                        "schema": {
+                            "$ref": "#/definitions/access.ErrorResponse"
+                        }
+                    }
+                }
+            },
+            "put": {
+                "description": "Update a payment by ID",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "payments"
+                ],
+                "summary": "Update a payment by ID",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Payment ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Update payment",
+                        "name": "payment",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.Payment"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.Payment"
+                        }
+                    },
+                    "403": {
+                        "description": "Only admins",
+                        "schema": {
+                            "$ref": "#/definitions/access.ErrorResponse"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "description": "Delete a payment by ID",
+                "produces": [
+                    "text/plain"
+                ],
+                "tags": [
+                    "payments"
+                ],
+                "summary": "Delete a payment by ID",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Payment ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Deleted",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "403": {
+                        "description": "Only admins",
+                        "schema": {
+                            "$ref": "#/definitions/access.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/payments/{id}/action": {
+            "get": {
+                "description": "Get the 3-D Secure challenge the customer has to pass for a payment in status requires_action. After the challenge the payment is finished with /payments/{id}/complete.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "payments"
+                ],
+                "summary": "Get the pending action of a payment",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Payment ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.PaymentAction"
+                        }
+                    },
+                    "403": {
+                        "description": "The payment belongs to another user",
+                        "schema": {
+                            "$ref": "#/definitions/access.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Payment not found",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "409": {
+                        "description": "Payment does not require action",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/payments/{id}/capture": {
+            "post": {
+                "description": "Charge an authorized payment. Without an amount the whole authorized amount is captured; a smaller amount captures only that part and releases the rest.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "payments"
+                ],
+                "summary": "Capture an authorized payment",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Payment ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Capture",
+                        "name": "capture",
+                        "in": "body",
+                        "schema": {
+                            "$ref": "#/definitions/main.CaptureRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.Payment"
+                        }
+                    },
+                    "403": {
+                        "description": "Only admins",
+                        "schema": {
+                            "$ref": "#/definitions/access.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Payment not found",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "409": {
+                        "description": "Payment is not authorized or the authorization has expired",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "422": {
+                        "description": "Capture exceeds the authorized amount",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/payments/{id}/chargebacks": {
+            "post": {
+                "description": "Record a chargeback reported by the provider in the ledger. A chargeback is recorded once per reference; repeating it returns the entry already posted.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "ledger"
+                ],
+                "summary": "Record a chargeback",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Payment ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Chargeback",
+                        "name": "chargeback",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.ChargebackRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Chargeback already recorded",
+                        "schema": {
+                            "$ref": "#/definitions/main.LedgerEntry"
+                        }
+                    },
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "$ref": "#/definitions/main.LedgerEntry"
+                        }
+                    },
+                    "403": {
+                        "description": "Only admins",
+                        "schema": {
+                            "$ref": "#/definitions/access.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Payment not found",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "409": {
+                        "description": "Payment has no captured amount",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "422": {
+                        "description": "Chargeback exceeds the captured amount",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/payments/{id}/complete": {
+            "post": {
+                "description": "Finish a payment in status requires_action once the customer has passed the 3-D Secure challenge. The payment is charged or authorized as originally requested and the order is moved to paid; a failed challenge makes the payment unsuccessful.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "payments"
+                ],
+                "summary": "Complete a payment after 3-D Secure",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Payment ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Challenge result",
+                        "name": "completion",
+                        "in": "body",
+                        "schema": {
+                            "$ref": "#/definitions/main.ActionCompletion"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.Payment"
+                        }
+                    },
+                    "403": {
+                        "description": "The payment belongs to another user",
+                        "schema": {
+                            "$ref": "#/definitions/access.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Payment not found",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "409": {
+                        "description": "Payment does not require action",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/payments/{id}/refunds": {
+            "get": {
+                "description": "Get all refunds of a payment, oldest first",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "refunds"
+                ],
+                "summary": "Get the refunds of a payment",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Payment ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/main.Refund"
+                            }
+                        }
+                    },
+                    "403": {
+                        "description": "The payment belongs to another user",
+                        "schema": {
+                            "$ref": "#/definitions/access.ErrorResponse"
+                        }
+                    }
+                }
+            },
+            "post": {
+                "description": "Refund a payment fully or partially through the payment provider. Without an amount everything not refunded yet is returned. Refunds together can never exceed the captured amount. The order is moved to refunded once the payment is fully refunded.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "refunds"
+                ],
+                "summary": "Refund a payment",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Payment ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Refund",
+                        "name": "refund",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.RefundRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "$ref": "#/definitions/main.Refund"
+                        }
+                    },
+                    "403": {
+                        "description": "Only admins",
+                        "schema": {
+                            "$ref": "#/definitions/access.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Payment not found",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "409": {
+                        "description": "Payment cannot be refunded",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "422": {
+                        "description": "Refund exceeds the amount left to refund",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "502": {
+                        "description": "Refund declined by the provider",
+                        "schema": {
+                            "$ref": "#/definitions/main.Refund"
+                        }
+                    }
+                }
+            }
+        },
+        "/payments/{id}/void": {
+            "post": {
+                "description": "Release the amount held on the card without charging it. The order is moved to refunded.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "payments"
+                ],
+                "summary": "Void an authorized payment",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Payment ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.Payment"
+                        }
+                    },
+                    "403": {
+                        "description": "Only admins",
+                        "schema": {
+                            "$ref": "#/definitions/access.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Payment not found",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "409": {
+                        "description": "Payment is not authorized",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/products": {
+            "get": {
+                "description": "Get all products",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "products"
+                ],
+                "summary": "Get all products",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/main.Product"
+                            }
+                        }
+                    }
+                }
+            },
+            "post": {
+                "description": "Create a new product",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "products"
+                ],
+                "summary": "Create a product",
+                "parameters": [
+                    {
+                        "description": "Create product",
+                        "name": "product",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.Product"
+                        }
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "$ref": "#/definitions/main.Product"
+                        }
+                    },
+                    "403": {
+                        "description": "Only admins",
+                        "schema": {
+                            "$ref": "#/definitions/access.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/products/{id}": {
+            "get": {
+                "description": "Get a product by ID",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "products"
+                ],
+                "summary": "Get a product by ID",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Product ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.Product"
+                        }
+                    }
+                }
+            },
+            "put": {
+                "description": "Update a product by ID",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "products"
+                ],
+                "summary": "Update a product by ID",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Product ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Update product",
+                        "name": "product",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.Product"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.Product"
+                        }
+                    },
+                    "403": {
+                        "description": "Only admins",
+                        "schema": {
+                            "$ref": "#/definitions/access.ErrorResponse"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "description": "Delete a product by ID",
+                "produces": [
+                    "text/plain"
+                ],
+                "tags": [
+                    "products"
+                ],
+                "summary": "Delete a product by ID",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Product ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Deleted",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "403": {
+                        "description": "Only admins",
+                        "schema": {
+                            "$ref": "#/definitions/access.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/products/{id}/price": {
+            "get": {
+                "description": "Get the price of a product in a currency: the product's own price in that currency if it has one, otherwise the base price converted at the current rate, rounded half to even.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "prices"
+                ],
+                "summary": "Get the price of a product in a currency",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Product ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Currency code, the base currency by default",
+                        "name": "currency",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.PriceQuote"
+                        }
+                    },
+                    "404": {
+                        "description": "Product not found",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "422": {
+                        "description": "No exchange rate for the currency",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/products/{id}/prices": {
+            "get": {
+                "description": "Get the prices of a product set in currencies other than the base currency",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "prices"
+                ],
+                "summary": "Get the price overrides of a product",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Product ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/main.ProductPrice"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/products/{id}/prices/{currency}": {
+            "put": {
+                "description": "Set the price of a product in a currency other than the base currency. It is charged instead of the converted base price.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "prices"
+                ],
+                "summary": "Set the price of a product in a currency",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Product ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Currency code",
+                        "name": "currency",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Price in minor units",
+                        "name": "price",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.Money"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.ProductPrice"
+                        }
+                    },
+                    "403": {
+                        "description": "Only admins",
+                        "schema": {
+                            "$ref": "#/definitions/access.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Product not found",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "description": "Delete a price override, so the base price is converted again",
+                "produces": [
+                    "text/plain"
+                ],
+                "tags": [
+                    "prices"
+                ],
+                "summary": "Delete the price of a product in a currency",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Product ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Currency code",
+                        "name": "currency",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Deleted",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "403": {
+                        "description": "Only admins",
+                        "schema": {
+                            "$ref": "#/definitions/access.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Price not found",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/search/orders": {
+            "get": {
+                "description": "Search orders by user or status",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "orders"
+                ],
+                "summary": "Search orders by user or status",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "User ID",
+                        "name": "user",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Order Status",
+                        "name": "status",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/main.Order"
+                            }
+                        }
+                    },
+                    "403": {
+                        "description": "Another user's orders",
+                        "schema": {
+                            "$ref": "#/definitions/access.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/search/payments": {
+            "get": {
+                "description": "Search payments by user, order, or status",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "payments"
+                ],
+                "summary": "Search payments by user, order, or status",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "User ID",
+                        "name": "user",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Order ID",
+                        "name": "order",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Payment Status",
+                        "name": "status",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/main.Payment"
+                            }
+                        }
+                    },
+                    "403": {
+                        "description": "Another user's payments",
+                        "schema": {
+                            "$ref": "#/definitions/access.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/search/products": {
+            "get": {
+                "description": "Search products by name or category",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "products"
+                ],
+                "summary": "Search products by name or category",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Product Name",
+                        "name": "name",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Product Category",
+                        "name": "category",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/main.Product"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/search/users": {
+            "get": {
+                "description": "Search users by name or role",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "users"
+                ],
+                "summary": "Search users by name or role",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Name",
+                        "name": "name",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Role",
+                        "name": "role",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/main.User"
+                            }
+                        }
+                    },
+                    "403": {
+                        "description": "Only admins",
+                        "schema": {
+                            "$ref": "#/definitions/access.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/users": {
+            "get": {
+                "description": "Get all users",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "users"
+                ],
+                "summary": "Get all users",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/main.User"
+                            }
+                        }
+                    },
+                    "403": {
+                        "description": "Only admins",
+                        "schema": {
+                            "$ref": "#/definitions/access.ErrorResponse"
+                        }
+                    }
+                }
+            },
+            "post": {
+                "description": "Create a new user",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "users"
+                ],
+                "summary": "Create a user",
+                "parameters": [
+                    {
+                        "description": "Create user",
+                        "name": "user",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.User"
+                        }
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "$ref": "#/definitions/main.User"
+                        }
+                    },
+                    "403": {
+                        "description": "Only admins",
+                        "schema": {
+                            "$ref": "#/definitions/access.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/users/{id}": {
+            "get": {
+                "description": "Get a user by ID",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "users"
+                ],
+                "summary": "Get a user by ID",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "User ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.User"
+                        }
+                    },
+                    "403": {
+                        "description": "Not the caller's user",
+                        "schema": {
+                            "$ref": "#/definitions/access.ErrorResponse"
+                        }
+                    }
+                }
+            },
+            "put": {
+                "description": "Update a user by ID. Changing the email address makes it unverified and sends a verification email to the new address.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "users"
+                ],
+                "summary": "Update a user by ID",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "User ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Update user",
+                        "name": "user",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.User"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.User"
+                        }
+                    },
+                    "403": {
+                        "description": "Not the caller's user, or a client changing their role",
+                        "schema": {
+                            "$ref": "#/definitions/access.ErrorResponse"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "description": "Delete a user by ID",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "users"
+                ],
+                "summary": "Delete a user by ID",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "User ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Deleted",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "403": {
+                        "description": "Not the caller's user",
+                        "schema": {
+                            "$ref": "#/definitions/access.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/users/{id}/2fa": {
+            "post": {
+                "description": "Create a new TOTP secret for the caller, as text and as an otpauth URI to show as a QR code. Two-factor authentication is turned on once a code is confirmed; starting again replaces an unconfirmed secret. It is mandatory for admins and optional for clients.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "two-factor"
+                ],
+                "summary": "Start enrolling in two-factor authentication",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "User ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.TOTPEnrollment"
+                        }
+                    },
+                    "403": {
+                        "description": "Not the caller's own user",
+                        "schema": {
+                            "$ref": "#/definitions/access.ErrorResponse"
+                        }
+                    },
+                    "409": {
+                        "description": "Two-factor authentication is already enabled",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/users/{id}/2fa/confirm": {
+            "post": {
+                "description": "Confirm the enrolment with a code of the authenticator app. The response has the recovery codes, shown only once, and new tokens of a session that passed two-factor authentication. Other sessions of the user are signed out.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "two-factor"
+                ],
+                "summary": "Turn on two-factor authentication",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "User ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Code of the authenticator app",
+                        "name": "code",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.TOTPCodeRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.RecoveryCodes"
+                        }
+                    },
+                    "401": {
+                        "description": "Invalid code",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "403": {
+                        "description": "Not the caller's own user",
+                        "schema": {
+                            "$ref": "#/definitions/access.ErrorResponse"
+                        }
+                    },
+                    "409": {
+                        "description": "Already enabled or not started",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "429": {
+                        "description": "Too many failed attempts",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/users/{id}/2fa/disable": {
+            "post": {
+                "description": "Turn off two-factor authentication of the caller after checking a code of the authenticator app. Admins cannot turn it off, since it is mandatory for them.",
+                "consumes": [
+                    "application/json"
+                ],
+                "tags": [
+                    "two-factor"
+                ],
+                "summary": "Turn off two-factor authentication",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "User ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Code of the authenticator app",
+                        "name": "code",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.TOTPCodeRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "No Content"
+                    },
+                    "401": {
+                        "description": "Invalid code",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "403": {
+                        "description": "Not the caller's own user, or an admin",
+                        "schema": {
+                            "$ref": "#/definitions/access.ErrorResponse"
+                        }
+                    },
+                    "409": {
+                        "description": "Two-factor authentication is not enabled",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "429": {
+                        "description": "Too many failed attempts",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/users/{id}/2fa/recovery-codes": {
+            "post": {
+                "description": "Replace the recovery codes of the caller after checking a code of the authenticator app. The old recovery codes stop working.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "two-factor"
+                ],
+                "summary": "Get new recovery codes",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "User ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Code of the authenticator app",
+                        "name": "code",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.TOTPCodeRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.RecoveryCodes"
+                        }
+                    },
+                    "401": {
+                        "description": "Invalid code",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "403": {
+                        "description": "Not the caller's own user",
+                        "schema": {
+                            "$ref": "#/definitions/access.ErrorResponse"
+                        }
+                    },
+                    "409": {
+                        "description": "Two-factor authentication is not enabled",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "429": {
+                        "description": "Too many failed attempts",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/users/{id}/2fa/reset": {
+            "post": {
+                "description": "Turn off two-factor authentication of a user who lost the authenticator and the recovery codes, and sign out all their sessions. Admins have to enrol again on their next sign in.",
+                "tags": [
+                    "two-factor"
+                ],
+                "summary": "Reset two-factor authentication of a user",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "User ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "No Content"
+                    },
+                    "403": {
+                        "description": "Only admins",
+                        "schema": {
+                            "$ref": "#/definitions/access.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "User not found",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/users/{id}/password": {
+            "post": {
+                "description": "Set a new password after checking the current one. Every refresh token of the user is revoked, so other sessions have to sign in again. Wrong current passwords count as failed logins of the account.",
+                "consumes": [
+                    "application/json"
+                ],
+                "tags": [
+                    "auth"
+                ],
+                "summary": "Change the password of a user",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "User ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Current and new password",
+                        "name": "password",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.ChangePasswordRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "No Content"
+                    },
+                    "401": {
+                        "description": "Current password is wrong",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "403": {
+                        "description": "Not the caller's user",
+                        "schema": {
+                            "$ref": "#/definitions/access.ErrorResponse"
+                        }
+                    },
+                    "429": {
+                        "description": "Too many failed attempts",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/users/{id}/payment-methods": {
+            "get": {
+                "description": "Get the payment methods a user can pay with instead of entering card data",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "payment-methods"
+                ],
+                "summary": "Get the saved cards of a user",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "User ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/main.PaymentMethod"
+                            }
+                        }
+                    },
+                    "403": {
+                        "description": "Another user's cards",
+                        "schema": {
+                            "$ref": "#/definitions/access.ErrorResponse"
+                        }
+                    }
+                }
+            },
+            "post": {
+                "description": "Save the card used for an accepted payment of the user as a payment method. Only the provider's card token and the masked card number are stored. Cards can also be saved while paying with save_card.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "payment-methods"
+                ],
+                "summary": "Save a card of a user",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "User ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Payment to save the card of",
+                        "name": "method",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.SavePaymentMethodRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "$ref": "#/definitions/main.PaymentMethod"
+                        }
+                    },
+                    "403": {
+                        "description": "Another user's cards",
+                        "schema": {
+                            "$ref": "#/definitions/access.ErrorResponse"
+                        }
+                    },
+                    "422": {
+                        "description": "Payment not found or has no saved card",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/users/{id}/payment-methods/{method_id}": {
+            "delete": {
+                "description": "Delete a payment method of a user",
+                "produces": [
+                    "text/plain"
+                ],
+                "tags": [
+                    "payment-methods"
+                ],
+                "summary": "Delete a saved card",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "User ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Payment method ID",
+                        "name": "method_id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Deleted",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "403": {
+                        "description": "Another user's cards",
+                        "schema": {
+                            "$ref": "#/definitions/access.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Payment method not found",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        }
+    },
+    "definitions": {
+        "access.ErrorResponse": {
+            "type": "object",
+            "properties": {
+                "error": {
+                    "type": "string",
+                    "example": "forbidden"
+                },
+                "message": {
+                    "type": "string",
+                    "example": "only admins may DELETE /products/1"
+                }
+            }
+        },
+        "main.AccountBalance": {
+            "type": "object",
+            "properties": {
+                "account": {
+                    "type": "string",
+                    "example": "assets:provider_clearing"
+                },
+                "balance": {
+                    "type": "integer",
+                    "example": 7500
+                },
+                "credit": {
+                    "type": "integer",
+                    "example": 2500
+                },
+                "currency": {
+                    "type": "string",
+                    "example": "KZT"
+                },
+                "debit": {
+                    "type": "integer",
+                    "example": 10000
+                }
+            }
+        },
+        "main.ActionCompletion": {
+            "type": "object",
+            "properties": {
+                "data": {
+                    "description": "Data is the challenge result returned by the card issuer, if any.",
+                    "type": "string",
+                    "example": "eyJ0cmFuc1N0YXR1cyI6IlkifQ"
+                }
+            }
+        },
+        "main.CaptureRequest": {
+            "type": "object",
+            "properties": {
+                "amount": {
+                    "$ref": "#/definitions/main.Money"
+                }
+            }
+        },
+        "main.ChangePasswordRequest": {
+            "type": "object",
+            "required": [
+                "current_password",
+                "new_password"
+            ],
+            "properties": {
+                "current_password": {
+                    "type": "string",
+                    "example": "correct horse battery"
+                },
+                "new_password": {
+                    "type": "string",
+                    "maxLength": 72,
+                    "minLength": 8,
+                    "example": "staple battery horse"
+                }
+            }
+        },
+        "main.ChargebackRequest": {
+            "type": "object",
+            "required": [
+                "reference"
+            ],
+            "properties": {
+                "amount": {
+                    "$ref": "#/definitions/main.Money"
+                },
+                "reason": {
+                    "type": "string",
+                    "maxLength": 255,
+                    "example": "Fraudulent transaction"
+                },
+                "reference": {
+                    "description": "Reference is the provider's identifier of the chargeback; a chargeback\nis recorded once per reference.",
+                    "type": "string",
+                    "maxLength": 100,
+                    "example": "CB-2023-0001"
+                }
+            }
+        },
+        "main.Checkout": {
+            "type": "object",
+            "properties": {
+                "action_url": {
+                    "type": "string",
+                    "example": "https://mock-acs.local/challenge/mock-000001"
+                },
+                "created_at": {
+                    "type": "string",
+                    "example": "2023-07-20T15:04:05Z"
+                },
+                "currency": {
+                    "type": "string",
+                    "example": "KZT"
+                },
+                "error": {
+                    "type": "string",
+                    "example": "insufficient stock"
+                },
+                "id": {
+                    "type": "integer",
+                    "readOnly": true,
+                    "example": 1
+                },
+                "items": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/main.CheckoutItem"
+                    }
+                },
+                "order_id": {
+                    "type": "integer",
+                    "example": 1
+                },
+                "payment_id": {
+                    "type": "integer",
+                    "example": 1
+                },
+                "payment_method_id": {
+                    "description": "PaymentMethodID is the saved card to charge. Card data sent with the\nrequest is never stored.",
+                    "type": "integer",
+                    "example": 0
+                },
+                "save_card": {
+                    "type": "boolean",
+                    "example": false
+                },
+                "status": {
+                    "type": "string",
+                    "enum": [
+                        "running",
+                        "requires_action",
+                        "compensating",
+                        "completed",
+                        "failed"
+                    ],
+                    "example": "completed"
+                },
+                "step": {
+                    "description": "Step is the step being run, or being compensated while the checkout\nis compensating.",
+                    "type": "string",
+                    "example": "confirm"
+                },
+                "steps": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/main.CheckoutStep"
+                    }
+                },
+                "total": {
+                    "$ref": "#/definitions/main.Money"
+                },
+                "updated_at": {
+                    "type": "string",
+                    "example": "2023-07-20T15:04:05Z"
+                },
+                "user_id": {
+                    "type": "integer",
+                    "example": 1
+                }
+            }
+        },
+        "main.CheckoutItem": {
+            "type": "object",
+            "required": [
+                "product_id",
+                "quantity"
+            ],
+            "properties": {
+                "product_id": {
+                    "type": "integer",
+                    "example": 1
+                },
+                "quantity": {
+                    "type": "integer",
+                    "example": 2
+                },
+                "unit_price": {
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/main.Money"
+                        }
+                    ],
+                    "readOnly": true
+                }
+            }
+        },
+        "main.CheckoutRequest": {
+            "type": "object",
+            "required": [
+                "items",
+                "user_id"
+            ],
+            "properties": {
+                "currency": {
+                    "type": "string",
+                    "example": "KZT"
+                },
+                "cvc": {
+                    "type": "string",
+                    "example": "636"
+                },
+                "expDate": {
+                    "type": "string",
+                    "example": "1030"
+                },
+                "hpan": {
+                    "type": "string",
+                    "example": "4003032704547597"
+                },
+                "items": {
+                    "type": "array",
+                    "minItems": 1,
+                    "items": {
+                        "$ref": "#/definitions/main.CheckoutItem"
+                    }
+                },
+                "payment_method_id": {
+                    "description": "PaymentMethodID charges a saved card of the user instead of card data.",
+                    "type": "integer",
+                    "example": 0
+                },
+                "save_card": {
+                    "type": "boolean",
+                    "example": false
+                },
+                "user_id": {
+                    "type": "integer",
+                    "example": 1
+                }
+            }
+        },
+        "main.CheckoutStep": {
+            "type": "object",
+            "properties": {
+                "action": {
+                    "type": "string",
+                    "enum": [
+                        "execute",
+                        "compensate"
+                    ],
+                    "example": "execute"
+                },
+                "created_at": {
+                    "type": "string",
+                    "example": "2023-07-20T15:04:05Z"
+                },
+                "error": {
+                    "type": "string",
+                    "example": ""
+                },
+                "result": {
+                    "type": "string",
+                    "enum": [
+                        "succeeded",
+                        "failed",
+                        "waiting"
+                    ],
+                    "example": "succeeded"
+                },
+                "step": {
+                    "type": "string",
+                    "example": "reserve_stock"
+                }
+            }
+        },
+        "main.CurrencyRate": {
+            "type": "object",
+            "required": [
+                "currency",
+                "rate"
+            ],
+            "properties": {
+                "currency": {
+                    "type": "string",
+                    "example": "USD"
+                },
+                "rate": {
+                    "type": "string",
+                    "example": "0.0021"
+                },
+                "updated_at": {
+                    "type": "string",
+                    "readOnly": true,
+                    "example": "2023-07-20T15:04:05Z"
+                }
+            }
+        },
+        "main.EmailRequest": {
+            "type": "object",
+            "required": [
+                "email"
+            ],
+            "properties": {
+                "email": {
+                    "type": "string",
+                    "example": "john.doe@example.com"
+                }
+            }
+        },
+        "main.EpayCallback": {
+            "type": "object",
+            "properties": {
+                "accountId": {
+                    "type": "string",
+                    "example": "1"
+                },
+                "amount": {
+                    "type": "number",
+                    "example": 100
+                },
+                "cardId": {
+                    "type": "string",
+                    "example": "b2c3d4e5-f6a7-4b8c-9d0e-1f2a3b4c5d6e"
+                },
+                "cardMask": {
+                    "type": "string",
+                    "example": "400303******7597"
+                },
+                "code": {
+                    "type": "string",
+                    "example": "ok"
+                },
+                "currency": {
+                    "type": "string",
+                    "example": "KZT"
+                },
+                "dateTime": {
+                    "type": "string",
+                    "example": "2023-07-20T15:04:05+06:00"
+                },
+                "description": {
+                    "type": "string",
+                    "example": "Order #1"
+                },
+                "id": {
+                    "type": "string",
+                    "example": "a5f1c3e2-7b4d-4e8f-9a0b-1c2d3e4f5a6b"
+                },
+                "invoiceId": {
+                    "type": "string",
+                    "example": "000100001"
+                },
+                "reason": {
+                    "type": "string",
+                    "example": "success"
+                },
+                "reasonCode": {
+                    "type": "integer",
+                    "example": 0
+                },
+                "reference": {
+                    "type": "string",
+                    "example": "320415146527"
+                },
+                "secret_hash": {
+                    "type": "string",
+                    "example": "3f2a..."
+                },
+                "terminal": {
+                    "type": "string",
+                    "example": "67e34d63-102f-4bd1-898e-370781d0074d"
+                }
+            }
+        },
+        "main.LedgerEntry": {
+            "type": "object",
+            "properties": {
+                "created_at": {
+                    "type": "string",
+                    "example": "2023-07-20T15:04:05Z"
+                },
+                "currency": {
+                    "type": "string",
+                    "example": "KZT"
+                },
+                "description": {
+                    "type": "string",
+                    "example": "Payment 1 captured"
+                },
+                "id": {
+                    "type": "integer",
+                    "readOnly": true,
+                    "example": 1
+                },
+                "kind": {
+                    "type": "string",
+                    "enum": [
+                        "charge",
+                        "fee",
+                        "refund",
+                        "chargeback"
+                    ],
+                    "example": "charge"
+                },
+                "lines": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/main.LedgerLine"
+                    }
+                },
+                "payment_id": {
+                    "type": "integer",
+                    "example": 1
+                },
+                "reference": {
+                    "type": "string",
+                    "example": "payment:1:charge"
+                }
+            }
+        },
+        "main.LedgerLine": {
+            "type": "object",
+            "properties": {
+                "account": {
+                    "type": "string",
+                    "example": "assets:provider_clearing"
+                },
+                "credit": {
+                    "type": "integer",
+                    "example": 0
+                },
+                "currency": {
+                    "type": "string",
+                    "example": "KZT"
+                },
+                "debit": {
+                    "type": "integer",
+                    "example": 10000
+                }
+            }
+        },
+        "main.LoginRequest": {
+            "type": "object",
+            "required": [
+                "email",
+                "password"
+            ],
+            "properties": {
+                "email": {
+                    "type": "string",
+                    "example": "john.doe@example.com"
+                },
+                "password": {
+                    "type": "string",
+                    "example": "correct horse battery"
+                }
+            }
+        },
+        "main.MFALoginRequest": {
+            "type": "object",
+            "required": [
+                "mfa_token"
+            ],
+            "properties": {
+                "code": {
+                    "description": "Code is the current code of the authenticator app. RecoveryCode can be\ngiven instead.",
+                    "type": "string",
+                    "example": "123456"
+                },
+                "mfa_token": {
+                    "type": "string",
+                    "example": "bWZhLWxvZ2luLXRva2Vu"
+                },
+                "recovery_code": {
+                    "type": "string",
+                    "example": "ABCDE-FGHIJ"
+                }
+            }
+        },
+        "main.Money": {
+            "type": "object",
+            "properties": {
+                "amount": {
+                    "type": "integer",
+                    "minimum": 0,
+                    "example": 100050
+                },
+                "currency": {
+                    "type": "string",
+                    "example": "KZT"
+                }
+            }
+        },
+        "main.Order": {
+            "type": "object",
+            "required": [
+                "items",
+                "user_id"
+            ],
+            "properties": {
+                "base_currency": {
+                    "description": "Snapshot of the exchange rate from BaseCurrency that converted prices\nwere calculated with. Empty when no price had to be converted.",
+                    "type": "string",
+                    "readOnly": true,
+                    "example": "KZT"
+                },
+                "currency": {
+                    "description": "Currency the order is priced and paid in, the shop currency by default.",
+                    "type": "string",
+                    "example": "USD"
+                },
+                "exchange_rate": {
+                    "type": "string",
+                    "readOnly": true,
+                    "example": "0.0021"
+                },
+                "id": {
+                    "type": "integer",
+                    "readOnly": true,
+                    "example": 1
+                },
+                "items": {
+                    "type": "array",
+                    "minItems": 1,
+                    "items": {
+                        "$ref": "#/definitions/main.OrderItem"
+                    }
+                },
+                "order_date": {
+                    "type": "string",
+                    "readOnly": true,
+                    "example": "2023-07-20T15:04:05Z"
+                },
+                "rate_updated_at": {
+                    "type": "string",
+                    "readOnly": true,
+                    "example": "2023-07-20T15:04:05Z"
+                },
+                "status": {
+                    "type": "string",
+                    "enum": [
+                        "new",
+                        "awaiting_payment",
+                        "paid",
+                        "in_process",
+                        "shipped",
+                        "completed",
+                        "cancelled",
+                        "refunded"
+                    ],
+                    "readOnly": true,
+                    "example": "new"
+                },
+                "total_price": {
+                    "$ref": "#/definitions/main.Money"
+                },
+                "user_id": {
+                    "type": "integer",
+                    "example": 1
+                }
+            }
+        },
+        "main.OrderItem": {
+            "type": "object",
+            "required": [
+                "product_id",
+                "quantity"
+            ],
+            "properties": {
+                "id": {
+                    "type": "integer",
+                    "readOnly": true,
+                    "example": 1
+                },
+                "line_total": {
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/main.Money"
+                        }
+                    ],
+                    "readOnly": true
+                },
+                "order_id": {
+                    "type": "integer",
+                    "readOnly": true,
+                    "example": 1
+                },
+                "product_id": {
+                    "type": "integer",
+                    "example": 1
+                },
+                "quantity": {
+                    "type": "integer",
+                    "example": 2
+                },
+                "unit_price": {
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/main.Money"
+                        }
+                    ],
+                    "readOnly": true
+                }
+            }
+        },
+        "main.OrderStatusHistory": {
+            "type": "object",
+            "properties": {
+                "changed_at": {
+                    "type": "string",
+                    "example": "2023-07-20T15:04:05Z"
+                },
+                "changed_by": {
+                    "type": "string",
+                    "example": "user:1"
+                },
+                "from_status": {
+                    "type": "string",
+                    "example": "new"
+                },
+                "id": {
+                    "type": "integer",
+                    "readOnly": true,
+                    "example": 1
+                },
+                "order_id": {
+                    "type": "integer",
+                    "example": 1
+                },
+                "reason": {
+                    "type": "string",
+                    "example": "Customer started checkout"
+                },
+                "to_status": {
+                    "type": "string",
+                    "example": "awaiting_payment"
+                }
+            }
+        },
+        "main.Payment": {
+            "type": "object",
+            "required": [
+                "order_id",
+                "status",
+                "user_id"
+            ],
+            "properties": {
+                "action_url": {
+                    "description": "ActionURL is the 3-D Secure challenge of a payment in requires_action.",
+                    "type": "string",
+                    "readOnly": true,
+                    "example": "https://mock-acs.local/challenge/mock-000001"
+                },
+                "amount": {
+                    "$ref": "#/definitions/main.Money"
+                },
+                "captured_amount": {
+                    "description": "CapturedAmount is the part of Amount actually charged.",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/main.Money"
+                        }
+                    ],
+                    "readOnly": true
+                },
+                "card_brand": {
+                    "type": "string",
+                    "readOnly": true,
+                    "example": "visa"
+                },
+                "card_id": {
+                    "type": "string",
+                    "readOnly": true,
+                    "example": "b2c3d4e5-f6a7-4b8c-9d0e-1f2a3b4c5d6e"
+                },
+                "card_mask": {
+                    "description": "Only the masked card number, the brand and the provider's card token\nare stored; the full card number and CVC never reach the database.",
+                    "type": "string",
+                    "readOnly": true,
+                    "example": "400303******7597"
+                },
+                "expires_at": {
+                    "description": "ExpiresAt is when an authorized payment is voided if it is not captured.",
+                    "type": "string",
+                    "readOnly": true,
+                    "example": "2023-07-27T15:04:05Z"
+                },
+                "id": {
+                    "type": "integer",
+                    "readOnly": true,
+                    "example": 1
+                },
+                "invoice_id": {
+                    "description": "InvoiceID identifies a single payment attempt at the provider.",
+                    "type": "string",
+                    "readOnly": true,
+                    "example": "000100001"
+                },
+                "order_id": {
+                    "type": "integer",
+                    "example": 1
+                },
+                "payment_date": {
+                    "type": "string",
+                    "readOnly": true,
+                    "example": "2023-07-20T15:04:05Z"
+                },
+                "status": {
+                    "type": "string",
+                    "enum": [
+                        "pending",
+                        "requires_action",
+                        "authorized",
+                        "successful",
+                        "unsuccessful",
+                        "voided",
+                        "expired",
+                        "partially_refunded",
+                        "refunded"
+                    ],
+                    "example": "successful"
+                },
+                "transaction_id": {
+                    "type": "string",
+                    "readOnly": true,
+                    "example": "9a1f3c2e-5a6b-4c7d-8e9f-0a1b2c3d4e5f"
+                },
+                "user_id": {
+                    "type": "integer",
+                    "example": 1
+                }
+            }
+        },
+        "main.PaymentAction": {
+            "type": "object",
+            "properties": {
+                "payment_id": {
+                    "type": "integer",
+                    "example": 1
+                },
+                "type": {
+                    "type": "string",
+                    "example": "redirect_3ds"
+                },
+                "url": {
+                    "type": "string",
+                    "example": "https://mock-acs.local/challenge/mock-000001"
+                }
+            }
+        },
+        "main.PaymentDiscrepancy": {
+            "type": "object",
+            "properties": {
+                "detected_at": {
+                    "type": "string",
+                    "example": "2023-07-20T15:04:05Z"
+                },
+                "id": {
+                    "type": "integer",
+                    "readOnly": true,
+                    "example": 1
+                },
+                "invoice_id": {
+                    "type": "string",
+                    "example": "000100001"
+                },
+                "kind": {
+                    "type": "string",
+                    "enum": [
+                        "stale_status",
+                        "status_mismatch",
+                        "amount_mismatch",
+                        "missing_at_provider"
+                    ],
+                    "example": "status_mismatch"
                 },
-                "status": {
-                    "type": "string",
-                    "enum": [
-                        "new",
-                        "in_process",
-                        "completed"
-                    ],
-                    "example": "new"
-                },
-                "total_price": {
-                    "type": "number",
-                    "example": 100.5
+                "local_amount": {
+                    "$ref": "#/definitions/main.Money"
                 },
-                "user_id": {
+                "local_status": {
+                    "type": "string",
+                    "example": "successful"
+                },
+                "note": {
+                    "type": "string",
+                    "example": "payment is successful locally but declined at the provider"
+                },
+                "payment_id": {
                     "type": "integer",
                     "example": 1
+                },
+                "provider_amount": {
+                    "$ref": "#/definitions/main.Money"
+                },
+                "provider_status": {
+                    "type": "string",
+                    "example": "declined"
+                },
+                "resolved": {
+                    "type": "boolean",
+                    "example": false
                 }
             }
         },
-        "main.Payment": {
+        "main.PaymentMethod": {
             "type": "object",
-            "required": [
-                "amount",
-                "order_id",
-                "status",
-                "user_id"
-            ],
             "properties": {
-                "amount": {
-                    "type": "number",
-                    "example": 100
-                },
-                "id": {
-                    "type": "integer",
-                    "readOnly": true,
-                    "example": 1
+                "card_brand": {
+                    "type": "string",
+                    "example": "visa"
                 },
-                "order_id": {
-                    "type": "integer",
-                    "example": 1
+                "card_mask": {
+                    "type": "string",
+                    "example": "400303******7597"
                 },
-                "payment_date": {
+                "created_at": {
                     "type": "string",
                     "readOnly": true,
                     "example": "2023-07-20T15:04:05Z"
                 },
-                "status": {
-                    "type": "string",
-                    "enum": [
-                        "successful",
-                        "unsuccessful"
-                    ],
-                    "example": "successful"
+                "id": {
+                    "type": "integer",
+                    "readOnly": true,
+                    "example": 1
                 },
                 "user_id": {
                     "type": "integer",
@@ -878,26 +3832,22 @@ const docTemplate = `{
         "main.PaymentRequest": {
             "type": "object",
             "required": [
-                "amount",
-                "cvc",
-                "expDate",
-                "hpan",
                 "order_id",
-                "terminalId",
                 "user_id"
             ],
             "properties": {
                 "amount": {
-                    "type": "number",
-                    "example": 100
+                    "$ref": "#/definitions/main.Money"
                 },
                 "cvc": {
                     "type": "string",
+                    "maxLength": 4,
+                    "minLength": 3,
                     "example": "636"
                 },
                 "expDate": {
                     "type": "string",
-                    "example": "1022"
+                    "example": "1030"
                 },
                 "hpan": {
                     "type": "string",
@@ -907,9 +3857,15 @@ const docTemplate = `{
                     "type": "integer",
                     "example": 1
                 },
-                "terminalId": {
-                    "type": "string",
-                    "example": "67e34d63-102f-4bd1-898e-370781d0074d"
+                "payment_method_id": {
+                    "description": "PaymentMethodID charges a saved card of the user instead of card data.",
+                    "type": "integer",
+                    "example": 0
+                },
+                "save_card": {
+                    "description": "SaveCard saves the card as a payment method of the user once it is accepted.",
+                    "type": "boolean",
+                    "example": false
                 },
                 "user_id": {
                     "type": "integer",
@@ -917,12 +3873,44 @@ const docTemplate = `{
                 }
             }
         },
+        "main.PriceQuote": {
+            "type": "object",
+            "properties": {
+                "base_price": {
+                    "$ref": "#/definitions/main.Money"
+                },
+                "price": {
+                    "$ref": "#/definitions/main.Money"
+                },
+                "product_id": {
+                    "type": "integer",
+                    "example": 1
+                },
+                "rate": {
+                    "type": "string",
+                    "example": "0.0021"
+                },
+                "rate_updated_at": {
+                    "type": "string",
+                    "example": "2023-07-20T15:04:05Z"
+                },
+                "source": {
+                    "description": "Source tells where the price comes from: the base price itself, a\nProductPrice override, or the base price converted at Rate.",
+                    "type": "string",
+                    "enum": [
+                        "base",
+                        "override",
+                        "converted"
+                    ],
+                    "example": "converted"
+                }
+            }
+        },
         "main.Product": {
             "type": "object",
             "required": [
                 "category",
-                "name",
-                "price"
+                "name"
             ],
             "properties": {
                 "category": {
@@ -948,8 +3936,7 @@ const docTemplate = `{
                     "example": "Laptop"
                 },
                 "price": {
-                    "type": "number",
-                    "example": 1000.5
+                    "$ref": "#/definitions/main.Money"
                 },
                 "stock": {
                     "type": "integer",
@@ -958,6 +3945,270 @@ const docTemplate = `{
                 }
             }
         },
+        "main.ProductPrice": {
+            "type": "object",
+            "properties": {
+                "id": {
+                    "type": "integer",
+                    "readOnly": true,
+                    "example": 1
+                },
+                "price": {
+                    "$ref": "#/definitions/main.Money"
+                },
+                "product_id": {
+                    "type": "integer",
+                    "readOnly": true,
+                    "example": 1
+                },
+                "updated_at": {
+                    "type": "string",
+                    "readOnly": true,
+                    "example": "2023-07-20T15:04:05Z"
+                }
+            }
+        },
+        "main.RecoveryCodes": {
+            "type": "object",
+            "properties": {
+                "recovery_codes": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    },
+                    "example": [
+                        "ABCDE-FGHIJ",
+                        "KLMNO-PQRST"
+                    ]
+                },
+                "tokens": {
+                    "$ref": "#/definitions/main.TokenResponse"
+                }
+            }
+        },
+        "main.RefreshRequest": {
+            "type": "object",
+            "required": [
+                "refresh_token"
+            ],
+            "properties": {
+                "refresh_token": {
+                    "type": "string",
+                    "example": "b3BhcXVlLXJhbmRvbS10b2tlbg"
+                }
+            }
+        },
+        "main.Refund": {
+            "type": "object",
+            "properties": {
+                "amount": {
+                    "$ref": "#/definitions/main.Money"
+                },
+                "created_at": {
+                    "type": "string",
+                    "readOnly": true,
+                    "example": "2023-07-20T15:04:05Z"
+                },
+                "id": {
+                    "type": "integer",
+                    "readOnly": true,
+                    "example": 1
+                },
+                "message": {
+                    "type": "string",
+                    "example": "insufficient funds on merchant account"
+                },
+                "payment_id": {
+                    "type": "integer",
+                    "example": 1
+                },
+                "reason": {
+                    "type": "string",
+                    "example": "Item returned"
+                },
+                "status": {
+                    "type": "string",
+                    "enum": [
+                        "pending",
+                        "successful",
+                        "unsuccessful"
+                    ],
+                    "example": "successful"
+                },
+                "transaction_id": {
+                    "type": "string",
+                    "example": "9a1f3c2e-5a6b-4c7d-8e9f-0a1b2c3d4e5f"
+                }
+            }
+        },
+        "main.RefundRequest": {
+            "type": "object",
+            "properties": {
+                "amount": {
+                    "$ref": "#/definitions/main.Money"
+                },
+                "reason": {
+                    "type": "string",
+                    "maxLength": 255,
+                    "example": "Item returned"
+                }
+            }
+        },
+        "main.RegisterRequest": {
+            "type": "object",
+            "required": [
+                "email",
+                "name",
+                "password"
+            ],
+            "properties": {
+                "address": {
+                    "type": "string",
+                    "example": "123 Main St"
+                },
+                "email": {
+                    "type": "string",
+                    "example": "john.doe@example.com"
+                },
+                "name": {
+                    "type": "string",
+                    "example": "John Doe"
+                },
+                "password": {
+                    "type": "string",
+                    "maxLength": 72,
+                    "minLength": 8,
+                    "example": "correct horse battery"
+                },
+                "phone": {
+                    "type": "string",
+                    "maxLength": 15,
+                    "minLength": 10,
+                    "example": "77771234567"
+                }
+            }
+        },
+        "main.ResetPasswordRequest": {
+            "type": "object",
+            "required": [
+                "new_password",
+                "token"
+            ],
+            "properties": {
+                "new_password": {
+                    "type": "string",
+                    "maxLength": 72,
+                    "minLength": 8,
+                    "example": "staple battery horse"
+                },
+                "token": {
+                    "type": "string",
+                    "example": "b3BhcXVlLXJhbmRvbS10b2tlbg"
+                }
+            }
+        },
+        "main.SavePaymentMethodRequest": {
+            "type": "object",
+            "required": [
+                "payment_id"
+            ],
+            "properties": {
+                "payment_id": {
+                    "type": "integer",
+                    "example": 1
+                }
+            }
+        },
+        "main.TOTPCodeRequest": {
+            "type": "object",
+            "required": [
+                "code"
+            ],
+            "properties": {
+                "code": {
+                    "type": "string",
+                    "example": "123456"
+                }
+            }
+        },
+        "main.TOTPEnrollment": {
+            "type": "object",
+            "properties": {
+                "otpauth_uri": {
+                    "type": "string",
+                    "example": "otpauth://totp/HL%20Online%20Shop:john.doe@example.com?algorithm=SHA1\u0026digits=6\u0026issuer=HL+Online+Shop\u0026period=30\u0026secret=JBSWY3DPEHPK3PXPJBSWY3DPEHPK3PXP"
+                },
+                "secret": {
+                    "type": "string",
+                    "example": "JBSWY3DPEHPK3PXPJBSWY3DPEHPK3PXP"
+                }
+            }
+        },
+        "main.TokenResponse": {
+            "type": "object",
+            "properties": {
+                "access_token": {
+                    "type": "string",
+                    "example": "eyJhbGciOiJIUzI1NiIsInR5cCI6IkpXVCJ9..."
+                },
+                "expires_in": {
+                    "type": "integer",
+                    "example": 900
+                },
+                "mfa_enrollment_required": {
+                    "description": "MFAEnrollmentRequired is set for an admin without two-factor\nauthentication, whose tokens only have the rights of a client until\nthey enrol.",
+                    "type": "boolean",
+                    "example": false
+                },
+                "mfa_required": {
+                    "type": "boolean",
+                    "example": false
+                },
+                "mfa_token": {
+                    "type": "string",
+                    "example": "bWZhLWxvZ2luLXRva2Vu"
+                },
+                "refresh_token": {
+                    "type": "string",
+                    "example": "b3BhcXVlLXJhbmRvbS10b2tlbg"
+                },
+                "token_type": {
+                    "type": "string",
+                    "example": "Bearer"
+                }
+            }
+        },
+        "main.TransitionRequest": {
+            "type": "object",
+            "required": [
+                "changed_by",
+                "status"
+            ],
+            "properties": {
+                "changed_by": {
+                    "type": "string",
+                    "example": "user:1"
+                },
+                "reason": {
+                    "type": "string",
+                    "example": "Customer started checkout"
+                },
+                "status": {
+                    "type": "string",
+                    "enum": [
+                        "new",
+                        "awaiting_payment",
+                        "paid",
+                        "in_process",
+                        "shipped",
+                        "completed",
+                        "cancelled",
+                        "refunded"
+                    ],
+                    "example": "awaiting_payment"
+                }
+            }
+        },
         "main.User": {
             "type": "object",
             "required": [
@@ -974,6 +4225,12 @@ const docTemplate = `{
                     "type": "string",
                     "example": "john.doe@example.com"
                 },
+                "email_verified_at": {
+                    "description": "EmailVerifiedAt is when the user confirmed the email address, nil until\nthen. Unverified users cannot place orders.",
+                    "type": "string",
+                    "readOnly": true,
+                    "example": "2023-07-20T15:10:00Z"
+                },
                 "id": {
                     "type": "integer",
                     "readOnly": true,
@@ -983,6 +4240,12 @@ const docTemplate = `{
                     "type": "string",
                     "example": "John Doe"
                 },
+                "phone": {
+                    "type": "string",
+                    "maxLength": 15,
+                    "minLength": 10,
+                    "example": "77771234567"
+                },
                 "registrationAt": {
                     "type": "string",
                     "readOnly": true,
@@ -995,6 +4258,24 @@ const docTemplate = `{
                         "client"
                     ],
                     "example": "client"
+                },
+                "totp_enabled_at": {
+                    "description": "TOTPEnabledAt is when two-factor authentication was turned on, nil\nwhile it is off. It is mandatory for admins.",
+                    "type": "string",
+                    "readOnly": true,
+                    "example": "2023-07-20T15:20:00Z"
+                }
+            }
+        },
+        "main.VerifyEmailRequest": {
+            "type": "object",
+            "required": [
+                "token"
+            ],
+            "properties": {
+                "token": {
+                    "type": "string",
+                    "example": "b3BhcXVlLXJhbmRvbS10b2tlbg"
                 }
             }
         }
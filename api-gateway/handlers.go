@@ -1,8 +1,10 @@
 package main
 
 import (
+	"HL_online_shop/common/access"
 	"github.com/gorilla/mux"
 	"io/ioutil"
+	"net"
 	"net/http"
 )
 
@@ -24,7 +26,17 @@ func proxyRequest(w http.ResponseWriter, r *http.Request, url string) {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	req.Header = r.Header
+	// Заголовки копируются, чтобы Idempotency-Key и остальные дошли до сервиса
+	req.Header = r.Header.Clone()
+	// Адрес клиента для лимита попыток входа; значение от клиента перезаписывается
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		req.Header.Set("X-Forwarded-For", host)
+	}
+	req.Header.Set(access.HeaderInternalToken, string(authConfig.InternalToken))
+	if req.Header.Get(access.HeaderUserID) == "" {
+		// Без пользователя сервисы приняли бы запрос за вызов другого сервиса
+		req.Header.Set(access.HeaderAnonymous, "true")
+	}
 
 	resp, err := client.Do(req)
 	if err != nil {
@@ -54,6 +66,7 @@ func proxyRequest(w http.ResponseWriter, r *http.Request, url string) {
 // @Tags users
 // @Produce json
 // @Success 200 {array} User
+// @Failure 403 {object} access.ErrorResponse "Only admins"
 // @Router /users [get]
 func handleUsers(w http.ResponseWriter, r *http.Request) {
 	proxyRequest(w, r, "http://user-service:8081/users")
@@ -66,6 +79,7 @@ func handleUsers(w http.ResponseWriter, r *http.Request) {
 // @Produce json
 // @Param id path int true "User ID"
 // @Success 200 {object} User
+// @Failure 403 {object} access.ErrorResponse "Not the caller's user"
 // @Router /users/{id} [get]
 func handleUserByID(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
@@ -81,6 +95,7 @@ func handleUserByID(w http.ResponseWriter, r *http.Request) {
 // @Produce json
 // @Param user body User true "Create user"
 // @Success 201 {object} User
+// @Failure 403 {object} access.ErrorResponse "Only admins"
 // @Router /users [post]
 func handleCreateUser(w http.ResponseWriter, r *http.Request) {
 	proxyRequest(w, r, "http://user-service:8081/users")
@@ -88,13 +103,14 @@ func handleCreateUser(w http.ResponseWriter, r *http.Request) {
 
 // UpdateUser godoc
 // @Summary Update a user by ID
-// @Description Update a user by ID
+// @Description Update a user by ID. Changing the email address makes it unverified and sends a verification email to the new address.
 // @Tags users
 // @Accept json
 // @Produce json
 // @Param id path int true "User ID"
 // @Param user body User true "Update user"
 // @Success 200 {object} User
+// @Failure 403 {object} access.ErrorResponse "Not the caller's user, or a client changing their role"
 // @Router /users/{id} [put]
 func handleUpdateUser(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
@@ -109,6 +125,7 @@ func handleUpdateUser(w http.ResponseWriter, r *http.Request) {
 // @Produce json
 // @Param id path int true "User ID"
 // @Success 200 {string} string "Deleted"
+// @Failure 403 {object} access.ErrorResponse "Not the caller's user"
 // @Router /users/{id} [delete]
 func handleDeleteUser(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
@@ -124,6 +141,7 @@ func handleDeleteUser(w http.ResponseWriter, r *http.Request) {
 // @Param name query string false "Name"
 // @Param role query string false "Role"
 // @Success 200 {array} User
+// @Failure 403 {object} access.ErrorResponse "Only admins"
 // @Router /search/users [get]
 func handleSearchUsers(w http.ResponseWriter, r *http.Request) {
 	name := r.URL.Query().Get("name")
@@ -131,6 +149,234 @@ func handleSearchUsers(w http.ResponseWriter, r *http.Request) {
 	proxyRequest(w, r, "http://user-service:8081/search/users?name="+name+"&role="+role)
 }
 
+// ChangePassword godoc
+// @Summary Change the password of a user
+// @Description Set a new password after checking the current one. Every refresh token of the user is revoked, so other sessions have to sign in again. Wrong current passwords count as failed logins of the account.
+// @Tags auth
+// @Accept json
+// @Param id path int true "User ID"
+// @Param password body ChangePasswordRequest true "Current and new password"
+// @Success 204
+// @Failure 401 {string} string "Current password is wrong"
+// @Failure 403 {object} access.ErrorResponse "Not the caller's user"
+// @Failure 429 {string} string "Too many failed attempts"
+// @Router /users/{id}/password [post]
+func handleChangePassword(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+	proxyRequest(w, r, "http://user-service:8081/users/"+id+"/password")
+}
+
+// StartTOTPEnrollment godoc
+// @Summary Start enrolling in two-factor authentication
+// @Description Create a new TOTP secret for the caller, as text and as an otpauth URI to show as a QR code. Two-factor authentication is turned on once a code is confirmed; starting again replaces an unconfirmed secret. It is mandatory for admins and optional for clients.
+// @Tags two-factor
+// @Produce json
+// @Param id path int true "User ID"
+// @Success 200 {object} TOTPEnrollment
+// @Failure 403 {object} access.ErrorResponse "Not the caller's own user"
+// @Failure 409 {string} string "Two-factor authentication is already enabled"
+// @Router /users/{id}/2fa [post]
+func handleStartTOTPEnrollment(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+	proxyRequest(w, r, "http://user-service:8081/users/"+id+"/2fa")
+}
+
+// ConfirmTOTPEnrollment godoc
+// @Summary Turn on two-factor authentication
+// @Description Confirm the enrolment with a code of the authenticator app. The response has the recovery codes, shown only once, and new tokens of a session that passed two-factor authentication. Other sessions of the user are signed out.
+// @Tags two-factor
+// @Accept json
+// @Produce json
+// @Param id path int true "User ID"
+// @Param code body TOTPCodeRequest true "Code of the authenticator app"
+// @Success 200 {object} RecoveryCodes
+// @Failure 401 {string} string "Invalid code"
+// @Failure 403 {object} access.ErrorResponse "Not the caller's own user"
+// @Failure 409 {string} string "Already enabled or not started"
+// @Failure 429 {string} string "Too many failed attempts"
+// @Router /users/{id}/2fa/confirm [post]
+func handleConfirmTOTPEnrollment(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+	proxyRequest(w, r, "http://user-service:8081/users/"+id+"/2fa/confirm")
+}
+
+// RegenerateRecoveryCodes godoc
+// @Summary Get new recovery codes
+// @Description Replace the recovery codes of the caller after checking a code of the authenticator app. The old recovery codes stop working.
+// @Tags two-factor
+// @Accept json
+// @Produce json
+// @Param id path int true "User ID"
+// @Param code body TOTPCodeRequest true "Code of the authenticator app"
+// @Success 200 {object} RecoveryCodes
+// @Failure 401 {string} string "Invalid code"
+// @Failure 403 {object} access.ErrorResponse "Not the caller's own user"
+// @Failure 409 {string} string "Two-factor authentication is not enabled"
+// @Failure 429 {string} string "Too many failed attempts"
+// @Router /users/{id}/2fa/recovery-codes [post]
+func handleRegenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+	proxyRequest(w, r, "http://user-service:8081/users/"+id+"/2fa/recovery-codes")
+}
+
+// DisableTOTP godoc
+// @Summary Turn off two-factor authentication
+// @Description Turn off two-factor authentication of the caller after checking a code of the authenticator app. Admins cannot turn it off, since it is mandatory for them.
+// @Tags two-factor
+// @Accept json
+// @Param id path int true "User ID"
+// @Param code body TOTPCodeRequest true "Code of the authenticator app"
+// @Success 204
+// @Failure 401 {string} string "Invalid code"
+// @Failure 403 {object} access.ErrorResponse "Not the caller's own user, or an admin"
+// @Failure 409 {string} string "Two-factor authentication is not enabled"
+// @Failure 429 {string} string "Too many failed attempts"
+// @Router /users/{id}/2fa/disable [post]
+func handleDisableTOTP(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+	proxyRequest(w, r, "http://user-service:8081/users/"+id+"/2fa/disable")
+}
+
+// ResetTOTP godoc
+// @Summary Reset two-factor authentication of a user
+// @Description Turn off two-factor authentication of a user who lost the authenticator and the recovery codes, and sign out all their sessions. Admins have to enrol again on their next sign in.
+// @Tags two-factor
+// @Param id path int true "User ID"
+// @Success 204
+// @Failure 403 {object} access.ErrorResponse "Only admins"
+// @Failure 404 {string} string "User not found"
+// @Router /users/{id}/2fa/reset [post]
+func handleResetTOTP(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+	proxyRequest(w, r, "http://user-service:8081/users/"+id+"/2fa/reset")
+}
+
+// Register godoc
+// @Summary Register a customer
+// @Description Create a client account that can sign in with its email and password. Passwords are stored as bcrypt hashes. A verification email is sent to the address; orders can only be placed once it is verified.
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param user body RegisterRequest true "New account"
+// @Success 201 {object} User
+// @Failure 409 {string} string "Email already registered"
+// @Router /auth/register [post]
+func handleRegister(w http.ResponseWriter, r *http.Request) {
+	proxyRequest(w, r, "http://user-service:8081/auth/register")
+}
+
+// Login godoc
+// @Summary Sign in
+// @Description Sign in with email and password and get an access token and a refresh token. If the user has two-factor authentication, the response only has mfa_required and an mfa_token to finish signing in at /auth/login/mfa. Admins without two-factor authentication get tokens with mfa_enrollment_required, which only have the rights of a client until they enrol. Failed attempts are limited per account and per IP address; over the limit the response is 429 with Retry-After.
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param credentials body LoginRequest true "Credentials"
+// @Success 200 {object} TokenResponse
+// @Failure 401 {string} string "Invalid email or password"
+// @Failure 429 {string} string "Too many failed attempts"
+// @Router /auth/login [post]
+func handleLogin(w http.ResponseWriter, r *http.Request) {
+	proxyRequest(w, r, "http://user-service:8081/auth/login")
+}
+
+// LoginMFA godoc
+// @Summary Finish signing in with two-factor authentication
+// @Description Exchange the mfa_token from /auth/login and a code of the authenticator app, or a recovery code, for an access token and a refresh token. The mfa_token expires after 5 minutes and works once. Wrong codes count as failed logins of the account.
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param login body MFALoginRequest true "Token from the first step and a code"
+// @Success 200 {object} TokenResponse
+// @Failure 401 {string} string "Invalid code or expired token"
+// @Failure 429 {string} string "Too many failed attempts"
+// @Router /auth/login/mfa [post]
+func handleLoginMFA(w http.ResponseWriter, r *http.Request) {
+	proxyRequest(w, r, "http://user-service:8081/auth/login/mfa")
+}
+
+// RefreshTokens godoc
+// @Summary Refresh the access token
+// @Description Exchange a refresh token for a new access token and a new refresh token. Each refresh token can be used once; using a revoked one again revokes every session of the user, since the token was probably stolen.
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param token body RefreshRequest true "Refresh token"
+// @Success 200 {object} TokenResponse
+// @Failure 401 {string} string "Invalid or expired refresh token"
+// @Router /auth/refresh [post]
+func handleRefreshTokens(w http.ResponseWriter, r *http.Request) {
+	proxyRequest(w, r, "http://user-service:8081/auth/refresh")
+}
+
+// Logout godoc
+// @Summary Sign out
+// @Description Revoke a refresh token. The access token stays valid until it expires, which is why it is short-lived.
+// @Tags auth
+// @Accept json
+// @Param token body RefreshRequest true "Refresh token"
+// @Success 204
+// @Router /auth/logout [post]
+func handleLogout(w http.ResponseWriter, r *http.Request) {
+	proxyRequest(w, r, "http://user-service:8081/auth/logout")
+}
+
+// VerifyEmail godoc
+// @Summary Verify an email address
+// @Description Confirm the address with the token from the verification email. A token works once, expires after EMAIL_VERIFICATION_TTL and does not verify an address the user changed to afterwards.
+// @Tags auth
+// @Accept json
+// @Param token body VerifyEmailRequest true "Token from the email"
+// @Success 204
+// @Failure 400 {string} string "Invalid, used or expired token"
+// @Router /auth/verify-email [post]
+func handleVerifyEmail(w http.ResponseWriter, r *http.Request) {
+	proxyRequest(w, r, "http://user-service:8081/auth/verify-email")
+}
+
+// ResendVerification godoc
+// @Summary Send the verification email again
+// @Description Send a new verification link to an unverified address. Earlier links stop working. The response is the same whether or not the address is registered, and at most 3 emails an hour are sent to an address.
+// @Tags auth
+// @Accept json
+// @Param email body EmailRequest true "Address to verify"
+// @Success 202
+// @Router /auth/verify-email/resend [post]
+func handleResendVerification(w http.ResponseWriter, r *http.Request) {
+	proxyRequest(w, r, "http://user-service:8081/auth/verify-email/resend")
+}
+
+// RequestPasswordReset godoc
+// @Summary Request a password reset
+// @Description Email a link to set a new password. Earlier links stop working. The response is the same whether or not the address is registered, and at most 3 emails an hour are sent to an address.
+// @Tags auth
+// @Accept json
+// @Param email body EmailRequest true "Address of the account"
+// @Success 202
+// @Router /auth/password-reset/request [post]
+func handleRequestPasswordReset(w http.ResponseWriter, r *http.Request) {
+	proxyRequest(w, r, "http://user-service:8081/auth/password-reset/request")
+}
+
+// ResetPassword godoc
+// @Summary Reset the password
+// @Description Set a new password with the token from the reset email. The token works once and expires after PASSWORD_RESET_TTL. Every refresh token of the user is revoked, and the address is verified since the user received the email.
+// @Tags auth
+// @Accept json
+// @Param reset body ResetPasswordRequest true "Token from the email and new password"
+// @Success 204
+// @Failure 400 {string} string "Invalid, used or expired token"
+// @Router /auth/password-reset [post]
+func handleResetPassword(w http.ResponseWriter, r *http.Request) {
+	proxyRequest(w, r, "http://user-service:8081/auth/password-reset")
+}
+
 // GetProducts godoc
 // @Summary Get all products
 // @Description Get all products
@@ -164,6 +410,7 @@ func handleProductByID(w http.ResponseWriter, r *http.Request) {
 // @Produce json
 // @Param product body Product true "Create product"
 // @Success 201 {object} Product
+// @Failure 403 {object} access.ErrorResponse "Only admins"
 // @Router /products [post]
 func handleCreateProduct(w http.ResponseWriter, r *http.Request) {
 	proxyRequest(w, r, "http://product-service:8082/products")
@@ -178,6 +425,7 @@ func handleCreateProduct(w http.ResponseWriter, r *http.Request) {
 // @Param id path int true "Product ID"
 // @Param product body Product true "Update product"
 // @Success 200 {object} Product
+// @Failure 403 {object} access.ErrorResponse "Only admins"
 // @Router /products/{id} [put]
 func handleUpdateProduct(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
@@ -192,6 +440,7 @@ func handleUpdateProduct(w http.ResponseWriter, r *http.Request) {
 // @Produce plain
 // @Param id path int true "Product ID"
 // @Success 200 {string} string "Deleted"
+// @Failure 403 {object} access.ErrorResponse "Only admins"
 // @Router /products/{id} [delete]
 func handleDeleteProduct(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
@@ -214,12 +463,137 @@ func handleSearchProducts(w http.ResponseWriter, r *http.Request) {
 	proxyRequest(w, r, "http://product-service:8082/search/products?name="+name+"&category="+category)
 }
 
+// GetProductPrice godoc
+// @Summary Get the price of a product in a currency
+// @Description Get the price of a product in a currency: the product's own price in that currency if it has one, otherwise the base price converted at the current rate, rounded half to even.
+// @Tags prices
+// @Produce json
+// @Param id path int true "Product ID"
+// @Param currency query string false "Currency code, the base currency by default"
+// @Success 200 {object} PriceQuote
+// @Failure 404 {string} string "Product not found"
+// @Failure 422 {string} string "No exchange rate for the currency"
+// @Router /products/{id}/price [get]
+func handleProductPrice(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	url := "http://product-service:8082/products/" + vars["id"] + "/price"
+	if r.URL.RawQuery != "" {
+		url += "?" + r.URL.RawQuery
+	}
+	proxyRequest(w, r, url)
+}
+
+// GetProductPrices godoc
+// @Summary Get the price overrides of a product
+// @Description Get the prices of a product set in currencies other than the base currency
+// @Tags prices
+// @Produce json
+// @Param id path int true "Product ID"
+// @Success 200 {array} ProductPrice
+// @Router /products/{id}/prices [get]
+func handleProductPrices(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	proxyRequest(w, r, "http://product-service:8082/products/"+vars["id"]+"/prices")
+}
+
+// SetProductPrice godoc
+// @Summary Set the price of a product in a currency
+// @Description Set the price of a product in a currency other than the base currency. It is charged instead of the converted base price.
+// @Tags prices
+// @Accept json
+// @Produce json
+// @Param id path int true "Product ID"
+// @Param currency path string true "Currency code"
+// @Param price body Money true "Price in minor units"
+// @Success 200 {object} ProductPrice
+// @Failure 404 {string} string "Product not found"
+// @Failure 403 {object} access.ErrorResponse "Only admins"
+// @Router /products/{id}/prices/{currency} [put]
+func handleSetProductPrice(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	proxyRequest(w, r, "http://product-service:8082/products/"+vars["id"]+"/prices/"+vars["currency"])
+}
+
+// DeleteProductPrice godoc
+// @Summary Delete the price of a product in a currency
+// @Description Delete a price override, so the base price is converted again
+// @Tags prices
+// @Produce plain
+// @Param id path int true "Product ID"
+// @Param currency path string true "Currency code"
+// @Success 200 {string} string "Deleted"
+// @Failure 404 {string} string "Price not found"
+// @Failure 403 {object} access.ErrorResponse "Only admins"
+// @Router /products/{id}/prices/{currency} [delete]
+func handleDeleteProductPrice(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	proxyRequest(w, r, "http://product-service:8082/products/"+vars["id"]+"/prices/"+vars["currency"])
+}
+
+// GetCurrencyRates godoc
+// @Summary Get the exchange rates
+// @Description Get how many units of each currency one unit of the base currency is worth
+// @Tags currency-rates
+// @Produce json
+// @Success 200 {array} CurrencyRate
+// @Router /currency-rates [get]
+func handleCurrencyRates(w http.ResponseWriter, r *http.Request) {
+	proxyRequest(w, r, "http://product-service:8082/currency-rates")
+}
+
+// SetCurrencyRate godoc
+// @Summary Set an exchange rate
+// @Description Set how many units of a currency one unit of the base currency is worth. Orders placed before keep the rate they were priced with.
+// @Tags currency-rates
+// @Accept json
+// @Produce json
+// @Param currency path string true "Currency code"
+// @Param rate body CurrencyRate true "Rate"
+// @Success 200 {object} CurrencyRate
+// @Failure 403 {object} access.ErrorResponse "Only admins"
+// @Router /currency-rates/{currency} [put]
+func handleSetCurrencyRate(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	proxyRequest(w, r, "http://product-service:8082/currency-rates/"+vars["currency"])
+}
+
+// ImportCurrencyRates godoc
+// @Summary Import exchange rates from CSV
+// @Description Create or replace exchange rates from a CSV file with currency,rate lines, e.g. USD,0.0021. A header line is allowed. Either every rate of the file is saved or none is.
+// @Tags currency-rates
+// @Accept text/csv
+// @Produce json
+// @Param rates body string true "CSV file"
+// @Success 200 {array} CurrencyRate
+// @Failure 400 {string} string "Invalid line"
+// @Failure 403 {object} access.ErrorResponse "Only admins"
+// @Router /currency-rates/import [post]
+func handleImportCurrencyRates(w http.ResponseWriter, r *http.Request) {
+	proxyRequest(w, r, "http://product-service:8082/currency-rates/import")
+}
+
+// DeleteCurrencyRate godoc
+// @Summary Delete an exchange rate
+// @Description Delete an exchange rate. Products without a price in the currency can no longer be ordered in it.
+// @Tags currency-rates
+// @Produce plain
+// @Param currency path string true "Currency code"
+// @Success 200 {string} string "Deleted"
+// @Failure 404 {string} string "Rate not found"
+// @Failure 403 {object} access.ErrorResponse "Only admins"
+// @Router /currency-rates/{currency} [delete]
+func handleDeleteCurrencyRate(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	proxyRequest(w, r, "http://product-service:8082/currency-rates/"+vars["currency"])
+}
+
 // GetOrders godoc
 // @Summary Get all orders
 // @Description Get all orders
 // @Tags orders
 // @Produce json
 // @Success 200 {array} Order
+// @Failure 403 {object} access.ErrorResponse "Only admins"
 // @Router /orders [get]
 func handleOrders(w http.ResponseWriter, r *http.Request) {
 	proxyRequest(w, r, "http://order-service:8083/orders")
@@ -232,6 +606,7 @@ func handleOrders(w http.ResponseWriter, r *http.Request) {
 // @Produce json
 // @Param id path int true "Order ID"
 // @Success 200 {object} Order
+// @Failure 403 {object} access.ErrorResponse "The order belongs to another user"
 // @Router /orders/{id} [get]
 func handleOrderByID(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
@@ -241,12 +616,16 @@ func handleOrderByID(w http.ResponseWriter, r *http.Request) {
 
 // CreateOrder godoc
 // @Summary Create an order
-// @Description Create a new order
+// @Description Create a new order for a user with a verified email address. Item prices and the total are calculated from the products service in the order currency and stock is reserved for every item. Prices converted from the base currency keep the exchange rate of this moment.
 // @Tags orders
 // @Accept json
 // @Produce json
 // @Param order body Order true "Create order"
+// @Param Idempotency-Key header string false "Unique key that makes the request safe to retry"
 // @Success 201 {object} Order
+// @Failure 409 {string} string "Insufficient stock or the exchange rate changed, retry"
+// @Failure 403 {object} access.ErrorResponse "The order is for another user, or the user has not verified the email address"
+// @Failure 422 {string} string "User or product not found, no exchange rate for the currency or Idempotency-Key reused for a different request"
 // @Router /orders [post]
 func handleCreateOrder(w http.ResponseWriter, r *http.Request) {
 	proxyRequest(w, r, "http://order-service:8083/orders")
@@ -254,13 +633,14 @@ func handleCreateOrder(w http.ResponseWriter, r *http.Request) {
 
 // UpdateOrder godoc
 // @Summary Update an order by ID
-// @Description Update an order by ID
+// @Description Update an order by ID. The status cannot be changed here, use the transitions endpoint instead.
 // @Tags orders
 // @Accept json
 // @Produce json
 // @Param id path int true "Order ID"
 // @Param order body Order true "Update order"
 // @Success 200 {object} Order
+// @Failure 403 {object} access.ErrorResponse "The order belongs to another user"
 // @Router /orders/{id} [put]
 func handleUpdateOrder(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
@@ -275,6 +655,7 @@ func handleUpdateOrder(w http.ResponseWriter, r *http.Request) {
 // @Produce plain
 // @Param id path int true "Order ID"
 // @Success 200 {string} string "Deleted"
+// @Failure 403 {object} access.ErrorResponse "The order belongs to another user"
 // @Router /orders/{id} [delete]
 func handleDeleteOrder(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
@@ -282,6 +663,39 @@ func handleDeleteOrder(w http.ResponseWriter, r *http.Request) {
 	proxyRequest(w, r, "http://order-service:8083/orders/"+id)
 }
 
+// TransitionOrder godoc
+// @Summary Change the status of an order
+// @Description Move an order to a new status. Only transitions allowed by the order lifecycle are accepted.
+// @Tags orders
+// @Accept json
+// @Produce json
+// @Param id path int true "Order ID"
+// @Param transition body TransitionRequest true "Transition"
+// @Success 200 {object} Order
+// @Failure 409 {string} string "Illegal transition"
+// @Failure 403 {object} access.ErrorResponse "The order belongs to another user, or a client tried a status other than cancelled"
+// @Router /orders/{id}/transitions [post]
+func handleTransitionOrder(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+	proxyRequest(w, r, "http://order-service:8083/orders/"+id+"/transitions")
+}
+
+// GetOrderTransitions godoc
+// @Summary Get the status history of an order
+// @Description Get all status transitions of an order, oldest first
+// @Tags orders
+// @Produce json
+// @Param id path int true "Order ID"
+// @Success 200 {array} OrderStatusHistory
+// @Failure 403 {object} access.ErrorResponse "The order belongs to another user"
+// @Router /orders/{id}/transitions [get]
+func handleOrderTransitions(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+	proxyRequest(w, r, "http://order-service:8083/orders/"+id+"/transitions")
+}
+
 // SearchOrders godoc
 // @Summary Search orders by user or status
 // @Description Search orders by user or status
@@ -290,6 +704,7 @@ func handleDeleteOrder(w http.ResponseWriter, r *http.Request) {
 // @Param user query int false "User ID"
 // @Param status query string false "Order Status"
 // @Success 200 {array} Order
+// @Failure 403 {object} access.ErrorResponse "Another user's orders"
 // @Router /search/orders [get]
 func handleSearchOrders(w http.ResponseWriter, r *http.Request) {
 	userId := r.URL.Query().Get("user")
@@ -303,6 +718,7 @@ func handleSearchOrders(w http.ResponseWriter, r *http.Request) {
 // @Tags payments
 // @Produce json
 // @Success 200 {array} Payment
+// @Failure 403 {object} access.ErrorResponse "Only admins"
 // @Router /payments [get]
 func handlePayments(w http.ResponseWriter, r *http.Request) {
 	proxyRequest(w, r, "http://payment-service:8084/payments")
@@ -315,6 +731,7 @@ func handlePayments(w http.ResponseWriter, r *http.Request) {
 // @Produce json
 // @Param id path int true "Payment ID"
 // @Success 200 {object} Payment
+// @Failure 403 {object} access.ErrorResponse "The payment belongs to another user"
 // @Router /payments/{id} [get]
 func handlePaymentByID(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
@@ -322,14 +739,65 @@ func handlePaymentByID(w http.ResponseWriter, r *http.Request) {
 	proxyRequest(w, r, "http://payment-service:8084/payments/"+id)
 }
 
+// AuthorizePayment godoc
+// @Summary Authorize a payment
+// @Description Reserve the order total on the card without charging it. The order is moved to paid once the amount is authorized. The payment has to be captured with /payments/{id}/capture before expires_at, otherwise it is voided automatically.
+// @Tags payments
+// @Accept json
+// @Produce json
+// @Param payment body PaymentRequest true "Authorize payment"
+// @Param Idempotency-Key header string false "Unique key that makes the request safe to retry; a retry returns the original response instead of authorizing the card again"
+// @Success 200 {object} Payment
+// @Failure 409 {string} string "Order cannot be paid"
+// @Failure 422 {string} string "Amount does not match the order or Idempotency-Key reused for a different request"
+// @Failure 403 {object} access.ErrorResponse "The payment is for another user"
+// @Router /payments/authorize [post]
+func handleAuthorizePayment(w http.ResponseWriter, r *http.Request) {
+	proxyRequest(w, r, "http://payment-service:8084/payments/authorize")
+}
+
+// EpayPostLink godoc
+// @Summary ePay payment callback
+// @Description Receives the payment result ePay posts to postLink. The callback must carry the secret_hash issued with the payment. A successful result is confirmed with ePay before the payment and its order are marked as paid. Repeated callbacks are safe.
+// @Tags callbacks
+// @Accept json
+// @Produce json
+// @Param callback body EpayCallback true "Payment result"
+// @Success 200 {object} Payment
+// @Failure 401 {string} string "Invalid callback signature"
+// @Failure 404 {string} string "Payment not found"
+// @Router /payments/callbacks/epay [post]
+func handleEpayCallback(w http.ResponseWriter, r *http.Request) {
+	proxyRequest(w, r, "http://payment-service:8084/payments/callbacks/epay")
+}
+
+// EpayFailurePostLink godoc
+// @Summary ePay failed payment callback
+// @Description Receives the result ePay posts to failurePostLink and marks the payment as unsuccessful. A payment that has already succeeded is not changed.
+// @Tags callbacks
+// @Accept json
+// @Produce json
+// @Param callback body EpayCallback true "Payment result"
+// @Success 200 {object} Payment
+// @Failure 401 {string} string "Invalid callback signature"
+// @Failure 404 {string} string "Payment not found"
+// @Router /payments/callbacks/epay/fail [post]
+func handleEpayFailureCallback(w http.ResponseWriter, r *http.Request) {
+	proxyRequest(w, r, "http://payment-service:8084/payments/callbacks/epay/fail")
+}
+
 // CreatePayment godoc
 // @Summary Create a payment
-// @Description Create a new payment using API ePayment.kz
+// @Description Create a new payment using the configured payment provider. The card is given either as card data or as a saved payment method of the user (payment_method_id); with save_card the card is saved once it is accepted. The amount must match the order total. The order is moved to awaiting_payment before the card is charged and to paid after a successful payment.
 // @Tags payments
 // @Accept json
 // @Produce json
 // @Param payment body PaymentRequest true "Create payment"
+// @Param Idempotency-Key header string false "Unique key that makes the request safe to retry; a retry returns the original response instead of charging the card again"
 // @Success 201 {object} Payment
+// @Failure 409 {string} string "Order cannot be paid"
+// @Failure 422 {string} string "Amount does not match the order or Idempotency-Key reused for a different request"
+// @Failure 403 {object} access.ErrorResponse "The payment is for another user"
 // @Router /payments [post]
 func handleCreatePayment(w http.ResponseWriter, r *http.Request) {
 	proxyRequest(w, r, "http://payment-service:8084/payments")
@@ -344,6 +812,7 @@ func handleCreatePayment(w http.ResponseWriter, r *http.Request) {
 // @Param id path int true "Payment ID"
 // @Param payment body Payment true "Update payment"
 // @Success 200 {object} Payment
+// @Failure 403 {object} access.ErrorResponse "Only admins"
 // @Router /payments/{id} [put]
 func handleUpdatePayment(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
@@ -358,6 +827,7 @@ func handleUpdatePayment(w http.ResponseWriter, r *http.Request) {
 // @Produce plain
 // @Param id path int true "Payment ID"
 // @Success 200 {string} string "Deleted"
+// @Failure 403 {object} access.ErrorResponse "Only admins"
 // @Router /payments/{id} [delete]
 func handleDeletePayment(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
@@ -365,6 +835,293 @@ func handleDeletePayment(w http.ResponseWriter, r *http.Request) {
 	proxyRequest(w, r, "http://payment-service:8084/payments/"+id)
 }
 
+// GetPaymentAction godoc
+// @Summary Get the pending action of a payment
+// @Description Get the 3-D Secure challenge the customer has to pass for a payment in status requires_action. After the challenge the payment is finished with /payments/{id}/complete.
+// @Tags payments
+// @Produce json
+// @Param id path int true "Payment ID"
+// @Success 200 {object} PaymentAction
+// @Failure 404 {string} string "Payment not found"
+// @Failure 409 {string} string "Payment does not require action"
+// @Failure 403 {object} access.ErrorResponse "The payment belongs to another user"
+// @Router /payments/{id}/action [get]
+func handlePaymentAction(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+	proxyRequest(w, r, "http://payment-service:8084/payments/"+id+"/action")
+}
+
+// CompletePayment godoc
+// @Summary Complete a payment after 3-D Secure
+// @Description Finish a payment in status requires_action once the customer has passed the 3-D Secure challenge. The payment is charged or authorized as originally requested and the order is moved to paid; a failed challenge makes the payment unsuccessful.
+// @Tags payments
+// @Accept json
+// @Produce json
+// @Param id path int true "Payment ID"
+// @Param completion body ActionCompletion false "Challenge result"
+// @Success 200 {object} Payment
+// @Failure 404 {string} string "Payment not found"
+// @Failure 409 {string} string "Payment does not require action"
+// @Failure 403 {object} access.ErrorResponse "The payment belongs to another user"
+// @Router /payments/{id}/complete [post]
+func handleCompletePayment(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+	proxyRequest(w, r, "http://payment-service:8084/payments/"+id+"/complete")
+}
+
+// CapturePayment godoc
+// @Summary Capture an authorized payment
+// @Description Charge an authorized payment. Without an amount the whole authorized amount is captured; a smaller amount captures only that part and releases the rest.
+// @Tags payments
+// @Accept json
+// @Produce json
+// @Param id path int true "Payment ID"
+// @Param capture body CaptureRequest false "Capture"
+// @Success 200 {object} Payment
+// @Failure 404 {string} string "Payment not found"
+// @Failure 409 {string} string "Payment is not authorized or the authorization has expired"
+// @Failure 422 {string} string "Capture exceeds the authorized amount"
+// @Failure 403 {object} access.ErrorResponse "Only admins"
+// @Router /payments/{id}/capture [post]
+func handleCapturePayment(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+	proxyRequest(w, r, "http://payment-service:8084/payments/"+id+"/capture")
+}
+
+// VoidPayment godoc
+// @Summary Void an authorized payment
+// @Description Release the amount held on the card without charging it. The order is moved to refunded.
+// @Tags payments
+// @Produce json
+// @Param id path int true "Payment ID"
+// @Success 200 {object} Payment
+// @Failure 404 {string} string "Payment not found"
+// @Failure 409 {string} string "Payment is not authorized"
+// @Failure 403 {object} access.ErrorResponse "Only admins"
+// @Router /payments/{id}/void [post]
+func handleVoidPayment(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+	proxyRequest(w, r, "http://payment-service:8084/payments/"+id+"/void")
+}
+
+// CreateRefund godoc
+// @Summary Refund a payment
+// @Description Refund a payment fully or partially through the payment provider. Without an amount everything not refunded yet is returned. Refunds together can never exceed the captured amount. The order is moved to refunded once the payment is fully refunded.
+// @Tags refunds
+// @Accept json
+// @Produce json
+// @Param id path int true "Payment ID"
+// @Param refund body RefundRequest true "Refund"
+// @Success 201 {object} Refund
+// @Failure 404 {string} string "Payment not found"
+// @Failure 409 {string} string "Payment cannot be refunded"
+// @Failure 422 {string} string "Refund exceeds the amount left to refund"
+// @Failure 502 {object} Refund "Refund declined by the provider"
+// @Failure 403 {object} access.ErrorResponse "Only admins"
+// @Router /payments/{id}/refunds [post]
+func handleCreateRefund(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+	proxyRequest(w, r, "http://payment-service:8084/payments/"+id+"/refunds")
+}
+
+// GetRefunds godoc
+// @Summary Get the refunds of a payment
+// @Description Get all refunds of a payment, oldest first
+// @Tags refunds
+// @Produce json
+// @Param id path int true "Payment ID"
+// @Success 200 {array} Refund
+// @Failure 403 {object} access.ErrorResponse "The payment belongs to another user"
+// @Router /payments/{id}/refunds [get]
+func handleRefunds(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+	proxyRequest(w, r, "http://payment-service:8084/payments/"+id+"/refunds")
+}
+
+// GetPaymentMethods godoc
+// @Summary Get the saved cards of a user
+// @Description Get the payment methods a user can pay with instead of entering card data
+// @Tags payment-methods
+// @Produce json
+// @Param id path int true "User ID"
+// @Success 200 {array} PaymentMethod
+// @Failure 403 {object} access.ErrorResponse "Another user's cards"
+// @Router /users/{id}/payment-methods [get]
+func handlePaymentMethods(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+	proxyRequest(w, r, "http://payment-service:8084/users/"+id+"/payment-methods")
+}
+
+// CreatePaymentMethod godoc
+// @Summary Save a card of a user
+// @Description Save the card used for an accepted payment of the user as a payment method. Only the provider's card token and the masked card number are stored. Cards can also be saved while paying with save_card.
+// @Tags payment-methods
+// @Accept json
+// @Produce json
+// @Param id path int true "User ID"
+// @Param method body SavePaymentMethodRequest true "Payment to save the card of"
+// @Success 201 {object} PaymentMethod
+// @Failure 422 {string} string "Payment not found or has no saved card"
+// @Failure 403 {object} access.ErrorResponse "Another user's cards"
+// @Router /users/{id}/payment-methods [post]
+func handleCreatePaymentMethod(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+	proxyRequest(w, r, "http://payment-service:8084/users/"+id+"/payment-methods")
+}
+
+// DeletePaymentMethod godoc
+// @Summary Delete a saved card
+// @Description Delete a payment method of a user
+// @Tags payment-methods
+// @Produce plain
+// @Param id path int true "User ID"
+// @Param method_id path int true "Payment method ID"
+// @Success 200 {string} string "Deleted"
+// @Failure 404 {string} string "Payment method not found"
+// @Failure 403 {object} access.ErrorResponse "Another user's cards"
+// @Router /users/{id}/payment-methods/{method_id} [delete]
+func handleDeletePaymentMethod(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+	methodID := vars["method_id"]
+	proxyRequest(w, r, "http://payment-service:8084/users/"+id+"/payment-methods/"+methodID)
+}
+
+// GetReconciliation godoc
+// @Summary Get reconciliation discrepancies
+// @Description Get the differences between payments and the provider found by reconciliation, newest first. Discrepancies reconciliation fixed on its own are resolved.
+// @Tags reconciliation
+// @Produce json
+// @Param resolved query bool false "Only resolved or only open discrepancies"
+// @Success 200 {array} PaymentDiscrepancy
+// @Failure 403 {object} access.ErrorResponse "Only admins"
+// @Router /payments/reconciliation [get]
+func handleReconciliation(w http.ResponseWriter, r *http.Request) {
+	url := "http://payment-service:8084/payments/reconciliation"
+	if r.URL.RawQuery != "" {
+		url += "?" + r.URL.RawQuery
+	}
+	proxyRequest(w, r, url)
+}
+
+// CreateChargeback godoc
+// @Summary Record a chargeback
+// @Description Record a chargeback reported by the provider in the ledger. A chargeback is recorded once per reference; repeating it returns the entry already posted.
+// @Tags ledger
+// @Accept json
+// @Produce json
+// @Param id path int true "Payment ID"
+// @Param chargeback body ChargebackRequest true "Chargeback"
+// @Success 201 {object} LedgerEntry
+// @Success 200 {object} LedgerEntry "Chargeback already recorded"
+// @Failure 404 {string} string "Payment not found"
+// @Failure 409 {string} string "Payment has no captured amount"
+// @Failure 422 {string} string "Chargeback exceeds the captured amount"
+// @Failure 403 {object} access.ErrorResponse "Only admins"
+// @Router /payments/{id}/chargebacks [post]
+func handleCreateChargeback(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+	proxyRequest(w, r, "http://payment-service:8084/payments/"+id+"/chargebacks")
+}
+
+// GetLedgerBalances godoc
+// @Summary Get account balances
+// @Description Get the debits, credits and balance of every ledger account per currency in minor units. Balance is debits minus credits, so the balances of each currency sum to zero.
+// @Tags ledger
+// @Produce json
+// @Param account query string false "Account, e.g. assets:provider_clearing"
+// @Param currency query string false "Currency code"
+// @Success 200 {array} AccountBalance
+// @Failure 403 {object} access.ErrorResponse "Only admins"
+// @Router /ledger/balances [get]
+func handleLedgerBalances(w http.ResponseWriter, r *http.Request) {
+	url := "http://payment-service:8084/ledger/balances"
+	if r.URL.RawQuery != "" {
+		url += "?" + r.URL.RawQuery
+	}
+	proxyRequest(w, r, url)
+}
+
+// GetLedgerJournal godoc
+// @Summary Get the journal
+// @Description Get ledger entries with their lines, newest first
+// @Tags ledger
+// @Produce json
+// @Param payment_id query int false "Payment ID"
+// @Param account query string false "Only entries that touch this account"
+// @Param limit query int false "Maximum number of entries" default(100)
+// @Success 200 {array} LedgerEntry
+// @Failure 403 {object} access.ErrorResponse "Only admins"
+// @Router /ledger/journal [get]
+func handleLedgerJournal(w http.ResponseWriter, r *http.Request) {
+	url := "http://payment-service:8084/ledger/journal"
+	if r.URL.RawQuery != "" {
+		url += "?" + r.URL.RawQuery
+	}
+	proxyRequest(w, r, url)
+}
+
+// CreateCheckout godoc
+// @Summary Check out a cart
+// @Description Place an order and pay for it in one request. The checkout validates the user, whose email address must be verified, prices the cart, creates the order, reserves stock, charges the card and confirms the order. If a step fails, the steps already done are undone: the payment is refunded, the stock released and the order cancelled. The checkout state is saved after every step and resumed after a restart. Card data is never stored, so a checkout interrupted before the card was charged can only be resumed with a saved payment method.
+// @Tags checkout
+// @Accept json
+// @Produce json
+// @Param checkout body CheckoutRequest true "Cart and card"
+// @Param Idempotency-Key header string false "Unique key that makes the request safe to retry"
+// @Success 201 {object} Checkout "Completed"
+// @Success 202 {object} Checkout "Waiting for 3-D Secure (see action_url) or for the payment outcome"
+// @Failure 403 {object} access.ErrorResponse "The checkout is for another user"
+// @Failure 422 {object} Checkout "Failed and compensated, the reason is in error"
+// @Router /checkout [post]
+func handleCreateCheckout(w http.ResponseWriter, r *http.Request) {
+	proxyRequest(w, r, "http://checkout-service:8085/checkout")
+}
+
+// SearchCheckouts godoc
+// @Summary Search checkouts by order, user or status
+// @Description Search checkouts by order, user or status, newest first. The checkout of an order shows how far it got.
+// @Tags checkout
+// @Produce json
+// @Param order_id query int false "Order ID"
+// @Param user_id query int false "User ID"
+// @Param status query string false "Checkout status" Enums(running, requires_action, compensating, completed, failed)
+// @Success 200 {array} Checkout
+// @Failure 403 {object} access.ErrorResponse "Another user's checkouts"
+// @Router /checkout [get]
+func handleSearchCheckouts(w http.ResponseWriter, r *http.Request) {
+	url := "http://checkout-service:8085/checkout"
+	if r.URL.RawQuery != "" {
+		url += "?" + r.URL.RawQuery
+	}
+	proxyRequest(w, r, url)
+}
+
+// GetCheckout godoc
+// @Summary Get a checkout by ID
+// @Description Get the status of a checkout and the history of its steps
+// @Tags checkout
+// @Produce json
+// @Param id path int true "Checkout ID"
+// @Success 200 {object} Checkout
+// @Failure 404 {string} string "Checkout not found"
+// @Failure 403 {object} access.ErrorResponse "The checkout belongs to another user"
+// @Router /checkout/{id} [get]
+func handleCheckoutByID(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+	proxyRequest(w, r, "http://checkout-service:8085/checkout/"+id)
+}
+
 // SearchPayments godoc
 // @Summary Search payments by user, order, or status
 // @Description Search payments by user, order, or status
@@ -374,6 +1131,7 @@ func handleDeletePayment(w http.ResponseWriter, r *http.Request) {
 // @Param order query int false "Order ID"
 // @Param status query string false "Payment Status"
 // @Success 200 {array} Payment
+// @Failure 403 {object} access.ErrorResponse "Another user's payments"
 // @Router /search/payments [get]
 func handleSearchPayments(w http.ResponseWriter, r *http.Request) {
 	userId := r.URL.Query().Get("user")
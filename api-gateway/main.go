@@ -11,7 +11,14 @@ import (
 func main() {
 
 	//Init()
+	var err error
+	authConfig, err = loadAuthConfig()
+	if err != nil {
+		log.Fatal("invalid JWT configuration: ", err)
+	}
+
 	r := mux.NewRouter()
+	r.Use(authenticate)
 
 	r.HandleFunc("/health", HealthCheck).Methods("GET")
 	r.PathPrefix("/swagger/").Handler(httpSwagger.WrapHandler)
@@ -22,14 +29,41 @@ func main() {
 	r.HandleFunc("/users", handleCreateUser).Methods("POST")
 	r.HandleFunc("/users/{id}", handleUpdateUser).Methods("PUT")
 	r.HandleFunc("/users/{id}", handleDeleteUser).Methods("DELETE")
+	r.HandleFunc("/users/{id}/payment-methods", handlePaymentMethods).Methods("GET")
+	r.HandleFunc("/users/{id}/payment-methods", handleCreatePaymentMethod).Methods("POST")
+	r.HandleFunc("/users/{id}/payment-methods/{method_id}", handleDeletePaymentMethod).Methods("DELETE")
+	r.HandleFunc("/users/{id}/password", handleChangePassword).Methods("POST")
+	r.HandleFunc("/users/{id}/2fa", handleStartTOTPEnrollment).Methods("POST")
+	r.HandleFunc("/users/{id}/2fa/confirm", handleConfirmTOTPEnrollment).Methods("POST")
+	r.HandleFunc("/users/{id}/2fa/recovery-codes", handleRegenerateRecoveryCodes).Methods("POST")
+	r.HandleFunc("/users/{id}/2fa/disable", handleDisableTOTP).Methods("POST")
+	r.HandleFunc("/users/{id}/2fa/reset", handleResetTOTP).Methods("POST")
 	r.HandleFunc("/search/users", handleSearchUsers).Methods("GET")
 
+	r.HandleFunc("/auth/register", handleRegister).Methods("POST")
+	r.HandleFunc("/auth/login", handleLogin).Methods("POST")
+	r.HandleFunc("/auth/login/mfa", handleLoginMFA).Methods("POST")
+	r.HandleFunc("/auth/refresh", handleRefreshTokens).Methods("POST")
+	r.HandleFunc("/auth/logout", handleLogout).Methods("POST")
+	r.HandleFunc("/auth/verify-email", handleVerifyEmail).Methods("POST")
+	r.HandleFunc("/auth/verify-email/resend", handleResendVerification).Methods("POST")
+	r.HandleFunc("/auth/password-reset", handleResetPassword).Methods("POST")
+	r.HandleFunc("/auth/password-reset/request", handleRequestPasswordReset).Methods("POST")
+
 	// Пример маршрутов для товаров
 	r.HandleFunc("/products", handleProducts).Methods("GET")
 	r.HandleFunc("/products/{id}", handleProductByID).Methods("GET")
 	r.HandleFunc("/products", handleCreateProduct).Methods("POST")
 	r.HandleFunc("/products/{id}", handleUpdateProduct).Methods("PUT")
 	r.HandleFunc("/products/{id}", handleDeleteProduct).Methods("DELETE")
+	r.HandleFunc("/products/{id}/price", handleProductPrice).Methods("GET")
+	r.HandleFunc("/products/{id}/prices", handleProductPrices).Methods("GET")
+	r.HandleFunc("/products/{id}/prices/{currency}", handleSetProductPrice).Methods("PUT")
+	r.HandleFunc("/products/{id}/prices/{currency}", handleDeleteProductPrice).Methods("DELETE")
+	r.HandleFunc("/currency-rates", handleCurrencyRates).Methods("GET")
+	r.HandleFunc("/currency-rates/import", handleImportCurrencyRates).Methods("POST")
+	r.HandleFunc("/currency-rates/{currency}", handleSetCurrencyRate).Methods("PUT")
+	r.HandleFunc("/currency-rates/{currency}", handleDeleteCurrencyRate).Methods("DELETE")
 	r.HandleFunc("/search/products", handleSearchProducts).Methods("GET")
 
 	r.HandleFunc("/orders", handleOrders).Methods("GET")
@@ -37,13 +71,31 @@ func main() {
 	r.HandleFunc("/orders", handleCreateOrder).Methods("POST")
 	r.HandleFunc("/orders/{id}", handleUpdateOrder).Methods("PUT")
 	r.HandleFunc("/orders/{id}", handleDeleteOrder).Methods("DELETE")
+	r.HandleFunc("/orders/{id}/transitions", handleTransitionOrder).Methods("POST")
+	r.HandleFunc("/orders/{id}/transitions", handleOrderTransitions).Methods("GET")
 	r.HandleFunc("/search/orders", handleSearchOrders).Methods("GET")
 
 	r.HandleFunc("/payments", handlePayments).Methods("GET")
+	r.HandleFunc("/payments/authorize", handleAuthorizePayment).Methods("POST")
+	r.HandleFunc("/payments/callbacks/epay", handleEpayCallback).Methods("POST")
+	r.HandleFunc("/payments/callbacks/epay/fail", handleEpayFailureCallback).Methods("POST")
+	r.HandleFunc("/payments/reconciliation", handleReconciliation).Methods("GET")
 	r.HandleFunc("/payments/{id}", handlePaymentByID).Methods("GET")
 	r.HandleFunc("/payments", handleCreatePayment).Methods("POST")
 	r.HandleFunc("/payments/{id}", handleUpdatePayment).Methods("PUT")
 	r.HandleFunc("/payments/{id}", handleDeletePayment).Methods("DELETE")
+	r.HandleFunc("/payments/{id}/action", handlePaymentAction).Methods("GET")
+	r.HandleFunc("/payments/{id}/complete", handleCompletePayment).Methods("POST")
+	r.HandleFunc("/payments/{id}/capture", handleCapturePayment).Methods("POST")
+	r.HandleFunc("/payments/{id}/void", handleVoidPayment).Methods("POST")
+	r.HandleFunc("/payments/{id}/refunds", handleCreateRefund).Methods("POST")
+	r.HandleFunc("/payments/{id}/refunds", handleRefunds).Methods("GET")
+	r.HandleFunc("/payments/{id}/chargebacks", handleCreateChargeback).Methods("POST")
+	r.HandleFunc("/ledger/balances", handleLedgerBalances).Methods("GET")
+	r.HandleFunc("/ledger/journal", handleLedgerJournal).Methods("GET")
+	r.HandleFunc("/checkout", handleCreateCheckout).Methods("POST")
+	r.HandleFunc("/checkout", handleSearchCheckouts).Methods("GET")
+	r.HandleFunc("/checkout/{id}", handleCheckoutByID).Methods("GET")
 	r.HandleFunc("/search/payments", handleSearchPayments).Methods("GET")
 
 	// Запуск сервера
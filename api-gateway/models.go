@@ -7,44 +7,434 @@ type User struct {
 	Name           string    `json:"name" validate:"required" example:"John Doe"`
 	Email          string    `json:"email" validate:"required,email" example:"john.doe@example.com"`
 	Address        string    `json:"address" example:"123 Main St"`
+	Phone          string    `json:"phone" validate:"omitempty,numeric,min=10,max=15" example:"77771234567"`
 	RegistrationAt time.Time `json:"registrationAt" readonly:"true" example:"2023-07-20T15:04:05Z"`
 	Role           string    `json:"role" validate:"required,oneof=admin client" example:"client"`
+	// EmailVerifiedAt is when the user confirmed the email address, nil until
+	// then. Unverified users cannot place orders.
+	EmailVerifiedAt *time.Time `json:"email_verified_at" readonly:"true" example:"2023-07-20T15:10:00Z"`
+	// TOTPEnabledAt is when two-factor authentication was turned on, nil
+	// while it is off. It is mandatory for admins.
+	TOTPEnabledAt *time.Time `json:"totp_enabled_at" readonly:"true" example:"2023-07-20T15:20:00Z"`
+}
+
+type RegisterRequest struct {
+	Name     string `json:"name" validate:"required" example:"John Doe"`
+	Email    string `json:"email" validate:"required,email" example:"john.doe@example.com"`
+	Password string `json:"password" validate:"required,min=8,max=72" example:"correct horse battery"`
+	Address  string `json:"address" example:"123 Main St"`
+	Phone    string `json:"phone" validate:"omitempty,numeric,min=10,max=15" example:"77771234567"`
+}
+
+type LoginRequest struct {
+	Email    string `json:"email" validate:"required" example:"john.doe@example.com"`
+	Password string `json:"password" validate:"required" example:"correct horse battery"`
+}
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token" validate:"required" example:"b3BhcXVlLXJhbmRvbS10b2tlbg"`
+}
+
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password" validate:"required" example:"correct horse battery"`
+	NewPassword     string `json:"new_password" validate:"required,min=8,max=72" example:"staple battery horse"`
+}
+
+type EmailRequest struct {
+	Email string `json:"email" validate:"required,email" example:"john.doe@example.com"`
+}
+
+type VerifyEmailRequest struct {
+	Token string `json:"token" validate:"required" example:"b3BhcXVlLXJhbmRvbS10b2tlbg"`
+}
+
+type ResetPasswordRequest struct {
+	Token       string `json:"token" validate:"required" example:"b3BhcXVlLXJhbmRvbS10b2tlbg"`
+	NewPassword string `json:"new_password" validate:"required,min=8,max=72" example:"staple battery horse"`
+}
+
+// TokenResponse is returned by login and refresh. The access token is sent as
+// a bearer token; the refresh token gets a new pair once the access token
+// expires. If the user has two-factor authentication, login returns only
+// MFAToken, which is exchanged for the tokens at /auth/login/mfa.
+type TokenResponse struct {
+	AccessToken  string `json:"access_token,omitempty" example:"eyJhbGciOiJIUzI1NiIsInR5cCI6IkpXVCJ9..."`
+	TokenType    string `json:"token_type,omitempty" example:"Bearer"`
+	ExpiresIn    int    `json:"expires_in,omitempty" example:"900"`
+	RefreshToken string `json:"refresh_token,omitempty" example:"b3BhcXVlLXJhbmRvbS10b2tlbg"`
+	MFARequired  bool   `json:"mfa_required,omitempty" example:"false"`
+	MFAToken     string `json:"mfa_token,omitempty" example:"bWZhLWxvZ2luLXRva2Vu"`
+	// MFAEnrollmentRequired is set for an admin without two-factor
+	// authentication, whose tokens only have the rights of a client until
+	// they enrol.
+	MFAEnrollmentRequired bool `json:"mfa_enrollment_required,omitempty" example:"false"`
+}
+
+type MFALoginRequest struct {
+	MFAToken string `json:"mfa_token" validate:"required" example:"bWZhLWxvZ2luLXRva2Vu"`
+	// Code is the current code of the authenticator app. RecoveryCode can be
+	// given instead.
+	Code         string `json:"code" validate:"required_without=RecoveryCode" example:"123456"`
+	RecoveryCode string `json:"recovery_code" validate:"required_without=Code" example:"ABCDE-FGHIJ"`
+}
+
+type TOTPCodeRequest struct {
+	Code string `json:"code" validate:"required,numeric,len=6" example:"123456"`
+}
+
+// TOTPEnrollment is the secret to add to an authenticator app, as text and as
+// an otpauth URI for a QR code.
+type TOTPEnrollment struct {
+	Secret     string `json:"secret" example:"JBSWY3DPEHPK3PXPJBSWY3DPEHPK3PXP"`
+	OTPAuthURI string `json:"otpauth_uri" example:"otpauth://totp/HL%20Online%20Shop:john.doe@example.com?algorithm=SHA1&digits=6&issuer=HL+Online+Shop&period=30&secret=JBSWY3DPEHPK3PXPJBSWY3DPEHPK3PXP"`
+}
+
+// RecoveryCodes are shown once; each can be used once instead of a TOTP code.
+// Tokens are returned when two-factor authentication is turned on, for a
+// session that has passed it.
+type RecoveryCodes struct {
+	RecoveryCodes []string       `json:"recovery_codes" example:"ABCDE-FGHIJ,KLMNO-PQRST"`
+	Tokens        *TokenResponse `json:"tokens,omitempty"`
+}
+
+// Money is an exact amount in minor units of a currency: 100050 KZT is
+// 1000.50 tenge.
+type Money struct {
+	Amount   int64  `gorm:"column:minor" json:"amount" validate:"gte=0" example:"100050"`
+	Currency string `gorm:"column:currency;size:3" json:"currency" validate:"omitempty,iso4217" example:"KZT"`
 }
 
 type Product struct {
 	ID          uint      `gorm:"primaryKey" json:"id" readonly:"true" example:"1"`
 	Name        string    `json:"name" validate:"required" example:"Laptop"`
 	Description string    `json:"description" example:"A high-performance laptop"`
-	Price       float64   `json:"price" validate:"required,gt=0" example:"1000.50"`
+	Price       Money     `gorm:"embedded;embeddedPrefix:price_" json:"price" validate:"positive_money"`
 	Category    string    `json:"category" validate:"required" example:"Electronics"`
 	Stock       int       `json:"stock" validate:"gte=0" example:"50"`
 	CreatedAt   time.Time `json:"created_at" readonly:"true" example:"2023-07-20T15:04:05Z"`
 }
 
+// ProductPrice is the price of a product in a currency other than the base
+// currency. It is charged instead of the converted base price.
+type ProductPrice struct {
+	ID        uint      `gorm:"primaryKey" json:"id" readonly:"true" example:"1"`
+	ProductID uint      `gorm:"index;not null" json:"product_id" readonly:"true" example:"1"`
+	Price     Money     `gorm:"embedded;embeddedPrefix:price_" json:"price" validate:"positive_money"`
+	UpdatedAt time.Time `json:"updated_at" readonly:"true" example:"2023-07-20T15:04:05Z"`
+}
+
+// CurrencyRate is how many units of Currency one unit of the base currency
+// is worth. Rates are exact decimals, written as strings in JSON.
+type CurrencyRate struct {
+	Currency  string    `gorm:"primaryKey;size:3" json:"currency" validate:"required,iso4217" example:"USD"`
+	Rate      string    `gorm:"type:numeric(24,12);not null" json:"rate" validate:"required,rate" example:"0.0021"`
+	UpdatedAt time.Time `json:"updated_at" readonly:"true" example:"2023-07-20T15:04:05Z"`
+}
+
+// PriceQuote is the price of a product in one currency.
+type PriceQuote struct {
+	ProductID uint  `json:"product_id" example:"1"`
+	Price     Money `json:"price"`
+	BasePrice Money `json:"base_price"`
+	// Source tells where the price comes from: the base price itself, a
+	// ProductPrice override, or the base price converted at Rate.
+	Source        string     `json:"source" example:"converted" enums:"base,override,converted"`
+	Rate          string     `json:"rate,omitempty" example:"0.0021"`
+	RateUpdatedAt *time.Time `json:"rate_updated_at,omitempty" example:"2023-07-20T15:04:05Z"`
+}
+
 type Order struct {
+	ID         uint        `gorm:"primaryKey" json:"id" readonly:"true" example:"1"`
+	UserID     uint        `json:"user_id" validate:"required" example:"1"`
+	Items      []OrderItem `gorm:"foreignKey:OrderID" json:"items" validate:"required,min=1,dive"`
+	TotalPrice Money       `gorm:"embedded;embeddedPrefix:total_price_" json:"total_price"`
+	// Currency the order is priced and paid in, the shop currency by default.
+	Currency string `gorm:"size:3" json:"currency" validate:"omitempty,iso4217" example:"USD"`
+	// Snapshot of the exchange rate from BaseCurrency that converted prices
+	// were calculated with. Empty when no price had to be converted.
+	BaseCurrency  string     `gorm:"size:3" json:"base_currency" readonly:"true" example:"KZT"`
+	ExchangeRate  string     `json:"exchange_rate,omitempty" readonly:"true" example:"0.0021"`
+	RateUpdatedAt *time.Time `json:"rate_updated_at,omitempty" readonly:"true" example:"2023-07-20T15:04:05Z"`
+	OrderDate     time.Time  `json:"order_date" readonly:"true" example:"2023-07-20T15:04:05Z"`
+	Status        string     `json:"status" readonly:"true" validate:"omitempty,oneof=new awaiting_payment paid in_process shipped completed cancelled refunded" example:"new"`
+}
+
+type OrderItem struct {
+	ID        uint  `gorm:"primaryKey" json:"id" readonly:"true" example:"1"`
+	OrderID   uint  `gorm:"index;not null" json:"order_id" readonly:"true" example:"1"`
+	ProductID uint  `json:"product_id" validate:"required" example:"1"`
+	Quantity  int   `json:"quantity" validate:"required,gt=0" example:"2"`
+	UnitPrice Money `gorm:"embedded;embeddedPrefix:unit_price_" json:"unit_price" readonly:"true"`
+	LineTotal Money `gorm:"embedded;embeddedPrefix:line_total_" json:"line_total" readonly:"true"`
+}
+
+type OrderStatusHistory struct {
 	ID         uint      `gorm:"primaryKey" json:"id" readonly:"true" example:"1"`
-	UserID     uint      `json:"user_id" validate:"required" example:"1"`
-	Products   []uint    `gorm:"-" json:"products" validate:"required" `
-	TotalPrice float64   `json:"total_price" validate:"required,gt=0" example:"100.50"`
-	OrderDate  time.Time `json:"order_date" readonly:"true" example:"2023-07-20T15:04:05Z"`
-	Status     string    `json:"status" validate:"required,oneof=new in_process completed" example:"new"`
+	OrderID    uint      `gorm:"index;not null" json:"order_id" example:"1"`
+	FromStatus string    `json:"from_status" example:"new"`
+	ToStatus   string    `json:"to_status" example:"awaiting_payment"`
+	ChangedBy  string    `json:"changed_by" example:"user:1"`
+	Reason     string    `json:"reason" example:"Customer started checkout"`
+	ChangedAt  time.Time `json:"changed_at" example:"2023-07-20T15:04:05Z"`
+}
+
+type TransitionRequest struct {
+	Status    string `json:"status" validate:"required,oneof=new awaiting_payment paid in_process shipped completed cancelled refunded" example:"awaiting_payment"`
+	ChangedBy string `json:"changed_by" validate:"required" example:"user:1"`
+	Reason    string `json:"reason" example:"Customer started checkout"`
 }
 
 type PaymentRequest struct {
-	Amount     float64 `json:"amount" validate:"required" example:"100.00"`
-	OrderID    int     `json:"order_id" validate:"required" example:"1"`
-	UserID     int     `json:"user_id" validate:"required" example:"1"`
-	HPAN       string  `json:"hpan" validate:"required" example:"4003032704547597"`
-	ExpDate    string  `json:"expDate" validate:"required" example:"1022"`
-	CVC        string  `json:"cvc" validate:"required" example:"636"`
-	TerminalID string  `json:"terminalId" validate:"required" example:"67e34d63-102f-4bd1-898e-370781d0074d"`
+	Amount  Money `json:"amount" validate:"positive_money"`
+	OrderID int   `json:"order_id" validate:"required" example:"1"`
+	UserID  int   `json:"user_id" validate:"required" example:"1"`
+	// PaymentMethodID charges a saved card of the user instead of card data.
+	PaymentMethodID int    `json:"payment_method_id,omitempty" example:"0"`
+	HPAN            string `json:"hpan,omitempty" validate:"required_without=PaymentMethodID,excluded_with=PaymentMethodID,omitempty,luhn" example:"4003032704547597"`
+	ExpDate         string `json:"expDate,omitempty" validate:"required_without=PaymentMethodID,excluded_with=PaymentMethodID,omitempty,card_expiry" example:"1030"`
+	CVC             string `json:"cvc,omitempty" validate:"required_without=PaymentMethodID,excluded_with=PaymentMethodID,omitempty,numeric,min=3,max=4" example:"636"`
+	// SaveCard saves the card as a payment method of the user once it is accepted.
+	SaveCard bool `json:"save_card" example:"false"`
 }
 
 type Payment struct {
 	ID          int       `gorm:"primaryKey" json:"id" readonly:"true" example:"1"`
 	UserID      int       `json:"user_id" validate:"required" example:"1"`
 	OrderID     int       `json:"order_id" validate:"required" example:"1"`
-	Amount      float64   `json:"amount" validate:"required,gt=0" example:"100"`
+	Amount      Money     `gorm:"embedded;embeddedPrefix:amount_" json:"amount" validate:"positive_money"`
 	PaymentDate time.Time `json:"payment_date" readonly:"true" example:"2023-07-20T15:04:05Z"`
-	Status      string    `json:"status" validate:"required,oneof=successful unsuccessful" example:"successful"`
+	Status      string    `json:"status" validate:"required,oneof=pending requires_action authorized successful unsuccessful voided expired partially_refunded refunded" example:"successful"`
+	// InvoiceID identifies a single payment attempt at the provider.
+	InvoiceID     string `gorm:"uniqueIndex" json:"invoice_id" readonly:"true" example:"000100001"`
+	TransactionID string `json:"transaction_id" readonly:"true" example:"9a1f3c2e-5a6b-4c7d-8e9f-0a1b2c3d4e5f"`
+	// CapturedAmount is the part of Amount actually charged.
+	CapturedAmount Money `gorm:"embedded;embeddedPrefix:captured_amount_" json:"captured_amount" readonly:"true"`
+	// ExpiresAt is when an authorized payment is voided if it is not captured.
+	ExpiresAt *time.Time `json:"expires_at,omitempty" readonly:"true" example:"2023-07-27T15:04:05Z"`
+	// ActionURL is the 3-D Secure challenge of a payment in requires_action.
+	ActionURL string `json:"action_url,omitempty" readonly:"true" example:"https://mock-acs.local/challenge/mock-000001"`
+	// Only the masked card number, the brand and the provider's card token
+	// are stored; the full card number and CVC never reach the database.
+	CardMask  string `json:"card_mask" readonly:"true" example:"400303******7597"`
+	CardBrand string `json:"card_brand" readonly:"true" example:"visa"`
+	CardID    string `json:"card_id" readonly:"true" example:"b2c3d4e5-f6a7-4b8c-9d0e-1f2a3b4c5d6e"`
+}
+
+// PaymentMethod is a card saved at the payment provider. Only the provider's
+// card token and the masked card data are kept.
+type PaymentMethod struct {
+	ID        int       `gorm:"primaryKey" json:"id" readonly:"true" example:"1"`
+	UserID    int       `gorm:"uniqueIndex:idx_payment_methods_user_card" json:"user_id" example:"1"`
+	CardID    string    `gorm:"uniqueIndex:idx_payment_methods_user_card" json:"-"`
+	CardMask  string    `json:"card_mask" example:"400303******7597"`
+	CardBrand string    `json:"card_brand" example:"visa"`
+	CreatedAt time.Time `json:"created_at" readonly:"true" example:"2023-07-20T15:04:05Z"`
+}
+
+func (PaymentMethod) TableName() string {
+	return "payment_methods"
+}
+
+// SavePaymentMethodRequest saves the card of an accepted payment.
+type SavePaymentMethodRequest struct {
+	PaymentID int `json:"payment_id" validate:"required" example:"1"`
+}
+
+// PaymentDiscrepancy is a difference between a payment and its transaction
+// at the provider found by reconciliation. Differences that reconciliation
+// could fix on its own are stored as resolved.
+type PaymentDiscrepancy struct {
+	ID             int       `gorm:"primaryKey" json:"id" readonly:"true" example:"1"`
+	PaymentID      int       `gorm:"uniqueIndex:idx_payment_discrepancies_payment_kind" json:"payment_id" example:"1"`
+	InvoiceID      string    `json:"invoice_id" example:"000100001"`
+	Kind           string    `gorm:"uniqueIndex:idx_payment_discrepancies_payment_kind" json:"kind" example:"status_mismatch" enums:"stale_status,status_mismatch,amount_mismatch,missing_at_provider"`
+	LocalStatus    string    `json:"local_status" example:"successful"`
+	ProviderStatus string    `json:"provider_status" example:"declined"`
+	LocalAmount    Money     `gorm:"embedded;embeddedPrefix:local_amount_" json:"local_amount"`
+	ProviderAmount Money     `gorm:"embedded;embeddedPrefix:provider_amount_" json:"provider_amount"`
+	Resolved       bool      `json:"resolved" example:"false"`
+	Note           string    `json:"note" example:"payment is successful locally but declined at the provider"`
+	DetectedAt     time.Time `json:"detected_at" example:"2023-07-20T15:04:05Z"`
+}
+
+func (PaymentDiscrepancy) TableName() string {
+	return "payment_discrepancies"
+}
+
+// LedgerEntry is a balanced journal entry of the ledger. Amounts are integer
+// minor units of Currency, and the debits of Lines always equal the credits.
+type LedgerEntry struct {
+	ID          int          `gorm:"primaryKey" json:"id" readonly:"true" example:"1"`
+	Kind        string       `gorm:"index" json:"kind" example:"charge" enums:"charge,fee,refund,chargeback"`
+	PaymentID   int          `gorm:"index" json:"payment_id" example:"1"`
+	Reference   string       `gorm:"uniqueIndex" json:"reference" example:"payment:1:charge"`
+	Currency    string       `json:"currency" example:"KZT"`
+	Description string       `json:"description" example:"Payment 1 captured"`
+	CreatedAt   time.Time    `json:"created_at" example:"2023-07-20T15:04:05Z"`
+	Lines       []LedgerLine `gorm:"foreignKey:EntryID" json:"lines"`
+}
+
+func (LedgerEntry) TableName() string {
+	return "ledger_entries"
+}
+
+// LedgerLine debits or credits one account. Exactly one of Debit and Credit
+// is set.
+type LedgerLine struct {
+	ID       int    `gorm:"primaryKey" json:"-"`
+	EntryID  int    `gorm:"index" json:"-"`
+	Account  string `gorm:"index" json:"account" example:"assets:provider_clearing"`
+	Currency string `json:"currency" example:"KZT"`
+	Debit    int64  `gorm:"not null;check:chk_ledger_lines_amounts,debit >= 0 AND credit >= 0" json:"debit" example:"10000"`
+	Credit   int64  `gorm:"not null;check:chk_ledger_lines_one_side,(debit > 0) <> (credit > 0)" json:"credit" example:"0"`
+}
+
+func (LedgerLine) TableName() string {
+	return "ledger_lines"
+}
+
+// AccountBalance is the total of an account in one currency. Balance is
+// debits minus credits.
+type AccountBalance struct {
+	Account  string `json:"account" example:"assets:provider_clearing"`
+	Currency string `json:"currency" example:"KZT"`
+	Debit    int64  `json:"debit" example:"10000"`
+	Credit   int64  `json:"credit" example:"2500"`
+	Balance  int64  `json:"balance" example:"7500"`
+}
+
+// ChargebackRequest records a chargeback reported by the provider.
+type ChargebackRequest struct {
+	Amount Money `json:"amount" validate:"positive_money"`
+	// Reference is the provider's identifier of the chargeback; a chargeback
+	// is recorded once per reference.
+	Reference string `json:"reference" validate:"required,max=100" example:"CB-2023-0001"`
+	Reason    string `json:"reason" validate:"max=255" example:"Fraudulent transaction"`
+}
+
+// PaymentAction is the step the customer has to take before a payment can
+// be completed.
+type PaymentAction struct {
+	PaymentID int    `json:"payment_id" example:"1"`
+	Type      string `json:"type" example:"redirect_3ds"`
+	URL       string `json:"url" example:"https://mock-acs.local/challenge/mock-000001"`
+}
+
+// ActionCompletion finishes a payment after the 3-D Secure challenge.
+type ActionCompletion struct {
+	// Data is the challenge result returned by the card issuer, if any.
+	Data string `json:"data" example:"eyJ0cmFuc1N0YXR1cyI6IlkifQ"`
+}
+
+func (Payment) TableName() string {
+	return "payments_shop"
+}
+
+// CaptureRequest charges an authorized payment. Without an amount the whole
+// authorized amount is captured.
+type CaptureRequest struct {
+	Amount Money `json:"amount"`
+}
+
+// RefundRequest asks to return money of a payment. Without an amount the
+// rest of the captured amount is refunded.
+type RefundRequest struct {
+	Amount Money  `json:"amount"`
+	Reason string `json:"reason" validate:"max=255" example:"Item returned"`
+}
+
+// Refund is a single full or partial refund of a payment.
+type Refund struct {
+	ID            int       `gorm:"primaryKey" json:"id" readonly:"true" example:"1"`
+	PaymentID     int       `gorm:"index" json:"payment_id" example:"1"`
+	Amount        Money     `gorm:"embedded;embeddedPrefix:amount_" json:"amount"`
+	Reason        string    `json:"reason" example:"Item returned"`
+	Status        string    `json:"status" example:"successful" enums:"pending,successful,unsuccessful"`
+	TransactionID string    `json:"transaction_id" example:"9a1f3c2e-5a6b-4c7d-8e9f-0a1b2c3d4e5f"`
+	Message       string    `json:"message,omitempty" example:"insufficient funds on merchant account"`
+	CreatedAt     time.Time `json:"created_at" readonly:"true" example:"2023-07-20T15:04:05Z"`
+}
+
+func (Refund) TableName() string {
+	return "payment_refunds"
+}
+
+// EpayCallback is the payment result ePay posts to the postLink and
+// failurePostLink URLs.
+type EpayCallback struct {
+	ID          string  `json:"id" example:"a5f1c3e2-7b4d-4e8f-9a0b-1c2d3e4f5a6b"`
+	DateTime    string  `json:"dateTime" example:"2023-07-20T15:04:05+06:00"`
+	InvoiceID   string  `json:"invoiceId" example:"000100001"`
+	Amount      float64 `json:"amount" example:"100"`
+	Currency    string  `json:"currency" example:"KZT"`
+	Terminal    string  `json:"terminal" example:"67e34d63-102f-4bd1-898e-370781d0074d"`
+	AccountID   string  `json:"accountId" example:"1"`
+	Description string  `json:"description" example:"Order #1"`
+	CardMask    string  `json:"cardMask" example:"400303******7597"`
+	CardID      string  `json:"cardId" example:"b2c3d4e5-f6a7-4b8c-9d0e-1f2a3b4c5d6e"`
+	Reference   string  `json:"reference" example:"320415146527"`
+	Code        string  `json:"code" example:"ok"`
+	Reason      string  `json:"reason" example:"success"`
+	ReasonCode  int     `json:"reasonCode" example:"0"`
+	SecretHash  string  `json:"secret_hash" example:"3f2a..."`
+}
+
+// Checkout is the persisted state of a checkout saga. It is saved after every
+// step, so a checkout interrupted by a crash is resumed from Step.
+type Checkout struct {
+	ID       uint           `gorm:"primaryKey" json:"id" readonly:"true" example:"1"`
+	UserID   uint           `gorm:"index" json:"user_id" example:"1"`
+	Currency string         `gorm:"size:3" json:"currency" example:"KZT"`
+	Items    []CheckoutItem `gorm:"foreignKey:CheckoutID" json:"items"`
+	Total    Money          `gorm:"embedded;embeddedPrefix:total_" json:"total"`
+	// PaymentMethodID is the saved card to charge. Card data sent with the
+	// request is never stored.
+	PaymentMethodID int    `json:"payment_method_id,omitempty" example:"0"`
+	SaveCard        bool   `gorm:"not null;default:false" json:"save_card" example:"false"`
+	OrderID         uint   `gorm:"index" json:"order_id,omitempty" example:"1"`
+	PaymentID       int    `json:"payment_id,omitempty" example:"1"`
+	ActionURL       string `json:"action_url,omitempty" example:"https://mock-acs.local/challenge/mock-000001"`
+	Status          string `gorm:"index" json:"status" example:"completed" enums:"running,requires_action,compensating,completed,failed"`
+	// Step is the step being run, or being compensated while the checkout
+	// is compensating.
+	Step      string         `json:"step" example:"confirm"`
+	Error     string         `json:"error,omitempty" example:"insufficient stock"`
+	Steps     []CheckoutStep `gorm:"foreignKey:CheckoutID" json:"steps"`
+	CreatedAt time.Time      `json:"created_at" example:"2023-07-20T15:04:05Z"`
+	UpdatedAt time.Time      `json:"updated_at" example:"2023-07-20T15:04:05Z"`
+}
+
+// CheckoutItem is a line of the cart. UnitPrice is the price quoted by the
+// products service when the cart was priced.
+type CheckoutItem struct {
+	ID         uint  `gorm:"primaryKey" json:"-"`
+	CheckoutID uint  `gorm:"index;not null" json:"-"`
+	ProductID  uint  `json:"product_id" validate:"required" example:"1"`
+	Quantity   int   `json:"quantity" validate:"required,gt=0" example:"2"`
+	UnitPrice  Money `gorm:"embedded;embeddedPrefix:unit_price_" json:"unit_price" readonly:"true"`
+}
+
+// CheckoutStep records one run of a step or of its compensation.
+type CheckoutStep struct {
+	ID         uint      `gorm:"primaryKey" json:"-"`
+	CheckoutID uint      `gorm:"index;not null" json:"-"`
+	Step       string    `json:"step" example:"reserve_stock"`
+	Action     string    `json:"action" example:"execute" enums:"execute,compensate"`
+	Result     string    `json:"result" example:"succeeded" enums:"succeeded,failed,waiting"`
+	Error      string    `json:"error,omitempty" example:""`
+	CreatedAt  time.Time `json:"created_at" example:"2023-07-20T15:04:05Z"`
+}
+
+// CheckoutRequest starts a checkout. The card is charged either by its data
+// or as a saved payment method of the user.
+type CheckoutRequest struct {
+	UserID   uint           `json:"user_id" validate:"required" example:"1"`
+	Currency string         `json:"currency" validate:"omitempty,iso4217" example:"KZT"`
+	Items    []CheckoutItem `json:"items" validate:"required,min=1,dive"`
+	// PaymentMethodID charges a saved card of the user instead of card data.
+	PaymentMethodID int    `json:"payment_method_id,omitempty" example:"0"`
+	HPAN            string `json:"hpan,omitempty" validate:"required_without=PaymentMethodID,excluded_with=PaymentMethodID" example:"4003032704547597"`
+	ExpDate         string `json:"expDate,omitempty" validate:"required_without=PaymentMethodID,excluded_with=PaymentMethodID" example:"1030"`
+	CVC             string `json:"cvc,omitempty" validate:"required_without=PaymentMethodID,excluded_with=PaymentMethodID" example:"636"`
+	SaveCard        bool   `json:"save_card" example:"false"`
 }
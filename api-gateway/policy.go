@@ -0,0 +1,168 @@
+package main
+
+import (
+	"HL_online_shop/common/access"
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// Access levels of a route.
+const (
+	// accessPublic routes need no token.
+	accessPublic = iota
+	// accessUser routes are open to every signed-in user. The service checks
+	// that the order, payment or checkout belongs to the caller.
+	accessUser
+	// accessSelf routes are about one user, named by a path variable or a
+	// query parameter: clients may only use their own ID.
+	accessSelf
+	accessAdmin
+)
+
+type routePolicy struct {
+	access int
+	// ownerVar is the path variable with the user ID of a self route.
+	ownerVar string
+	// ownerQuery is the query parameter with the user ID of a self route.
+	// When a client leaves it out it is set to the client's ID.
+	ownerQuery string
+}
+
+var (
+	public    = routePolicy{access: accessPublic}
+	signedIn  = routePolicy{access: accessUser}
+	adminOnly = routePolicy{access: accessAdmin}
+)
+
+func selfPath(name string) routePolicy {
+	return routePolicy{access: accessSelf, ownerVar: name}
+}
+
+func selfQuery(name string) routePolicy {
+	return routePolicy{access: accessSelf, ownerQuery: name}
+}
+
+// routePolicies declares who may call each route, by method and path
+// template. A route missing here is refused.
+var routePolicies = map[string]routePolicy{
+	"GET /health":   public,
+	"GET /swagger/": public,
+
+	"GET /users":                                     adminOnly,
+	"POST /users":                                    adminOnly,
+	"GET /users/{id}":                                selfPath("id"),
+	"PUT /users/{id}":                                selfPath("id"),
+	"DELETE /users/{id}":                             selfPath("id"),
+	"GET /users/{id}/payment-methods":                selfPath("id"),
+	"POST /users/{id}/payment-methods":               selfPath("id"),
+	"DELETE /users/{id}/payment-methods/{method_id}": selfPath("id"),
+	"POST /users/{id}/password":                      selfPath("id"),
+	"POST /users/{id}/2fa":                           selfPath("id"),
+	"POST /users/{id}/2fa/confirm":                   selfPath("id"),
+	"POST /users/{id}/2fa/recovery-codes":            selfPath("id"),
+	"POST /users/{id}/2fa/disable":                   selfPath("id"),
+	"POST /users/{id}/2fa/reset":                     adminOnly,
+	"GET /search/users":                              adminOnly,
+
+	"POST /auth/register":               public,
+	"POST /auth/login":                  public,
+	"POST /auth/login/mfa":              public,
+	"POST /auth/refresh":                public,
+	"POST /auth/logout":                 public,
+	"POST /auth/verify-email":           public,
+	"POST /auth/verify-email/resend":    public,
+	"POST /auth/password-reset":         public,
+	"POST /auth/password-reset/request": public,
+
+	"GET /products":                           public,
+	"GET /products/{id}":                      public,
+	"GET /products/{id}/price":                public,
+	"GET /products/{id}/prices":               public,
+	"GET /search/products":                    public,
+	"GET /currency-rates":                     public,
+	"POST /products":                          adminOnly,
+	"PUT /products/{id}":                      adminOnly,
+	"DELETE /products/{id}":                   adminOnly,
+	"PUT /products/{id}/prices/{currency}":    adminOnly,
+	"DELETE /products/{id}/prices/{currency}": adminOnly,
+	"POST /currency-rates/import":             adminOnly,
+	"PUT /currency-rates/{currency}":          adminOnly,
+	"DELETE /currency-rates/{currency}":       adminOnly,
+
+	"GET /orders":                   adminOnly,
+	"POST /orders":                  signedIn,
+	"GET /orders/{id}":              signedIn,
+	"PUT /orders/{id}":              signedIn,
+	"DELETE /orders/{id}":           signedIn,
+	"POST /orders/{id}/transitions": signedIn,
+	"GET /orders/{id}/transitions":  signedIn,
+	"GET /search/orders":            selfQuery("user"),
+
+	"GET /payments":                      adminOnly,
+	"POST /payments":                     signedIn,
+	"POST /payments/authorize":           signedIn,
+	"GET /payments/{id}":                 signedIn,
+	"PUT /payments/{id}":                 adminOnly,
+	"DELETE /payments/{id}":              adminOnly,
+	"GET /payments/{id}/action":          signedIn,
+	"POST /payments/{id}/complete":       signedIn,
+	"POST /payments/{id}/capture":        adminOnly,
+	"POST /payments/{id}/void":           adminOnly,
+	"POST /payments/{id}/refunds":        adminOnly,
+	"GET /payments/{id}/refunds":         signedIn,
+	"POST /payments/{id}/chargebacks":    adminOnly,
+	"GET /payments/reconciliation":       adminOnly,
+	"GET /ledger/balances":               adminOnly,
+	"GET /ledger/journal":                adminOnly,
+	"GET /search/payments":               selfQuery("user"),
+	"POST /payments/callbacks/epay":      public,
+	"POST /payments/callbacks/epay/fail": public,
+
+	"POST /checkout":     signedIn,
+	"GET /checkout":      selfQuery("user_id"),
+	"GET /checkout/{id}": signedIn,
+}
+
+// routePolicyOf returns the policy of the route a request matched.
+func routePolicyOf(r *http.Request) (routePolicy, bool) {
+	route := mux.CurrentRoute(r)
+	if route == nil {
+		return routePolicy{}, false
+	}
+	template, err := route.GetPathTemplate()
+	if err != nil {
+		return routePolicy{}, false
+	}
+	policy, ok := routePolicies[r.Method+" "+template]
+	return policy, ok
+}
+
+// authorize checks a signed-in caller against the policy of a route. A self
+// route without its query parameter is narrowed to the caller.
+func (p routePolicy) authorize(r *http.Request, claims *Claims) error {
+	if claims.Role == access.RoleAdmin {
+		return nil
+	}
+	switch p.access {
+	case accessAdmin:
+		return fmt.Errorf("only admins may %s %s", r.Method, r.URL.Path)
+	case accessSelf:
+		if p.ownerVar != "" && mux.Vars(r)[p.ownerVar] != claims.Subject {
+			return fmt.Errorf("clients may only access their own user")
+		}
+		if p.ownerQuery != "" {
+			query := r.URL.Query()
+			switch query.Get(p.ownerQuery) {
+			case "":
+				query.Set(p.ownerQuery, claims.Subject)
+				r.URL.RawQuery = query.Encode()
+			case claims.Subject:
+			default:
+				return fmt.Errorf("clients may only search their own data")
+			}
+		}
+	}
+	return nil
+}
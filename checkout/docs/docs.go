@@ -0,0 +1,429 @@
+// Package docs Code generated by swaggo/swag. DO NOT EDIT
+package docs
+
+import "github.com/swaggo/swag"
+
+const docTemplate = `{
+    "schemes": {{ marshal .Schemes }},
+    "swagger": "2.0",
+    "info": {
+        "description": "{{escape .Description}}",
+        "title": "{{.Title}}",
+        "contact": {
+            "name": "API Support",
+            "url": "http://www.swagger.io/support",
+            "email": "support@swagger.io"
+        },
+        "version": "{{.Version}}"
+    },
+    "host": "{{.Host}}",
+    "basePath": "{{.BasePath}}",
+    "paths": {
+        "/checkout": {
+            "get": {
+                "description": "Search checkouts by order, user or status, newest first. The checkout of an order shows how far it got. Clients only find their own checkouts.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "checkout"
+                ],
+                "summary": "Search checkouts by order, user or status",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Order ID",
+                        "name": "order_id",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "User ID",
+                        "name": "user_id",
+                        "in": "query"
+                    },
+                    {
+                        "enum": [
+                            "running",
+                            "requires_action",
+                            "compensating",
+                            "completed",
+                            "failed"
+                        ],
+                        "type": "string",
+                        "description": "Checkout status",
+                        "name": "status",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/main.Checkout"
+                            }
+                        }
+                    },
+                    "403": {
+                        "description": "Another user's checkouts",
+                        "schema": {
+                            "$ref": "#/definitions/access.ErrorResponse"
+                        }
+                    }
+                }
+            },
+            "post": {
+                "description": "Place an order and pay for it in one request. The checkout validates the user, whose email address must be verified, prices the cart, creates the order, reserves stock, charges the card and confirms the order. If a step fails, the steps already done are undone: the payment is refunded, the stock released and the order cancelled. The checkout state is saved after every step and resumed after a restart. Card data is never stored, so a checkout interrupted before the card was charged can only be resumed with a saved payment method.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "checkout"
+                ],
+                "summary": "Check out a cart",
+                "parameters": [
+                    {
+                        "description": "Cart and card",
+                        "name": "checkout",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.CheckoutRequest"
+                        }
+                    },
+                    {
+                        "type": "string",
+                        "description": "Unique key that makes the request safe to retry",
+                        "name": "Idempotency-Key",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "Completed",
+                        "schema": {
+                            "$ref": "#/definitions/main.Checkout"
+                        }
+                    },
+                    "202": {
+                        "description": "Waiting for 3-D Secure (see action_url) or for the payment outcome",
+                        "schema": {
+                            "$ref": "#/definitions/main.Checkout"
+                        }
+                    },
+                    "403": {
+                        "description": "The checkout is for another user",
+                        "schema": {
+                            "$ref": "#/definitions/access.ErrorResponse"
+                        }
+                    },
+                    "422": {
+                        "description": "Failed and compensated, the reason is in error",
+                        "schema": {
+                            "$ref": "#/definitions/main.Checkout"
+                        }
+                    }
+                }
+            }
+        },
+        "/checkout/{id}": {
+            "get": {
+                "description": "Get the status of a checkout and the history of its steps",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "checkout"
+                ],
+                "summary": "Get a checkout by ID",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Checkout ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.Checkout"
+                        }
+                    },
+                    "403": {
+                        "description": "The checkout belongs to another user",
+                        "schema": {
+                            "$ref": "#/definitions/access.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Checkout not found",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/health": {
+            "get": {
+                "description": "Check the health of the service",
+                "produces": [
+                    "text/plain"
+                ],
+                "tags": [
+                    "health"
+                ],
+                "summary": "Health Check",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        }
+    },
+    "definitions": {
+        "access.ErrorResponse": {
+            "type": "object",
+            "properties": {
+                "error": {
+                    "type": "string",
+                    "example": "forbidden"
+                },
+                "message": {
+                    "type": "string",
+                    "example": "only admins may DELETE /products/1"
+                }
+            }
+        },
+        "main.Checkout": {
+            "type": "object",
+            "properties": {
+                "action_url": {
+                    "type": "string",
+                    "example": "https://mock-acs.local/challenge/mock-000001"
+                },
+                "created_at": {
+                    "type": "string",
+                    "example": "2023-07-20T15:04:05Z"
+                },
+                "currency": {
+                    "type": "string",
+                    "example": "KZT"
+                },
+                "error": {
+                    "type": "string",
+                    "example": "insufficient stock"
+                },
+                "id": {
+                    "type": "integer",
+                    "readOnly": true,
+                    "example": 1
+                },
+                "items": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/main.CheckoutItem"
+                    }
+                },
+                "order_id": {
+                    "type": "integer",
+                    "example": 1
+                },
+                "payment_id": {
+                    "type": "integer",
+                    "example": 1
+                },
+                "payment_method_id": {
+                    "description": "PaymentMethodID is the saved card to charge. Card data sent with the\nrequest is never stored.",
+                    "type": "integer",
+                    "example": 0
+                },
+                "save_card": {
+                    "type": "boolean",
+                    "example": false
+                },
+                "status": {
+                    "type": "string",
+                    "enum": [
+                        "running",
+                        "requires_action",
+                        "compensating",
+                        "completed",
+                        "failed"
+                    ],
+                    "example": "completed"
+                },
+                "step": {
+                    "description": "Step is the step being run, or being compensated while the checkout\nis compensating.",
+                    "type": "string",
+                    "example": "confirm"
+                },
+                "steps": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/main.CheckoutStep"
+                    }
+                },
+                "total": {
+                    "$ref": "#/definitions/main.Money"
+                },
+                "updated_at": {
+                    "type": "string",
+                    "example": "2023-07-20T15:04:05Z"
+                },
+                "user_id": {
+                    "type": "integer",
+                    "example": 1
+                }
+            }
+        },
+        "main.CheckoutItem": {
+            "type": "object",
+            "required": [
+                "product_id",
+                "quantity"
+            ],
+            "properties": {
+                "product_id": {
+                    "type": "integer",
+                    "example": 1
+                },
+                "quantity": {
+                    "type": "integer",
+                    "example": 2
+                },
+                "unit_price": {
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/main.Money"
+                        }
+                    ],
+                    "readOnly": true
+                }
+            }
+        },
+        "main.CheckoutRequest": {
+            "type": "object",
+            "required": [
+                "items",
+                "user_id"
+            ],
+            "properties": {
+                "currency": {
+                    "type": "string",
+                    "example": "KZT"
+                },
+                "cvc": {
+                    "type": "string",
+                    "example": "636"
+                },
+                "expDate": {
+                    "type": "string",
+                    "example": "1030"
+                },
+                "hpan": {
+                    "type": "string",
+                    "example": "4003032704547597"
+                },
+                "items": {
+                    "type": "array",
+                    "minItems": 1,
+                    "items": {
+                        "$ref": "#/definitions/main.CheckoutItem"
+                    }
+                },
+                "payment_method_id": {
+                    "description": "PaymentMethodID charges a saved card of the user instead of card data.",
+                    "type": "integer",
+                    "example": 0
+                },
+                "save_card": {
+                    "type": "boolean",
+                    "example": false
+                },
+                "user_id": {
+                    "type": "integer",
+                    "example": 1
+                }
+            }
+        },
+        "main.CheckoutStep": {
+            "type": "object",
+            "properties": {
+                "action": {
+                    "type": "string",
+                    "enum": [
+                        "execute",
+                        "compensate"
+                    ],
+                    "example": "execute"
+                },
+                "created_at": {
+                    "type": "string",
+                    "example": "2023-07-20T15:04:05Z"
+                },
+                "error": {
+                    "type": "string",
+                    "example": ""
+                },
+                "result": {
+                    "type": "string",
+                    "enum": [
+                        "succeeded",
+                        "failed",
+                        "waiting"
+                    ],
+                    "example": "succeeded"
+                },
+                "step": {
+                    "type": "string",
+                    "example": "reserve_stock"
+                }
+            }
+        },
+        "main.Money": {
+            "type": "object",
+            "properties": {
+                "amount": {
+                    "type": "integer",
+                    "minimum": 0,
+                    "example": 100050
+                },
+                "currency": {
+                    "type": "string",
+                    "example": "KZT"
+                }
+            }
+        }
+    }
+}`
+
+// SwaggerInfo holds exported Swagger Info so clients can modify it
+var SwaggerInfo = &swag.Spec{
+	Version:          "1.0",
+	Host:             "localhost:8085",
+	BasePath:         "/",
+	Schemes:          []string{},
+	Title:            "Checkout API",
+	Description:      "This is a checkout API that places and pays for orders.",
+	InfoInstanceName: "swagger",
+	SwaggerTemplate:  docTemplate,
+	LeftDelim:        "{{",
+	RightDelim:       "}}",
+}
+
+func init() {
+	swag.Register(SwaggerInfo.InstanceName(), SwaggerInfo)
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"HL_online_shop/common/access"
+	"HL_online_shop/common/money"
+	"encoding/json"
+	"github.com/go-playground/validator/v10"
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+var validate = *validator.New()
+
+// HealthCheck godoc
+// @Summary Health Check
+// @Description Check the health of the service
+// @Tags health
+// @Produce plain
+// @Success 200 {string} string "OK"
+// @Router /health [get]
+func HealthCheck(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("OK"))
+}
+
+// CreateCheckout godoc
+// @Summary Check out a cart
+// @Description Place an order and pay for it in one request. The checkout validates the user, whose email address must be verified, prices the cart, creates the order, reserves stock, charges the card and confirms the order. If a step fails, the steps already done are undone: the payment is refunded, the stock released and the order cancelled. The checkout state is saved after every step and resumed after a restart. Card data is never stored, so a checkout interrupted before the card was charged can only be resumed with a saved payment method.
+// @Tags checkout
+// @Accept json
+// @Produce json
+// @Param checkout body CheckoutRequest true "Cart and card"
+// @Param Idempotency-Key header string false "Unique key that makes the request safe to retry"
+// @Success 201 {object} Checkout "Completed"
+// @Success 202 {object} Checkout "Waiting for 3-D Secure (see action_url) or for the payment outcome"
+// @Failure 403 {object} access.ErrorResponse "The checkout is for another user"
+// @Failure 422 {object} Checkout "Failed and compensated, the reason is in error"
+// @Router /checkout [post]
+func CreateCheckout(w http.ResponseWriter, r *http.Request) {
+	var req CheckoutRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := validate.Struct(req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !access.RequireOwner(w, r, req.UserID) {
+		return
+	}
+
+	checkout := &Checkout{
+		UserID:          req.UserID,
+		Currency:        strings.ToUpper(req.Currency),
+		PaymentMethodID: req.PaymentMethodID,
+		SaveCard:        req.SaveCard,
+		Status:          CheckoutRunning,
+		Step:            checkoutSteps[0].name,
+	}
+	if checkout.Currency == "" {
+		checkout.Currency = money.ShopCurrency()
+	}
+	for _, item := range req.Items {
+		checkout.Items = append(checkout.Items, CheckoutItem{ProductID: item.ProductID, Quantity: item.Quantity})
+	}
+	if err := CreateCheckoutRepo(checkout); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var card *CardData
+	if req.PaymentMethodID == 0 {
+		card = &CardData{HPAN: req.HPAN, ExpDate: req.ExpDate, CVC: req.CVC}
+	}
+	runCheckout(checkout, card)
+
+	w.Header().Set("Content-Type", "application/json")
+	switch checkout.Status {
+	case CheckoutCompleted:
+		w.WriteHeader(http.StatusCreated)
+	case CheckoutFailed:
+		w.WriteHeader(http.StatusUnprocessableEntity)
+	default:
+		w.WriteHeader(http.StatusAccepted)
+	}
+	json.NewEncoder(w).Encode(checkout)
+}
+
+// GetCheckout godoc
+// @Summary Get a checkout by ID
+// @Description Get the status of a checkout and the history of its steps
+// @Tags checkout
+// @Produce json
+// @Param id path int true "Checkout ID"
+// @Success 200 {object} Checkout
+// @Failure 403 {object} access.ErrorResponse "The checkout belongs to another user"
+// @Failure 404 {string} string "Checkout not found"
+// @Router /checkout/{id} [get]
+func GetCheckout(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	id, err := strconv.Atoi(params["id"])
+	if err != nil {
+		http.Error(w, "Invalid checkout ID", http.StatusBadRequest)
+		return
+	}
+
+	checkout, err := GetCheckoutByIDRepo(uint(id))
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			http.Error(w, "Checkout not found", http.StatusNotFound)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	if !access.RequireOwner(w, r, checkout.UserID) {
+		return
+	}
+	json.NewEncoder(w).Encode(checkout)
+}
+
+// SearchCheckouts godoc
+// @Summary Search checkouts by order, user or status
+// @Description Search checkouts by order, user or status, newest first. The checkout of an order shows how far it got. Clients only find their own checkouts.
+// @Tags checkout
+// @Produce json
+// @Param order_id query int false "Order ID"
+// @Param user_id query int false "User ID"
+// @Param status query string false "Checkout status" Enums(running, requires_action, compensating, completed, failed)
+// @Success 200 {array} Checkout
+// @Failure 403 {object} access.ErrorResponse "Another user's checkouts"
+// @Router /checkout [get]
+func SearchCheckouts(w http.ResponseWriter, r *http.Request) {
+	var orderID, userID uint
+	if s := r.URL.Query().Get("order_id"); s != "" {
+		id, err := strconv.Atoi(s)
+		if err != nil {
+			http.Error(w, "Invalid order ID", http.StatusBadRequest)
+			return
+		}
+		orderID = uint(id)
+	}
+	if s := r.URL.Query().Get("user_id"); s != "" {
+		id, err := strconv.Atoi(s)
+		if err != nil {
+			http.Error(w, "Invalid user ID", http.StatusBadRequest)
+			return
+		}
+		userID = uint(id)
+	}
+	if caller := access.CallerFrom(r); r.URL.Query().Get("user_id") == "" && !caller.Privileged() {
+		userID = caller.UserID
+	}
+	if !access.RequireOwner(w, r, userID) {
+		return
+	}
+
+	checkouts, err := SearchCheckoutsRepo(orderID, userID, r.URL.Query().Get("status"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(checkouts)
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"HL_online_shop/common/access"
+	_ "HL_online_shop/docs"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	httpSwagger "github.com/swaggo/http-swagger"
+)
+
+// @title Checkout API
+// @version 1.0
+// @description This is a checkout API that places and pays for orders.
+// @host localhost:8085
+// @BasePath /
+
+// @contact.name API Support
+// @contact.url http://www.swagger.io/support
+// @contact.email support@swagger.io
+
+func main() {
+	InitDB()
+	if err := access.LoadInternalToken(); err != nil {
+		log.Fatal("invalid internal token: ", err)
+	}
+	go idempotencyKeys.Expire(time.Hour)
+	go resumeCheckouts(time.Now(), checkoutResumeInterval())
+
+	r := mux.NewRouter()
+	r.Use(access.RequireInternalToken)
+	r.HandleFunc("/health", HealthCheck).Methods("GET")
+	r.HandleFunc("/checkout", idempotencyKeys.Wrap(CreateCheckout)).Methods("POST")
+	r.HandleFunc("/checkout", SearchCheckouts).Methods("GET")
+	r.HandleFunc("/checkout/{id}", GetCheckout).Methods("GET")
+	r.PathPrefix("/swagger/").Handler(httpSwagger.WrapHandler)
+
+	srv := &http.Server{
+		Handler: r,
+		Addr:    "0.0.0.0:8085",
+		// Оформление ждёт ответа провайдера платежей
+		WriteTimeout: 90 * time.Second,
+		ReadTimeout:  15 * time.Second,
+	}
+
+	log.Println("Checkout service is running on port 8085")
+	log.Fatal(srv.ListenAndServe())
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"time"
+)
+
+// Checkout statuses. A checkout runs until it is completed or, after a step
+// failed, until every step done so far has been compensated.
+const (
+	CheckoutRunning        = "running"
+	CheckoutRequiresAction = "requires_action"
+	CheckoutCompensating   = "compensating"
+	CheckoutCompleted      = "completed"
+	CheckoutFailed         = "failed"
+)
+
+// Checkout is the persisted state of a checkout saga. It is saved after every
+// step, so a checkout interrupted by a crash is resumed from Step.
+type Checkout struct {
+	ID       uint           `gorm:"primaryKey" json:"id" readonly:"true" example:"1"`
+	UserID   uint           `gorm:"index" json:"user_id" example:"1"`
+	Currency string         `gorm:"size:3" json:"currency" example:"KZT"`
+	Items    []CheckoutItem `gorm:"foreignKey:CheckoutID" json:"items"`
+	Total    Money          `gorm:"embedded;embeddedPrefix:total_" json:"total"`
+	// PaymentMethodID is the saved card to charge. Card data sent with the
+	// request is never stored.
+	PaymentMethodID int    `json:"payment_method_id,omitempty" example:"0"`
+	SaveCard        bool   `gorm:"not null;default:false" json:"save_card" example:"false"`
+	OrderID         uint   `gorm:"index" json:"order_id,omitempty" example:"1"`
+	PaymentID       int    `json:"payment_id,omitempty" example:"1"`
+	ActionURL       string `json:"action_url,omitempty" example:"https://mock-acs.local/challenge/mock-000001"`
+	Status          string `gorm:"index" json:"status" example:"completed" enums:"running,requires_action,compensating,completed,failed"`
+	// Step is the step being run, or being compensated while the checkout
+	// is compensating.
+	Step      string         `json:"step" example:"confirm"`
+	Error     string         `json:"error,omitempty" example:"insufficient stock"`
+	Steps     []CheckoutStep `gorm:"foreignKey:CheckoutID" json:"steps"`
+	CreatedAt time.Time      `json:"created_at" example:"2023-07-20T15:04:05Z"`
+	UpdatedAt time.Time      `json:"updated_at" example:"2023-07-20T15:04:05Z"`
+}
+
+func (Checkout) TableName() string {
+	return "checkout_sagas"
+}
+
+// CheckoutItem is a line of the cart. UnitPrice is the price quoted by the
+// products service when the cart was priced.
+type CheckoutItem struct {
+	ID         uint  `gorm:"primaryKey" json:"-"`
+	CheckoutID uint  `gorm:"index;not null" json:"-"`
+	ProductID  uint  `json:"product_id" validate:"required" example:"1"`
+	Quantity   int   `json:"quantity" validate:"required,gt=0" example:"2"`
+	UnitPrice  Money `gorm:"embedded;embeddedPrefix:unit_price_" json:"unit_price" readonly:"true"`
+}
+
+func (CheckoutItem) TableName() string {
+	return "checkout_items"
+}
+
+// CheckoutStep records one run of a step or of its compensation.
+type CheckoutStep struct {
+	ID         uint      `gorm:"primaryKey" json:"-"`
+	CheckoutID uint      `gorm:"index;not null" json:"-"`
+	Step       string    `json:"step" example:"reserve_stock"`
+	Action     string    `json:"action" example:"execute" enums:"execute,compensate"`
+	Result     string    `json:"result" example:"succeeded" enums:"succeeded,failed,waiting"`
+	Error      string    `json:"error,omitempty" example:""`
+	CreatedAt  time.Time `json:"created_at" example:"2023-07-20T15:04:05Z"`
+}
+
+func (CheckoutStep) TableName() string {
+	return "checkout_steps"
+}
+
+// CheckoutRequest starts a checkout. The card is charged either by its data
+// or as a saved payment method of the user.
+type CheckoutRequest struct {
+	UserID   uint           `json:"user_id" validate:"required" example:"1"`
+	Currency string         `json:"currency" validate:"omitempty,iso4217" example:"KZT"`
+	Items    []CheckoutItem `json:"items" validate:"required,min=1,dive"`
+	// PaymentMethodID charges a saved card of the user instead of card data.
+	PaymentMethodID int    `json:"payment_method_id,omitempty" example:"0"`
+	HPAN            string `json:"hpan,omitempty" validate:"required_without=PaymentMethodID,excluded_with=PaymentMethodID" example:"4003032704547597"`
+	ExpDate         string `json:"expDate,omitempty" validate:"required_without=PaymentMethodID,excluded_with=PaymentMethodID" example:"1030"`
+	CVC             string `json:"cvc,omitempty" validate:"required_without=PaymentMethodID,excluded_with=PaymentMethodID" example:"636"`
+	SaveCard        bool   `json:"save_card" example:"false"`
+}
+
+// CardData is the card of a checkout. It only lives in memory while the
+// request is being handled.
+type CardData struct {
+	HPAN    string
+	ExpDate string
+	CVC     string
+}
@@ -0,0 +1,11 @@
+package main
+
+import "HL_online_shop/common/money"
+
+// Money is an exact amount in minor units of a currency, shared with the
+// other services.
+type Money = money.Money
+
+func init() {
+	money.RegisterValidation(&validate)
+}
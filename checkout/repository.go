@@ -0,0 +1,110 @@
+package main
+
+import (
+	"HL_online_shop/common/idempotency"
+	"github.com/joho/godotenv"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"log"
+	"os"
+	"time"
+)
+
+var db *gorm.DB
+
+// idempotencyKeys stores the responses to requests sent with an
+// Idempotency-Key header.
+var idempotencyKeys *idempotency.Store
+
+func InitDB() {
+	if err := godotenv.Load(); err != nil {
+		log.Println("Error loading .env file")
+	}
+	url := os.Getenv("DATABASE_URL")
+	dsn := url
+	var err error
+	db, err = gorm.Open(postgres.Open(dsn), &gorm.Config{Logger: logger.New(
+		log.New(os.Stdout, "\r\n", log.LstdFlags),
+		logger.Config{
+			SlowThreshold: 200 * time.Millisecond,
+			LogLevel:      logger.Warn,
+			// Значения параметров не попадают в лог
+			ParameterizedQueries:      true,
+			IgnoreRecordNotFoundError: true,
+			Colorful:                  true,
+		},
+	)})
+	if err != nil {
+		log.Fatal("failed to connect to the database:", err)
+	}
+
+	db.AutoMigrate(&Checkout{}, &CheckoutItem{}, &CheckoutStep{})
+	idempotencyKeys = idempotency.NewStore(db, "checkout_idempotency_keys")
+	idempotencyKeys.Migrate()
+}
+
+func CreateCheckoutRepo(checkout *Checkout) error {
+	return db.Create(checkout).Error
+}
+
+// SaveCheckoutRepo saves the state of a checkout and the prices of its items.
+func SaveCheckoutRepo(checkout *Checkout) error {
+	return db.Session(&gorm.Session{FullSaveAssociations: true}).Omit("Steps").Save(checkout).Error
+}
+
+// AddCheckoutStepRepo appends a step run to the history of a checkout.
+func AddCheckoutStepRepo(step *CheckoutStep) error {
+	return db.Create(step).Error
+}
+
+func GetCheckoutByIDRepo(id uint) (*Checkout, error) {
+	var checkout Checkout
+	result := db.Preload("Items").Preload("Steps", func(tx *gorm.DB) *gorm.DB {
+		return tx.Order("id")
+	}).First(&checkout, id)
+	return &checkout, result.Error
+}
+
+func SearchCheckoutsRepo(orderID, userID uint, status string) ([]Checkout, error) {
+	var checkouts []Checkout
+	query := db.Preload("Items").Preload("Steps", func(tx *gorm.DB) *gorm.DB {
+		return tx.Order("id")
+	})
+	if orderID != 0 {
+		query = query.Where("order_id = ?", orderID)
+	}
+	if userID != 0 {
+		query = query.Where("user_id = ?", userID)
+	}
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+	result := query.Order("id DESC").Find(&checkouts)
+	return checkouts, result.Error
+}
+
+// GetUnfinishedCheckoutsRepo returns the checkouts that are neither completed
+// nor failed and have not been updated since before.
+func GetUnfinishedCheckoutsRepo(before time.Time) ([]Checkout, error) {
+	var checkouts []Checkout
+	result := db.Preload("Items").
+		Where("status IN ? AND updated_at < ?", []string{CheckoutRunning, CheckoutRequiresAction, CheckoutCompensating}, before).
+		Order("id").
+		Find(&checkouts)
+	return checkouts, result.Error
+}
+
+// ClaimCheckoutRepo touches a checkout that has not changed since it was
+// loaded. It returns false if someone else has updated it in the meantime.
+func ClaimCheckoutRepo(checkout *Checkout) (bool, error) {
+	now := time.Now()
+	result := db.Model(&Checkout{}).
+		Where("id = ? AND updated_at = ?", checkout.ID, checkout.UpdatedAt).
+		UpdateColumn("updated_at", now)
+	if result.Error != nil || result.RowsAffected == 0 {
+		return false, result.Error
+	}
+	checkout.UpdatedAt = now
+	return true, nil
+}
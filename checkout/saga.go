@@ -0,0 +1,395 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+)
+
+var (
+	// ErrActionRequired means the customer has to pass 3-D Secure before
+	// the payment goes through.
+	ErrActionRequired = errors.New("payment requires customer action")
+	// ErrOutcomeUnknown means a step may or may not have taken effect, e.g.
+	// after a timeout. The checkout is left as it is and checked again later.
+	ErrOutcomeUnknown = errors.New("outcome unknown")
+	ErrPaymentFailed  = errors.New("payment failed")
+	ErrCardNotStored  = errors.New("card data is not stored, the card cannot be charged after a restart")
+)
+
+// sagaStep is a step of the checkout. compensate undoes the step and must be
+// safe to run more than once and when execute had no effect; steps that only
+// read have none.
+type sagaStep struct {
+	name       string
+	execute    func(checkout *Checkout, card *CardData) error
+	compensate func(checkout *Checkout) error
+}
+
+var checkoutSteps = []sagaStep{
+	{name: "validate_user", execute: validateUserStep},
+	{name: "price_cart", execute: priceCartStep},
+	{name: "create_order", execute: createOrderStep, compensate: cancelOrderStep},
+	{name: "reserve_stock", execute: reserveStockStep, compensate: releaseStockStep},
+	{name: "charge_payment", execute: chargePaymentStep, compensate: refundPaymentStep},
+	{name: "confirm", execute: confirmStep},
+}
+
+func stepIndex(name string) int {
+	for i, step := range checkoutSteps {
+		if step.name == name {
+			return i
+		}
+	}
+	return 0
+}
+
+// runCheckout runs the steps of a checkout from its current step. If a step
+// fails, the steps done so far are compensated in reverse order. card is nil
+// when a checkout is resumed, since card data is never stored.
+func runCheckout(checkout *Checkout, card *CardData) {
+	if checkout.Status == CheckoutCompensating {
+		compensateCheckout(checkout)
+		return
+	}
+
+	for i := stepIndex(checkout.Step); i < len(checkoutSteps); i++ {
+		step := checkoutSteps[i]
+		checkout.Step = step.name
+		checkout.Status = CheckoutRunning
+		saveCheckout(checkout)
+
+		err := step.execute(checkout, card)
+		switch {
+		case err == nil:
+			recordStep(checkout, step.name, "execute", "succeeded", nil)
+		case errors.Is(err, ErrActionRequired), errors.Is(err, ErrOutcomeUnknown):
+			// Сага ждёт клиента или результата платежа и продолжится в resumeCheckouts
+			if errors.Is(err, ErrActionRequired) {
+				checkout.Status = CheckoutRequiresAction
+			}
+			recordStep(checkout, step.name, "execute", "waiting", err)
+			saveCheckout(checkout)
+			return
+		default:
+			recordStep(checkout, step.name, "execute", "failed", err)
+			checkout.Error = err.Error()
+			checkout.Status = CheckoutCompensating
+			saveCheckout(checkout)
+			compensateCheckout(checkout)
+			return
+		}
+	}
+
+	checkout.Status = CheckoutCompleted
+	checkout.ActionURL = ""
+	saveCheckout(checkout)
+}
+
+// compensateCheckout undoes the steps of a failed checkout, starting with the
+// step that failed. A compensation that fails is retried by resumeCheckouts.
+func compensateCheckout(checkout *Checkout) {
+	for i := stepIndex(checkout.Step); i >= 0; i-- {
+		step := checkoutSteps[i]
+		if step.compensate == nil {
+			continue
+		}
+		checkout.Step = step.name
+		saveCheckout(checkout)
+
+		if err := step.compensate(checkout); err != nil {
+			log.Printf("failed to compensate step %s of checkout %d: %v", step.name, checkout.ID, err)
+			recordStep(checkout, step.name, "compensate", "failed", err)
+			return
+		}
+		recordStep(checkout, step.name, "compensate", "succeeded", nil)
+	}
+
+	checkout.Status = CheckoutFailed
+	checkout.ActionURL = ""
+	saveCheckout(checkout)
+}
+
+// saveCheckout persists the state of a checkout. A failure is only logged:
+// the checkout is then resumed from the last saved step, and every step can
+// be repeated without doing its work twice.
+func saveCheckout(checkout *Checkout) {
+	if err := SaveCheckoutRepo(checkout); err != nil {
+		log.Printf("failed to save checkout %d: %v", checkout.ID, err)
+	}
+}
+
+func recordStep(checkout *Checkout, name, action, result string, err error) {
+	step := CheckoutStep{CheckoutID: checkout.ID, Step: name, Action: action, Result: result}
+	if err != nil {
+		step.Error = err.Error()
+	}
+	if err := AddCheckoutStepRepo(&step); err != nil {
+		log.Printf("failed to record step %s of checkout %d: %v", name, checkout.ID, err)
+	}
+	checkout.Steps = append(checkout.Steps, step)
+}
+
+// validateUserStep makes sure the user exists and may place orders.
+func validateUserStep(checkout *Checkout, card *CardData) error {
+	user, err := getUser(checkout.UserID)
+	if err != nil {
+		return err
+	}
+	if user.EmailVerifiedAt == nil {
+		return fmt.Errorf("%w: verify your email address before placing orders", ErrRejected)
+	}
+	return nil
+}
+
+// priceCartStep quotes every item in the checkout currency.
+func priceCartStep(checkout *Checkout, card *CardData) error {
+	total := Money{Currency: checkout.Currency}
+	for i := range checkout.Items {
+		item := &checkout.Items[i]
+		quote, err := getPriceQuote(item.ProductID, checkout.Currency)
+		if err != nil {
+			return err
+		}
+		if quote.Price.Currency != checkout.Currency {
+			return fmt.Errorf("%w: product %d is priced in %s", ErrServiceUnavailable, item.ProductID, quote.Price.Currency)
+		}
+		item.UnitPrice = quote.Price
+		total = total.Add(quote.Price.Times(item.Quantity))
+	}
+	checkout.Total = total
+	return nil
+}
+
+// createOrderStep creates the order. If the orders service could not be
+// reached or failed, the order may exist already; the step is then retried
+// with the same idempotency key instead of being compensated, which would
+// leave that order behind without its ID.
+func createOrderStep(checkout *Checkout, card *CardData) error {
+	order, err := createOrder(checkout)
+	if err != nil {
+		return outcomeUnknown(err)
+	}
+	checkout.OrderID = order.ID
+	return nil
+}
+
+// cancelOrderStep cancels the order, which also releases its stock. An order
+// whose payment was refunded is already closed.
+func cancelOrderStep(checkout *Checkout) error {
+	if checkout.OrderID == 0 {
+		return nil
+	}
+	err := transitionOrder(checkout.OrderID, "cancelled", fmt.Sprintf("Checkout %d failed", checkout.ID))
+	if statusOf(err) != http.StatusConflict {
+		return err
+	}
+	order, err := getOrder(checkout.OrderID)
+	if err != nil {
+		return err
+	}
+	if order.Status != "cancelled" && order.Status != "refunded" {
+		return fmt.Errorf("order %d cannot be cancelled in status %s", order.ID, order.Status)
+	}
+	return nil
+}
+
+func reserveStockStep(checkout *Checkout, card *CardData) error {
+	return outcomeUnknown(reserveStock(checkout))
+}
+
+// outcomeUnknown turns the error of a call that may have taken effect, since
+// the service could not be reached or failed with a 5xx, into
+// ErrOutcomeUnknown. Rejections are returned as they are.
+func outcomeUnknown(err error) error {
+	if err != nil && errors.Is(err, ErrServiceUnavailable) {
+		return fmt.Errorf("%w: %v", ErrOutcomeUnknown, err)
+	}
+	return err
+}
+
+func releaseStockStep(checkout *Checkout) error {
+	if checkout.OrderID == 0 {
+		return nil
+	}
+	if err := releaseStock(checkout.OrderID); err != nil && statusOf(err) != http.StatusNotFound {
+		return err
+	}
+	return nil
+}
+
+// chargePaymentStep charges the checkout total. A payment of the order made
+// before the checkout was interrupted is picked up instead of charging the
+// card again. A payment the provider only authorized is captured here, so the
+// order is never confirmed on money that was held but not charged.
+func chargePaymentStep(checkout *Checkout, card *CardData) error {
+	payment, err := checkoutPayment(checkout)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrOutcomeUnknown, err)
+	}
+	if payment == nil {
+		if card == nil && checkout.PaymentMethodID == 0 {
+			return ErrCardNotStored
+		}
+		payment, err = chargePayment(checkout, card)
+		if err != nil {
+			// 502 означает, что провайдер отклонил платёж; при остальных ошибках исход неизвестен
+			status := statusOf(err)
+			if status == http.StatusBadGateway || errors.Is(err, ErrRejected) {
+				return fmt.Errorf("%w: %v", ErrPaymentFailed, err)
+			}
+			return fmt.Errorf("%w: %v", ErrOutcomeUnknown, err)
+		}
+	}
+
+	checkout.PaymentID = payment.ID
+	checkout.ActionURL = payment.ActionURL
+	switch payment.Status {
+	case "successful":
+		return nil
+	case "authorized":
+		return capturePaymentStep(checkout, payment)
+	case "requires_action":
+		if time.Since(checkout.CreatedAt) > checkoutActionTimeout() {
+			return fmt.Errorf("%w: 3-D Secure not completed within %s", ErrPaymentFailed, checkoutActionTimeout())
+		}
+		return ErrActionRequired
+	case "pending":
+		return fmt.Errorf("%w: payment %d is pending", ErrOutcomeUnknown, payment.ID)
+	}
+	return fmt.Errorf("%w: payment %d is %s", ErrPaymentFailed, payment.ID, payment.Status)
+}
+
+// capturePaymentStep captures an authorized payment of a checkout. A 409
+// means the payment is no longer authorized, e.g. it was captured by an
+// earlier attempt or has expired; its status is checked again on resume.
+func capturePaymentStep(checkout *Checkout, payment *Payment) error {
+	captured, err := capturePayment(payment.ID)
+	if err != nil {
+		status := statusOf(err)
+		if status == http.StatusConflict {
+			return fmt.Errorf("%w: %v", ErrOutcomeUnknown, err)
+		}
+		if status == http.StatusBadGateway || errors.Is(err, ErrRejected) {
+			return fmt.Errorf("%w: %v", ErrPaymentFailed, err)
+		}
+		return fmt.Errorf("%w: %v", ErrOutcomeUnknown, err)
+	}
+	if captured.Status != "successful" {
+		return fmt.Errorf("%w: payment %d is %s after capture", ErrPaymentFailed, captured.ID, captured.Status)
+	}
+	return nil
+}
+
+// checkoutPayment returns the payment of a checkout, or nil if the card has
+// not been charged yet.
+func checkoutPayment(checkout *Checkout) (*Payment, error) {
+	if checkout.PaymentID != 0 {
+		return getPayment(checkout.PaymentID)
+	}
+	return findPayment(checkout.OrderID)
+}
+
+// refundPaymentStep returns the money of a charged payment and releases an
+// authorized one.
+func refundPaymentStep(checkout *Checkout) error {
+	if checkout.OrderID == 0 {
+		return nil
+	}
+	payment, err := checkoutPayment(checkout)
+	if err != nil || payment == nil {
+		return err
+	}
+	checkout.PaymentID = payment.ID
+
+	switch payment.Status {
+	case "successful", "partially_refunded":
+		return refundPayment(payment.ID, fmt.Sprintf("Checkout %d failed", checkout.ID))
+	case "authorized":
+		return voidPayment(payment.ID)
+	case "pending":
+		return fmt.Errorf("%w: payment %d is pending", ErrOutcomeUnknown, payment.ID)
+	}
+	return nil
+}
+
+// confirmStep makes sure the order is paid. The payments service moves the
+// order to paid itself, so this only repairs a transition it failed to make,
+// and only once the payment has been captured.
+func confirmStep(checkout *Checkout, card *CardData) error {
+	order, err := getOrder(checkout.OrderID)
+	if err != nil {
+		return err
+	}
+	switch order.Status {
+	case "paid":
+		return nil
+	case "awaiting_payment":
+		payment, err := getPayment(checkout.PaymentID)
+		if err != nil {
+			return err
+		}
+		if payment.Status != "successful" {
+			return fmt.Errorf("%w: payment %d is %s", ErrRejected, payment.ID, payment.Status)
+		}
+		return transitionOrder(order.ID, "paid", fmt.Sprintf("Checkout %d confirmed payment %d", checkout.ID, checkout.PaymentID))
+	}
+	return fmt.Errorf("%w: order %d is %s", ErrRejected, order.ID, order.Status)
+}
+
+// checkoutResumeInterval is how often unfinished checkouts are resumed. It
+// can be changed with the CHECKOUT_RESUME_INTERVAL environment variable.
+func checkoutResumeInterval() time.Duration {
+	if interval, err := time.ParseDuration(os.Getenv("CHECKOUT_RESUME_INTERVAL")); err == nil && interval > 0 {
+		return interval
+	}
+	return time.Minute
+}
+
+// checkoutActionTimeout is how long a checkout waits for the customer to pass
+// 3-D Secure before it is compensated. It can be changed with the
+// CHECKOUT_ACTION_TIMEOUT environment variable.
+func checkoutActionTimeout() time.Duration {
+	if timeout, err := time.ParseDuration(os.Getenv("CHECKOUT_ACTION_TIMEOUT")); err == nil && timeout > 0 {
+		return timeout
+	}
+	return 15 * time.Minute
+}
+
+// resumeCheckouts continues the checkouts that were interrupted, wait for the
+// customer or for a payment outcome, or still have steps to compensate.
+// First every checkout left unfinished before startedAt is resumed; afterwards
+// only those that have not been updated for an interval, so a checkout that
+// is still being handled by a request is left alone.
+func resumeCheckouts(startedAt time.Time, interval time.Duration) {
+	resumeUnfinishedCheckouts(startedAt)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		resumeUnfinishedCheckouts(time.Now().Add(-interval))
+	}
+}
+
+func resumeUnfinishedCheckouts(before time.Time) {
+	checkouts, err := GetUnfinishedCheckoutsRepo(before)
+	if err != nil {
+		log.Println("failed to load unfinished checkouts:", err)
+		return
+	}
+	for i := range checkouts {
+		checkout := &checkouts[i]
+		claimed, err := ClaimCheckoutRepo(checkout)
+		if err != nil {
+			log.Printf("failed to claim checkout %d: %v", checkout.ID, err)
+			continue
+		}
+		if !claimed {
+			continue
+		}
+		log.Printf("resuming checkout %d at step %s (%s)", checkout.ID, checkout.Step, checkout.Status)
+		runCheckout(checkout, nil)
+	}
+}
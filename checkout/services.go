@@ -0,0 +1,329 @@
+package main
+
+import (
+	"HL_online_shop/common/access"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrRejected is returned when a service refuses a request, e.g. for
+	// insufficient stock. Retrying the same request does not help.
+	ErrRejected           = errors.New("request rejected")
+	ErrServiceUnavailable = errors.New("service unavailable")
+)
+
+var serviceClient = &http.Client{Timeout: 10 * time.Second, Transport: access.Transport{Base: http.DefaultTransport}}
+
+// paymentsClient waits longer, since a charge waits for the payment provider.
+var paymentsClient = &http.Client{Timeout: 45 * time.Second, Transport: access.Transport{Base: http.DefaultTransport}}
+
+func serviceURL(env, fallback string) string {
+	if url := os.Getenv(env); url != "" {
+		return url
+	}
+	return fallback
+}
+
+func usersServiceURL() string {
+	return serviceURL("USERS_SERVICE_URL", "http://user-service:8081")
+}
+
+func productsServiceURL() string {
+	return serviceURL("PRODUCTS_SERVICE_URL", "http://product-service:8082")
+}
+
+func ordersServiceURL() string {
+	return serviceURL("ORDERS_SERVICE_URL", "http://order-service:8083")
+}
+
+func paymentsServiceURL() string {
+	return serviceURL("PAYMENTS_SERVICE_URL", "http://payment-service:8084")
+}
+
+// serviceError keeps the status and message of a failed call. It matches
+// ErrRejected for client errors and ErrServiceUnavailable otherwise.
+type serviceError struct {
+	service string
+	status  int
+	msg     string
+}
+
+func (e *serviceError) Error() string {
+	return fmt.Sprintf("%s service: %s", e.service, e.msg)
+}
+
+func (e *serviceError) Unwrap() error {
+	if e.status >= 400 && e.status < 500 {
+		return ErrRejected
+	}
+	return ErrServiceUnavailable
+}
+
+// statusOf returns the HTTP status of a failed call, or 0 if the service
+// could not be reached.
+func statusOf(err error) int {
+	var serr *serviceError
+	if errors.As(err, &serr) {
+		return serr.status
+	}
+	return 0
+}
+
+// callService sends a JSON request and decodes a successful response into
+// out. The idempotency key, if any, makes the request safe to repeat.
+func callService(client *http.Client, service, method, url, idempotencyKey string, body, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, url, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if idempotencyKey != "" {
+		req.Header.Set("Idempotency-Key", idempotencyKey)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: %s service: %v", ErrServiceUnavailable, service, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(resp.Body)
+		text := strings.TrimSpace(string(msg))
+		if text == "" {
+			text = resp.Status
+		}
+		return &serviceError{service: service, status: resp.StatusCode, msg: text}
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("%w: %s service: %v", ErrServiceUnavailable, service, err)
+	}
+	return nil
+}
+
+// User is the subset of the users service model the checkout needs.
+type User struct {
+	ID              uint       `json:"id"`
+	EmailVerifiedAt *time.Time `json:"email_verified_at"`
+}
+
+func getUser(userID uint) (*User, error) {
+	var user User
+	if err := callService(serviceClient, "users", http.MethodGet, fmt.Sprintf("%s/users/%d", usersServiceURL(), userID), "", nil, &user); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// PriceQuote is the price of a product in one currency as returned by the
+// products service.
+type PriceQuote struct {
+	ProductID uint  `json:"product_id"`
+	Price     Money `json:"price"`
+}
+
+func getPriceQuote(productID uint, currency string) (*PriceQuote, error) {
+	u := fmt.Sprintf("%s/products/%d/price", productsServiceURL(), productID)
+	if currency != "" {
+		u += "?currency=" + url.QueryEscape(currency)
+	}
+	var quote PriceQuote
+	if err := callService(serviceClient, "products", http.MethodGet, u, "", nil, &quote); err != nil {
+		return nil, err
+	}
+	return &quote, nil
+}
+
+type reservationItem struct {
+	ProductID uint `json:"product_id"`
+	Quantity  int  `json:"quantity"`
+}
+
+type reservationRequest struct {
+	OrderID uint              `json:"order_id"`
+	Items   []reservationItem `json:"items"`
+}
+
+// reserveStock reserves the cart for the order of a checkout. Reserving an
+// order again returns its existing reservations.
+func reserveStock(checkout *Checkout) error {
+	req := reservationRequest{OrderID: checkout.OrderID}
+	for _, item := range checkout.Items {
+		req.Items = append(req.Items, reservationItem{ProductID: item.ProductID, Quantity: item.Quantity})
+	}
+	return callService(serviceClient, "products", http.MethodPost, productsServiceURL()+"/reservations", "", req, nil)
+}
+
+func releaseStock(orderID uint) error {
+	u := fmt.Sprintf("%s/reservations/%d/release", productsServiceURL(), orderID)
+	return callService(serviceClient, "products", http.MethodPost, u, "", nil, nil)
+}
+
+// Order is the subset of the orders service model the checkout service needs.
+type Order struct {
+	ID         uint   `json:"id"`
+	TotalPrice Money  `json:"total_price"`
+	Status     string `json:"status"`
+}
+
+type orderItem struct {
+	ProductID uint `json:"product_id"`
+	Quantity  int  `json:"quantity"`
+}
+
+type orderRequest struct {
+	UserID     uint        `json:"user_id"`
+	Currency   string      `json:"currency"`
+	Items      []orderItem `json:"items"`
+	TotalPrice Money       `json:"total_price"`
+}
+
+// createOrder creates the order of a checkout without reserving stock. The
+// total quoted for the cart is sent along, so the order is rejected if the
+// prices have changed since. The request is sent with an idempotency key,
+// so a resumed checkout gets the order created before.
+func createOrder(checkout *Checkout) (*Order, error) {
+	req := orderRequest{UserID: checkout.UserID, Currency: checkout.Currency, TotalPrice: checkout.Total}
+	for _, item := range checkout.Items {
+		req.Items = append(req.Items, orderItem{ProductID: item.ProductID, Quantity: item.Quantity})
+	}
+	var order Order
+	key := fmt.Sprintf("checkout-%d-order", checkout.ID)
+	err := callService(serviceClient, "orders", http.MethodPost, ordersServiceURL()+"/orders?reserve_stock=false", key, req, &order)
+	if err != nil {
+		return nil, err
+	}
+	return &order, nil
+}
+
+func getOrder(orderID uint) (*Order, error) {
+	var order Order
+	err := callService(serviceClient, "orders", http.MethodGet, fmt.Sprintf("%s/orders/%d", ordersServiceURL(), orderID), "", nil, &order)
+	if err != nil {
+		return nil, err
+	}
+	return &order, nil
+}
+
+type orderTransition struct {
+	Status    string `json:"status"`
+	ChangedBy string `json:"changed_by"`
+	Reason    string `json:"reason,omitempty"`
+}
+
+func transitionOrder(orderID uint, status, reason string) error {
+	u := fmt.Sprintf("%s/orders/%d/transitions", ordersServiceURL(), orderID)
+	body := orderTransition{Status: status, ChangedBy: "checkout-service", Reason: reason}
+	return callService(serviceClient, "orders", http.MethodPost, u, "", body, nil)
+}
+
+// Payment is the subset of the payments service model the checkout service
+// needs.
+type Payment struct {
+	ID             int    `json:"id"`
+	Status         string `json:"status"`
+	CapturedAmount Money  `json:"captured_amount"`
+	ActionURL      string `json:"action_url"`
+}
+
+type paymentRequest struct {
+	Amount          Money  `json:"amount"`
+	OrderID         uint   `json:"order_id"`
+	UserID          uint   `json:"user_id"`
+	PaymentMethodID int    `json:"payment_method_id,omitempty"`
+	HPAN            string `json:"hpan,omitempty"`
+	ExpDate         string `json:"expDate,omitempty"`
+	CVC             string `json:"cvc,omitempty"`
+	SaveCard        bool   `json:"save_card"`
+}
+
+// chargePayment charges the total of a checkout, with the card data if given
+// and otherwise with the saved payment method.
+func chargePayment(checkout *Checkout, card *CardData) (*Payment, error) {
+	req := paymentRequest{
+		Amount:          checkout.Total,
+		OrderID:         checkout.OrderID,
+		UserID:          checkout.UserID,
+		PaymentMethodID: checkout.PaymentMethodID,
+		SaveCard:        checkout.SaveCard,
+	}
+	if card != nil {
+		req.HPAN, req.ExpDate, req.CVC = card.HPAN, card.ExpDate, card.CVC
+	}
+	var payment Payment
+	key := fmt.Sprintf("checkout-%d-payment", checkout.ID)
+	if err := callService(paymentsClient, "payments", http.MethodPost, paymentsServiceURL()+"/payments", key, req, &payment); err != nil {
+		return nil, err
+	}
+	return &payment, nil
+}
+
+func getPayment(paymentID int) (*Payment, error) {
+	var payment Payment
+	err := callService(serviceClient, "payments", http.MethodGet, fmt.Sprintf("%s/payments/%d", paymentsServiceURL(), paymentID), "", nil, &payment)
+	if err != nil {
+		return nil, err
+	}
+	return &payment, nil
+}
+
+// findPayment returns the latest payment of an order, or nil if it has none.
+func findPayment(orderID uint) (*Payment, error) {
+	var payments []Payment
+	u := fmt.Sprintf("%s/search/payments?order=%d", paymentsServiceURL(), orderID)
+	if err := callService(serviceClient, "payments", http.MethodGet, u, "", nil, &payments); err != nil {
+		return nil, err
+	}
+	var latest *Payment
+	for i := range payments {
+		if latest == nil || payments[i].ID > latest.ID {
+			latest = &payments[i]
+		}
+	}
+	return latest, nil
+}
+
+type refundRequest struct {
+	Reason string `json:"reason"`
+}
+
+// refundPayment refunds everything not refunded yet.
+func refundPayment(paymentID int, reason string) error {
+	u := fmt.Sprintf("%s/payments/%d/refunds", paymentsServiceURL(), paymentID)
+	return callService(paymentsClient, "payments", http.MethodPost, u, "", refundRequest{Reason: reason}, nil)
+}
+
+// capturePayment charges the whole authorized amount of a payment.
+func capturePayment(paymentID int) (*Payment, error) {
+	var payment Payment
+	u := fmt.Sprintf("%s/payments/%d/capture", paymentsServiceURL(), paymentID)
+	if err := callService(paymentsClient, "payments", http.MethodPost, u, "", nil, &payment); err != nil {
+		return nil, err
+	}
+	return &payment, nil
+}
+
+func voidPayment(paymentID int) error {
+	u := fmt.Sprintf("%s/payments/%d/void", paymentsServiceURL(), paymentID)
+	return callService(paymentsClient, "payments", http.MethodPost, u, "", nil, nil)
+}
@@ -0,0 +1,191 @@
+// Package access checks who a request to a service was made by. The API
+// gateway verifies the token of a client and passes the user on in trusted
+// headers, together with a secret it shares with the services; the services
+// send the same secret to each other.
+package access
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"strconv"
+)
+
+// Headers the API gateway sets from a verified token. HeaderInternalToken
+// carries the secret the gateway and the services share; requests from the
+// other services carry it without a user. The gateway marks requests to
+// public routes with HeaderAnonymous, so they do not pass for a service.
+const (
+	HeaderUserID        = "X-User-ID"
+	HeaderUserRole      = "X-User-Role"
+	HeaderInternalToken = "X-Internal-Token"
+	HeaderAnonymous     = "X-Anonymous"
+)
+
+const (
+	RoleAdmin  = "admin"
+	RoleClient = "client"
+)
+
+var internalToken []byte
+
+// ReadInternalToken reads the secret shared by the gateway and the services
+// from INTERNAL_SERVICE_TOKEN.
+func ReadInternalToken() ([]byte, error) {
+	token := []byte(os.Getenv("INTERNAL_SERVICE_TOKEN"))
+	if len(token) < 32 {
+		return nil, errors.New("INTERNAL_SERVICE_TOKEN must be set to at least 32 bytes")
+	}
+	return token, nil
+}
+
+// LoadInternalToken reads the internal token that RequireInternalToken checks
+// and Transport sends.
+func LoadInternalToken() error {
+	token, err := ReadInternalToken()
+	if err != nil {
+		return err
+	}
+	internalToken = token
+	return nil
+}
+
+// fromInside reports whether a request carries the internal token.
+func fromInside(r *http.Request) bool {
+	token := []byte(r.Header.Get(HeaderInternalToken))
+	return len(internalToken) > 0 && subtle.ConstantTimeCompare(token, internalToken) == 1
+}
+
+// RequireInternalToken answers 401 to requests without the internal token, so
+// a service cannot be called around the gateway. Health checks are open.
+func RequireInternalToken(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/health" && !fromInside(r) {
+			WriteUnauthorized(w)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Transport sends the internal token with every call to the other services.
+type Transport struct {
+	Base http.RoundTripper
+}
+
+func (t Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Header.Set(HeaderInternalToken, string(internalToken))
+	return t.Base.RoundTrip(req)
+}
+
+// Caller is the user or service a request was made by.
+type Caller struct {
+	UserID uint
+	Role   string
+	// Service is set for calls from another service on no user's behalf.
+	Service bool
+}
+
+// CallerFrom returns the caller of a request, or nil if the request does not
+// carry the internal token or came from an anonymous client through the
+// gateway, and so is unauthenticated.
+func CallerFrom(r *http.Request) *Caller {
+	if !fromInside(r) || r.Header.Get(HeaderAnonymous) != "" {
+		return nil
+	}
+	subject := r.Header.Get(HeaderUserID)
+	if subject == "" {
+		return &Caller{Service: true}
+	}
+	caller := &Caller{Role: r.Header.Get(HeaderUserRole)}
+	// Нечисловой ID не совпадёт ни с одним пользователем
+	if id, err := strconv.ParseUint(subject, 10, 64); err == nil {
+		caller.UserID = uint(id)
+	}
+	return caller
+}
+
+// Privileged reports whether the caller may act on the data of every user,
+// which admins and the other services may.
+func (c *Caller) Privileged() bool {
+	return c != nil && (c.Service || c.Role == RoleAdmin)
+}
+
+func (c *Caller) Owns(userID uint) bool {
+	return c != nil && (c.Privileged() || c.UserID == userID)
+}
+
+// RequireAdmin writes a 401 response if the caller is unknown and a 403
+// response unless it is privileged.
+func RequireAdmin(w http.ResponseWriter, r *http.Request) bool {
+	caller := CallerFrom(r)
+	if caller == nil {
+		WriteUnauthorized(w)
+		return false
+	}
+	if caller.Privileged() {
+		return true
+	}
+	WriteForbidden(w, "only admins may "+r.Method+" "+r.URL.Path)
+	return false
+}
+
+// RequireOwner writes a 401 response if the caller is unknown and a 403
+// response unless it is the user or is privileged.
+func RequireOwner(w http.ResponseWriter, r *http.Request, userID uint) bool {
+	caller := CallerFrom(r)
+	if caller == nil {
+		WriteUnauthorized(w)
+		return false
+	}
+	if caller.Owns(userID) {
+		return true
+	}
+	WriteForbidden(w, "clients may only access their own data")
+	return false
+}
+
+// RequireSelf writes a 401 response if the caller is unknown and a 403
+// response unless it is the user. Unlike RequireOwner, admins and the other
+// services may not act for the user.
+func RequireSelf(w http.ResponseWriter, r *http.Request, userID uint) bool {
+	caller := CallerFrom(r)
+	if caller == nil {
+		WriteUnauthorized(w)
+		return false
+	}
+	if !caller.Service && caller.UserID == userID {
+		return true
+	}
+	WriteForbidden(w, "only the user may "+r.Method+" "+r.URL.Path)
+	return false
+}
+
+// ErrorResponse is the body of 401 and 403 responses of the gateway and of
+// the services.
+type ErrorResponse struct {
+	Error   string `json:"error" example:"forbidden"`
+	Message string `json:"message" example:"only admins may DELETE /products/1"`
+}
+
+// WriteError writes a 401 or 403 response.
+func WriteError(w http.ResponseWriter, status int, message string) {
+	code := "forbidden"
+	if status == http.StatusUnauthorized {
+		code = "unauthorized"
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(ErrorResponse{Error: code, Message: message})
+}
+
+func WriteForbidden(w http.ResponseWriter, message string) {
+	WriteError(w, http.StatusForbidden, message)
+}
+
+func WriteUnauthorized(w http.ResponseWriter) {
+	WriteError(w, http.StatusUnauthorized, "requests must come through the API gateway")
+}
@@ -0,0 +1,81 @@
+package access
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+const testToken = "0123456789abcdef0123456789abcdef"
+
+func TestCallerFrom(t *testing.T) {
+	internalToken = []byte(testToken)
+	t.Cleanup(func() { internalToken = nil })
+
+	tests := []struct {
+		name    string
+		headers map[string]string
+		want    *Caller
+	}{
+		{"no token", map[string]string{HeaderUserID: "42"}, nil},
+		{"wrong token", map[string]string{HeaderInternalToken: "wrong", HeaderUserID: "42"}, nil},
+		{"anonymous", map[string]string{HeaderInternalToken: testToken, HeaderAnonymous: "true"}, nil},
+		{"service", map[string]string{HeaderInternalToken: testToken}, &Caller{Service: true}},
+		{"user", map[string]string{HeaderInternalToken: testToken, HeaderUserID: "42", HeaderUserRole: RoleClient}, &Caller{UserID: 42, Role: RoleClient}},
+		{"non-numeric user", map[string]string{HeaderInternalToken: testToken, HeaderUserID: "abc"}, &Caller{}},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/orders/1", nil)
+		for k, v := range tt.headers {
+			r.Header.Set(k, v)
+		}
+		got := CallerFrom(r)
+		if (got == nil) != (tt.want == nil) || got != nil && *got != *tt.want {
+			t.Errorf("%s: got %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRequire(t *testing.T) {
+	internalToken = []byte(testToken)
+	t.Cleanup(func() { internalToken = nil })
+
+	service := map[string]string{HeaderInternalToken: testToken}
+	owner := map[string]string{HeaderInternalToken: testToken, HeaderUserID: "42", HeaderUserRole: RoleClient}
+	other := map[string]string{HeaderInternalToken: testToken, HeaderUserID: "7", HeaderUserRole: RoleClient}
+	admin := map[string]string{HeaderInternalToken: testToken, HeaderUserID: "1", HeaderUserRole: RoleAdmin}
+
+	require := map[string]func(http.ResponseWriter, *http.Request) bool{
+		"admin": RequireAdmin,
+		"owner": func(w http.ResponseWriter, r *http.Request) bool { return RequireOwner(w, r, 42) },
+		"self":  func(w http.ResponseWriter, r *http.Request) bool { return RequireSelf(w, r, 42) },
+	}
+	tests := []struct {
+		check   string
+		name    string
+		headers map[string]string
+		want    int
+	}{
+		{"admin", "unknown", nil, http.StatusUnauthorized},
+		{"admin", "client", owner, http.StatusForbidden},
+		{"admin", "admin", admin, http.StatusOK},
+		{"admin", "service", service, http.StatusOK},
+		{"owner", "owner", owner, http.StatusOK},
+		{"owner", "other client", other, http.StatusForbidden},
+		{"owner", "admin", admin, http.StatusOK},
+		{"self", "owner", owner, http.StatusOK},
+		{"self", "admin", admin, http.StatusForbidden},
+		{"self", "service", service, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/users/42", nil)
+		for k, v := range tt.headers {
+			r.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		ok := require[tt.check](w, r)
+		if ok != (tt.want == http.StatusOK) || w.Code != tt.want {
+			t.Errorf("%s %s: got %v and %d, want %d", tt.check, tt.name, ok, w.Code, tt.want)
+		}
+	}
+}
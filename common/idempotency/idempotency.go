@@ -0,0 +1,202 @@
+// Package idempotency makes POST handlers safe to retry with an
+// Idempotency-Key header.
+package idempotency
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"HL_online_shop/common/access"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// KeyTTL is how long a stored response can be replayed.
+const KeyTTL = 24 * time.Hour
+
+// record stores the response to a request sent with an Idempotency-Key
+// header. A row without a status code belongs to a request that is still
+// being processed.
+type record struct {
+	Key         string `gorm:"primaryKey"`
+	RequestHash string `gorm:"not null"`
+	StatusCode  int
+	ContentType string
+	Body        []byte
+	CreatedAt   time.Time `gorm:"index"`
+}
+
+// Store keeps the stored responses of a service in its own table.
+type Store struct {
+	db    *gorm.DB
+	table string
+}
+
+func NewStore(db *gorm.DB, table string) *Store {
+	return &Store{db: db, table: table}
+}
+
+// Migrate creates or updates the table of the store.
+func (s *Store) Migrate() error {
+	return s.db.Table(s.table).AutoMigrate(&record{})
+}
+
+func (s *Store) records() *gorm.DB {
+	return s.db.Table(s.table)
+}
+
+// Wrap makes a handler safe to retry. The first request with a given
+// Idempotency-Key runs the handler and its response is stored; later requests
+// of the same caller with the same key and body get the stored response
+// without running the handler again. Reusing a key for a different request is rejected with 422.
+// Server errors are not stored so the request can be retried, except for
+// 504: after a timeout the outcome is unknown and a retry must not repeat it.
+func (s *Store) Wrap(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Idempotency-Key")
+		if key == "" {
+			next(w, r)
+			return
+		}
+		if len(key) > 255 {
+			http.Error(w, "Idempotency-Key must be at most 255 characters", http.StatusBadRequest)
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		hash := requestHash(r, body)
+		key = scopedKey(r, key)
+
+		result := s.records().Clauses(clause.OnConflict{DoNothing: true}).Create(&record{Key: key, RequestHash: hash})
+		if result.Error != nil {
+			http.Error(w, result.Error.Error(), http.StatusInternalServerError)
+			return
+		}
+		if result.RowsAffected == 0 {
+			s.replayResponse(w, key, hash)
+			return
+		}
+
+		rec := newResponseRecorder()
+		next(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+
+		if rec.status >= 500 && rec.status != http.StatusGatewayTimeout {
+			// Ключ освобождается, чтобы запрос можно было повторить
+			if err := s.records().Delete(&record{}, "key = ?", key).Error; err != nil {
+				log.Printf("failed to release idempotency key %q: %v", key, err)
+			}
+		} else {
+			err := s.records().Where("key = ?", key).Updates(record{
+				StatusCode:  rec.status,
+				ContentType: rec.header.Get("Content-Type"),
+				Body:        rec.body.Bytes(),
+			}).Error
+			if err != nil {
+				log.Printf("failed to store response for idempotency key %q: %v", key, err)
+			}
+		}
+		rec.writeTo(w)
+	}
+}
+
+func (s *Store) replayResponse(w http.ResponseWriter, key, hash string) {
+	var stored record
+	if err := s.records().First(&stored, "key = ?", key).Error; err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if stored.RequestHash != hash {
+		http.Error(w, "Idempotency-Key was already used for a different request", http.StatusUnprocessableEntity)
+		return
+	}
+	if stored.StatusCode == 0 {
+		http.Error(w, "A request with this Idempotency-Key is still being processed", http.StatusConflict)
+		return
+	}
+
+	if stored.ContentType != "" {
+		w.Header().Set("Content-Type", stored.ContentType)
+	}
+	w.Header().Set("Idempotent-Replayed", "true")
+	w.WriteHeader(stored.StatusCode)
+	w.Write(stored.Body)
+}
+
+// Expire deletes stored responses older than KeyTTL every interval.
+func (s *Store) Expire(interval time.Duration) {
+	for range time.Tick(interval) {
+		result := s.records().Where("created_at < ?", time.Now().Add(-KeyTTL)).Delete(&record{})
+		if result.Error != nil {
+			log.Println("failed to expire idempotency keys:", result.Error)
+		}
+	}
+}
+
+// scopedKey prefixes an Idempotency-Key with the caller, so a user cannot get
+// the stored response to another user's request by sending the same key.
+func scopedKey(r *http.Request, key string) string {
+	caller := r.Header.Get(access.HeaderUserID)
+	if caller == "" {
+		caller = "service"
+	} else {
+		caller = "user:" + caller
+	}
+	return caller + "/" + key
+}
+
+// requestHash identifies a request by its method, path, query and body.
+func requestHash(r *http.Request, body []byte) string {
+	h := sha256.New()
+	h.Write([]byte(r.Method + " " + r.URL.Path + "?" + r.URL.RawQuery + "\n"))
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// responseRecorder buffers a response so it can be stored before it is sent.
+type responseRecorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func newResponseRecorder() *responseRecorder {
+	return &responseRecorder{header: make(http.Header)}
+}
+
+func (rec *responseRecorder) Header() http.Header {
+	return rec.header
+}
+
+func (rec *responseRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+}
+
+func (rec *responseRecorder) Write(data []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	return rec.body.Write(data)
+}
+
+func (rec *responseRecorder) writeTo(w http.ResponseWriter) {
+	for k, v := range rec.header {
+		w.Header()[k] = v
+	}
+	w.WriteHeader(rec.status)
+	w.Write(rec.body.Bytes())
+}
@@ -0,0 +1,211 @@
+// Package money holds the amounts the orders, products, payments and checkout
+// services store and exchange.
+package money
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+	"gorm.io/gorm"
+)
+
+// Money is an exact amount in minor units of a currency: 100050 KZT is
+// 1000.50 tenge. Amounts are never stored or added up as floats.
+//
+// Amounts that do not come out in whole minor units, such as a percentage tax
+// or discount or a decimal amount from a provider, are rounded half to even,
+// so rounding errors do not drift in one direction over many operations.
+type Money struct {
+	Amount   int64  `gorm:"column:minor" json:"amount" validate:"gte=0" example:"100050"`
+	Currency string `gorm:"column:currency;size:3" json:"currency" validate:"omitempty,iso4217" example:"KZT"`
+}
+
+// RegisterValidation adds the positive_money tag to v, which accepts a Money
+// of more than zero.
+func RegisterValidation(v *validator.Validate) error {
+	return v.RegisterValidation("positive_money", func(fl validator.FieldLevel) bool {
+		m, ok := fl.Field().Interface().(Money)
+		return ok && m.Amount > 0
+	})
+}
+
+// minorUnitDigits lists the currencies whose minor unit is not a hundredth.
+var minorUnitDigits = map[string]int{
+	"JPY": 0,
+	"KRW": 0,
+	"BHD": 3,
+	"KWD": 3,
+}
+
+// Digits returns the number of digits of the minor unit of a currency.
+func Digits(currency string) int {
+	if digits, ok := minorUnitDigits[currency]; ok {
+		return digits
+	}
+	return 2
+}
+
+// ShopCurrency is the currency of amounts sent or stored without one.
+func ShopCurrency() string {
+	if currency := os.Getenv("SHOP_CURRENCY"); currency != "" {
+		return currency
+	}
+	return "KZT"
+}
+
+// WithDefaultCurrency fills in the shop currency of an amount sent without one.
+func (m Money) WithDefaultCurrency() Money {
+	if m.Currency == "" {
+		m.Currency = ShopCurrency()
+	}
+	return m
+}
+
+func (m Money) Add(other Money) Money {
+	m.Amount += other.Amount
+	return m
+}
+
+func (m Money) Sub(other Money) Money {
+	m.Amount -= other.Amount
+	return m
+}
+
+func (m Money) Times(quantity int) Money {
+	m.Amount *= int64(quantity)
+	return m
+}
+
+// MulRate multiplies the amount by numerator/denominator, rounding half to
+// even. Taxes and discounts are applied with it: 12% VAT is MulRate(12, 100).
+func (m Money) MulRate(numerator, denominator int64) Money {
+	m.Amount = roundHalfEven(m.Amount*numerator, denominator)
+	return m
+}
+
+// roundHalfEven divides and rounds to the nearest integer, ties to even.
+func roundHalfEven(numerator, denominator int64) int64 {
+	if denominator < 0 {
+		numerator, denominator = -numerator, -denominator
+	}
+	quotient, remainder := numerator/denominator, numerator%denominator
+	if remainder < 0 {
+		quotient--
+		remainder += denominator
+	}
+	switch {
+	case 2*remainder > denominator, 2*remainder == denominator && quotient%2 != 0:
+		quotient++
+	}
+	return quotient
+}
+
+// SameCurrency reports whether both amounts are in the same currency.
+func (m Money) SameCurrency(other Money) bool {
+	return m.Currency == other.Currency
+}
+
+// Decimal formats the amount in major units, e.g. "1000.50".
+func (m Money) Decimal() string {
+	digits := Digits(m.Currency)
+	sign, amount := "", m.Amount
+	if amount < 0 {
+		sign, amount = "-", -amount
+	}
+	if digits == 0 {
+		return sign + strconv.FormatInt(amount, 10)
+	}
+	s := fmt.Sprintf("%0*d", digits+1, amount)
+	return sign + s[:len(s)-digits] + "." + s[len(s)-digits:]
+}
+
+func (m Money) String() string {
+	return m.Decimal() + " " + m.Currency
+}
+
+// Parse converts a decimal amount in major units, e.g. "1000.505", to minor
+// units of currency, rounding extra digits half to even.
+func Parse(decimal, currency string) (Money, error) {
+	digits := Digits(currency)
+	s := strings.TrimSpace(decimal)
+	negative := strings.HasPrefix(s, "-")
+	s = strings.TrimPrefix(s, "-")
+	whole, fraction, _ := strings.Cut(s, ".")
+	if whole == "" {
+		whole = "0"
+	}
+
+	kept, dropped := fraction, ""
+	if len(fraction) > digits {
+		kept, dropped = fraction[:digits], fraction[digits:]
+	}
+	kept += strings.Repeat("0", digits-len(kept))
+	if !isDigits(whole) || !isDigits(fraction) {
+		return Money{}, fmt.Errorf("invalid amount %q", decimal)
+	}
+	amount, err := strconv.ParseInt(whole+kept, 10, 64)
+	if err != nil {
+		return Money{}, fmt.Errorf("invalid amount %q", decimal)
+	}
+	if dropped != "" {
+		// Цифры одной длины сравниваются как строки
+		half := "5" + strings.Repeat("0", len(dropped)-1)
+		if dropped > half || dropped == half && amount%2 != 0 {
+			amount++
+		}
+	}
+	if negative {
+		amount = -amount
+	}
+	return Money{Amount: amount, Currency: currency}, nil
+}
+
+func isDigits(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// FromFloat converts an amount in major units to Money. The float is read as
+// the shortest decimal that represents it, so 1000.5 becomes 100050.
+func FromFloat(amount float64, currency string) Money {
+	m, _ := Parse(strconv.FormatFloat(amount, 'f', -1, 64), currency)
+	return m
+}
+
+// MigrateColumn moves a float amount column to the Money columns
+// prefix+"minor" and prefix+"currency" and drops it. Existing amounts are in
+// the shop currency.
+func MigrateColumn(db *gorm.DB, table, column, prefix string) error {
+	if !db.Migrator().HasColumn(table, column) {
+		return nil
+	}
+	currency := ShopCurrency()
+	return db.Transaction(func(tx *gorm.DB) error {
+		var rows []struct {
+			ID    uint
+			Value float64
+		}
+		err := tx.Table(table).Select("id, " + column + " AS value").Where(column + " IS NOT NULL").Scan(&rows).Error
+		if err != nil {
+			return err
+		}
+		for _, row := range rows {
+			m := FromFloat(row.Value, currency)
+			err := tx.Table(table).Where("id = ?", row.ID).Updates(map[string]interface{}{
+				prefix + "minor":    m.Amount,
+				prefix + "currency": m.Currency,
+			}).Error
+			if err != nil {
+				return err
+			}
+		}
+		return tx.Migrator().DropColumn(table, column)
+	})
+}
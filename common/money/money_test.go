@@ -0,0 +1,178 @@
+package money
+
+import "testing"
+
+func TestRoundHalfEven(t *testing.T) {
+	tests := []struct {
+		numerator, denominator, want int64
+	}{
+		{10, 5, 2},
+		{1, 3, 0},
+		{2, 3, 1},
+		{1, 2, 0},
+		{3, 2, 2},
+		{5, 2, 2},
+		{7, 2, 4},
+		{-1, 2, 0},
+		{-3, 2, -2},
+		{-5, 2, -2},
+		{-7, 2, -4},
+		{-2, 3, -1},
+		{5, -2, -2},
+		{-5, -2, 2},
+		{0, 7, 0},
+	}
+	for _, tt := range tests {
+		if got := roundHalfEven(tt.numerator, tt.denominator); got != tt.want {
+			t.Errorf("roundHalfEven(%d, %d) = %d, want %d", tt.numerator, tt.denominator, got, tt.want)
+		}
+	}
+}
+
+func TestMulRate(t *testing.T) {
+	tests := []struct {
+		amount                 int64
+		numerator, denominator int64
+		want                   int64
+	}{
+		{100050, 12, 100, 12006},
+		{1005, 10, 100, 100},
+		{1015, 10, 100, 102},
+		{25, 50, 100, 12},
+		{75, 50, 100, 38},
+		{100000, 3, 7, 42857},
+	}
+	for _, tt := range tests {
+		got := Money{Amount: tt.amount, Currency: "KZT"}.MulRate(tt.numerator, tt.denominator)
+		if got != (Money{Amount: tt.want, Currency: "KZT"}) {
+			t.Errorf("%d * %d/%d = %v, want %d", tt.amount, tt.numerator, tt.denominator, got, tt.want)
+		}
+	}
+}
+
+func TestMoneyDecimal(t *testing.T) {
+	tests := []struct {
+		money Money
+		want  string
+	}{
+		{Money{100050, "KZT"}, "1000.50"},
+		{Money{5, "KZT"}, "0.05"},
+		{Money{0, "KZT"}, "0.00"},
+		{Money{-5, "KZT"}, "-0.05"},
+		{Money{-100050, "USD"}, "-1000.50"},
+		{Money{1234, "JPY"}, "1234"},
+		{Money{-1234, "JPY"}, "-1234"},
+		{Money{1234, "KWD"}, "1.234"},
+		{Money{5, "BHD"}, "0.005"},
+		{Money{100, ""}, "1.00"},
+	}
+	for _, tt := range tests {
+		if got := tt.money.Decimal(); got != tt.want {
+			t.Errorf("%#v.Decimal() = %q, want %q", tt.money, got, tt.want)
+		}
+	}
+	if got := (Money{100050, "KZT"}).String(); got != "1000.50 KZT" {
+		t.Errorf("String() = %q", got)
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		decimal  string
+		currency string
+		want     int64
+	}{
+		{"1000.50", "KZT", 100050},
+		{"1000.5", "KZT", 100050},
+		{"1000", "KZT", 100000},
+		{".5", "KZT", 50},
+		{" 12.34 ", "KZT", 1234},
+		{"0", "KZT", 0},
+		// Лишние цифры округляются до чётного
+		{"1000.505", "KZT", 100050},
+		{"1000.515", "KZT", 100052},
+		{"1000.5051", "KZT", 100051},
+		{"1000.504999", "KZT", 100050},
+		{"1000.525", "KZT", 100052},
+		{"-1.005", "KZT", -100},
+		{"-1.015", "KZT", -102},
+		{"1234.5", "JPY", 1234},
+		{"1235.5", "JPY", 1236},
+		{"1.2345", "KWD", 1234},
+		{"1.2355", "KWD", 1236},
+	}
+	for _, tt := range tests {
+		got, err := Parse(tt.decimal, tt.currency)
+		if err != nil {
+			t.Errorf("Parse(%q, %s): %v", tt.decimal, tt.currency, err)
+			continue
+		}
+		if got != (Money{Amount: tt.want, Currency: tt.currency}) {
+			t.Errorf("Parse(%q, %s) = %d, want %d", tt.decimal, tt.currency, got.Amount, tt.want)
+		}
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	for _, decimal := range []string{"abc", "1.2.3", "1,50", "--1", "1e3", "+1", "1.-5", "99999999999999999999"} {
+		if got, err := Parse(decimal, "KZT"); err == nil {
+			t.Errorf("Parse(%q) = %v, want an error", decimal, got)
+		}
+	}
+}
+
+func TestParseRoundTrip(t *testing.T) {
+	for _, m := range []Money{{100050, "KZT"}, {-7, "USD"}, {0, "EUR"}, {1234, "JPY"}, {1001, "KWD"}} {
+		got, err := Parse(m.Decimal(), m.Currency)
+		if err != nil || got != m {
+			t.Errorf("Parse(%q) = %v, %v, want %v", m.Decimal(), got, err, m)
+		}
+	}
+}
+
+func TestFromFloat(t *testing.T) {
+	tests := []struct {
+		amount float64
+		want   int64
+	}{
+		{1000.5, 100050},
+		{19.99, 1999},
+		{0.1 + 0.2, 30},
+		{1.005, 100},
+		{1.015, 102},
+		{0, 0},
+	}
+	for _, tt := range tests {
+		if got := FromFloat(tt.amount, "KZT"); got.Amount != tt.want || got.Currency != "KZT" {
+			t.Errorf("FromFloat(%v) = %v, want %d", tt.amount, got, tt.want)
+		}
+	}
+}
+
+func TestMoneyCurrencies(t *testing.T) {
+	kzt := Money{100, "KZT"}
+	if !kzt.SameCurrency(Money{5, "KZT"}) {
+		t.Error("KZT and KZT are not the same currency")
+	}
+	for _, other := range []Money{{100, "USD"}, {100, ""}, {100, "kzt"}} {
+		if kzt.SameCurrency(other) {
+			t.Errorf("KZT and %q are the same currency", other.Currency)
+		}
+	}
+
+	t.Setenv("SHOP_CURRENCY", "USD")
+	if got := (Money{Amount: 100}).WithDefaultCurrency(); got.Currency != "USD" {
+		t.Errorf("default currency %q, want USD", got.Currency)
+	}
+	if got := kzt.WithDefaultCurrency(); got != kzt {
+		t.Errorf("WithDefaultCurrency changed %v to %v", kzt, got)
+	}
+	t.Setenv("SHOP_CURRENCY", "")
+	if got := (Money{Amount: 100}).WithDefaultCurrency(); got.Currency != "KZT" {
+		t.Errorf("default currency %q, want KZT", got.Currency)
+	}
+
+	if got := kzt.Add(Money{50, "KZT"}).Sub(Money{30, "KZT"}).Times(3); got != (Money{360, "KZT"}) {
+		t.Errorf("(100 + 50 - 30) * 3 = %v", got)
+	}
+}
@@ -58,11 +58,17 @@ const docTemplate = `{
                                 "$ref": "#/definitions/main.Order"
                             }
                         }
+                    },
+                    "403": {
+                        "description": "Only admins",
+                        "schema": {
+                            "$ref": "#/definitions/access.ErrorResponse"
+                        }
                     }
                 }
             },
             "post": {
-                "description": "Create a new order",
+                "description": "Create a new order for a user with a verified email address. Item prices and the total are calculated from the products service in the order currency and stock is reserved for every item. Prices converted from the base currency keep the exchange rate of this moment.",
                 "consumes": [
                     "application/json"
                 ],
@@ -82,6 +88,19 @@ const docTemplate = `{
                         "schema": {
                             "$ref": "#/definitions/main.Order"
                         }
+                    },
+                    {
+                        "type": "string",
+                        "description": "Unique key that makes the request safe to retry",
+                        "name": "Idempotency-Key",
+                        "in": "header"
+                    },
+                    {
+                        "type": "boolean",
+                        "default": true,
+                        "description": "Reserve stock for the items, false when the caller reserves it itself",
+                        "name": "reserve_stock",
+                        "in": "query"
                     }
                 ],
                 "responses": {
@@ -90,42 +109,23 @@ const docTemplate = `{
                         "schema": {
                             "$ref": "#/definitions/main.Order"
                         }
-                    }
-                }
-            }
-        },
-        "/orders/search": {
-            "get": {
-                "description": "Search orders by user or status",
-                "produces": [
-                    "application/json"
-                ],
-                "tags": [
-                    "orders"
-                ],
-                "summary": "Search orders by user or status",
-                "parameters": [
-                    {
-                        "type": "integer",
-                        "description": "User ID",
-                        "name": "user",
-                        "in": "query"
                     },
-                    {
-                        "type": "string",
-                        "description": "Order Status",
-                        "name": "status",
-                        "in": "query"
-                    }
-                ],
-                "responses": {
-                    "200": {
-                        "description": "OK",
+                    "403": {
+                        "description": "The order is for another user, or the user has not verified the email address",
                         "schema": {
-                            "type": "array",
-                            "items": {
-                                "$ref": "#/definitions/main.Order"
-                            }
+                            "$ref": "#/definitions/access.ErrorResponse"
+                        }
+                    },
+                    "409": {
+                        "description": "Insufficient stock or the exchange rate changed, retry",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "422": {
+                        "description": "User or product not found, no exchange rate for the currency or Idempotency-Key reused for a different request",
+                        "schema": {
+                            "type": "string"
                         }
                     }
                 }
@@ -156,11 +156,17 @@ const docTemplate = `{
                         "schema": {
                             "$ref": "#/definitions/main.Order"
                         }
+                    },
+                    "403": {
+                        "description": "The order belongs to another user",
+                        "schema": {
+                            "$ref": "#/definitions/access.ErrorResponse"
+                        }
                     }
                 }
             },
             "put": {
-                "description": "Update an order by ID",
+                "description": "Update a new order by ID. The status cannot be changed here, use the transitions endpoint instead.",
                 "consumes": [
                     "application/json"
                 ],
@@ -195,6 +201,12 @@ const docTemplate = `{
                         "schema": {
                             "$ref": "#/definitions/main.Order"
                         }
+                    },
+                    "403": {
+                        "description": "The order belongs to another user",
+                        "schema": {
+                            "$ref": "#/definitions/access.ErrorResponse"
+                        }
                     }
                 }
             },
@@ -222,55 +234,353 @@ const docTemplate = `{
                         "schema": {
                             "type": "string"
                         }
+                    },
+                    "403": {
+                        "description": "The order belongs to another user",
+                        "schema": {
+                            "$ref": "#/definitions/access.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/orders/{id}/transitions": {
+            "get": {
+                "description": "Get all status transitions of an order, oldest first",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "orders"
+                ],
+                "summary": "Get the status history of an order",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Order ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/main.OrderStatusHistory"
+                            }
+                        }
+                    },
+                    "403": {
+                        "description": "The order belongs to another user",
+                        "schema": {
+                            "$ref": "#/definitions/access.ErrorResponse"
+                        }
+                    }
+                }
+            },
+            "post": {
+                "description": "Move an order to a new status. Only transitions allowed by the order lifecycle are accepted. Paying for an order commits its reserved stock, cancelling it releases the stock. Clients may only cancel their own orders; changed_by is then set to the client.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "orders"
+                ],
+                "summary": "Change the status of an order",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Order ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Transition",
+                        "name": "transition",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.TransitionRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.Order"
+                        }
+                    },
+                    "403": {
+                        "description": "The order belongs to another user, or a client tried a status other than cancelled",
+                        "schema": {
+                            "$ref": "#/definitions/access.ErrorResponse"
+                        }
+                    },
+                    "409": {
+                        "description": "Illegal transition",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/search/orders": {
+            "get": {
+                "description": "Search orders by user or status. Clients only find their own orders.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "orders"
+                ],
+                "summary": "Search orders by user or status",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "User ID",
+                        "name": "user",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Order Status",
+                        "name": "status",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/main.Order"
+                            }
+                        }
+                    },
+                    "403": {
+                        "description": "Another user's orders",
+                        "schema": {
+                            "$ref": "#/definitions/access.ErrorResponse"
+                        }
                     }
                 }
             }
         }
     },
     "definitions": {
+        "access.ErrorResponse": {
+            "type": "object",
+            "properties": {
+                "error": {
+                    "type": "string",
+                    "example": "forbidden"
+                },
+                "message": {
+                    "type": "string",
+                    "example": "only admins may DELETE /products/1"
+                }
+            }
+        },
+        "main.Money": {
+            "type": "object",
+            "properties": {
+                "amount": {
+                    "type": "integer",
+                    "minimum": 0,
+                    "example": 100050
+                },
+                "currency": {
+                    "type": "string",
+                    "example": "KZT"
+                }
+            }
+        },
         "main.Order": {
             "type": "object",
             "required": [
-                "products",
-                "status",
-                "total_price",
+                "items",
                 "user_id"
             ],
             "properties": {
+                "base_currency": {
+                    "description": "Snapshot of the exchange rate from BaseCurrency that converted prices\nwere calculated with. Empty when no price had to be converted.",
+                    "type": "string",
+                    "readOnly": true,
+                    "example": "KZT"
+                },
+                "currency": {
+                    "description": "Currency the order is priced and paid in, the shop currency by default.",
+                    "type": "string",
+                    "example": "USD"
+                },
+                "exchange_rate": {
+                    "type": "string",
+                    "readOnly": true,
+                    "example": "0.0021"
+                },
                 "id": {
                     "type": "integer",
                     "readOnly": true,
                     "example": 1
                 },
+                "items": {
+                    "type": "array",
+                    "minItems": 1,
+                    "items": {
+                        "$ref": "#/definitions/main.OrderItem"
+                    }
+                },
                 "order_date": {
                     "type": "string",
                     "readOnly": true,
                     "example": "2023-07-20T15:04:05Z"
                 },
-                "products": {
-                    "type": "array",
-                    "items": {
-                        "type": "integer"
-                    }
+                "rate_updated_at": {
+                    "type": "string",
+                    "readOnly": true,
+                    "example": "2023-07-20T15:04:05Z"
                 },
                 "status": {
                     "type": "string",
                     "enum": [
                         "new",
+                        "awaiting_payment",
+                        "paid",
                         "in_process",
-                        "completed"
+                        "shipped",
+                        "completed",
+                        "cancelled",
+                        "refunded"
                     ],
+                    "readOnly": true,
                     "example": "new"
                 },
                 "total_price": {
-                    "type": "number",
-                    "example": 100.5
+                    "$ref": "#/definitions/main.Money"
                 },
                 "user_id": {
                     "type": "integer",
                     "example": 1
                 }
             }
+        },
+        "main.OrderItem": {
+            "type": "object",
+            "required": [
+                "product_id",
+                "quantity"
+            ],
+            "properties": {
+                "id": {
+                    "type": "integer",
+                    "readOnly": true,
+                    "example": 1
+                },
+                "line_total": {
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/main.Money"
+                        }
+                    ],
+                    "readOnly": true
+                },
+                "order_id": {
+                    "type": "integer",
+                    "readOnly": true,
+                    "example": 1
+                },
+                "product_id": {
+                    "type": "integer",
+                    "example": 1
+                },
+                "quantity": {
+                    "type": "integer",
+                    "example": 2
+                },
+                "unit_price": {
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/main.Money"
+                        }
+                    ],
+                    "readOnly": true
+                }
+            }
+        },
+        "main.OrderStatusHistory": {
+            "type": "object",
+            "properties": {
+                "changed_at": {
+                    "type": "string",
+                    "example": "2023-07-20T15:04:05Z"
+                },
+                "changed_by": {
+                    "type": "string",
+                    "example": "user:1"
+                },
+                "from_status": {
+                    "type": "string",
+                    "example": "new"
+                },
+                "id": {
+                    "type": "integer",
+                    "readOnly": true,
+                    "example": 1
+                },
+                "order_id": {
+                    "type": "integer",
+                    "example": 1
+                },
+                "reason": {
+                    "type": "string",
+                    "example": "Customer started checkout"
+                },
+                "to_status": {
+                    "type": "string",
+                    "example": "awaiting_payment"
+                }
+            }
+        },
+        "main.TransitionRequest": {
+            "type": "object",
+            "required": [
+                "changed_by",
+                "status"
+            ],
+            "properties": {
+                "changed_by": {
+                    "type": "string",
+                    "example": "user:1"
+                },
+                "reason": {
+                    "type": "string",
+                    "example": "Customer started checkout"
+                },
+                "status": {
+                    "type": "string",
+                    "enum": [
+                        "new",
+                        "awaiting_payment",
+                        "paid",
+                        "in_process",
+                        "shipped",
+                        "completed",
+                        "cancelled",
+                        "refunded"
+                    ],
+                    "example": "awaiting_payment"
+                }
+            }
         }
     }
 }`
@@ -1,10 +1,14 @@
 package main
 
 import (
+	"HL_online_shop/common/access"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"github.com/go-playground/validator/v10"
 	"github.com/gorilla/mux"
 	"gorm.io/gorm"
+	"log"
 	"net/http"
 	"strconv"
 	"time"
@@ -30,8 +34,12 @@ func HealthCheck(w http.ResponseWriter, r *http.Request) {
 // @Tags orders
 // @Produce json
 // @Success 200 {array} Order
+// @Failure 403 {object} access.ErrorResponse "Only admins"
 // @Router /orders [get]
 func GetOrders(w http.ResponseWriter, r *http.Request) {
+	if !access.RequireAdmin(w, r) {
+		return
+	}
 	orders, err := GetAllOrdersRepo()
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -40,16 +48,58 @@ func GetOrders(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(orders)
 }
 
+// applyOrderPricing prices the order from the products service and writes an
+// error response if that is not possible. A client-supplied total_price is
+// optional, but when present it must match the calculated total.
+func applyOrderPricing(w http.ResponseWriter, order *Order) bool {
+	clientTotal := order.TotalPrice
+	if err := priceOrder(order); err != nil {
+		writeProductsError(w, err)
+		return false
+	}
+	if clientTotal.Currency == "" {
+		clientTotal.Currency = order.TotalPrice.Currency
+	}
+	if clientTotal.Amount != 0 && clientTotal != order.TotalPrice {
+		http.Error(w, fmt.Sprintf("total_price %s does not match calculated total %s", clientTotal, order.TotalPrice), http.StatusUnprocessableEntity)
+		return false
+	}
+	return true
+}
+
+// writeProductsError maps errors from the products service to a response.
+func writeProductsError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ErrProductNotFound), errors.Is(err, ErrNoRate):
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+	case errors.Is(err, ErrInsufficientStock), errors.Is(err, ErrRateChanged):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		http.Error(w, err.Error(), http.StatusBadGateway)
+	}
+}
+
 // CreateOrder godoc
 // @Summary Create an order
-// @Description Create a new order
+// @Description Create a new order for a user with a verified email address. Item prices and the total are calculated from the products service in the order currency and stock is reserved for every item. Prices converted from the base currency keep the exchange rate of this moment.
 // @Tags orders
 // @Accept json
 // @Produce json
 // @Param order body Order true "Create order"
+// @Param Idempotency-Key header string false "Unique key that makes the request safe to retry"
+// @Param reserve_stock query bool false "Reserve stock for the items, false when the caller reserves it itself" default(true)
 // @Success 201 {object} Order
+// @Failure 409 {string} string "Insufficient stock or the exchange rate changed, retry"
+// @Failure 403 {object} access.ErrorResponse "The order is for another user, or the user has not verified the email address"
+// @Failure 422 {string} string "User or product not found, no exchange rate for the currency or Idempotency-Key reused for a different request"
 // @Router /orders [post]
 func CreateOrder(w http.ResponseWriter, r *http.Request) {
+	skipReservation := r.URL.Query().Get("reserve_stock") == "false"
+	if skipReservation && !access.CallerFrom(r).Privileged() {
+		access.WriteForbidden(w, "only services may create an order without reserving stock")
+		return
+	}
+
 	var order Order
 	if err := json.NewDecoder(r.Body).Decode(&order); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
@@ -60,14 +110,45 @@ func CreateOrder(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	if !access.RequireOwner(w, r, order.UserID) {
+		return
+	}
+	if err := checkUserMayOrder(order.UserID); err != nil {
+		switch {
+		case errors.Is(err, ErrEmailNotVerified):
+			access.WriteForbidden(w, err.Error())
+		case errors.Is(err, ErrUserNotFound):
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		default:
+			http.Error(w, err.Error(), http.StatusBadGateway)
+		}
+		return
+	}
 	if order.OrderDate.IsZero() {
 		order.OrderDate = time.Now()
 
 	}
+	order.Status = StatusNew
+	if !applyOrderPricing(w, &order) {
+		return
+	}
 	if err := CreateOrderRepo(&order); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	if skipReservation {
+		// Сток резервирует вызывающий сервис, например сага оформления заказа
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(order)
+		return
+	}
+	if err := reserveStock(&order); err != nil {
+		if err := DeleteOrderRepo(order.ID); err != nil {
+			log.Printf("failed to delete order %d after failed stock reservation: %v", order.ID, err)
+		}
+		writeProductsError(w, err)
+		return
+	}
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(order)
 }
@@ -79,6 +160,7 @@ func CreateOrder(w http.ResponseWriter, r *http.Request) {
 // @Produce json
 // @Param id path int true "Order ID"
 // @Success 200 {object} Order
+// @Failure 403 {object} access.ErrorResponse "The order belongs to another user"
 // @Router /orders/{id} [get]
 func GetOrder(w http.ResponseWriter, r *http.Request) {
 	params := mux.Vars(r)
@@ -97,18 +179,22 @@ func GetOrder(w http.ResponseWriter, r *http.Request) {
 		}
 		return
 	}
+	if !access.RequireOwner(w, r, order.UserID) {
+		return
+	}
 	json.NewEncoder(w).Encode(order)
 }
 
 // UpdateOrder godoc
 // @Summary Update an order by ID
-// @Description Update an order by ID
+// @Description Update a new order by ID. The status cannot be changed here, use the transitions endpoint instead.
 // @Tags orders
 // @Accept json
 // @Produce json
 // @Param id path int true "Order ID"
 // @Param order body Order true "Update order"
 // @Success 200 {object} Order
+// @Failure 403 {object} access.ErrorResponse "The order belongs to another user"
 // @Router /orders/{id} [put]
 func UpdateOrder(w http.ResponseWriter, r *http.Request) {
 	params := mux.Vars(r)
@@ -128,7 +214,40 @@ func UpdateOrder(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	order.ID = uint(id)
+
+	existing, err := GetOrderByIDRepo(uint(id))
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			http.Error(w, "Order not found", http.StatusNotFound)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	if !access.RequireOwner(w, r, existing.UserID) || !access.RequireOwner(w, r, order.UserID) {
+		return
+	}
+	if order.Status != "" && order.Status != existing.Status {
+		http.Error(w, "Order status can only be changed with POST /orders/{id}/transitions", http.StatusConflict)
+		return
+	}
+	if existing.Status != StatusNew {
+		http.Error(w, "Only new orders can be updated", http.StatusConflict)
+		return
+	}
+	order.ID = existing.ID
+	order.Status = existing.Status
+	order.OrderDate = existing.OrderDate
+	if order.Currency == "" {
+		order.Currency = existing.Currency
+	}
+	if !applyOrderPricing(w, &order) {
+		return
+	}
+	if err := replaceStockReservation(existing, &order); err != nil {
+		writeProductsError(w, err)
+		return
+	}
 	if err := UpdateOrderRepo(&order); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -143,6 +262,7 @@ func UpdateOrder(w http.ResponseWriter, r *http.Request) {
 // @Produce plain
 // @Param id path int true "Order ID"
 // @Success 200 {string} string "Deleted"
+// @Failure 403 {object} access.ErrorResponse "The order belongs to another user"
 // @Router /orders/{id} [delete]
 func DeleteOrder(w http.ResponseWriter, r *http.Request) {
 	params := mux.Vars(r)
@@ -151,7 +271,13 @@ func DeleteOrder(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Invalid order ID", http.StatusBadRequest)
 		return
 	}
+	if !requireOrderOwner(w, r, uint(id)) {
+		return
+	}
 
+	if err := releaseStock(uint(id)); err != nil && !errors.Is(err, ErrNoReservation) {
+		log.Printf("failed to release stock of deleted order %d: %v", id, err)
+	}
 	if err := DeleteOrderRepo(uint(id)); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -160,14 +286,102 @@ func DeleteOrder(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode("Deleted")
 }
 
+// TransitionOrder godoc
+// @Summary Change the status of an order
+// @Description Move an order to a new status. Only transitions allowed by the order lifecycle are accepted. Paying for an order commits its reserved stock, cancelling it releases the stock. Clients may only cancel their own orders; changed_by is then set to the client.
+// @Tags orders
+// @Accept json
+// @Produce json
+// @Param id path int true "Order ID"
+// @Param transition body TransitionRequest true "Transition"
+// @Success 200 {object} Order
+// @Failure 403 {object} access.ErrorResponse "The order belongs to another user, or a client tried a status other than cancelled"
+// @Failure 409 {string} string "Illegal transition"
+// @Router /orders/{id}/transitions [post]
+func TransitionOrder(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	id, err := strconv.Atoi(params["id"])
+	if err != nil {
+		http.Error(w, "Invalid order ID", http.StatusBadRequest)
+		return
+	}
+
+	if !requireOrderOwner(w, r, uint(id)) {
+		return
+	}
+
+	var req TransitionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if caller := access.CallerFrom(r); !caller.Privileged() {
+		// Клиент может только отменить свой заказ, и в истории записывается он сам
+		if req.Status != StatusCancelled {
+			access.WriteForbidden(w, "clients may only cancel an order")
+			return
+		}
+		req.ChangedBy = fmt.Sprintf("user:%d", caller.UserID)
+	}
+	err = validate.Struct(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	order, err := TransitionOrderRepo(uint(id), req, applyStockEffects)
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			http.Error(w, "Order not found", http.StatusNotFound)
+		} else if errors.Is(err, ErrIllegalTransition) || errors.Is(err, ErrInsufficientStock) {
+			http.Error(w, err.Error(), http.StatusConflict)
+		} else if errors.Is(err, ErrProductsUnavailable) {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	json.NewEncoder(w).Encode(order)
+}
+
+// GetOrderTransitions godoc
+// @Summary Get the status history of an order
+// @Description Get all status transitions of an order, oldest first
+// @Tags orders
+// @Produce json
+// @Param id path int true "Order ID"
+// @Success 200 {array} OrderStatusHistory
+// @Failure 403 {object} access.ErrorResponse "The order belongs to another user"
+// @Router /orders/{id}/transitions [get]
+func GetOrderTransitions(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	id, err := strconv.Atoi(params["id"])
+	if err != nil {
+		http.Error(w, "Invalid order ID", http.StatusBadRequest)
+		return
+	}
+	if !requireOrderOwner(w, r, uint(id)) {
+		return
+	}
+
+	history, err := GetOrderHistoryRepo(uint(id))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(history)
+}
+
 // SearchOrders godoc
 // @Summary Search orders by user or status
-// @Description Search orders by user or status
+// @Description Search orders by user or status. Clients only find their own orders.
 // @Tags orders
 // @Produce json
 // @Param user query int false "User ID"
 // @Param status query string false "Order Status"
 // @Success 200 {array} Order
+// @Failure 403 {object} access.ErrorResponse "Another user's orders"
 // @Router /search/orders [get]
 func SearchOrders(w http.ResponseWriter, r *http.Request) {
 	userIDStr := r.URL.Query().Get("user")
@@ -182,6 +396,12 @@ func SearchOrders(w http.ResponseWriter, r *http.Request) {
 		}
 		userID = uint(parsedUserID)
 	}
+	if caller := access.CallerFrom(r); userIDStr == "" && !caller.Privileged() {
+		userID = caller.UserID
+	}
+	if !access.RequireOwner(w, r, userID) {
+		return
+	}
 
 	orders, err := SearchOrdersRepo(userID, status)
 	if err != nil {
@@ -191,3 +411,21 @@ func SearchOrders(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(orders)
 	w.WriteHeader(http.StatusOK)
 }
+
+// requireOrderOwner writes an error response unless the caller may act on the
+// order.
+func requireOrderOwner(w http.ResponseWriter, r *http.Request, id uint) bool {
+	if access.CallerFrom(r).Privileged() {
+		return true
+	}
+	order, err := GetOrderByIDRepo(id)
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			http.Error(w, "Order not found", http.StatusNotFound)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return false
+	}
+	return access.RequireOwner(w, r, order.UserID)
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"HL_online_shop/common/access"
 	_ "HL_online_shop/docs"
 	"log"
 	"net/http"
@@ -22,14 +23,21 @@ import (
 
 func main() {
 	InitDB()
+	if err := access.LoadInternalToken(); err != nil {
+		log.Fatal("invalid internal token: ", err)
+	}
+	go idempotencyKeys.Expire(time.Hour)
 
 	r := mux.NewRouter()
+	r.Use(access.RequireInternalToken)
 	r.HandleFunc("/health", HealthCheck).Methods("GET")
 	r.HandleFunc("/orders", GetOrders).Methods("GET")
-	r.HandleFunc("/orders", CreateOrder).Methods("POST")
+	r.HandleFunc("/orders", idempotencyKeys.Wrap(CreateOrder)).Methods("POST")
 	r.HandleFunc("/orders/{id}", GetOrder).Methods("GET")
 	r.HandleFunc("/orders/{id}", UpdateOrder).Methods("PUT")
 	r.HandleFunc("/orders/{id}", DeleteOrder).Methods("DELETE")
+	r.HandleFunc("/orders/{id}/transitions", TransitionOrder).Methods("POST")
+	r.HandleFunc("/orders/{id}/transitions", GetOrderTransitions).Methods("GET")
 	r.HandleFunc("/search/orders", SearchOrders).Methods("GET")
 	r.PathPrefix("/swagger/").Handler(httpSwagger.WrapHandler)
 
@@ -5,14 +5,65 @@ import (
 )
 
 type Order struct {
-	ID         uint      `gorm:"primaryKey" json:"id" readonly:"true" example:"1"`
-	UserID     uint      `json:"user_id" validate:"required" example:"1"`
-	Products   []uint    `gorm:"-" json:"products" validate:"required" `
-	TotalPrice float64   `json:"total_price" validate:"required,gt=0" example:"100.50"`
-	OrderDate  time.Time `json:"order_date" readonly:"true" example:"2023-07-20T15:04:05Z"`
-	Status     string    `json:"status" validate:"required,oneof=new in_process completed" example:"new"`
+	ID         uint        `gorm:"primaryKey" json:"id" readonly:"true" example:"1"`
+	UserID     uint        `json:"user_id" validate:"required" example:"1"`
+	Items      []OrderItem `gorm:"foreignKey:OrderID" json:"items" validate:"required,min=1,dive"`
+	TotalPrice Money       `gorm:"embedded;embeddedPrefix:total_price_" json:"total_price"`
+	// Currency the order is priced and paid in, the shop currency by default.
+	Currency string `gorm:"size:3" json:"currency" validate:"omitempty,iso4217" example:"USD"`
+	// Snapshot of the exchange rate from BaseCurrency that converted prices
+	// were calculated with. Empty when no price had to be converted.
+	BaseCurrency  string     `gorm:"size:3" json:"base_currency" readonly:"true" example:"KZT"`
+	ExchangeRate  string     `json:"exchange_rate,omitempty" readonly:"true" example:"0.0021"`
+	RateUpdatedAt *time.Time `json:"rate_updated_at,omitempty" readonly:"true" example:"2023-07-20T15:04:05Z"`
+	OrderDate     time.Time  `json:"order_date" readonly:"true" example:"2023-07-20T15:04:05Z"`
+	Status        string     `json:"status" readonly:"true" validate:"omitempty,oneof=new awaiting_payment paid in_process shipped completed cancelled refunded" example:"new"`
 }
 
 func (Order) TableName() string {
 	return "orders_shop"
 }
+
+// OrderItem is a single line of an order. UnitPrice is a snapshot of the
+// product price at the moment the order was placed.
+type OrderItem struct {
+	ID        uint  `gorm:"primaryKey" json:"id" readonly:"true" example:"1"`
+	OrderID   uint  `gorm:"index;not null" json:"order_id" readonly:"true" example:"1"`
+	ProductID uint  `json:"product_id" validate:"required" example:"1"`
+	Quantity  int   `json:"quantity" validate:"required,gt=0" example:"2"`
+	UnitPrice Money `gorm:"embedded;embeddedPrefix:unit_price_" json:"unit_price" readonly:"true"`
+	LineTotal Money `gorm:"embedded;embeddedPrefix:line_total_" json:"line_total" readonly:"true"`
+}
+
+func (OrderItem) TableName() string {
+	return "order_items"
+}
+
+// OrderStatusHistory records a single status change of an order.
+type OrderStatusHistory struct {
+	ID         uint      `gorm:"primaryKey" json:"id" readonly:"true" example:"1"`
+	OrderID    uint      `gorm:"index;not null" json:"order_id" example:"1"`
+	FromStatus string    `json:"from_status" example:"new"`
+	ToStatus   string    `json:"to_status" example:"awaiting_payment"`
+	ChangedBy  string    `json:"changed_by" example:"user:1"`
+	Reason     string    `json:"reason" example:"Customer started checkout"`
+	ChangedAt  time.Time `json:"changed_at" example:"2023-07-20T15:04:05Z"`
+}
+
+func (OrderStatusHistory) TableName() string {
+	return "order_status_history"
+}
+
+type TransitionRequest struct {
+	Status    string `json:"status" validate:"required,oneof=new awaiting_payment paid in_process shipped completed cancelled refunded" example:"awaiting_payment"`
+	ChangedBy string `json:"changed_by" validate:"required" example:"user:1"`
+	Reason    string `json:"reason" example:"Customer started checkout"`
+}
+
+// calculateLineTotals fills LineTotal for every item.
+func (o *Order) calculateLineTotals() {
+	for i := range o.Items {
+		item := &o.Items[i]
+		item.LineTotal = item.UnitPrice.Times(item.Quantity)
+	}
+}
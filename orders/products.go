@@ -0,0 +1,221 @@
+package main
+
+import (
+	"HL_online_shop/common/access"
+	"HL_online_shop/common/money"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+var (
+	ErrProductNotFound     = errors.New("product not found")
+	ErrInsufficientStock   = errors.New("insufficient stock")
+	ErrProductsUnavailable = errors.New("products service unavailable")
+	ErrNoReservation       = errors.New("no stock reservation")
+	ErrNoRate              = errors.New("no exchange rate for the currency")
+	ErrRateChanged         = errors.New("exchange rate changed while pricing the order")
+)
+
+var serviceClient = &http.Client{Timeout: 10 * time.Second, Transport: access.Transport{Base: http.DefaultTransport}}
+
+func productsServiceURL() string {
+	if url := os.Getenv("PRODUCTS_SERVICE_URL"); url != "" {
+		return url
+	}
+	return "http://product-service:8082"
+}
+
+// PriceQuote is the price of a product in one currency as returned by the
+// products service.
+type PriceQuote struct {
+	ProductID     uint       `json:"product_id"`
+	Price         Money      `json:"price"`
+	BasePrice     Money      `json:"base_price"`
+	Source        string     `json:"source"`
+	Rate          string     `json:"rate"`
+	RateUpdatedAt *time.Time `json:"rate_updated_at"`
+}
+
+func getPriceQuote(id uint, currency string) (*PriceQuote, error) {
+	url := fmt.Sprintf("%s/products/%d/price?currency=%s", productsServiceURL(), id, currency)
+	resp, err := serviceClient.Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrProductsUnavailable, err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, fmt.Errorf("%w: %d", ErrProductNotFound, id)
+	case http.StatusUnprocessableEntity:
+		msg, _ := io.ReadAll(resp.Body)
+		return nil, &productsError{err: ErrNoRate, msg: strings.TrimSpace(string(msg))}
+	default:
+		return nil, fmt.Errorf("%w: unexpected status %s", ErrProductsUnavailable, resp.Status)
+	}
+
+	var quote PriceQuote
+	if err := json.NewDecoder(resp.Body).Decode(&quote); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrProductsUnavailable, err)
+	}
+	return &quote, nil
+}
+
+// priceOrder snapshots the current product prices in the order currency into
+// the order items and recalculates the line totals and the order total.
+// Prices converted from the base currency must all use the same rate, which
+// is stored on the order.
+func priceOrder(order *Order) error {
+	if order.Currency == "" {
+		order.Currency = money.ShopCurrency()
+	}
+	order.BaseCurrency = money.ShopCurrency()
+	order.ExchangeRate, order.RateUpdatedAt = "", nil
+	for i := range order.Items {
+		item := &order.Items[i]
+		quote, err := getPriceQuote(item.ProductID, order.Currency)
+		if err != nil {
+			return err
+		}
+		if quote.Price.Currency != order.Currency {
+			return fmt.Errorf("%w: product %d is priced in %s", ErrProductsUnavailable, item.ProductID, quote.Price.Currency)
+		}
+		item.UnitPrice = quote.Price
+		order.BaseCurrency = quote.BasePrice.WithDefaultCurrency().Currency
+		if quote.Source != "converted" {
+			continue
+		}
+		if order.ExchangeRate != "" && order.ExchangeRate != quote.Rate {
+			return fmt.Errorf("%w: %s and %s", ErrRateChanged, order.ExchangeRate, quote.Rate)
+		}
+		order.ExchangeRate, order.RateUpdatedAt = quote.Rate, quote.RateUpdatedAt
+	}
+	order.calculateLineTotals()
+	total := Money{Currency: order.Currency}
+	for _, item := range order.Items {
+		total = total.Add(item.LineTotal)
+	}
+	order.TotalPrice = total
+	return nil
+}
+
+type reservationItem struct {
+	ProductID uint `json:"product_id"`
+	Quantity  int  `json:"quantity"`
+}
+
+type reservationRequest struct {
+	OrderID uint              `json:"order_id"`
+	Items   []reservationItem `json:"items"`
+}
+
+// reserveStock reserves stock for every item of the order.
+func reserveStock(order *Order) error {
+	req := reservationRequest{OrderID: order.ID}
+	for _, item := range order.Items {
+		req.Items = append(req.Items, reservationItem{ProductID: item.ProductID, Quantity: item.Quantity})
+	}
+	body, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	return callReservations(productsServiceURL()+"/reservations", body)
+}
+
+// replaceStockReservation swaps the reservation of an order for one matching
+// its updated items. If the new items cannot be reserved the old reservation
+// is restored on a best-effort basis.
+func replaceStockReservation(old, updated *Order) error {
+	if err := releaseStock(old.ID); err != nil && !errors.Is(err, ErrNoReservation) {
+		return err
+	}
+	if err := reserveStock(updated); err != nil {
+		if err := reserveStock(old); err != nil {
+			log.Printf("failed to restore stock reservation of order %d: %v", old.ID, err)
+		}
+		return err
+	}
+	return nil
+}
+
+// commitStock makes the stock reservations of an order final.
+func commitStock(orderID uint) error {
+	return callReservations(fmt.Sprintf("%s/reservations/%d/commit", productsServiceURL(), orderID), nil)
+}
+
+// releaseStock returns the reserved stock of an order.
+func releaseStock(orderID uint) error {
+	return callReservations(fmt.Sprintf("%s/reservations/%d/release", productsServiceURL(), orderID), nil)
+}
+
+// returnStock puts the committed stock of a refunded order back.
+func returnStock(orderID uint) error {
+	return callReservations(fmt.Sprintf("%s/reservations/%d/return", productsServiceURL(), orderID), nil)
+}
+
+func callReservations(url string, body []byte) error {
+	resp, err := serviceClient.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrProductsUnavailable, err)
+	}
+	defer resp.Body.Close()
+
+	msg, _ := io.ReadAll(resp.Body)
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusCreated:
+		return nil
+	case http.StatusNotFound:
+		return &productsError{err: ErrNoReservation, msg: strings.TrimSpace(string(msg))}
+	case http.StatusConflict:
+		return &productsError{err: ErrInsufficientStock, msg: strings.TrimSpace(string(msg))}
+	case http.StatusUnprocessableEntity:
+		return &productsError{err: ErrProductNotFound, msg: strings.TrimSpace(string(msg))}
+	default:
+		return fmt.Errorf("%w: %s: %s", ErrProductsUnavailable, resp.Status, strings.TrimSpace(string(msg)))
+	}
+}
+
+// productsError keeps the message of the products service while still
+// matching one of the sentinel errors above.
+type productsError struct {
+	err error
+	msg string
+}
+
+func (e *productsError) Error() string {
+	return e.msg
+}
+
+func (e *productsError) Unwrap() error {
+	return e.err
+}
+
+// applyStockEffects keeps the stock reservations in line with the order
+// status: paying for an order commits its stock, cancelling releases it and
+// refunding it, which includes a voided or expired authorization, returns it.
+func applyStockEffects(order *Order, status string) error {
+	var err error
+	switch status {
+	case StatusPaid:
+		err = commitStock(order.ID)
+	case StatusCancelled:
+		err = releaseStock(order.ID)
+	case StatusRefunded:
+		err = returnStock(order.ID)
+	}
+	if errors.Is(err, ErrNoReservation) {
+		// Orders placed before stock reservations existed have nothing to commit.
+		log.Printf("order %d has no stock reservation to update", order.ID)
+		return nil
+	}
+	return err
+}
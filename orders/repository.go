@@ -1,15 +1,24 @@
 package main
 
 import (
+	"HL_online_shop/common/idempotency"
+	"HL_online_shop/common/money"
 	"github.com/joho/godotenv"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/logger"
 	"log"
 	"os"
+	"time"
 )
 
 var db *gorm.DB
 
+// idempotencyKeys stores the responses to requests sent with an
+// Idempotency-Key header.
+var idempotencyKeys *idempotency.Store
+
 func InitDB() {
 	if err := godotenv.Load(); err != nil {
 		log.Println("Error loading .env file")
@@ -17,39 +26,142 @@ func InitDB() {
 	url := os.Getenv("DATABASE_URL")
 	dsn := url
 	var err error
-	db, err = gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	db, err = gorm.Open(postgres.Open(dsn), &gorm.Config{Logger: logger.New(
+		log.New(os.Stdout, "\r\n", log.LstdFlags),
+		logger.Config{
+			SlowThreshold: 200 * time.Millisecond,
+			LogLevel:      logger.Warn,
+			// Значения параметров не попадают в лог
+			ParameterizedQueries:      true,
+			IgnoreRecordNotFoundError: true,
+			Colorful:                  true,
+		},
+	)})
 	if err != nil {
 		log.Fatal("failed to connect to the database:", err)
 	}
 
 	db.Table("orders_shop").AutoMigrate(&Order{})
+	db.Table("order_items").AutoMigrate(&OrderItem{})
+	db.Table("order_status_history").AutoMigrate(&OrderStatusHistory{})
+	for _, column := range []struct{ table, name, prefix string }{
+		{"orders_shop", "total_price", "total_price_"},
+		{"order_items", "unit_price", "unit_price_"},
+		{"order_items", "line_total", "line_total_"},
+	} {
+		if err := money.MigrateColumn(db, column.table, column.name, column.prefix); err != nil {
+			log.Fatal("failed to migrate order amounts:", err)
+		}
+	}
+	// Заказы до мультивалютности оформлены в валюте суммы без пересчёта
+	db.Exec("UPDATE orders_shop SET currency = total_price_currency, base_currency = total_price_currency WHERE currency IS NULL OR currency = ''")
+	idempotencyKeys = idempotency.NewStore(db, "order_idempotency_keys")
+	idempotencyKeys.Migrate()
 }
 
 func GetAllOrdersRepo() ([]Order, error) {
 	var orders []Order
-	result := db.Find(&orders)
+	result := db.Preload("Items").Find(&orders)
 	return orders, result.Error
 }
 
 func GetOrderByIDRepo(id uint) (*Order, error) {
 	var order Order
-	result := db.First(&order, id)
+	result := db.Preload("Items").First(&order, id)
 	return &order, result.Error
 }
 
 func CreateOrderRepo(order *Order) error {
-	result := db.Create(order)
-	return result.Error
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Omit("Items").Create(order).Error; err != nil {
+			return err
+		}
+		return createOrderItems(tx, order)
+	})
 }
 
+// UpdateOrderRepo saves the order and replaces its items with order.Items.
 func UpdateOrderRepo(order *Order) error {
-	result := db.Save(order)
-	return result.Error
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Omit("Items").Save(order).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("order_id = ?", order.ID).Delete(&OrderItem{}).Error; err != nil {
+			return err
+		}
+		return createOrderItems(tx, order)
+	})
 }
 
 func DeleteOrderRepo(id uint) error {
-	result := db.Delete(&Order{}, id)
-	return result.Error
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("order_id = ?", id).Delete(&OrderItem{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("order_id = ?", id).Delete(&OrderStatusHistory{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&Order{}, id).Error
+	})
+}
+
+func createOrderItems(tx *gorm.DB, order *Order) error {
+	if len(order.Items) == 0 {
+		return nil
+	}
+	for i := range order.Items {
+		order.Items[i].ID = 0
+		order.Items[i].OrderID = order.ID
+	}
+	return tx.Create(&order.Items).Error
+}
+
+// TransitionOrderRepo moves the order to the requested status and records the
+// change in the status history. The order row is locked for the duration of
+// the transaction so concurrent transitions are serialized. Moving an order to
+// the status it already has is a no-op. If effect is not nil it is called
+// before the transaction commits, and an error from it aborts the transition.
+func TransitionOrderRepo(id uint, req TransitionRequest, effect func(order *Order, status string) error) (*Order, error) {
+	var order Order
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&order, id).Error; err != nil {
+			return err
+		}
+		if order.Status == req.Status {
+			return nil
+		}
+		if err := checkTransition(order.Status, req.Status); err != nil {
+			return err
+		}
+		if effect != nil {
+			if err := effect(&order, req.Status); err != nil {
+				return err
+			}
+		}
+
+		history := OrderStatusHistory{
+			OrderID:    order.ID,
+			FromStatus: order.Status,
+			ToStatus:   req.Status,
+			ChangedBy:  req.ChangedBy,
+			Reason:     req.Reason,
+			ChangedAt:  time.Now(),
+		}
+		if err := tx.Model(&order).Update("status", req.Status).Error; err != nil {
+			return err
+		}
+		return tx.Create(&history).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return GetOrderByIDRepo(id)
+}
+
+func GetOrderHistoryRepo(orderID uint) ([]OrderStatusHistory, error) {
+	var history []OrderStatusHistory
+	result := db.Where("order_id = ?", orderID).Order("changed_at, id").Find(&history)
+	return history, result.Error
 }
 
 func SearchOrdersRepo(userID uint, status string) ([]Order, error) {
@@ -64,6 +176,6 @@ func SearchOrdersRepo(userID uint, status string) ([]Order, error) {
 		query = query.Where("status = ?", status)
 	}
 
-	result := query.Find(&orders)
+	result := query.Preload("Items").Find(&orders)
 	return orders, result.Error
 }
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+const (
+	StatusNew             = "new"
+	StatusAwaitingPayment = "awaiting_payment"
+	StatusPaid            = "paid"
+	StatusInProcess       = "in_process"
+	StatusShipped         = "shipped"
+	StatusCompleted       = "completed"
+	StatusCancelled       = "cancelled"
+	StatusRefunded        = "refunded"
+)
+
+var ErrIllegalTransition = errors.New("illegal order status transition")
+
+// orderTransitions lists the statuses an order may move to from each status.
+// Orders that have been paid for can no longer be cancelled, only refunded.
+var orderTransitions = map[string][]string{
+	StatusNew:             {StatusAwaitingPayment, StatusCancelled},
+	StatusAwaitingPayment: {StatusPaid, StatusCancelled},
+	StatusPaid:            {StatusInProcess, StatusRefunded},
+	StatusInProcess:       {StatusShipped, StatusRefunded},
+	StatusShipped:         {StatusCompleted, StatusRefunded},
+	StatusCompleted:       {StatusRefunded},
+	StatusCancelled:       {},
+	StatusRefunded:        {},
+}
+
+func checkTransition(from, to string) error {
+	for _, allowed := range orderTransitions[from] {
+		if allowed == to {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s -> %s", ErrIllegalTransition, from, to)
+}
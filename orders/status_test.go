@@ -0,0 +1,55 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCheckTransition(t *testing.T) {
+	statuses := []string{
+		StatusNew, StatusAwaitingPayment, StatusPaid, StatusInProcess,
+		StatusShipped, StatusCompleted, StatusCancelled, StatusRefunded,
+	}
+	allowed := map[[2]string]bool{
+		{StatusNew, StatusAwaitingPayment}:       true,
+		{StatusNew, StatusCancelled}:             true,
+		{StatusAwaitingPayment, StatusPaid}:      true,
+		{StatusAwaitingPayment, StatusCancelled}: true,
+		{StatusPaid, StatusInProcess}:            true,
+		{StatusPaid, StatusRefunded}:             true,
+		{StatusInProcess, StatusShipped}:         true,
+		{StatusInProcess, StatusRefunded}:        true,
+		{StatusShipped, StatusCompleted}:         true,
+		{StatusShipped, StatusRefunded}:          true,
+		{StatusCompleted, StatusRefunded}:        true,
+	}
+
+	for _, from := range statuses {
+		for _, to := range statuses {
+			err := checkTransition(from, to)
+			if allowed[[2]string{from, to}] {
+				if err != nil {
+					t.Errorf("%s -> %s: got %v, want allowed", from, to, err)
+				}
+			} else if !errors.Is(err, ErrIllegalTransition) {
+				t.Errorf("%s -> %s: got %v, want ErrIllegalTransition", from, to, err)
+			}
+		}
+	}
+}
+
+func TestCheckTransitionUnknownStatus(t *testing.T) {
+	tests := []struct {
+		from, to string
+	}{
+		{"", StatusNew},
+		{"lost", StatusCancelled},
+		{StatusNew, "lost"},
+		{StatusNew, ""},
+	}
+	for _, tt := range tests {
+		if err := checkTransition(tt.from, tt.to); !errors.Is(err, ErrIllegalTransition) {
+			t.Errorf("%q -> %q: got %v, want ErrIllegalTransition", tt.from, tt.to, err)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+)
+
+var (
+	ErrUserNotFound     = errors.New("user not found")
+	ErrEmailNotVerified = errors.New("verify your email address before placing orders")
+	ErrUsersUnavailable = errors.New("users service unavailable")
+)
+
+// User is the subset of the users service model the orders service needs.
+type User struct {
+	ID              uint       `json:"id"`
+	EmailVerifiedAt *time.Time `json:"email_verified_at"`
+}
+
+func usersServiceURL() string {
+	if url := os.Getenv("USERS_SERVICE_URL"); url != "" {
+		return url
+	}
+	return "http://user-service:8081"
+}
+
+func getUser(id uint) (*User, error) {
+	resp, err := serviceClient.Get(fmt.Sprintf("%s/users/%d", usersServiceURL(), id))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUsersUnavailable, err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, fmt.Errorf("%w: %d", ErrUserNotFound, id)
+	default:
+		return nil, fmt.Errorf("%w: unexpected status %s", ErrUsersUnavailable, resp.Status)
+	}
+
+	var user User
+	if err := json.NewDecoder(resp.Body).Decode(&user); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUsersUnavailable, err)
+	}
+	return &user, nil
+}
+
+// checkUserMayOrder makes sure the user exists and has verified the email
+// address.
+func checkUserMayOrder(userID uint) error {
+	user, err := getUser(userID)
+	if err != nil {
+		return err
+	}
+	if user.EmailVerifiedAt == nil {
+		return ErrEmailNotVerified
+	}
+	return nil
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+)
+
+// PAN is a card number. It prints masked so it cannot leak into logs or
+// error messages through fmt.
+type PAN string
+
+func (p PAN) String() string {
+	return maskPAN(string(p))
+}
+
+func (p PAN) GoString() string {
+	return p.String()
+}
+
+// CVC is a card security code. It never prints.
+type CVC string
+
+func (c CVC) String() string {
+	if c == "" {
+		return ""
+	}
+	return "***"
+}
+
+func (c CVC) GoString() string {
+	return c.String()
+}
+
+func init() {
+	validate.RegisterValidation("luhn", func(fl validator.FieldLevel) bool {
+		return luhnValid(fl.Field().String())
+	})
+	validate.RegisterValidation("card_expiry", func(fl validator.FieldLevel) bool {
+		return expiryValid(fl.Field().String(), time.Now())
+	})
+}
+
+// luhnValid reports whether pan is 12 to 19 digits with a valid Luhn check digit.
+func luhnValid(pan string) bool {
+	if len(pan) < 12 || len(pan) > 19 {
+		return false
+	}
+	sum := 0
+	double := false
+	for i := len(pan) - 1; i >= 0; i-- {
+		c := pan[i]
+		if c < '0' || c > '9' {
+			return false
+		}
+		d := int(c - '0')
+		if double {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		double = !double
+	}
+	return sum%10 == 0
+}
+
+// expiryValid reports whether an MMYY expiry date is well formed and the card
+// is still valid in the month of now.
+func expiryValid(expDate string, now time.Time) bool {
+	if len(expDate) != 4 {
+		return false
+	}
+	month, err := strconv.Atoi(expDate[:2])
+	if err != nil || month < 1 || month > 12 {
+		return false
+	}
+	year, err := strconv.Atoi(expDate[2:])
+	if err != nil {
+		return false
+	}
+	year += 2000
+	return year > now.Year() || (year == now.Year() && month >= int(now.Month()))
+}
+
+// maskPAN keeps the first 6 and the last 4 digits of a card number.
+func maskPAN(pan string) string {
+	if len(pan) < 12 {
+		return strings.Repeat("*", len(pan))
+	}
+	return pan[:6] + strings.Repeat("*", len(pan)-10) + pan[len(pan)-4:]
+}
+
+// cardBrand detects the payment system from the leading digits of a card number.
+func cardBrand(pan string) string {
+	prefix := func(n int) int {
+		if len(pan) < n {
+			return -1
+		}
+		v, _ := strconv.Atoi(pan[:n])
+		return v
+	}
+	switch {
+	case strings.HasPrefix(pan, "4"):
+		return "visa"
+	case prefix(2) >= 51 && prefix(2) <= 55, prefix(4) >= 2221 && prefix(4) <= 2720:
+		return "mastercard"
+	case prefix(4) >= 2200 && prefix(4) <= 2204:
+		return "mir"
+	case prefix(2) == 34, prefix(2) == 37:
+		return "amex"
+	case prefix(2) == 62:
+		return "unionpay"
+	}
+	return "unknown"
+}
+
+// panPattern matches digit runs long enough to be a card number.
+var panPattern = regexp.MustCompile(`\d{12,19}`)
+
+// redactPANs masks everything that looks like a card number in s, including
+// numbers that fail the Luhn check, since a mistyped card is still card data.
+func redactPANs(s string) string {
+	return panPattern.ReplaceAllStringFunc(s, maskPAN)
+}
+
+// redactingWriter masks card numbers in everything written through it. It is
+// installed as the output of the standard logger.
+type redactingWriter struct {
+	w io.Writer
+}
+
+func (rw redactingWriter) Write(p []byte) (int, error) {
+	if _, err := rw.w.Write([]byte(redactPANs(string(p)))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
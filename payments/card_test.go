@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestLuhnValid(t *testing.T) {
+	tests := []struct {
+		pan  string
+		want bool
+	}{
+		{"4111111111111111", true},
+		{"4111111111111112", false},
+		{"5555555555554444", true},
+		{"2200000000000004", true},
+		{"378282246310005", true},
+		{"6011111111111117", true},
+		{"4222222222222", true},
+		{"4000000000000000006", true},
+		{"4000000000000000007", false},
+		{"424242424242", true},
+		{"42424242424", false},
+		{"42424242424242424242", false},
+		{"4111 1111 1111 1111", false},
+		{"4111-1111-1111-1111", false},
+		{"411111111111111a", false},
+		{"000000000000", true},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := luhnValid(tt.pan); got != tt.want {
+			t.Errorf("luhnValid(%q) = %v, want %v", tt.pan, got, tt.want)
+		}
+	}
+}
+
+func TestExpiryValid(t *testing.T) {
+	december := time.Date(2024, time.December, 31, 23, 59, 0, 0, time.UTC)
+	january := time.Date(2025, time.January, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		expDate string
+		now     time.Time
+		want    bool
+	}{
+		{"1224", december, true},
+		{"1224", january, false},
+		{"0125", december, true},
+		{"0125", january, true},
+		{"1124", december, false},
+		{"0124", december, false},
+		{"1230", january, true},
+		{"0199", january, true},
+		{"0024", december, false},
+		{"1325", december, false},
+		{"125", december, false},
+		{"01255", december, false},
+		{"ab25", december, false},
+		{"01ab", december, false},
+		{"", december, false},
+	}
+	for _, tt := range tests {
+		if got := expiryValid(tt.expDate, tt.now); got != tt.want {
+			t.Errorf("expiryValid(%q, %s) = %v, want %v", tt.expDate, tt.now.Format("2006-01"), got, tt.want)
+		}
+	}
+}
+
+func TestMaskPAN(t *testing.T) {
+	tests := []struct {
+		pan  string
+		want string
+	}{
+		{"4111111111111111", "411111******1111"},
+		{"378282246310005", "378282*****0005"},
+		{"4000000000000000006", "400000*********0006"},
+		{"424242424242", "424242**4242"},
+		{"42424242424", "***********"},
+		{"1234", "****"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := maskPAN(tt.pan); got != tt.want {
+			t.Errorf("maskPAN(%q) = %q, want %q", tt.pan, got, tt.want)
+		}
+	}
+}
+
+func TestPANDoesNotPrint(t *testing.T) {
+	pan := PAN("4111111111111111")
+	for _, format := range []string{"%s", "%v", "%+v", "%#v"} {
+		if got := fmt.Sprintf(format, pan); got != "411111******1111" {
+			t.Errorf("Sprintf(%q, pan) = %q", format, got)
+		}
+	}
+	if got := fmt.Sprintf("%v %#v", CVC("123"), CVC("123")); got != "*** ***" {
+		t.Errorf("CVC printed as %q", got)
+	}
+}
+
+func TestRedactPANs(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"card 4111111111111111 declined", "card 411111******1111 declined"},
+		{"typo 4111111111111112", "typo 411111******1112"},
+		{"order 12345 amount 100050", "order 12345 amount 100050"},
+		{"a 5555555555554444, b 4111111111111111", "a 555555******4444, b 411111******1111"},
+	}
+	for _, tt := range tests {
+		if got := redactPANs(tt.in); got != tt.want {
+			t.Errorf("redactPANs(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// Config is the configuration of the payments service. It is read from the
+// JSON file named by PAYMENTS_CONFIG_FILE, if set, and then overridden by
+// environment variables.
+type Config struct {
+	Provider string `json:"provider"`
+	// Currencies are the currencies the provider can charge. Orders in any
+	// other currency cannot be paid.
+	Currencies []string `json:"currencies"`
+	Timeout    Duration `json:"timeout"`
+	// AuthorizationTTL is how long an authorized payment can be captured
+	// before it is voided automatically.
+	AuthorizationTTL Duration `json:"authorization_ttl"`
+	// ReconcileInterval is how often payments are reconciled with the
+	// provider; ReconcileLookback is how far back each run looks.
+	ReconcileInterval Duration   `json:"reconcile_interval"`
+	ReconcileLookback Duration   `json:"reconcile_lookback"`
+	Epay              EpayConfig `json:"epay"`
+}
+
+type EpayConfig struct {
+	OAuthURL     string `json:"oauth_url"`
+	APIURL       string `json:"api_url"`
+	ClientID     string `json:"client_id"`
+	ClientSecret Secret `json:"client_secret"`
+	TerminalID   string `json:"terminal_id"`
+	Scope        string `json:"scope"`
+	// PostLink and FailurePostLink are the public URLs ePay posts payment
+	// results to. They must reach the /payments/callbacks/epay endpoints.
+	PostLink        string `json:"post_link"`
+	FailurePostLink string `json:"failure_post_link"`
+	// CallbackSecret signs the callbacks so forged results are rejected.
+	CallbackSecret Secret `json:"callback_secret"`
+	// TokenRefreshBefore is how long before expiry a cached token is refreshed.
+	TokenRefreshBefore Duration `json:"token_refresh_before"`
+	// PublicKeyTTL is how long the downloaded RSA public key is reused.
+	PublicKeyTTL Duration `json:"public_key_ttl"`
+}
+
+var config *Config
+
+// Secret is a string that is never printed or marshalled in clear text.
+type Secret string
+
+func (s Secret) String() string {
+	if s == "" {
+		return ""
+	}
+	return "[REDACTED]"
+}
+
+func (s Secret) GoString() string {
+	return s.String()
+}
+
+func (s Secret) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.String())
+}
+
+// Duration is a time.Duration written as a string such as "30s" in JSON.
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d Duration) String() string {
+	return time.Duration(d).String()
+}
+
+func defaultConfig() *Config {
+	return &Config{
+		Provider:          "epay",
+		Currencies:        []string{"KZT"},
+		Timeout:           Duration(30 * time.Second),
+		AuthorizationTTL:  Duration(7 * 24 * time.Hour),
+		ReconcileInterval: Duration(time.Hour),
+		ReconcileLookback: Duration(72 * time.Hour),
+		Epay: EpayConfig{
+			OAuthURL:           "https://testoauth.homebank.kz/epay2/oauth2/token",
+			APIURL:             "https://testepay.homebank.kz/api",
+			Scope:              "webapi usermanagement email_send verification statement statistics payment",
+			TokenRefreshBefore: Duration(time.Minute),
+			PublicKeyTTL:       Duration(time.Hour),
+		},
+	}
+}
+
+// LoadConfig builds the configuration from the defaults, the optional config
+// file and the environment, and validates the result.
+func LoadConfig() (*Config, error) {
+	cfg := defaultConfig()
+
+	if path := os.Getenv("PAYMENTS_CONFIG_FILE"); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("read config file: %w", err)
+		}
+		if err := json.Unmarshal(data, cfg); err != nil {
+			return nil, fmt.Errorf("parse config file %s: %w", path, err)
+		}
+	}
+
+	setFromEnv(&cfg.Provider, "PAYMENT_PROVIDER")
+	if currencies := os.Getenv("PAYMENT_CURRENCIES"); currencies != "" {
+		cfg.Currencies = strings.Split(currencies, ",")
+		for i := range cfg.Currencies {
+			cfg.Currencies[i] = strings.TrimSpace(cfg.Currencies[i])
+		}
+	}
+	setFromEnv(&cfg.Epay.OAuthURL, "EPAY_OAUTH_URL")
+	setFromEnv(&cfg.Epay.APIURL, "EPAY_API_URL")
+	setFromEnv(&cfg.Epay.ClientID, "EPAY_CLIENT_ID")
+	setFromEnv(&cfg.Epay.TerminalID, "EPAY_TERMINAL_ID")
+	setFromEnv(&cfg.Epay.Scope, "EPAY_SCOPE")
+	setFromEnv(&cfg.Epay.PostLink, "EPAY_POST_LINK")
+	setFromEnv(&cfg.Epay.FailurePostLink, "EPAY_FAILURE_POST_LINK")
+	if secret := os.Getenv("EPAY_CLIENT_SECRET"); secret != "" {
+		cfg.Epay.ClientSecret = Secret(secret)
+	}
+	if secret := os.Getenv("EPAY_CALLBACK_SECRET"); secret != "" {
+		cfg.Epay.CallbackSecret = Secret(secret)
+	}
+	for name, field := range map[string]*Duration{
+		"PAYMENT_TIMEOUT":            &cfg.Timeout,
+		"PAYMENT_AUTHORIZATION_TTL":  &cfg.AuthorizationTTL,
+		"PAYMENT_RECONCILE_INTERVAL": &cfg.ReconcileInterval,
+		"PAYMENT_RECONCILE_LOOKBACK": &cfg.ReconcileLookback,
+		"EPAY_TOKEN_REFRESH_BEFORE":  &cfg.Epay.TokenRefreshBefore,
+		"EPAY_PUBLIC_KEY_TTL":        &cfg.Epay.PublicKeyTTL,
+	} {
+		if err := durationFromEnv(field, name); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+func (c *Config) Validate() error {
+	var errs []error
+	if c.Provider != "epay" && c.Provider != "mock" {
+		errs = append(errs, fmt.Errorf("provider must be epay or mock, got %q", c.Provider))
+	}
+	if len(c.Currencies) == 0 {
+		errs = append(errs, errors.New("currencies must list at least one currency"))
+	}
+	for _, currency := range c.Currencies {
+		if len(currency) != 3 || strings.ToUpper(currency) != currency {
+			errs = append(errs, fmt.Errorf("currencies must be ISO 4217 codes, got %q", currency))
+		}
+	}
+	if c.Timeout <= 0 {
+		errs = append(errs, errors.New("timeout must be positive"))
+	}
+	if c.AuthorizationTTL <= 0 {
+		errs = append(errs, errors.New("authorization_ttl must be positive"))
+	}
+	if c.ReconcileInterval <= 0 {
+		errs = append(errs, errors.New("reconcile_interval must be positive"))
+	}
+	if c.ReconcileLookback <= 0 {
+		errs = append(errs, errors.New("reconcile_lookback must be positive"))
+	}
+
+	if c.Provider == "epay" {
+		if !isAbsoluteURL(c.Epay.OAuthURL) {
+			errs = append(errs, errors.New("epay.oauth_url must be an absolute URL"))
+		}
+		if !isAbsoluteURL(c.Epay.APIURL) {
+			errs = append(errs, errors.New("epay.api_url must be an absolute URL"))
+		}
+		if c.Epay.ClientID == "" {
+			errs = append(errs, errors.New("epay.client_id is required"))
+		}
+		if c.Epay.ClientSecret == "" {
+			errs = append(errs, errors.New("epay.client_secret is required"))
+		}
+		if c.Epay.TerminalID == "" {
+			errs = append(errs, errors.New("epay.terminal_id is required"))
+		}
+		if !isAbsoluteURL(c.Epay.PostLink) {
+			errs = append(errs, errors.New("epay.post_link must be an absolute URL"))
+		}
+		if !isAbsoluteURL(c.Epay.FailurePostLink) {
+			errs = append(errs, errors.New("epay.failure_post_link must be an absolute URL"))
+		}
+		if len(c.Epay.CallbackSecret) < 16 {
+			errs = append(errs, errors.New("epay.callback_secret must be at least 16 characters"))
+		}
+		if c.Epay.TokenRefreshBefore < 0 {
+			errs = append(errs, errors.New("epay.token_refresh_before must not be negative"))
+		}
+		if c.Epay.PublicKeyTTL <= 0 {
+			errs = append(errs, errors.New("epay.public_key_ttl must be positive"))
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid payments config: %w", errors.Join(errs...))
+	}
+	return nil
+}
+
+func setFromEnv(field *string, name string) {
+	if value := os.Getenv(name); value != "" {
+		*field = value
+	}
+}
+
+func durationFromEnv(field *Duration, name string) error {
+	value := os.Getenv(name)
+	if value == "" {
+		return nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	*field = Duration(d)
+	return nil
+}
+
+// AcceptsCurrency reports whether the provider can charge in currency.
+func (c *Config) AcceptsCurrency(currency string) bool {
+	for _, accepted := range c.Currencies {
+		if accepted == currency {
+			return true
+		}
+	}
+	return false
+}
+
+func isAbsoluteURL(value string) bool {
+	u, err := url.Parse(value)
+	return err == nil && u.Scheme != "" && u.Host != ""
+}
@@ -39,6 +39,99 @@ const docTemplate = `{
                 }
             }
         },
+        "/ledger/balances": {
+            "get": {
+                "description": "Get the debits, credits and balance of every ledger account per currency in minor units. Balance is debits minus credits, so the balances of each currency sum to zero.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "ledger"
+                ],
+                "summary": "Get account balances",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Account, e.g. assets:provider_clearing",
+                        "name": "account",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Currency code",
+                        "name": "currency",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/main.AccountBalance"
+                            }
+                        }
+                    },
+                    "403": {
+                        "description": "Only admins",
+                        "schema": {
+                            "$ref": "#/definitions/access.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/ledger/journal": {
+            "get": {
+                "description": "Get ledger entries with their lines, newest first",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "ledger"
+                ],
+                "summary": "Get the journal",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Payment ID",
+                        "name": "payment_id",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Only entries that touch this account",
+                        "name": "account",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "default": 100,
+                        "description": "Maximum number of entries",
+                        "name": "limit",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/main.LedgerEntry"
+                            }
+                        }
+                    },
+                    "403": {
+                        "description": "Only admins",
+                        "schema": {
+                            "$ref": "#/definitions/access.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
         "/payments": {
             "get": {
                 "description": "Get all payments",
@@ -58,11 +151,17 @@ const docTemplate = `{
                                 "$ref": "#/definitions/main.Payment"
                             }
                         }
+                    },
+                    "403": {
+                        "description": "Only admins",
+                        "schema": {
+                            "$ref": "#/definitions/access.ErrorResponse"
+                        }
                     }
                 }
             },
             "post": {
-                "description": "Create a new payment using API ePayment.kz",
+                "description": "Create a new payment using the configured payment provider. The card is given either as card data or as a saved payment method of the user (payment_method_id); with save_card the card is saved once it is accepted. The amount must match the order total. The order is moved to awaiting_payment before the card is charged and to paid after a successful payment.",
                 "consumes": [
                     "application/json"
                 ],
@@ -82,6 +181,12 @@ const docTemplate = `{
                         "schema": {
                             "$ref": "#/definitions/main.PaymentRequest"
                         }
+                    },
+                    {
+                        "type": "string",
+                        "description": "Unique key that makes the request safe to retry; a retry returns the original response instead of charging the card again",
+                        "name": "Idempotency-Key",
+                        "in": "header"
                     }
                 ],
                 "responses": {
@@ -90,13 +195,248 @@ const docTemplate = `{
                         "schema": {
                             "$ref": "#/definitions/main.Payment"
                         }
+                    },
+                    "403": {
+                        "description": "The payment is for another user",
+                        "schema": {
+                            "$ref": "#/definitions/access.ErrorResponse"
+                        }
+                    },
+                    "409": {
+                        "description": "Order cannot be paid",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "422": {
+                        "description": "Amount does not match the order or Idempotency-Key reused for a different request",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/payments/authorize": {
+            "post": {
+                "description": "Reserve the order total on the card without charging it. The order is moved to paid once the amount is authorized. The payment has to be captured with /payments/{id}/capture before expires_at, otherwise it is voided automatically.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "payments"
+                ],
+                "summary": "Authorize a payment",
+                "parameters": [
+                    {
+                        "description": "Authorize payment",
+                        "name": "payment",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.PaymentRequest"
+                        }
+                    },
+                    {
+                        "type": "string",
+                        "description": "Unique key that makes the request safe to retry; a retry returns the original response instead of authorizing the card again",
+                        "name": "Idempotency-Key",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.Payment"
+                        }
+                    },
+                    "403": {
+                        "description": "The payment is for another user",
+                        "schema": {
+                            "$ref": "#/definitions/access.ErrorResponse"
+                        }
+                    },
+                    "409": {
+                        "description": "Order cannot be paid",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "422": {
+                        "description": "Amount does not match the order or Idempotency-Key reused for a different request",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/payments/callbacks/epay": {
+            "post": {
+                "description": "Receives the payment result ePay posts to postLink. The callback must carry the secret_hash issued with the payment. A successful result is confirmed with ePay before the payment and its order are marked as paid. Repeated callbacks are safe.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "callbacks"
+                ],
+                "summary": "ePay payment callback",
+                "parameters": [
+                    {
+                        "description": "Payment result",
+                        "name": "callback",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.EpayCallback"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.Payment"
+                        }
+                    },
+                    "401": {
+                        "description": "Invalid callback signature",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "404": {
+                        "description": "Payment not found",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/payments/callbacks/epay/fail": {
+            "post": {
+                "description": "Receives the result ePay posts to failurePostLink and marks the payment as unsuccessful. A payment that has already succeeded is not changed.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "callbacks"
+                ],
+                "summary": "ePay failed payment callback",
+                "parameters": [
+                    {
+                        "description": "Payment result",
+                        "name": "callback",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.EpayCallback"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.Payment"
+                        }
+                    },
+                    "401": {
+                        "description": "Invalid callback signature",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "404": {
+                        "description": "Payment not found",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/payments/reconciliation": {
+            "get": {
+                "description": "Get the differences between payments and the provider found by reconciliation, newest first. Discrepancies reconciliation fixed on its own are resolved.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "reconciliation"
+                ],
+                "summary": "Get reconciliation discrepancies",
+                "parameters": [
+                    {
+                        "type": "boolean",
+                        "description": "Only resolved or only open discrepancies",
+                        "name": "resolved",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/main.PaymentDiscrepancy"
+                            }
+                        }
+                    },
+                    "403": {
+                        "description": "Only admins",
+                        "schema": {
+                            "$ref": "#/definitions/access.ErrorResponse"
+                        }
                     }
                 }
             }
         },
         "/payments/{id}": {
             "get": {
-                "responses": {}
+                "description": "Get a payment by ID",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "payments"
+                ],
+                "summary": "Get a payment by ID",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Payment ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.Payment"
+                        }
+                    },
+                    "403": {
+                        "description": "The payment belongs to another user",
+                        "schema": {
+                            "$ref": "#/definitions/access.ErrorResponse"
+                        }
+                    }
+                }
             },
             "put": {
                 "description": "Update a payment by ID",
@@ -134,6 +474,12 @@ const docTemplate = `{
                         "schema": {
                             "$ref": "#/definitions/main.Payment"
                         }
+                    },
+                    "403": {
+                        "description": "Only admins",
+                        "schema": {
+                            "$ref": "#/definitions/access.ErrorResponse"
+                        }
                     }
                 }
             },
@@ -161,13 +507,413 @@ const docTemplate = `{
                         "schema": {
                             "type": "string"
                         }
+                    },
+                    "403": {
+                        "description": "Only admins",
+                        "schema": {
+                            "$ref": "#/definitions/access.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/payments/{id}/action": {
+            "get": {
+                "description": "Get the 3-D Secure challenge the customer has to pass for a payment in status requires_action. After the challenge the payment is finished with /payments/{id}/complete.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "payments"
+                ],
+                "summary": "Get the pending action of a payment",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Payment ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.PaymentAction"
+                        }
+                    },
+                    "403": {
+                        "description": "The payment belongs to another user",
+                        "schema": {
+                            "$ref": "#/definitions/access.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Payment not found",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "409": {
+                        "description": "Payment does not require action",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/payments/{id}/capture": {
+            "post": {
+                "description": "Charge an authorized payment. Without an amount the whole authorized amount is captured; a smaller amount captures only that part and releases the rest.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "payments"
+                ],
+                "summary": "Capture an authorized payment",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Payment ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Capture",
+                        "name": "capture",
+                        "in": "body",
+                        "schema": {
+                            "$ref": "#/definitions/main.CaptureRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.Payment"
+                        }
+                    },
+                    "403": {
+                        "description": "Only admins",
+                        "schema": {
+                            "$ref": "#/definitions/access.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Payment not found",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "409": {
+                        "description": "Payment is not authorized or the authorization has expired",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "422": {
+                        "description": "Capture exceeds the authorized amount",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/payments/{id}/chargebacks": {
+            "post": {
+                "description": "Record a chargeback reported by the provider in the ledger. A chargeback is recorded once per reference; repeating it returns the entry already posted. Chargebacks together can never exceed the captured amount minus refunds.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "ledger"
+                ],
+                "summary": "Record a chargeback",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Payment ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Chargeback",
+                        "name": "chargeback",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.ChargebackRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Chargeback already recorded",
+                        "schema": {
+                            "$ref": "#/definitions/main.LedgerEntry"
+                        }
+                    },
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "$ref": "#/definitions/main.LedgerEntry"
+                        }
+                    },
+                    "403": {
+                        "description": "Only admins",
+                        "schema": {
+                            "$ref": "#/definitions/access.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Payment not found",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "409": {
+                        "description": "Payment has no captured amount",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "422": {
+                        "description": "Chargeback exceeds the amount left after refunds and chargebacks",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/payments/{id}/complete": {
+            "post": {
+                "description": "Finish a payment in status requires_action once the customer has passed the 3-D Secure challenge. The payment is charged or authorized as originally requested and the order is moved to paid; a failed challenge makes the payment unsuccessful.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "payments"
+                ],
+                "summary": "Complete a payment after 3-D Secure",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Payment ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Challenge result",
+                        "name": "completion",
+                        "in": "body",
+                        "schema": {
+                            "$ref": "#/definitions/main.ActionCompletion"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.Payment"
+                        }
+                    },
+                    "403": {
+                        "description": "The payment belongs to another user",
+                        "schema": {
+                            "$ref": "#/definitions/access.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Payment not found",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "409": {
+                        "description": "Payment does not require action",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/payments/{id}/refunds": {
+            "get": {
+                "description": "Get all refunds of a payment, oldest first",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "refunds"
+                ],
+                "summary": "Get the refunds of a payment",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Payment ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/main.Refund"
+                            }
+                        }
+                    },
+                    "403": {
+                        "description": "The payment belongs to another user",
+                        "schema": {
+                            "$ref": "#/definitions/access.ErrorResponse"
+                        }
+                    }
+                }
+            },
+            "post": {
+                "description": "Refund a payment fully or partially through the payment provider. Without an amount everything not refunded yet is returned. Refunds together can never exceed the captured amount. The order is moved to refunded once the payment is fully refunded.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "refunds"
+                ],
+                "summary": "Refund a payment",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Payment ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Refund",
+                        "name": "refund",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.RefundRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "$ref": "#/definitions/main.Refund"
+                        }
+                    },
+                    "403": {
+                        "description": "Only admins",
+                        "schema": {
+                            "$ref": "#/definitions/access.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Payment not found",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "409": {
+                        "description": "Payment cannot be refunded",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "422": {
+                        "description": "Refund exceeds the amount left to refund",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "502": {
+                        "description": "Refund declined by the provider",
+                        "schema": {
+                            "$ref": "#/definitions/main.Refund"
+                        }
+                    }
+                }
+            }
+        },
+        "/payments/{id}/void": {
+            "post": {
+                "description": "Release the amount held on the card without charging it. The order is moved to refunded.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "payments"
+                ],
+                "summary": "Void an authorized payment",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Payment ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.Payment"
+                        }
+                    },
+                    "403": {
+                        "description": "Only admins",
+                        "schema": {
+                            "$ref": "#/definitions/access.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Payment not found",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "409": {
+                        "description": "Payment is not authorized",
+                        "schema": {
+                            "type": "string"
+                        }
                     }
                 }
             }
         },
         "/search/payments": {
             "get": {
-                "description": "Search payments by user, order, or status",
+                "description": "Search payments by user, order, or status. Clients only find their own payments.",
                 "produces": [
                     "application/json"
                 ],
@@ -204,30 +950,438 @@ const docTemplate = `{
                                 "$ref": "#/definitions/main.Payment"
                             }
                         }
+                    },
+                    "403": {
+                        "description": "Another user's payments",
+                        "schema": {
+                            "$ref": "#/definitions/access.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/users/{id}/payment-methods": {
+            "get": {
+                "description": "Get the payment methods a user can pay with instead of entering card data",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "payment-methods"
+                ],
+                "summary": "Get the saved cards of a user",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "User ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/main.PaymentMethod"
+                            }
+                        }
+                    },
+                    "403": {
+                        "description": "Another user's cards",
+                        "schema": {
+                            "$ref": "#/definitions/access.ErrorResponse"
+                        }
+                    }
+                }
+            },
+            "post": {
+                "description": "Save the card used for an accepted payment of the user as a payment method. Only the provider's card token and the masked card number are stored. Cards can also be saved while paying with save_card.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "payment-methods"
+                ],
+                "summary": "Save a card of a user",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "User ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Payment to save the card of",
+                        "name": "method",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.SavePaymentMethodRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "$ref": "#/definitions/main.PaymentMethod"
+                        }
+                    },
+                    "403": {
+                        "description": "Another user's cards",
+                        "schema": {
+                            "$ref": "#/definitions/access.ErrorResponse"
+                        }
+                    },
+                    "422": {
+                        "description": "Payment not found or has no saved card",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/users/{id}/payment-methods/{method_id}": {
+            "delete": {
+                "description": "Delete a payment method of a user",
+                "produces": [
+                    "text/plain"
+                ],
+                "tags": [
+                    "payment-methods"
+                ],
+                "summary": "Delete a saved card",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "User ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Payment method ID",
+                        "name": "method_id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Deleted",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "403": {
+                        "description": "Another user's cards",
+                        "schema": {
+                            "$ref": "#/definitions/access.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Payment method not found",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        }
+    },
+    "definitions": {
+        "access.ErrorResponse": {
+            "type": "object",
+            "properties": {
+                "error": {
+                    "type": "string",
+                    "example": "forbidden"
+                },
+                "message": {
+                    "type": "string",
+                    "example": "only admins may DELETE /products/1"
+                }
+            }
+        },
+        "main.AccountBalance": {
+            "type": "object",
+            "properties": {
+                "account": {
+                    "type": "string",
+                    "example": "assets:provider_clearing"
+                },
+                "balance": {
+                    "type": "integer",
+                    "example": 7500
+                },
+                "credit": {
+                    "type": "integer",
+                    "example": 2500
+                },
+                "currency": {
+                    "type": "string",
+                    "example": "KZT"
+                },
+                "debit": {
+                    "type": "integer",
+                    "example": 10000
+                }
+            }
+        },
+        "main.ActionCompletion": {
+            "type": "object",
+            "properties": {
+                "data": {
+                    "description": "Data is the challenge result returned by the card issuer, if any.",
+                    "type": "string",
+                    "example": "eyJ0cmFuc1N0YXR1cyI6IlkifQ"
+                }
+            }
+        },
+        "main.CaptureRequest": {
+            "type": "object",
+            "properties": {
+                "amount": {
+                    "$ref": "#/definitions/main.Money"
+                }
+            }
+        },
+        "main.ChargebackRequest": {
+            "type": "object",
+            "required": [
+                "reference"
+            ],
+            "properties": {
+                "amount": {
+                    "$ref": "#/definitions/main.Money"
+                },
+                "reason": {
+                    "type": "string",
+                    "maxLength": 255,
+                    "example": "Fraudulent transaction"
+                },
+                "reference": {
+                    "description": "Reference is the provider's identifier of the chargeback; a chargeback\nis recorded once per reference.",
+                    "type": "string",
+                    "maxLength": 100,
+                    "example": "CB-2023-0001"
+                }
+            }
+        },
+        "main.EpayCallback": {
+            "type": "object",
+            "properties": {
+                "accountId": {
+                    "type": "string",
+                    "example": "1"
+                },
+                "amount": {
+                    "type": "number",
+                    "example": 100
+                },
+                "cardId": {
+                    "type": "string",
+                    "example": "b2c3d4e5-f6a7-4b8c-9d0e-1f2a3b4c5d6e"
+                },
+                "cardMask": {
+                    "type": "string",
+                    "example": "400303******7597"
+                },
+                "code": {
+                    "type": "string",
+                    "example": "ok"
+                },
+                "currency": {
+                    "type": "string",
+                    "example": "KZT"
+                },
+                "dateTime": {
+                    "type": "string",
+                    "example": "2023-07-20T15:04:05+06:00"
+                },
+                "description": {
+                    "type": "string",
+                    "example": "Order #1"
+                },
+                "id": {
+                    "type": "string",
+                    "example": "a5f1c3e2-7b4d-4e8f-9a0b-1c2d3e4f5a6b"
+                },
+                "invoiceId": {
+                    "type": "string",
+                    "example": "000100001"
+                },
+                "reason": {
+                    "type": "string",
+                    "example": "success"
+                },
+                "reasonCode": {
+                    "type": "integer",
+                    "example": 0
+                },
+                "reference": {
+                    "type": "string",
+                    "example": "320415146527"
+                },
+                "secret_hash": {
+                    "type": "string",
+                    "example": "3f2a..."
+                },
+                "terminal": {
+                    "type": "string",
+                    "example": "67e34d63-102f-4bd1-898e-370781d0074d"
+                }
+            }
+        },
+        "main.LedgerEntry": {
+            "type": "object",
+            "properties": {
+                "created_at": {
+                    "type": "string",
+                    "example": "2023-07-20T15:04:05Z"
+                },
+                "currency": {
+                    "type": "string",
+                    "example": "KZT"
+                },
+                "description": {
+                    "type": "string",
+                    "example": "Payment 1 captured"
+                },
+                "id": {
+                    "type": "integer",
+                    "readOnly": true,
+                    "example": 1
+                },
+                "kind": {
+                    "type": "string",
+                    "enum": [
+                        "charge",
+                        "fee",
+                        "refund",
+                        "chargeback"
+                    ],
+                    "example": "charge"
+                },
+                "lines": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/main.LedgerLine"
                     }
+                },
+                "payment_id": {
+                    "type": "integer",
+                    "example": 1
+                },
+                "reference": {
+                    "type": "string",
+                    "example": "payment:1:charge"
                 }
             }
-        }
-    },
-    "definitions": {
+        },
+        "main.LedgerLine": {
+            "type": "object",
+            "properties": {
+                "account": {
+                    "type": "string",
+                    "example": "assets:provider_clearing"
+                },
+                "credit": {
+                    "type": "integer",
+                    "example": 0
+                },
+                "currency": {
+                    "type": "string",
+                    "example": "KZT"
+                },
+                "debit": {
+                    "type": "integer",
+                    "example": 10000
+                }
+            }
+        },
+        "main.Money": {
+            "type": "object",
+            "properties": {
+                "amount": {
+                    "type": "integer",
+                    "minimum": 0,
+                    "example": 100050
+                },
+                "currency": {
+                    "type": "string",
+                    "example": "KZT"
+                }
+            }
+        },
         "main.Payment": {
             "type": "object",
             "required": [
-                "amount",
                 "order_id",
                 "status",
                 "user_id"
             ],
             "properties": {
+                "action_url": {
+                    "description": "ActionURL is the 3-D Secure challenge of a payment in requires_action.",
+                    "type": "string",
+                    "readOnly": true,
+                    "example": "https://mock-acs.local/challenge/mock-000001"
+                },
                 "amount": {
-                    "type": "number",
-                    "example": 100
+                    "$ref": "#/definitions/main.Money"
+                },
+                "captured_amount": {
+                    "description": "CapturedAmount is the part of Amount actually charged.",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/main.Money"
+                        }
+                    ],
+                    "readOnly": true
+                },
+                "card_brand": {
+                    "type": "string",
+                    "readOnly": true,
+                    "example": "visa"
+                },
+                "card_id": {
+                    "type": "string",
+                    "readOnly": true,
+                    "example": "b2c3d4e5-f6a7-4b8c-9d0e-1f2a3b4c5d6e"
+                },
+                "card_mask": {
+                    "description": "Only the masked card number, the brand and the provider's card token\nare stored; the full card number and CVC never reach the database.",
+                    "type": "string",
+                    "readOnly": true,
+                    "example": "400303******7597"
+                },
+                "expires_at": {
+                    "description": "ExpiresAt is when an authorized payment is voided if it is not captured.",
+                    "type": "string",
+                    "readOnly": true,
+                    "example": "2023-07-27T15:04:05Z"
                 },
                 "id": {
                     "type": "integer",
                     "readOnly": true,
                     "example": 1
                 },
+                "invoice_id": {
+                    "description": "InvoiceID identifies a single payment attempt at the provider.",
+                    "type": "string",
+                    "readOnly": true,
+                    "example": "000100001"
+                },
                 "order_id": {
                     "type": "integer",
                     "example": 1
@@ -240,11 +1394,122 @@ const docTemplate = `{
                 "status": {
                     "type": "string",
                     "enum": [
+                        "pending",
+                        "requires_action",
+                        "authorized",
                         "successful",
-                        "unsuccessful"
+                        "unsuccessful",
+                        "voided",
+                        "expired",
+                        "partially_refunded",
+                        "refunded"
+                    ],
+                    "example": "successful"
+                },
+                "transaction_id": {
+                    "type": "string",
+                    "readOnly": true,
+                    "example": "9a1f3c2e-5a6b-4c7d-8e9f-0a1b2c3d4e5f"
+                },
+                "user_id": {
+                    "type": "integer",
+                    "example": 1
+                }
+            }
+        },
+        "main.PaymentAction": {
+            "type": "object",
+            "properties": {
+                "payment_id": {
+                    "type": "integer",
+                    "example": 1
+                },
+                "type": {
+                    "type": "string",
+                    "example": "redirect_3ds"
+                },
+                "url": {
+                    "type": "string",
+                    "example": "https://mock-acs.local/challenge/mock-000001"
+                }
+            }
+        },
+        "main.PaymentDiscrepancy": {
+            "type": "object",
+            "properties": {
+                "detected_at": {
+                    "type": "string",
+                    "example": "2023-07-20T15:04:05Z"
+                },
+                "id": {
+                    "type": "integer",
+                    "readOnly": true,
+                    "example": 1
+                },
+                "invoice_id": {
+                    "type": "string",
+                    "example": "000100001"
+                },
+                "kind": {
+                    "type": "string",
+                    "enum": [
+                        "stale_status",
+                        "status_mismatch",
+                        "amount_mismatch",
+                        "missing_at_provider",
+                        "pending_refund"
                     ],
+                    "example": "status_mismatch"
+                },
+                "local_amount": {
+                    "$ref": "#/definitions/main.Money"
+                },
+                "local_status": {
+                    "type": "string",
                     "example": "successful"
                 },
+                "note": {
+                    "type": "string",
+                    "example": "payment is successful locally but declined at the provider"
+                },
+                "payment_id": {
+                    "type": "integer",
+                    "example": 1
+                },
+                "provider_amount": {
+                    "$ref": "#/definitions/main.Money"
+                },
+                "provider_status": {
+                    "type": "string",
+                    "example": "declined"
+                },
+                "resolved": {
+                    "type": "boolean",
+                    "example": false
+                }
+            }
+        },
+        "main.PaymentMethod": {
+            "type": "object",
+            "properties": {
+                "card_brand": {
+                    "type": "string",
+                    "example": "visa"
+                },
+                "card_mask": {
+                    "type": "string",
+                    "example": "400303******7597"
+                },
+                "created_at": {
+                    "type": "string",
+                    "readOnly": true,
+                    "example": "2023-07-20T15:04:05Z"
+                },
+                "id": {
+                    "type": "integer",
+                    "readOnly": true,
+                    "example": 1
+                },
                 "user_id": {
                     "type": "integer",
                     "example": 1
@@ -254,26 +1519,22 @@ const docTemplate = `{
         "main.PaymentRequest": {
             "type": "object",
             "required": [
-                "amount",
-                "cvc",
-                "expDate",
-                "hpan",
                 "order_id",
-                "terminalId",
                 "user_id"
             ],
             "properties": {
                 "amount": {
-                    "type": "number",
-                    "example": 100
+                    "$ref": "#/definitions/main.Money"
                 },
                 "cvc": {
                     "type": "string",
+                    "maxLength": 4,
+                    "minLength": 3,
                     "example": "636"
                 },
                 "expDate": {
                     "type": "string",
-                    "example": "1022"
+                    "example": "1030"
                 },
                 "hpan": {
                     "type": "string",
@@ -283,15 +1544,89 @@ const docTemplate = `{
                     "type": "integer",
                     "example": 1
                 },
-                "terminalId": {
-                    "type": "string",
-                    "example": "67e34d63-102f-4bd1-898e-370781d0074d"
+                "payment_method_id": {
+                    "description": "PaymentMethodID charges a saved card of the user instead of card data.",
+                    "type": "integer",
+                    "example": 0
+                },
+                "save_card": {
+                    "description": "SaveCard saves the card as a payment method of the user once it is accepted.",
+                    "type": "boolean",
+                    "example": false
                 },
                 "user_id": {
                     "type": "integer",
                     "example": 1
                 }
             }
+        },
+        "main.Refund": {
+            "type": "object",
+            "properties": {
+                "amount": {
+                    "$ref": "#/definitions/main.Money"
+                },
+                "created_at": {
+                    "type": "string",
+                    "readOnly": true,
+                    "example": "2023-07-20T15:04:05Z"
+                },
+                "id": {
+                    "type": "integer",
+                    "readOnly": true,
+                    "example": 1
+                },
+                "message": {
+                    "type": "string",
+                    "example": "insufficient funds on merchant account"
+                },
+                "payment_id": {
+                    "type": "integer",
+                    "example": 1
+                },
+                "reason": {
+                    "type": "string",
+                    "example": "Item returned"
+                },
+                "status": {
+                    "type": "string",
+                    "enum": [
+                        "pending",
+                        "successful",
+                        "unsuccessful"
+                    ],
+                    "example": "successful"
+                },
+                "transaction_id": {
+                    "type": "string",
+                    "example": "9a1f3c2e-5a6b-4c7d-8e9f-0a1b2c3d4e5f"
+                }
+            }
+        },
+        "main.RefundRequest": {
+            "type": "object",
+            "properties": {
+                "amount": {
+                    "$ref": "#/definitions/main.Money"
+                },
+                "reason": {
+                    "type": "string",
+                    "maxLength": 255,
+                    "example": "Item returned"
+                }
+            }
+        },
+        "main.SavePaymentMethodRequest": {
+            "type": "object",
+            "required": [
+                "payment_id"
+            ],
+            "properties": {
+                "payment_id": {
+                    "type": "integer",
+                    "example": 1
+                }
+            }
         }
     }
 }`
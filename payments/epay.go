@@ -0,0 +1,532 @@
+package main
+
+import (
+	"HL_online_shop/common/money"
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// EpayProvider processes payments through the ePay (homebank.kz) API.
+type EpayProvider struct {
+	config     EpayConfig
+	client     *http.Client
+	tokens     *tokenCache
+	publicKeys *publicKeyCache
+}
+
+// NewEpayProvider creates the provider and starts the background refresh of
+// the ePay public key.
+func NewEpayProvider(cfg *Config) *EpayProvider {
+	p := &EpayProvider{
+		config: cfg.Epay,
+		client: &http.Client{Timeout: time.Duration(cfg.Timeout)},
+	}
+	p.tokens = newTokenCache(p.fetchToken, time.Duration(cfg.Epay.TokenRefreshBefore))
+	p.publicKeys = newPublicKeyCache(p.fetchRSAPublicKey, time.Duration(cfg.Epay.PublicKeyTTL))
+	p.publicKeys.startBackgroundRefresh()
+	return p
+}
+
+func (p *EpayProvider) Authorize(req ChargeRequest) (*ProviderResult, error) {
+	// Получение токена
+	token, err := p.getToken()
+	if err != nil {
+		return nil, err
+	}
+
+	// Сохранённая карта оплачивается по токену, криптограмма не нужна
+	var cryptogram string
+	if req.CardID == "" {
+		publicKey, err := p.getRSAPublicKey()
+		if err != nil {
+			return nil, err
+		}
+
+		card := req.Card
+		card.TerminalID = p.config.TerminalID
+		cryptogram, err = createCryptogram(card, publicKey)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Выполнение платежа
+	paymentResponse, err := p.makePayment(token, cryptogram, req)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &ProviderResult{
+		TransactionID: paymentResponse.ID,
+		InvoiceID:     paymentResponse.InvoiceID,
+		Status:        epayStatus(paymentResponse.Status),
+		Amount:        epayMoney(paymentResponse.Amount, req.Amount.Currency),
+		Code:          paymentResponse.Code,
+		Message:       paymentResponse.Description,
+		CardID:        paymentResponse.CardID,
+		Secure3D:      paymentResponse.Secure3D,
+		Fee:           epayMoney(paymentResponse.Fee, req.Amount.Currency),
+	}
+	if paymentResponse.Code != 0 {
+		result.Status = ProviderStatusDeclined
+	} else if paymentResponse.Secure3D != "" {
+		result.Status = ProviderStatusRequiresAction
+	}
+
+	if req.Capture && result.Status == ProviderStatusAuthorized {
+		if err := p.captureOrVoid(result, req.Amount); err != nil {
+			return nil, fmt.Errorf("capture after authorization: %w", err)
+		}
+	}
+	return result, nil
+}
+
+// CompleteAction checks the transaction after the 3-D Secure challenge. ePay
+// finishes the challenge itself, so only the capture is left to do.
+func (p *EpayProvider) CompleteAction(req ActionRequest) (*ProviderResult, error) {
+	result, err := p.Status(req.InvoiceID)
+	if err != nil {
+		return nil, err
+	}
+	if req.Capture && result.Status == ProviderStatusAuthorized {
+		if err := p.captureOrVoid(result, req.Amount); err != nil {
+			return nil, fmt.Errorf("capture after 3-D Secure: %w", err)
+		}
+	}
+	return result, nil
+}
+
+// captureOrVoid captures an authorization made for an immediate charge. If
+// the capture fails the authorization is voided, so the amount does not stay
+// held on the card of a payment that failed.
+func (p *EpayProvider) captureOrVoid(result *ProviderResult, amount Money) error {
+	if _, err := p.Capture(result.TransactionID, amount); err != nil {
+		if _, voidErr := p.Void(result.TransactionID); voidErr != nil {
+			log.Printf("failed to void transaction %s after its capture failed: %v", result.TransactionID, voidErr)
+		}
+		return err
+	}
+	result.Status = ProviderStatusCaptured
+	return nil
+}
+
+func (p *EpayProvider) Capture(transactionID string, amount Money) (*ProviderResult, error) {
+	if err := p.operation(transactionID, "charge", amount); err != nil {
+		return nil, err
+	}
+	return &ProviderResult{TransactionID: transactionID, Status: ProviderStatusCaptured, Amount: amount}, nil
+}
+
+func (p *EpayProvider) Void(transactionID string) (*ProviderResult, error) {
+	if err := p.operation(transactionID, "cancel", Money{}); err != nil {
+		return nil, err
+	}
+	return &ProviderResult{TransactionID: transactionID, Status: ProviderStatusVoided}, nil
+}
+
+func (p *EpayProvider) Refund(transactionID string, amount Money) (*ProviderResult, error) {
+	if err := p.operation(transactionID, "refund", amount); err != nil {
+		return nil, err
+	}
+	return &ProviderResult{TransactionID: transactionID, Status: ProviderStatusRefunded, Amount: amount}, nil
+}
+
+// Result codes of the ePay status check.
+const (
+	epayResultSuccess  = "100"
+	epayResultNotFound = "102"
+)
+
+type epayStatusResponse struct {
+	ResultCode    string `json:"resultCode"`
+	ResultMessage string `json:"resultMessage"`
+	Transaction   struct {
+		ID         string      `json:"id"`
+		InvoiceID  string      `json:"invoiceID"`
+		Amount     json.Number `json:"amount"`
+		Currency   string      `json:"currency"`
+		StatusName string      `json:"statusName"`
+		CardID     string      `json:"cardID"`
+		Reason     string      `json:"reason"`
+	} `json:"transaction"`
+}
+
+func (p *EpayProvider) Status(invoiceID string) (*ProviderResult, error) {
+	token, err := p.getToken()
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest("GET", p.config.APIURL+"/check-status/payment/transaction/"+invoiceID, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, providerError(err)
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, providerError(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: status check: status: %s, body: %s", ErrProviderFailed, resp.Status, body)
+	}
+	var status epayStatusResponse
+	if err := json.Unmarshal(body, &status); err != nil {
+		return nil, fmt.Errorf("%w: failed to decode status response: %v", ErrProviderFailed, err)
+	}
+	switch status.ResultCode {
+	case epayResultSuccess:
+	case epayResultNotFound:
+		return nil, fmt.Errorf("%w: %s", ErrTransactionNotFound, status.ResultMessage)
+	default:
+		return nil, fmt.Errorf("%w: status check: result code %s: %s", ErrProviderFailed, status.ResultCode, status.ResultMessage)
+	}
+	if status.Transaction.ID == "" {
+		return nil, fmt.Errorf("%w: status check returned no transaction", ErrProviderFailed)
+	}
+
+	return &ProviderResult{
+		TransactionID: status.Transaction.ID,
+		InvoiceID:     status.Transaction.InvoiceID,
+		Status:        epayStatus(status.Transaction.StatusName),
+		Amount:        epayMoney(status.Transaction.Amount, status.Transaction.Currency),
+		Message:       status.Transaction.Reason,
+		CardID:        status.Transaction.CardID,
+	}, nil
+}
+
+// operation runs an operation (charge, refund, cancel) on an existing ePay
+// transaction. An amount of zero applies it to the full amount.
+func (p *EpayProvider) operation(transactionID, operation string, amount Money) error {
+	token, err := p.getToken()
+	if err != nil {
+		return err
+	}
+
+	url := fmt.Sprintf("%s/operation/%s/%s", p.config.APIURL, transactionID, operation)
+	if amount.Amount > 0 {
+		url += "?amount=" + amount.Decimal()
+	}
+	req, err := http.NewRequest("POST", url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return providerError(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("%s failed: status: %s, body: %s", operation, resp.Status, body)
+	}
+	return nil
+}
+
+// callbackSignature is the secretHash sent with a payment. ePay returns it in
+// the postLink callback as secret_hash, which proves the callback is genuine.
+func callbackSignature(secret Secret, invoiceID string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(invoiceID))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// verifyCallbackSignature reports whether signature matches the invoice.
+func verifyCallbackSignature(secret Secret, invoiceID, signature string) bool {
+	if secret == "" || signature == "" {
+		return false
+	}
+	expected := callbackSignature(secret, invoiceID)
+	return hmac.Equal([]byte(expected), []byte(signature))
+}
+
+// epayStatus maps an ePay transaction status to a provider-neutral status.
+func epayStatus(name string) string {
+	switch name {
+	case "AUTH":
+		return ProviderStatusAuthorized
+	case "CHARGE":
+		return ProviderStatusCaptured
+	case "REFUND":
+		return ProviderStatusRefunded
+	case "CANCEL", "CANCEL_OLD":
+		return ProviderStatusVoided
+	case "3D":
+		return ProviderStatusRequiresAction
+	case "NEW":
+		return ProviderStatusPending
+	}
+	return ProviderStatusDeclined
+}
+
+// providerError marks network timeouts so callers can tell them apart from
+// declined payments.
+func providerError(err error) error {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return fmt.Errorf("%w: %v", ErrProviderTimeout, err)
+	}
+	return err
+}
+
+type TokenResponse struct {
+	AccessToken string `json:"access_token"`
+	ExpiresIn   string `json:"expires_in"`
+	Scope       string `json:"scope"`
+	TokenType   string `json:"token_type"`
+}
+
+// getToken returns a cached OAuth token, requesting a new one when needed.
+func (p *EpayProvider) getToken() (string, error) {
+	return p.tokens.Get()
+}
+
+// fetchToken requests a new OAuth token. The token is not bound to an invoice
+// so it can be reused for any payment until it expires.
+func (p *EpayProvider) fetchToken() (*TokenResponse, error) {
+	// Создаем буфер для тела запроса и writer для multipart формы
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+
+	// Добавляем поля формы
+	writer.WriteField("grant_type", "client_credentials")
+	writer.WriteField("scope", p.config.Scope)
+	writer.WriteField("client_id", p.config.ClientID)
+	writer.WriteField("client_secret", string(p.config.ClientSecret))
+	writer.WriteField("terminalId", p.config.TerminalID)
+
+	// Закрываем writer чтобы отправить все данные
+	err := writer.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest("POST", p.config.OAuthURL, body)
+	if err != nil {
+		return nil, err
+	}
+
+	// Устанавливаем заголовок Content-Type включая boundary
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, providerError(err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status: %s, body: %s ", resp.Status, respBody)
+	}
+
+	var token TokenResponse
+	err = json.Unmarshal(respBody, &token)
+	if err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+type PaymentRequestMake struct {
+	// Amount is a decimal in major units, written exactly from Money.
+	Amount          json.Number `json:"amount"`
+	Currency        string      `json:"currency"`
+	Name            string      `json:"name"`
+	Cryptogram      string      `json:"cryptogram"`
+	InvoiceID       string      `json:"invoiceId"`
+	InvoiceIDAlt    string      `json:"invoiceIdAlt,omitempty"`
+	Description     string      `json:"description"`
+	AccountID       string      `json:"accountId,omitempty"`
+	Email           string      `json:"email,omitempty"`
+	Phone           string      `json:"phone,omitempty"`
+	PostLink        string      `json:"postLink"`
+	FailurePostLink string      `json:"failurePostLink,omitempty"`
+	SecretHash      string      `json:"secretHash,omitempty"`
+	CardSave        bool        `json:"cardSave"`
+	Data            string      `json:"data,omitempty"`
+	// PaymentType and CardID charge a saved card instead of a cryptogram.
+	PaymentType string      `json:"paymentType,omitempty"`
+	CardID      *EpayCardID `json:"cardId,omitempty"`
+}
+
+type EpayCardID struct {
+	ID string `json:"id"`
+}
+
+// epayMoney reads a decimal amount from an ePay response. An amount with more
+// digits than the currency has is rounded half to even.
+func epayMoney(amount json.Number, currency string) Money {
+	if currency == "" {
+		currency = money.ShopCurrency()
+	}
+	m, err := money.Parse(amount.String(), currency)
+	if err != nil {
+		log.Printf("invalid ePay amount %q: %v", amount, err)
+	}
+	return m
+}
+
+type PaymentResponse struct {
+	ID           string      `json:"id"`
+	Amount       json.Number `json:"amount"`
+	Currency     string      `json:"currency"`
+	InvoiceID    string      `json:"invoiceID"`
+	AccountID    string      `json:"accountID"`
+	Phone        string      `json:"phone"`
+	Email        string      `json:"email"`
+	Description  string      `json:"description"`
+	Reference    string      `json:"reference"`
+	IntReference string      `json:"intReference"`
+	Secure3D     string      `json:"secure3D"`
+	CardID       string      `json:"cardID"`
+	Fee          json.Number `json:"fee"`
+	Code         int         `json:"code"`
+	Status       string      `json:"status"`
+}
+
+func (p *EpayProvider) makePayment(token, cryptogram string, charge ChargeRequest) (*PaymentResponse, error) {
+	url := p.config.APIURL + "/payment/cryptopay"
+
+	paymentRequestMake := PaymentRequestMake{
+		Amount:          json.Number(charge.Amount.Decimal()),
+		Currency:        charge.Amount.Currency,
+		Name:            charge.Customer.Name,
+		Cryptogram:      cryptogram,
+		InvoiceID:       charge.InvoiceID,
+		Description:     charge.Description,
+		AccountID:       strconv.Itoa(charge.UserID),
+		Email:           charge.Customer.Email,
+		Phone:           charge.Customer.Phone,
+		PostLink:        p.config.PostLink,
+		FailurePostLink: p.config.FailurePostLink,
+		SecretHash:      callbackSignature(p.config.CallbackSecret, charge.InvoiceID),
+		CardSave:        charge.SaveCard,
+	}
+	if charge.CardID != "" {
+		url = p.config.APIURL + "/payments/cards/auth"
+		paymentRequestMake.PaymentType = "cardId"
+		paymentRequestMake.CardID = &EpayCardID{ID: charge.CardID}
+		paymentRequestMake.CardSave = false
+	}
+
+	jsonData, err := json.Marshal(paymentRequestMake)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal payment request: %w", err)
+	}
+
+	//fmt.Println("Sending data to server:", string(jsonData)) // Отладка отправляемых данных
+
+	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %w", providerError(err))
+	}
+	defer resp.Body.Close()
+
+	//if resp.StatusCode != http.StatusOK {
+	//	body, _ := ioutil.ReadAll(resp.Body)
+	//	return nil, fmt.Errorf("server returned non-200 status: %d, body: %s", resp.StatusCode, string(body))
+	//}
+
+	var paymentResponse PaymentResponse
+	if err := json.NewDecoder(resp.Body).Decode(&paymentResponse); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	return &paymentResponse, nil
+}
+
+// getRSAPublicKey returns the cached ePay public key used to encrypt card data.
+func (p *EpayProvider) getRSAPublicKey() (*rsa.PublicKey, error) {
+	return p.publicKeys.Get()
+}
+
+func (p *EpayProvider) fetchRSAPublicKey() (*rsa.PublicKey, error) {
+	resp, err := p.client.Get(p.config.APIURL + "/public.rsa")
+	if err != nil {
+		return nil, providerError(err)
+	}
+	defer resp.Body.Close()
+
+	pemData, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(pemData)
+	if block == nil || block.Type != "PUBLIC KEY" {
+		return nil, errors.New("failed to decode PEM block containing public key")
+	}
+
+	pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	publicKey, ok := pub.(*rsa.PublicKey)
+	if !ok {
+		return nil, errors.New("not RSA public key")
+	}
+
+	return publicKey, nil
+}
+
+// "hpan":"4003032704547597","expDate":"1022","cvc":"636","terminalId":"67e34d63-102f-4bd1-898e-370781d0074d"
+type CardData struct {
+	HPAN       PAN    `json:"hpan" validate:"required" example:"4003032704547597"`
+	ExpDate    string `json:"expDate" validate:"required" example:"1022"`
+	CVC        CVC    `json:"cvc" validate:"required" example:"636"`
+	TerminalID string `json:"terminalId" validate:"required" example:"67e34d63-102f-4bd1-898e-370781d0074d"`
+}
+
+func createCryptogram(cardData CardData, publicKey *rsa.PublicKey) (string, error) {
+	cardDataJSON, err := json.Marshal(cardData)
+	if err != nil {
+		return "", err
+	}
+
+	encryptedData, err := rsa.EncryptPKCS1v15(rand.Reader, publicKey, cardDataJSON)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.StdEncoding.EncodeToString(encryptedData), nil
+}
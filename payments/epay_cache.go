@@ -0,0 +1,183 @@
+package main
+
+import (
+	"crypto/rsa"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// tokenCache keeps the ePay OAuth token until shortly before it expires.
+// Concurrent callers share a single token request: while a refresh is in
+// flight nobody else starts another one. A token inside the refresh window is
+// still handed out while it is being refreshed in the background.
+type tokenCache struct {
+	fetch         func() (*TokenResponse, error)
+	refreshBefore time.Duration
+
+	mu        sync.Mutex
+	token     string
+	expiresAt time.Time
+	inflight  *tokenCall
+}
+
+type tokenCall struct {
+	done  chan struct{}
+	token string
+	err   error
+}
+
+func newTokenCache(fetch func() (*TokenResponse, error), refreshBefore time.Duration) *tokenCache {
+	return &tokenCache{fetch: fetch, refreshBefore: refreshBefore}
+}
+
+func (c *tokenCache) Get() (string, error) {
+	c.mu.Lock()
+	now := time.Now()
+	if c.token != "" && now.Before(c.expiresAt) {
+		token := c.token
+		if now.After(c.expiresAt.Add(-c.refreshBefore)) && c.inflight == nil {
+			c.startRefresh()
+		}
+		c.mu.Unlock()
+		return token, nil
+	}
+
+	call := c.inflight
+	if call == nil {
+		call = c.startRefresh()
+	}
+	c.mu.Unlock()
+
+	<-call.done
+	return call.token, call.err
+}
+
+// startRefresh fetches a new token in the background. c.mu must be held.
+func (c *tokenCache) startRefresh() *tokenCall {
+	call := &tokenCall{done: make(chan struct{})}
+	c.inflight = call
+
+	go func() {
+		resp, err := c.fetch()
+
+		c.mu.Lock()
+		if err == nil {
+			c.token = resp.AccessToken
+			c.expiresAt = time.Now().Add(tokenLifetime(resp))
+			call.token = resp.AccessToken
+		} else {
+			log.Println("failed to refresh ePay token:", err)
+			call.err = err
+		}
+		c.inflight = nil
+		c.mu.Unlock()
+		close(call.done)
+	}()
+	return call
+}
+
+// tokenLifetime parses TokenResponse.ExpiresIn. A token without a usable
+// lifetime is not reused.
+func tokenLifetime(resp *TokenResponse) time.Duration {
+	seconds, err := strconv.Atoi(resp.ExpiresIn)
+	if err != nil || seconds <= 0 {
+		return 0
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// publicKeyRetryInterval is how long a failed refresh of the public key is
+// not retried, so requests do not all wait for ePay while it is down.
+const publicKeyRetryInterval = time.Minute
+
+// publicKeyCache keeps the ePay RSA public key for ttl. A key that can no
+// longer be refreshed keeps being used until a refresh succeeds, which is
+// retried every publicKeyRetryInterval.
+type publicKeyCache struct {
+	fetch func() (*rsa.PublicKey, error)
+	ttl   time.Duration
+
+	// refreshMu serializes downloads; mu guards the fields below and is not
+	// held during a download, so readers never wait for ePay.
+	refreshMu sync.Mutex
+	mu        sync.RWMutex
+	key       *rsa.PublicKey
+	fetchedAt time.Time
+	// retryAt is when a refresh is tried again after one failed, and lastErr
+	// is the error it failed with.
+	retryAt time.Time
+	lastErr error
+}
+
+func newPublicKeyCache(fetch func() (*rsa.PublicKey, error), ttl time.Duration) *publicKeyCache {
+	return &publicKeyCache{fetch: fetch, ttl: ttl}
+}
+
+func (c *publicKeyCache) Get() (*rsa.PublicKey, error) {
+	c.mu.RLock()
+	key, fresh := c.key, c.fresh(time.Now())
+	c.mu.RUnlock()
+	if key != nil && fresh {
+		return key, nil
+	}
+	return c.refresh(false)
+}
+
+// fresh reports whether the cached key can be used without a refresh at now.
+// c.mu must be held.
+func (c *publicKeyCache) fresh(now time.Time) bool {
+	return now.Sub(c.fetchedAt) < c.ttl || now.Before(c.retryAt)
+}
+
+// refresh downloads the key unless another goroutine refreshed it while we
+// waited or a failed refresh is not to be retried yet. force skips these
+// checks.
+func (c *publicKeyCache) refresh(force bool) (*rsa.PublicKey, error) {
+	c.refreshMu.Lock()
+	defer c.refreshMu.Unlock()
+
+	if !force {
+		c.mu.RLock()
+		key, fresh, lastErr := c.key, c.fresh(time.Now()), c.lastErr
+		c.mu.RUnlock()
+		if fresh {
+			if key == nil {
+				return nil, lastErr
+			}
+			return key, nil
+		}
+	}
+
+	key, err := c.fetch()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err != nil {
+		c.retryAt = time.Now().Add(publicKeyRetryInterval)
+		c.lastErr = err
+		if c.key != nil {
+			log.Println("failed to refresh ePay public key, using the cached key:", err)
+			return c.key, nil
+		}
+		return nil, err
+	}
+	c.key = key
+	c.fetchedAt = time.Now()
+	c.retryAt = time.Time{}
+	c.lastErr = nil
+	return key, nil
+}
+
+// startBackgroundRefresh refreshes the key every half TTL so requests
+// normally never wait for the download.
+func (c *publicKeyCache) startBackgroundRefresh() {
+	go func() {
+		ticker := time.NewTicker(c.ttl / 2)
+		defer ticker.Stop()
+		for range ticker.C {
+			c.refresh(true)
+		}
+	}()
+}
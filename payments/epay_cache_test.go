@@ -0,0 +1,162 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestTokenCacheSingleFetch(t *testing.T) {
+	var fetches atomic.Int32
+	release := make(chan struct{})
+	mux := http.NewServeMux()
+	mux.HandleFunc("/oauth2/token", func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		<-release
+		fmt.Fprint(w, `{"access_token":"test-token","expires_in":"3600"}`)
+	})
+	p := testEpayProvider(t, mux)
+
+	const callers = 20
+	tokens := make([]string, callers)
+	errs := make([]error, callers)
+	var wg sync.WaitGroup
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			tokens[i], errs[i] = p.getToken()
+		}(i)
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	for i := range tokens {
+		if errs[i] != nil || tokens[i] != "test-token" {
+			t.Errorf("caller %d got %q, %v", i, tokens[i], errs[i])
+		}
+	}
+	if n := fetches.Load(); n != 1 {
+		t.Errorf("%d token requests, want 1", n)
+	}
+}
+
+func TestTokenCacheRefetch(t *testing.T) {
+	var fetches atomic.Int32
+	mux := http.NewServeMux()
+	mux.HandleFunc("/oauth2/token", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"access_token":"token-%d","expires_in":"3600"}`, fetches.Add(1))
+	})
+	p := testEpayProvider(t, mux)
+
+	token, err := p.getToken()
+	if err != nil || token != "token-1" {
+		t.Fatalf("got %q, %v", token, err)
+	}
+	if token, err := p.getToken(); err != nil || token != "token-1" {
+		t.Fatalf("cached token: got %q, %v", token, err)
+	}
+
+	// Истёкший токен запрашивается заново, и вызывающий ждёт новый
+	p.tokens.mu.Lock()
+	p.tokens.expiresAt = time.Now().Add(-time.Second)
+	p.tokens.mu.Unlock()
+	if token, err := p.getToken(); err != nil || token != "token-2" {
+		t.Fatalf("expired token: got %q, %v", token, err)
+	}
+
+	// Токен в окне обновления ещё выдаётся, пока новый запрашивается в фоне
+	p.tokens.mu.Lock()
+	p.tokens.expiresAt = time.Now().Add(30 * time.Second)
+	p.tokens.mu.Unlock()
+	if token, err := p.getToken(); err != nil || token != "token-2" {
+		t.Fatalf("token in the refresh window: got %q, %v", token, err)
+	}
+	deadline := time.Now().Add(time.Second)
+	for {
+		token, err := p.getToken()
+		if err == nil && token == "token-3" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("token was not refreshed in the background: got %q, %v", token, err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := fetches.Load(); n != 3 {
+		t.Errorf("%d token requests, want 3", n)
+	}
+}
+
+func TestPublicKeyCacheBackoff(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var fetches atomic.Int32
+	var failing atomic.Bool
+	failing.Store(true)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/public.rsa", func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		if failing.Load() {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		pem.Encode(w, &pem.Block{Type: "PUBLIC KEY", Bytes: der})
+	})
+	p := testEpayProvider(t, mux)
+
+	if _, err := p.getRSAPublicKey(); err == nil {
+		t.Fatal("got a key from a failing server")
+	}
+	for i := 0; i < 5; i++ {
+		if _, err := p.getRSAPublicKey(); err == nil {
+			t.Fatal("got a key from a failing server")
+		}
+	}
+	if n := fetches.Load(); n != 1 {
+		t.Fatalf("%d key requests within the retry interval, want 1", n)
+	}
+
+	// После интервала повтора ключ запрашивается снова
+	failing.Store(false)
+	p.publicKeys.mu.Lock()
+	p.publicKeys.retryAt = time.Now().Add(-time.Second)
+	p.publicKeys.mu.Unlock()
+	got, err := p.getRSAPublicKey()
+	if err != nil || !got.Equal(&key.PublicKey) {
+		t.Fatalf("after the retry interval: got %v, %v", got, err)
+	}
+	if n := fetches.Load(); n != 2 {
+		t.Fatalf("%d key requests, want 2", n)
+	}
+
+	// Устаревший ключ используется дальше, пока обновление не удастся
+	failing.Store(true)
+	p.publicKeys.mu.Lock()
+	p.publicKeys.fetchedAt = time.Now().Add(-2 * time.Hour)
+	p.publicKeys.mu.Unlock()
+	for i := 0; i < 5; i++ {
+		got, err := p.getRSAPublicKey()
+		if err != nil || !got.Equal(&key.PublicKey) {
+			t.Fatalf("stale key: got %v, %v", got, err)
+		}
+	}
+	if n := fetches.Load(); n != 3 {
+		t.Errorf("%d key requests, want 3", n)
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestEpayStatus(t *testing.T) {
+	tests := []struct {
+		name     string
+		code     int
+		body     string
+		want     string
+		wantErr  error
+		notFound bool
+	}{
+		{
+			name: "captured",
+			code: http.StatusOK,
+			body: `{"resultCode":"100","resultMessage":"SUCCESS","transaction":{"id":"tx-1","invoiceID":"000100001","amount":1000.5,"currency":"KZT","statusName":"CHARGE"}}`,
+			want: ProviderStatusCaptured,
+		},
+		{
+			name:     "not found",
+			code:     http.StatusOK,
+			body:     `{"resultCode":"102","resultMessage":"Transaction not found"}`,
+			wantErr:  ErrTransactionNotFound,
+			notFound: true,
+		},
+		{
+			name:    "server error",
+			code:    http.StatusInternalServerError,
+			body:    `{"resultCode":"102"}`,
+			wantErr: ErrProviderFailed,
+		},
+		{
+			name:    "unauthorized",
+			code:    http.StatusUnauthorized,
+			body:    `invalid token`,
+			wantErr: ErrProviderFailed,
+		},
+		{
+			name:    "other result code",
+			code:    http.StatusOK,
+			body:    `{"resultCode":"101","resultMessage":"Access denied"}`,
+			wantErr: ErrProviderFailed,
+		},
+		{
+			name:    "no result code",
+			code:    http.StatusOK,
+			body:    `{}`,
+			wantErr: ErrProviderFailed,
+		},
+		{
+			name:    "success without a transaction",
+			code:    http.StatusOK,
+			body:    `{"resultCode":"100","resultMessage":"SUCCESS"}`,
+			wantErr: ErrProviderFailed,
+		},
+		{
+			name:    "invalid body",
+			code:    http.StatusOK,
+			body:    `<html>`,
+			wantErr: ErrProviderFailed,
+		},
+	}
+	for _, tt := range tests {
+		mux := http.NewServeMux()
+		mux.HandleFunc("/check-status/payment/transaction/000100001", func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Authorization") != "Bearer test-token" {
+				t.Errorf("%s: Authorization %q", tt.name, r.Header.Get("Authorization"))
+			}
+			w.WriteHeader(tt.code)
+			fmt.Fprint(w, tt.body)
+		})
+		p := testEpayProvider(t, mux)
+
+		result, err := p.Status("000100001")
+		if tt.wantErr != nil {
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("%s: got %v, want %v", tt.name, err, tt.wantErr)
+			}
+			if !tt.notFound && errors.Is(err, ErrTransactionNotFound) {
+				t.Errorf("%s: %v is reported as not found", tt.name, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if result.Status != tt.want || result.Amount != (Money{Amount: 100050, Currency: "KZT"}) {
+			t.Errorf("%s: got %s %v", tt.name, result.Status, result.Amount)
+		}
+	}
+}
+
+// testEpayProvider returns an EpayProvider whose OAuth and API URLs point at
+// a test server running handler. The OAuth endpoint /oauth2/token is served
+// unless handler has its own.
+func testEpayProvider(t *testing.T, handler *http.ServeMux) *EpayProvider {
+	t.Helper()
+	if _, pattern := handler.Handler(httptest.NewRequest("POST", "/oauth2/token", nil)); pattern == "" {
+		handler.HandleFunc("/oauth2/token", func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, `{"access_token":"test-token","expires_in":"3600","token_type":"Bearer"}`)
+		})
+	}
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	p := &EpayProvider{
+		config: EpayConfig{OAuthURL: server.URL + "/oauth2/token", APIURL: server.URL},
+		client: server.Client(),
+	}
+	p.tokens = newTokenCache(p.fetchToken, time.Minute)
+	p.publicKeys = newPublicKeyCache(p.fetchRSAPublicKey, time.Hour)
+	return p
+}
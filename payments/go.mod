@@ -3,6 +3,7 @@ module HL_online_shop
 go 1.21.6
 
 require (
+	HL_online_shop/common v0.0.0
 	github.com/go-playground/validator/v10 v10.22.0
 	github.com/gorilla/mux v1.8.1
 	github.com/joho/godotenv v1.5.1
@@ -40,3 +41,5 @@ require (
 	golang.org/x/tools v0.7.0 // indirect
 	gopkg.in/yaml.v2 v2.4.0 // indirect
 )
+
+replace HL_online_shop/common => ../common
@@ -1,10 +1,16 @@
 package main
 
 import (
+	"HL_online_shop/common/access"
+	"HL_online_shop/common/money"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"github.com/go-playground/validator/v10"
 	"github.com/gorilla/mux"
 	"gorm.io/gorm"
+	"io"
+	"log"
 	"net/http"
 	"strconv"
 	"time"
@@ -30,8 +36,12 @@ func HealthCheck(w http.ResponseWriter, r *http.Request) {
 // @Tags payments
 // @Produce json
 // @Success 200 {array} Payment
+// @Failure 403 {object} access.ErrorResponse "Only admins"
 // @Router /payments [get]
 func GetPayments(w http.ResponseWriter, r *http.Request) {
+	if !access.RequireAdmin(w, r) {
+		return
+	}
 	payments, err := GetAllPaymentsRepo()
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -42,14 +52,41 @@ func GetPayments(w http.ResponseWriter, r *http.Request) {
 
 // CreatePayment godoc
 // @Summary Create a payment
-// @Description Create a new payment using API ePayment.kz
+// @Description Create a new payment using the configured payment provider. The card is given either as card data or as a saved payment method of the user (payment_method_id); with save_card the card is saved once it is accepted. The amount must match the order total. The order is moved to awaiting_payment before the card is charged and to paid after a successful payment.
 // @Tags payments
 // @Accept json
 // @Produce json
 // @Param payment body PaymentRequest true "Create payment"
+// @Param Idempotency-Key header string false "Unique key that makes the request safe to retry; a retry returns the original response instead of charging the card again"
 // @Success 201 {object} Payment
+// @Failure 409 {string} string "Order cannot be paid"
+// @Failure 422 {string} string "Amount does not match the order or Idempotency-Key reused for a different request"
+// @Failure 403 {object} access.ErrorResponse "The payment is for another user"
 // @Router /payments [post]
 func CreatePayment(w http.ResponseWriter, r *http.Request) {
+	createPayment(w, r, true)
+}
+
+// AuthorizePayment godoc
+// @Summary Authorize a payment
+// @Description Reserve the order total on the card without charging it. The order is moved to paid once the amount is authorized. The payment has to be captured with /payments/{id}/capture before expires_at, otherwise it is voided automatically.
+// @Tags payments
+// @Accept json
+// @Produce json
+// @Param payment body PaymentRequest true "Authorize payment"
+// @Param Idempotency-Key header string false "Unique key that makes the request safe to retry; a retry returns the original response instead of authorizing the card again"
+// @Success 200 {object} Payment
+// @Failure 409 {string} string "Order cannot be paid"
+// @Failure 422 {string} string "Amount does not match the order or Idempotency-Key reused for a different request"
+// @Failure 403 {object} access.ErrorResponse "The payment is for another user"
+// @Router /payments/authorize [post]
+func AuthorizePayment(w http.ResponseWriter, r *http.Request) {
+	createPayment(w, r, false)
+}
+
+// createPayment charges the order of a PaymentRequest, or only authorizes the
+// amount if capture is false.
+func createPayment(w http.ResponseWriter, r *http.Request, capture bool) {
 	var paymentRequest PaymentRequest
 	if err := json.NewDecoder(r.Body).Decode(&paymentRequest); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
@@ -61,60 +98,306 @@ func CreatePayment(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	if !access.RequireOwner(w, r, uint(paymentRequest.UserID)) {
+		return
+	}
 
-	// Получение токена
-	token, err := getToken()
+	// Сумма платежа должна совпадать с суммой заказа
+	order, err := getOrder(paymentRequest.OrderID)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeServiceError(w, err)
+		return
+	}
+	if int(order.UserID) != paymentRequest.UserID {
+		http.Error(w, "Order does not belong to the user", http.StatusUnprocessableEntity)
+		return
+	}
+	total := order.TotalPrice.WithDefaultCurrency()
+	if !config.AcceptsCurrency(total.Currency) {
+		http.Error(w, fmt.Sprintf("Payments in %s are not accepted", total.Currency), http.StatusUnprocessableEntity)
+		return
+	}
+	if paymentRequest.Amount.Currency == "" {
+		paymentRequest.Amount.Currency = total.Currency
+	}
+	if paymentRequest.Amount != total {
+		http.Error(w, fmt.Sprintf("Amount %s does not match order total %s", paymentRequest.Amount, total), http.StatusUnprocessableEntity)
 		return
 	}
 
-	publicKey, err := getRSAPublicKey()
+	user, err := getUser(paymentRequest.UserID)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeServiceError(w, err)
 		return
 	}
 
-	cardData := CardData{
-		HPAN:       paymentRequest.HPAN,
-		ExpDate:    paymentRequest.ExpDate,
-		CVC:        paymentRequest.CVC,
-		TerminalID: paymentRequest.TerminalID,
+	var method *PaymentMethod
+	if paymentRequest.PaymentMethodID != 0 {
+		method, err = GetPaymentMethodRepo(paymentRequest.UserID, paymentRequest.PaymentMethodID)
+		if err != nil {
+			if err == gorm.ErrRecordNotFound {
+				http.Error(w, "Payment method not found", http.StatusUnprocessableEntity)
+			} else {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+			}
+			return
+		}
 	}
 
-	cryptogram, err := createCryptogram(cardData, publicKey)
+	if err := transitionOrder(paymentRequest.OrderID, "awaiting_payment", "Payment started"); err != nil {
+		writeServiceError(w, err)
+		return
+	}
 
-	// Выполнение платежа
-	paymentResponse, err := makePayment(token, cryptogram)
+	invoiceID, err := NextInvoiceIDRepo()
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	// Сохранение информации о платеже в базу данных
+	// Платёж сохраняется до обращения к провайдеру, чтобы попытка не потерялась
 	payment := &Payment{
-		Amount:      paymentRequest.Amount,
-		OrderID:     paymentRequest.OrderID,
-		Status:      paymentResponse.Status,
-		UserID:      paymentRequest.UserID,
-		PaymentDate: time.Now(),
+		Amount:        total,
+		AuthorizeOnly: !capture,
+		OrderID:       paymentRequest.OrderID,
+		Status:        "pending",
+		UserID:        paymentRequest.UserID,
+		PaymentDate:   time.Now(),
+		InvoiceID:     invoiceID,
+		CardMask:      maskPAN(string(paymentRequest.HPAN)),
+		CardBrand:     cardBrand(string(paymentRequest.HPAN)),
+	}
+	if method != nil {
+		payment.CardMask = method.CardMask
+		payment.CardBrand = method.CardBrand
+		payment.CardID = method.CardID
+	}
+	if err := CreatePaymentRepo(payment); err != nil {
+		http.Error(w, "Failed to save payment", http.StatusInternalServerError)
+		return
 	}
-	if payment.Status == "" {
-		payment.Status = "failure"
 
+	cardData := CardData{
+		HPAN:    paymentRequest.HPAN,
+		ExpDate: paymentRequest.ExpDate,
+		CVC:     paymentRequest.CVC,
 	}
 
-	if err := CreatePaymentRepo(payment); err != nil {
+	// Выполнение платежа
+	result, err := provider.Authorize(ChargeRequest{
+		OrderID:     paymentRequest.OrderID,
+		UserID:      paymentRequest.UserID,
+		InvoiceID:   invoiceID,
+		Amount:      total,
+		Description: fmt.Sprintf("Order #%d", order.ID),
+		Customer: Customer{
+			Name:  user.Name,
+			Email: user.Email,
+			Phone: user.Phone,
+		},
+		Card:     cardData,
+		CardID:   payment.CardID,
+		SaveCard: paymentRequest.SaveCard,
+		Capture:  capture,
+	})
+	if err != nil {
+		// При таймауте результат неизвестен, платёж остаётся в статусе pending
+		if !errors.Is(err, ErrProviderTimeout) {
+			payment.Status = "unsuccessful"
+			if _, err := SetPaymentResultRepo(payment); err != nil {
+				log.Printf("failed to save payment %d: %v", payment.ID, err)
+			}
+		}
+		writeProviderError(w, err)
+		return
+	}
+
+	// Сохранение информации о платеже в базу данных
+	payment, err = applyProviderResult(payment, result)
+	if err != nil {
 		http.Error(w, "Failed to save payment", http.StatusInternalServerError)
 		return
 	}
 
+	if paymentRequest.SaveCard && method == nil && cardAccepted(payment) {
+		if _, err := savePaymentMethod(payment); err != nil {
+			log.Printf("failed to save card of payment %d: %v", payment.ID, err)
+		}
+	}
+
 	// Возврат успешного ответа
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(payment)
 }
 
+// applyProviderResult records the provider outcome of a payment. The order is
+// moved to paid only by the update that makes the payment successful, so a
+// result reported twice (in the response and in a callback) pays it once.
+func applyProviderResult(payment *Payment, result *ProviderResult) (*Payment, error) {
+	if result.TransactionID != "" {
+		payment.TransactionID = result.TransactionID
+	}
+	if result.CardID != "" {
+		payment.CardID = result.CardID
+	}
+	payment.Status = paymentStatus(result.Status)
+	payment.ActionURL = ""
+	switch payment.Status {
+	case "requires_action":
+		payment.ActionURL = result.Secure3D
+	case "successful":
+		payment.CapturedAmount = payment.Amount
+	case "authorized":
+		expiresAt := time.Now().Add(time.Duration(config.AuthorizationTTL))
+		payment.ExpiresAt = &expiresAt
+	}
+
+	updated, err := SetPaymentResultRepo(payment)
+	if err != nil {
+		return nil, err
+	}
+	if !updated {
+		return GetPaymentByIDRepo(uint(payment.ID))
+	}
+
+	// Оплаченный заказ переводится в статус paid, что списывает зарезервированный товар.
+	// Авторизованная сумма уже гарантирована, поэтому заказ можно отгружать до списания.
+	if payment.Status == "successful" {
+		recordCharge(payment, result.Fee)
+	}
+	if payment.Status == "successful" || payment.Status == "authorized" {
+		reason := fmt.Sprintf("Payment %d succeeded", payment.ID)
+		if err := transitionOrder(payment.OrderID, "paid", reason); err != nil {
+			log.Printf("failed to mark order %d as paid: %v", payment.OrderID, err)
+		}
+	}
+	return payment, nil
+}
+
+// paymentStatus maps a provider status to the status of a Payment.
+func paymentStatus(providerStatus string) string {
+	switch providerStatus {
+	case ProviderStatusCaptured:
+		return "successful"
+	case ProviderStatusAuthorized:
+		return "authorized"
+	case ProviderStatusRequiresAction:
+		return "requires_action"
+	case ProviderStatusPending:
+		return "pending"
+	}
+	return "unsuccessful"
+}
+
+// EpayPostLink godoc
+// @Summary ePay payment callback
+// @Description Receives the payment result ePay posts to postLink. The callback must carry the secret_hash issued with the payment. A successful result is confirmed with ePay before the payment and its order are marked as paid. Repeated callbacks are safe.
+// @Tags callbacks
+// @Accept json
+// @Produce json
+// @Param callback body EpayCallback true "Payment result"
+// @Success 200 {object} Payment
+// @Failure 401 {string} string "Invalid callback signature"
+// @Failure 404 {string} string "Payment not found"
+// @Router /payments/callbacks/epay [post]
+func EpayPostLink(w http.ResponseWriter, r *http.Request) {
+	handleEpayCallback(w, r, false)
+}
+
+// EpayFailurePostLink godoc
+// @Summary ePay failed payment callback
+// @Description Receives the result ePay posts to failurePostLink and marks the payment as unsuccessful. A payment that has already succeeded is not changed.
+// @Tags callbacks
+// @Accept json
+// @Produce json
+// @Param callback body EpayCallback true "Payment result"
+// @Success 200 {object} Payment
+// @Failure 401 {string} string "Invalid callback signature"
+// @Failure 404 {string} string "Payment not found"
+// @Router /payments/callbacks/epay/fail [post]
+func EpayFailurePostLink(w http.ResponseWriter, r *http.Request) {
+	handleEpayCallback(w, r, true)
+}
+
+func handleEpayCallback(w http.ResponseWriter, r *http.Request, failed bool) {
+	var callback EpayCallback
+	if err := json.NewDecoder(r.Body).Decode(&callback); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if !verifyCallbackSignature(config.Epay.CallbackSecret, callback.InvoiceID, callback.SecretHash) {
+		log.Printf("rejected ePay callback for invoice %q: invalid signature", callback.InvoiceID)
+		http.Error(w, "Invalid callback signature", http.StatusUnauthorized)
+		return
+	}
+
+	payment, err := GetPaymentByInvoiceIDRepo(callback.InvoiceID)
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			http.Error(w, "Payment not found", http.StatusNotFound)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	result := &ProviderResult{
+		TransactionID: callback.ID,
+		InvoiceID:     callback.InvoiceID,
+		Status:        ProviderStatusDeclined,
+		Amount:        money.FromFloat(callback.Amount, payment.Amount.Currency),
+		Message:       callback.Reason,
+	}
+	if !failed && callback.Code == "ok" {
+		// Успешный результат подтверждается у провайдера, прежде чем заказ будет считаться оплаченным
+		result, err = provider.Status(callback.InvoiceID)
+		if err != nil {
+			writeProviderError(w, err)
+			return
+		}
+		if result.Status == ProviderStatusCaptured && result.Amount != payment.Amount {
+			log.Printf("ePay callback for invoice %s: captured %s, expected %s", callback.InvoiceID, result.Amount, payment.Amount)
+			http.Error(w, "Captured amount does not match the payment", http.StatusUnprocessableEntity)
+			return
+		}
+	}
+
+	payment, err = applyProviderResult(payment, result)
+	if err != nil {
+		http.Error(w, "Failed to save payment", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(payment)
+}
+
+// writeServiceError maps errors from the orders and users services to a response.
+func writeServiceError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ErrNotFound):
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+	case errors.Is(err, ErrOrderNotPayable):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		http.Error(w, err.Error(), http.StatusBadGateway)
+	}
+}
+
+// writeProviderError maps errors from the payment provider to a response with
+// card numbers masked.
+func writeProviderError(w http.ResponseWriter, err error) {
+	// Ответ провайдера может содержать данные карты
+	msg := redactPANs(err.Error())
+	if errors.Is(err, ErrProviderTimeout) {
+		http.Error(w, msg, http.StatusGatewayTimeout)
+		return
+	}
+	http.Error(w, msg, http.StatusBadGateway)
+}
+
 // GetPayment godoc
 // @Summary Get a payment by ID
 // @Description Get a payment by ID
@@ -122,6 +405,7 @@ func CreatePayment(w http.ResponseWriter, r *http.Request) {
 // @Produce json
 // @Param id path int true "Payment ID"
 // @Success 200 {object} Payment
+// @Failure 403 {object} access.ErrorResponse "The payment belongs to another user"
 // @Router /payments/{id} [get]
 func GetPayment(w http.ResponseWriter, r *http.Request) {
 	params := mux.Vars(r)
@@ -140,9 +424,722 @@ func GetPayment(w http.ResponseWriter, r *http.Request) {
 		}
 		return
 	}
+	if !access.RequireOwner(w, r, uint(payment.UserID)) {
+		return
+	}
+	json.NewEncoder(w).Encode(payment)
+}
+
+// GetReconciliation godoc
+// @Summary Get reconciliation discrepancies
+// @Description Get the differences between payments and the provider found by reconciliation, newest first. Discrepancies reconciliation fixed on its own are resolved.
+// @Tags reconciliation
+// @Produce json
+// @Param resolved query bool false "Only resolved or only open discrepancies"
+// @Success 200 {array} PaymentDiscrepancy
+// @Failure 403 {object} access.ErrorResponse "Only admins"
+// @Router /payments/reconciliation [get]
+func GetReconciliation(w http.ResponseWriter, r *http.Request) {
+	if !access.RequireAdmin(w, r) {
+		return
+	}
+	var resolved *bool
+	if value := r.URL.Query().Get("resolved"); value != "" {
+		parsed, err := strconv.ParseBool(value)
+		if err != nil {
+			http.Error(w, "Invalid resolved value", http.StatusBadRequest)
+			return
+		}
+		resolved = &parsed
+	}
+
+	discrepancies, err := GetDiscrepanciesRepo(resolved)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(discrepancies)
+}
+
+// GetPaymentMethods godoc
+// @Summary Get the saved cards of a user
+// @Description Get the payment methods a user can pay with instead of entering card data
+// @Tags payment-methods
+// @Produce json
+// @Param id path int true "User ID"
+// @Success 200 {array} PaymentMethod
+// @Failure 403 {object} access.ErrorResponse "Another user's cards"
+// @Router /users/{id}/payment-methods [get]
+func GetPaymentMethods(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	userID, err := strconv.Atoi(params["id"])
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+	if !access.RequireOwner(w, r, uint(userID)) {
+		return
+	}
+
+	methods, err := GetPaymentMethodsRepo(userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(methods)
+}
+
+// CreatePaymentMethod godoc
+// @Summary Save a card of a user
+// @Description Save the card used for an accepted payment of the user as a payment method. Only the provider's card token and the masked card number are stored. Cards can also be saved while paying with save_card.
+// @Tags payment-methods
+// @Accept json
+// @Produce json
+// @Param id path int true "User ID"
+// @Param method body SavePaymentMethodRequest true "Payment to save the card of"
+// @Success 201 {object} PaymentMethod
+// @Failure 422 {string} string "Payment not found or has no saved card"
+// @Failure 403 {object} access.ErrorResponse "Another user's cards"
+// @Router /users/{id}/payment-methods [post]
+func CreatePaymentMethod(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	userID, err := strconv.Atoi(params["id"])
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+	if !access.RequireOwner(w, r, uint(userID)) {
+		return
+	}
+
+	var request SavePaymentMethodRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := validate.Struct(request); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	payment, err := GetPaymentByIDRepo(uint(request.PaymentID))
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			http.Error(w, "Payment not found", http.StatusUnprocessableEntity)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	if payment.UserID != userID {
+		http.Error(w, "Payment does not belong to the user", http.StatusUnprocessableEntity)
+		return
+	}
+	if !cardAccepted(payment) {
+		http.Error(w, "Payment has no card that can be saved", http.StatusUnprocessableEntity)
+		return
+	}
+
+	method, err := savePaymentMethod(payment)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(method)
+}
+
+// DeletePaymentMethod godoc
+// @Summary Delete a saved card
+// @Description Delete a payment method of a user
+// @Tags payment-methods
+// @Produce plain
+// @Param id path int true "User ID"
+// @Param method_id path int true "Payment method ID"
+// @Success 200 {string} string "Deleted"
+// @Failure 404 {string} string "Payment method not found"
+// @Failure 403 {object} access.ErrorResponse "Another user's cards"
+// @Router /users/{id}/payment-methods/{method_id} [delete]
+func DeletePaymentMethod(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	userID, err := strconv.Atoi(params["id"])
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+	if !access.RequireOwner(w, r, uint(userID)) {
+		return
+	}
+	methodID, err := strconv.Atoi(params["method_id"])
+	if err != nil {
+		http.Error(w, "Invalid payment method ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := DeletePaymentMethodRepo(userID, methodID); err != nil {
+		if err == gorm.ErrRecordNotFound {
+			http.Error(w, "Payment method not found", http.StatusNotFound)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode("Deleted")
+}
+
+// cardAccepted reports whether the provider accepted the card of a payment
+// and returned a token for it.
+func cardAccepted(payment *Payment) bool {
+	if payment.CardID == "" {
+		return false
+	}
+	switch payment.Status {
+	case "authorized", "successful", "partially_refunded", "refunded":
+		return true
+	}
+	return false
+}
+
+func savePaymentMethod(payment *Payment) (*PaymentMethod, error) {
+	method := &PaymentMethod{
+		UserID:    payment.UserID,
+		CardID:    payment.CardID,
+		CardMask:  payment.CardMask,
+		CardBrand: payment.CardBrand,
+	}
+	if err := SavePaymentMethodRepo(method); err != nil {
+		return nil, err
+	}
+	return method, nil
+}
+
+// GetPaymentAction godoc
+// @Summary Get the pending action of a payment
+// @Description Get the 3-D Secure challenge the customer has to pass for a payment in status requires_action. After the challenge the payment is finished with /payments/{id}/complete.
+// @Tags payments
+// @Produce json
+// @Param id path int true "Payment ID"
+// @Success 200 {object} PaymentAction
+// @Failure 404 {string} string "Payment not found"
+// @Failure 409 {string} string "Payment does not require action"
+// @Failure 403 {object} access.ErrorResponse "The payment belongs to another user"
+// @Router /payments/{id}/action [get]
+func GetPaymentAction(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	id, err := strconv.Atoi(params["id"])
+	if err != nil {
+		http.Error(w, "Invalid payment ID", http.StatusBadRequest)
+		return
+	}
+
+	payment, err := GetPaymentByIDRepo(uint(id))
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			http.Error(w, "Payment not found", http.StatusNotFound)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	if !access.RequireOwner(w, r, uint(payment.UserID)) {
+		return
+	}
+	if payment.Status != "requires_action" {
+		http.Error(w, fmt.Sprintf("Payment in status %s does not require action", payment.Status), http.StatusConflict)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(PaymentAction{PaymentID: payment.ID, Type: "redirect_3ds", URL: payment.ActionURL})
+}
+
+// CompletePayment godoc
+// @Summary Complete a payment after 3-D Secure
+// @Description Finish a payment in status requires_action once the customer has passed the 3-D Secure challenge. The payment is charged or authorized as originally requested and the order is moved to paid; a failed challenge makes the payment unsuccessful.
+// @Tags payments
+// @Accept json
+// @Produce json
+// @Param id path int true "Payment ID"
+// @Param completion body ActionCompletion false "Challenge result"
+// @Success 200 {object} Payment
+// @Failure 404 {string} string "Payment not found"
+// @Failure 409 {string} string "Payment does not require action"
+// @Failure 403 {object} access.ErrorResponse "The payment belongs to another user"
+// @Router /payments/{id}/complete [post]
+func CompletePayment(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	id, err := strconv.Atoi(params["id"])
+	if err != nil {
+		http.Error(w, "Invalid payment ID", http.StatusBadRequest)
+		return
+	}
+
+	var completion ActionCompletion
+	if err := json.NewDecoder(r.Body).Decode(&completion); err != nil && err != io.EOF {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	payment, err := GetPaymentByIDRepo(uint(id))
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			http.Error(w, "Payment not found", http.StatusNotFound)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	if !access.RequireOwner(w, r, uint(payment.UserID)) {
+		return
+	}
+	if payment.Status != "requires_action" {
+		http.Error(w, fmt.Sprintf("Payment in status %s does not require action", payment.Status), http.StatusConflict)
+		return
+	}
+
+	result, err := provider.CompleteAction(ActionRequest{
+		TransactionID: payment.TransactionID,
+		InvoiceID:     payment.InvoiceID,
+		Amount:        payment.Amount,
+		Data:          completion.Data,
+		Capture:       !payment.AuthorizeOnly,
+	})
+	if err != nil {
+		writeProviderError(w, err)
+		return
+	}
+
+	payment, err = applyProviderResult(payment, result)
+	if err != nil {
+		http.Error(w, "Failed to save payment", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(payment)
 }
 
+// CapturePayment godoc
+// @Summary Capture an authorized payment
+// @Description Charge an authorized payment. Without an amount the whole authorized amount is captured; a smaller amount captures only that part and releases the rest.
+// @Tags payments
+// @Accept json
+// @Produce json
+// @Param id path int true "Payment ID"
+// @Param capture body CaptureRequest false "Capture"
+// @Success 200 {object} Payment
+// @Failure 404 {string} string "Payment not found"
+// @Failure 409 {string} string "Payment is not authorized or the authorization has expired"
+// @Failure 422 {string} string "Capture exceeds the authorized amount"
+// @Failure 403 {object} access.ErrorResponse "Only admins"
+// @Router /payments/{id}/capture [post]
+func CapturePayment(w http.ResponseWriter, r *http.Request) {
+	if !access.RequireAdmin(w, r) {
+		return
+	}
+	params := mux.Vars(r)
+	id, err := strconv.Atoi(params["id"])
+	if err != nil {
+		http.Error(w, "Invalid payment ID", http.StatusBadRequest)
+		return
+	}
+
+	var captureRequest CaptureRequest
+	if err := json.NewDecoder(r.Body).Decode(&captureRequest); err != nil && err != io.EOF {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := validate.Struct(captureRequest); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var captured *ProviderResult
+	payment, err := UpdateAuthorizationRepo(id, func(payment *Payment) error {
+		if payment.ExpiresAt != nil && time.Now().After(*payment.ExpiresAt) {
+			return ErrAuthorizationExpired
+		}
+		amount := captureRequest.Amount
+		if amount.Amount == 0 {
+			amount = payment.Amount
+		}
+		if amount.Currency == "" {
+			amount.Currency = payment.Amount.Currency
+		}
+		if !amount.SameCurrency(payment.Amount) {
+			return fmt.Errorf("%w: requested %s, authorized %s", ErrCurrencyMismatch, amount.Currency, payment.Amount.Currency)
+		}
+		if amount.Amount > payment.Amount.Amount {
+			return fmt.Errorf("%w: requested %s, authorized %s", ErrCaptureTooLarge, amount, payment.Amount)
+		}
+
+		result, err := provider.Capture(payment.TransactionID, amount)
+		if err != nil {
+			return fmt.Errorf("%w: %w", ErrProviderFailed, err)
+		}
+		captured = result
+		payment.Status = "successful"
+		payment.CapturedAmount = amount
+		payment.ExpiresAt = nil
+		return nil
+	})
+	if err != nil {
+		writeAuthorizationError(w, err)
+		return
+	}
+	recordCharge(payment, captured.Fee)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(payment)
+}
+
+// VoidPayment godoc
+// @Summary Void an authorized payment
+// @Description Release the amount held on the card without charging it. The order is moved to refunded.
+// @Tags payments
+// @Produce json
+// @Param id path int true "Payment ID"
+// @Success 200 {object} Payment
+// @Failure 404 {string} string "Payment not found"
+// @Failure 409 {string} string "Payment is not authorized"
+// @Failure 403 {object} access.ErrorResponse "Only admins"
+// @Router /payments/{id}/void [post]
+func VoidPayment(w http.ResponseWriter, r *http.Request) {
+	if !access.RequireAdmin(w, r) {
+		return
+	}
+	params := mux.Vars(r)
+	id, err := strconv.Atoi(params["id"])
+	if err != nil {
+		http.Error(w, "Invalid payment ID", http.StatusBadRequest)
+		return
+	}
+
+	payment, err := UpdateAuthorizationRepo(id, func(payment *Payment) error {
+		if _, err := provider.Void(payment.TransactionID); err != nil {
+			return fmt.Errorf("%w: %w", ErrProviderFailed, err)
+		}
+		payment.Status = "voided"
+		return nil
+	})
+	if err != nil {
+		writeAuthorizationError(w, err)
+		return
+	}
+
+	reason := fmt.Sprintf("Authorization of payment %d voided", payment.ID)
+	if err := transitionOrder(payment.OrderID, "refunded", reason); err != nil {
+		log.Printf("failed to mark order %d as refunded: %v", payment.OrderID, err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(payment)
+}
+
+// expireAuthorization voids an authorization that was not captured in time.
+// The hold is released by the bank anyway, so a failed void is only logged.
+func expireAuthorization(id int) error {
+	payment, err := UpdateAuthorizationRepo(id, func(payment *Payment) error {
+		if _, err := provider.Void(payment.TransactionID); err != nil {
+			log.Printf("failed to void expired payment %d: %v", payment.ID, err)
+		}
+		payment.Status = "expired"
+		return nil
+	})
+	if errors.Is(err, ErrPaymentNotAuthorized) {
+		// Платёж успели списать или отменить
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	reason := fmt.Sprintf("Authorization of payment %d expired", payment.ID)
+	if err := transitionOrder(payment.OrderID, "refunded", reason); err != nil {
+		log.Printf("failed to mark order %d as refunded: %v", payment.OrderID, err)
+	}
+	return nil
+}
+
+// writeAuthorizationError maps errors from capturing or voiding a payment to
+// a response.
+func writeAuthorizationError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		http.Error(w, "Payment not found", http.StatusNotFound)
+	case errors.Is(err, ErrPaymentNotAuthorized), errors.Is(err, ErrAuthorizationExpired):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, ErrCaptureTooLarge), errors.Is(err, ErrCurrencyMismatch):
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+	case errors.Is(err, ErrProviderFailed):
+		writeProviderError(w, err)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// CreateRefund godoc
+// @Summary Refund a payment
+// @Description Refund a payment fully or partially through the payment provider. Without an amount everything not refunded yet is returned. Refunds together can never exceed the captured amount. The order is moved to refunded once the payment is fully refunded.
+// @Tags refunds
+// @Accept json
+// @Produce json
+// @Param id path int true "Payment ID"
+// @Param refund body RefundRequest true "Refund"
+// @Success 201 {object} Refund
+// @Failure 404 {string} string "Payment not found"
+// @Failure 409 {string} string "Payment cannot be refunded"
+// @Failure 422 {string} string "Refund exceeds the amount left to refund"
+// @Failure 502 {object} Refund "Refund declined by the provider"
+// @Failure 403 {object} access.ErrorResponse "Only admins"
+// @Router /payments/{id}/refunds [post]
+func CreateRefund(w http.ResponseWriter, r *http.Request) {
+	if !access.RequireAdmin(w, r) {
+		return
+	}
+	params := mux.Vars(r)
+	id, err := strconv.Atoi(params["id"])
+	if err != nil {
+		http.Error(w, "Invalid payment ID", http.StatusBadRequest)
+		return
+	}
+
+	var refundRequest RefundRequest
+	if err := json.NewDecoder(r.Body).Decode(&refundRequest); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := validate.Struct(refundRequest); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Возврат сначала сохраняется в статусе pending, чтобы сумма была зарезервирована
+	payment, refund, err := CreateRefundRepo(id, refundRequest.Amount, refundRequest.Reason)
+	if err != nil {
+		writeRefundError(w, err)
+		return
+	}
+
+	result, err := provider.Refund(payment.TransactionID, refund.Amount)
+	if err != nil {
+		// При таймауте результат неизвестен, возврат остаётся в статусе pending
+		if errors.Is(err, ErrProviderTimeout) {
+			writeProviderError(w, err)
+			return
+		}
+		refund.Status = "unsuccessful"
+		refund.Message = redactPANs(err.Error())
+	} else {
+		refund.Status = "successful"
+		refund.TransactionID = result.TransactionID
+	}
+
+	if _, err := completeRefund(refund); err != nil {
+		http.Error(w, "Failed to save refund", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if refund.Status == "successful" {
+		w.WriteHeader(http.StatusCreated)
+	} else {
+		w.WriteHeader(http.StatusBadGateway)
+	}
+	json.NewEncoder(w).Encode(refund)
+}
+
+// completeRefund saves the outcome of a refund. A successful refund is posted
+// to the ledger, and the order is moved to refunded once the payment is fully
+// refunded.
+func completeRefund(refund *Refund) (*Payment, error) {
+	payment, err := CompleteRefundRepo(refund)
+	if err != nil {
+		return nil, err
+	}
+	if refund.Status != "successful" {
+		return payment, nil
+	}
+	recordLedger(refundEntry(refund))
+	if payment.Status == "refunded" {
+		reason := fmt.Sprintf("Payment %d refunded", payment.ID)
+		if err := transitionOrder(payment.OrderID, "refunded", reason); err != nil {
+			log.Printf("failed to mark order %d as refunded: %v", payment.OrderID, err)
+		}
+	}
+	return payment, nil
+}
+
+// GetRefunds godoc
+// @Summary Get the refunds of a payment
+// @Description Get all refunds of a payment, oldest first
+// @Tags refunds
+// @Produce json
+// @Param id path int true "Payment ID"
+// @Success 200 {array} Refund
+// @Failure 403 {object} access.ErrorResponse "The payment belongs to another user"
+// @Router /payments/{id}/refunds [get]
+func GetRefunds(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	id, err := strconv.Atoi(params["id"])
+	if err != nil {
+		http.Error(w, "Invalid payment ID", http.StatusBadRequest)
+		return
+	}
+	if !requirePaymentOwner(w, r, uint(id)) {
+		return
+	}
+
+	refunds, err := GetRefundsRepo(id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(refunds)
+}
+
+// writeRefundError maps errors from CreateRefundRepo to a response.
+func writeRefundError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		http.Error(w, "Payment not found", http.StatusNotFound)
+	case errors.Is(err, ErrPaymentNotRefundable):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, ErrRefundTooLarge), errors.Is(err, ErrCurrencyMismatch):
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// CreateChargeback godoc
+// @Summary Record a chargeback
+// @Description Record a chargeback reported by the provider in the ledger. A chargeback is recorded once per reference; repeating it returns the entry already posted. Chargebacks together can never exceed the captured amount minus refunds.
+// @Tags ledger
+// @Accept json
+// @Produce json
+// @Param id path int true "Payment ID"
+// @Param chargeback body ChargebackRequest true "Chargeback"
+// @Success 201 {object} LedgerEntry
+// @Success 200 {object} LedgerEntry "Chargeback already recorded"
+// @Failure 404 {string} string "Payment not found"
+// @Failure 409 {string} string "Payment has no captured amount"
+// @Failure 422 {string} string "Chargeback exceeds the amount left after refunds and chargebacks"
+// @Failure 403 {object} access.ErrorResponse "Only admins"
+// @Router /payments/{id}/chargebacks [post]
+func CreateChargeback(w http.ResponseWriter, r *http.Request) {
+	if !access.RequireAdmin(w, r) {
+		return
+	}
+	params := mux.Vars(r)
+	id, err := strconv.Atoi(params["id"])
+	if err != nil {
+		http.Error(w, "Invalid payment ID", http.StatusBadRequest)
+		return
+	}
+
+	var chargeback ChargebackRequest
+	if err := json.NewDecoder(r.Body).Decode(&chargeback); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := validate.Struct(chargeback); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	entry, posted, err := PostChargebackRepo(id, chargeback)
+	if err != nil {
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			http.Error(w, "Payment not found", http.StatusNotFound)
+		case errors.Is(err, ErrNothingCaptured):
+			http.Error(w, err.Error(), http.StatusConflict)
+		case errors.Is(err, ErrChargebackTooLarge), errors.Is(err, ErrCurrencyMismatch):
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		default:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if !posted {
+		json.NewEncoder(w).Encode(entry)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(entry)
+}
+
+// GetLedgerBalances godoc
+// @Summary Get account balances
+// @Description Get the debits, credits and balance of every ledger account per currency in minor units. Balance is debits minus credits, so the balances of each currency sum to zero.
+// @Tags ledger
+// @Produce json
+// @Param account query string false "Account, e.g. assets:provider_clearing"
+// @Param currency query string false "Currency code"
+// @Success 200 {array} AccountBalance
+// @Failure 403 {object} access.ErrorResponse "Only admins"
+// @Router /ledger/balances [get]
+func GetLedgerBalances(w http.ResponseWriter, r *http.Request) {
+	if !access.RequireAdmin(w, r) {
+		return
+	}
+	query := r.URL.Query()
+	balances, err := GetAccountBalancesRepo(query.Get("account"), query.Get("currency"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(balances)
+}
+
+// GetLedgerJournal godoc
+// @Summary Get the journal
+// @Description Get ledger entries with their lines, newest first
+// @Tags ledger
+// @Produce json
+// @Param payment_id query int false "Payment ID"
+// @Param account query string false "Only entries that touch this account"
+// @Param limit query int false "Maximum number of entries" default(100)
+// @Success 200 {array} LedgerEntry
+// @Failure 403 {object} access.ErrorResponse "Only admins"
+// @Router /ledger/journal [get]
+func GetLedgerJournal(w http.ResponseWriter, r *http.Request) {
+	if !access.RequireAdmin(w, r) {
+		return
+	}
+	query := r.URL.Query()
+	paymentID := 0
+	if value := query.Get("payment_id"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil {
+			http.Error(w, "Invalid payment ID", http.StatusBadRequest)
+			return
+		}
+		paymentID = parsed
+	}
+	limit := 100
+	if value := query.Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 || parsed > 1000 {
+			http.Error(w, "Invalid limit, must be between 1 and 1000", http.StatusBadRequest)
+			return
+		}
+		limit = parsed
+	}
+
+	entries, err := GetJournalRepo(paymentID, query.Get("account"), limit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(entries)
+}
+
 // UpdatePayment godoc
 // @Summary Update a payment by ID
 // @Description Update a payment by ID
@@ -152,8 +1149,12 @@ func GetPayment(w http.ResponseWriter, r *http.Request) {
 // @Param id path int true "Payment ID"
 // @Param payment body Payment true "Update payment"
 // @Success 200 {object} Payment
+// @Failure 403 {object} access.ErrorResponse "Only admins"
 // @Router /payments/{id} [put]
 func UpdatePayment(w http.ResponseWriter, r *http.Request) {
+	if !access.RequireAdmin(w, r) {
+		return
+	}
 	params := mux.Vars(r)
 	id, err := strconv.Atoi(params["id"])
 	if err != nil {
@@ -186,8 +1187,12 @@ func UpdatePayment(w http.ResponseWriter, r *http.Request) {
 // @Produce plain
 // @Param id path int true "Payment ID"
 // @Success 200 {string} string "Deleted"
+// @Failure 403 {object} access.ErrorResponse "Only admins"
 // @Router /payments/{id} [delete]
 func DeletePayment(w http.ResponseWriter, r *http.Request) {
+	if !access.RequireAdmin(w, r) {
+		return
+	}
 	params := mux.Vars(r)
 	id, err := strconv.Atoi(params["id"])
 	if err != nil {
@@ -205,13 +1210,14 @@ func DeletePayment(w http.ResponseWriter, r *http.Request) {
 
 // SearchPayments godoc
 // @Summary Search payments by user, order, or status
-// @Description Search payments by user, order, or status
+// @Description Search payments by user, order, or status. Clients only find their own payments.
 // @Tags payments
 // @Produce json
 // @Param user query int false "User ID"
 // @Param order query int false "Order ID"
 // @Param status query string false "Payment Status"
 // @Success 200 {array} Payment
+// @Failure 403 {object} access.ErrorResponse "Another user's payments"
 // @Router /search/payments [get]
 func SearchPayments(w http.ResponseWriter, r *http.Request) {
 	userIDStr := r.URL.Query().Get("user")
@@ -227,6 +1233,12 @@ func SearchPayments(w http.ResponseWriter, r *http.Request) {
 		}
 		userID = uint(parsedUserID)
 	}
+	if caller := access.CallerFrom(r); userIDStr == "" && !caller.Privileged() {
+		userID = caller.UserID
+	}
+	if !access.RequireOwner(w, r, userID) {
+		return
+	}
 
 	var orderID uint
 	if orderIDStr != "" {
@@ -246,3 +1258,21 @@ func SearchPayments(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(payments)
 	w.WriteHeader(http.StatusOK)
 }
+
+// requirePaymentOwner writes an error response unless the caller may act on
+// the payment.
+func requirePaymentOwner(w http.ResponseWriter, r *http.Request, id uint) bool {
+	if access.CallerFrom(r).Privileged() {
+		return true
+	}
+	payment, err := GetPaymentByIDRepo(id)
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			http.Error(w, "Payment not found", http.StatusNotFound)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return false
+	}
+	return access.RequireOwner(w, r, uint(payment.UserID))
+}
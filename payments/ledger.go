@@ -0,0 +1,146 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+)
+
+// Ledger accounts. Money the provider holds for the shop is an asset; sales
+// are revenue, refunds reduce it, and provider fees and chargebacks are
+// expenses.
+const (
+	AccountProviderClearing = "assets:provider_clearing"
+	AccountSales            = "revenue:sales"
+	AccountRefunds          = "revenue:refunds"
+	AccountPaymentFees      = "expenses:payment_fees"
+	AccountChargebacks      = "expenses:chargebacks"
+)
+
+var ErrUnbalancedEntry = errors.New("ledger entry is not balanced")
+
+// transfer builds an entry that debits one account and credits another with
+// the same amount.
+func transfer(kind, reference string, paymentID int, description, debit, credit string, amount Money) *LedgerEntry {
+	return &LedgerEntry{
+		Kind:        kind,
+		PaymentID:   paymentID,
+		Reference:   reference,
+		Currency:    amount.Currency,
+		Description: description,
+		Lines: []LedgerLine{
+			{Account: debit, Currency: amount.Currency, Debit: amount.Amount},
+			{Account: credit, Currency: amount.Currency, Credit: amount.Amount},
+		},
+	}
+}
+
+// checkBalanced enforces that every line debits or credits a positive amount
+// in the entry's currency and that debits equal credits.
+func (e *LedgerEntry) checkBalanced() error {
+	if len(e.Lines) < 2 {
+		return fmt.Errorf("%w: %s has %d lines", ErrUnbalancedEntry, e.Reference, len(e.Lines))
+	}
+	var debits, credits int64
+	for _, line := range e.Lines {
+		if line.Currency != e.Currency {
+			return fmt.Errorf("%w: %s mixes %s and %s", ErrUnbalancedEntry, e.Reference, e.Currency, line.Currency)
+		}
+		if line.Debit < 0 || line.Credit < 0 || (line.Debit > 0) == (line.Credit > 0) {
+			return fmt.Errorf("%w: %s has a line on %s that is not a single positive debit or credit", ErrUnbalancedEntry, e.Reference, line.Account)
+		}
+		debits += line.Debit
+		credits += line.Credit
+	}
+	if debits != credits {
+		return fmt.Errorf("%w: %s debits %d, credits %d", ErrUnbalancedEntry, e.Reference, debits, credits)
+	}
+	return nil
+}
+
+func chargeEntry(payment *Payment) *LedgerEntry {
+	return transfer("charge", fmt.Sprintf("payment:%d:charge", payment.ID), payment.ID,
+		fmt.Sprintf("Payment %d captured", payment.ID),
+		AccountProviderClearing, AccountSales, payment.CapturedAmount)
+}
+
+// feeEntry books the provider fee of a payment.
+func feeEntry(payment *Payment, fee Money) *LedgerEntry {
+	return transfer("fee", fmt.Sprintf("payment:%d:fee", payment.ID), payment.ID,
+		fmt.Sprintf("Provider fee for payment %d", payment.ID),
+		AccountPaymentFees, AccountProviderClearing, fee)
+}
+
+func refundEntry(refund *Refund) *LedgerEntry {
+	return transfer("refund", fmt.Sprintf("refund:%d", refund.ID), refund.PaymentID,
+		fmt.Sprintf("Refund %d of payment %d", refund.ID, refund.PaymentID),
+		AccountRefunds, AccountProviderClearing, refund.Amount)
+}
+
+func chargebackEntry(payment *Payment, request ChargebackRequest) *LedgerEntry {
+	description := fmt.Sprintf("Chargeback %s of payment %d", request.Reference, payment.ID)
+	if request.Reason != "" {
+		description += ": " + request.Reason
+	}
+	return transfer("chargeback", fmt.Sprintf("payment:%d:chargeback:%s", payment.ID, request.Reference), payment.ID,
+		description,
+		AccountChargebacks, AccountProviderClearing, request.Amount)
+}
+
+// recordLedger posts an entry for money that has already moved at the
+// provider. A failure must not undo the payment, so it is only logged; the
+// entry is posted again by backfillLedger on the next start.
+func recordLedger(entry *LedgerEntry) {
+	if _, err := PostLedgerEntryRepo(entry); err != nil {
+		log.Printf("failed to post ledger entry %s: %v", entry.Reference, err)
+	}
+}
+
+// recordCharge posts the captured amount of a payment and the provider fee
+// reported for it.
+func recordCharge(payment *Payment, fee Money) {
+	recordLedger(chargeEntry(payment))
+	if fee.Amount > 0 {
+		recordLedger(feeEntry(payment, fee))
+	}
+}
+
+// backfillLedger posts the charges and refunds that are missing from the
+// ledger, such as payments made before it existed. Entries already posted
+// are skipped by their reference.
+func backfillLedger() {
+	payments, err := GetCapturedPaymentsRepo()
+	if err != nil {
+		log.Println("failed to backfill ledger:", err)
+		return
+	}
+	posted := 0
+	for i := range payments {
+		ok, err := PostLedgerEntryRepo(chargeEntry(&payments[i]))
+		if err != nil {
+			log.Printf("failed to post charge of payment %d: %v", payments[i].ID, err)
+		}
+		if ok {
+			posted++
+		}
+	}
+
+	refunds, err := GetSuccessfulRefundsRepo()
+	if err != nil {
+		log.Println("failed to backfill ledger:", err)
+		return
+	}
+	for i := range refunds {
+		ok, err := PostLedgerEntryRepo(refundEntry(&refunds[i]))
+		if err != nil {
+			log.Printf("failed to post refund %d: %v", refunds[i].ID, err)
+		}
+		if ok {
+			posted++
+		}
+	}
+
+	if posted > 0 {
+		log.Printf("posted %d missing ledger entries", posted)
+	}
+}
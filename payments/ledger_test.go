@@ -0,0 +1,89 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestLedgerEntriesBalance(t *testing.T) {
+	payment := &Payment{ID: 7, CapturedAmount: Money{Amount: 100050, Currency: "KZT"}}
+	refund := &Refund{ID: 3, PaymentID: 7, Amount: Money{Amount: 25000, Currency: "KZT"}}
+	chargeback := ChargebackRequest{Amount: Money{Amount: 75050, Currency: "KZT"}, Reference: "CB-1", Reason: "Fraud"}
+
+	tests := []struct {
+		name    string
+		entry   *LedgerEntry
+		debit   string
+		credit  string
+		amount  int64
+		kind    string
+		payment int
+	}{
+		{"capture", chargeEntry(payment), AccountProviderClearing, AccountSales, 100050, "charge", 7},
+		{"fee", feeEntry(payment, Money{Amount: 2001, Currency: "KZT"}), AccountPaymentFees, AccountProviderClearing, 2001, "fee", 7},
+		{"refund", refundEntry(refund), AccountRefunds, AccountProviderClearing, 25000, "refund", 7},
+		{"chargeback", chargebackEntry(payment, chargeback), AccountChargebacks, AccountProviderClearing, 75050, "chargeback", 7},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.entry.checkBalanced(); err != nil {
+				t.Fatal(err)
+			}
+			var debits, credits int64
+			for _, line := range tt.entry.Lines {
+				debits += line.Debit
+				credits += line.Credit
+				if line.Debit > 0 && line.Account != tt.debit {
+					t.Errorf("debited %s, want %s", line.Account, tt.debit)
+				}
+				if line.Credit > 0 && line.Account != tt.credit {
+					t.Errorf("credited %s, want %s", line.Account, tt.credit)
+				}
+			}
+			if debits != credits || debits != tt.amount {
+				t.Errorf("debits %d, credits %d, want %d each", debits, credits, tt.amount)
+			}
+			if tt.entry.Kind != tt.kind || tt.entry.PaymentID != tt.payment || tt.entry.Currency != "KZT" {
+				t.Errorf("got kind %s, payment %d, currency %s", tt.entry.Kind, tt.entry.PaymentID, tt.entry.Currency)
+			}
+		})
+	}
+
+	// Все проводки по платежу вместе дают нулевой баланс по каждой валюте
+	balance := map[string]int64{}
+	for _, tt := range tests {
+		for _, line := range tt.entry.Lines {
+			balance[line.Currency] += line.Debit - line.Credit
+		}
+	}
+	for currency, sum := range balance {
+		if sum != 0 {
+			t.Errorf("balance of %s is %d, want 0", currency, sum)
+		}
+	}
+}
+
+func TestCheckBalancedRejects(t *testing.T) {
+	line := func(account string, debit, credit int64) LedgerLine {
+		return LedgerLine{Account: account, Currency: "KZT", Debit: debit, Credit: credit}
+	}
+	tests := []struct {
+		name  string
+		lines []LedgerLine
+	}{
+		{"no lines", nil},
+		{"one line", []LedgerLine{line(AccountSales, 100, 0)}},
+		{"debits exceed credits", []LedgerLine{line(AccountProviderClearing, 101, 0), line(AccountSales, 0, 100)}},
+		{"credits exceed debits", []LedgerLine{line(AccountProviderClearing, 100, 0), line(AccountSales, 0, 101)}},
+		{"zero amount", []LedgerLine{line(AccountProviderClearing, 0, 0), line(AccountSales, 0, 0)}},
+		{"both sides on a line", []LedgerLine{line(AccountProviderClearing, 100, 100), line(AccountSales, 0, 0)}},
+		{"negative amount", []LedgerLine{line(AccountProviderClearing, -100, 0), line(AccountSales, 0, -100)}},
+		{"mixed currencies", []LedgerLine{line(AccountProviderClearing, 100, 0), {Account: AccountSales, Currency: "USD", Credit: 100}}},
+	}
+	for _, tt := range tests {
+		entry := &LedgerEntry{Reference: tt.name, Currency: "KZT", Lines: tt.lines}
+		if err := entry.checkBalanced(); !errors.Is(err, ErrUnbalancedEntry) {
+			t.Errorf("%s: got %v, want ErrUnbalancedEntry", tt.name, err)
+		}
+	}
+}
@@ -1,9 +1,11 @@
 package main
 
 import (
+	"HL_online_shop/common/access"
 	_ "HL_online_shop/docs"
 	"log"
 	"net/http"
+	"os"
 	"time"
 
 	"github.com/gorilla/mux"
@@ -21,16 +23,64 @@ import (
 // @contact.email support@swagger.io
 
 func main() {
+	// Номера карт маскируются во всех логах сервиса
+	log.SetOutput(redactingWriter{w: os.Stderr})
+
 	InitDB()
+	var err error
+	if err = access.LoadInternalToken(); err != nil {
+		log.Fatal("invalid internal token: ", err)
+	}
+	go idempotencyKeys.Expire(time.Hour)
+
+	config, err = LoadConfig()
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("Payments config: %+v", *config)
+
+	provider, err = newPaymentProvider(config)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// "payments reconcile" выполняет одну сверку с провайдером и завершается
+	if len(os.Args) > 1 && os.Args[1] == "reconcile" {
+		if err := runReconciliation(); err != nil {
+			os.Exit(1)
+		}
+		return
+	}
+
+	go expireAuthorizations(time.Minute)
+	go reconcileLoop(time.Duration(config.ReconcileInterval))
+	go backfillLedger()
 
 	r := mux.NewRouter()
+	r.Use(access.RequireInternalToken)
 	r.HandleFunc("/health", HealthCheck).Methods("GET")
 	r.HandleFunc("/payments", GetPayments).Methods("GET")
-	r.HandleFunc("/payments", CreatePayment).Methods("POST")
+	r.HandleFunc("/payments", idempotencyKeys.Wrap(CreatePayment)).Methods("POST")
+	r.HandleFunc("/payments/authorize", idempotencyKeys.Wrap(AuthorizePayment)).Methods("POST")
+	r.HandleFunc("/payments/reconciliation", GetReconciliation).Methods("GET")
+	r.HandleFunc("/payments/callbacks/epay", EpayPostLink).Methods("POST")
+	r.HandleFunc("/payments/callbacks/epay/fail", EpayFailurePostLink).Methods("POST")
 	r.HandleFunc("/payments/{id}", GetPayment).Methods("GET")
 	r.HandleFunc("/payments/{id}", UpdatePayment).Methods("PUT")
 	r.HandleFunc("/payments/{id}", DeletePayment).Methods("DELETE")
+	r.HandleFunc("/payments/{id}/action", GetPaymentAction).Methods("GET")
+	r.HandleFunc("/payments/{id}/complete", CompletePayment).Methods("POST")
+	r.HandleFunc("/payments/{id}/capture", CapturePayment).Methods("POST")
+	r.HandleFunc("/payments/{id}/void", VoidPayment).Methods("POST")
+	r.HandleFunc("/payments/{id}/refunds", CreateRefund).Methods("POST")
+	r.HandleFunc("/payments/{id}/refunds", GetRefunds).Methods("GET")
+	r.HandleFunc("/payments/{id}/chargebacks", idempotencyKeys.Wrap(CreateChargeback)).Methods("POST")
+	r.HandleFunc("/ledger/balances", GetLedgerBalances).Methods("GET")
+	r.HandleFunc("/ledger/journal", GetLedgerJournal).Methods("GET")
 	r.HandleFunc("/search/payments", SearchPayments).Methods("GET")
+	r.HandleFunc("/users/{id}/payment-methods", GetPaymentMethods).Methods("GET")
+	r.HandleFunc("/users/{id}/payment-methods", CreatePaymentMethod).Methods("POST")
+	r.HandleFunc("/users/{id}/payment-methods/{method_id}", DeletePaymentMethod).Methods("DELETE")
 	r.PathPrefix("/swagger/").Handler(httpSwagger.WrapHandler)
 
 	srv := &http.Server{
@@ -43,3 +93,22 @@ func main() {
 	log.Println("Payments service is running on port 8084")
 	log.Fatal(srv.ListenAndServe())
 }
+
+// expireAuthorizations periodically voids authorizations whose capture window
+// has closed.
+func expireAuthorizations(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		payments, err := GetExpiredAuthorizationsRepo(time.Now())
+		if err != nil {
+			log.Println("failed to load expired authorizations:", err)
+			continue
+		}
+		for _, payment := range payments {
+			if err := expireAuthorization(payment.ID); err != nil {
+				log.Printf("failed to expire payment %d: %v", payment.ID, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Card numbers with a fixed outcome on the mock provider. Any other card is
+// approved.
+const (
+	MockCardDeclined  = "4000000000000002"
+	MockCard3DSecure  = "4000000000003220"
+	MockCardTimeout   = "4000000000000119"
+	mockChallengeHost = "https://mock-acs.local/challenge/"
+
+	// MockActionFail passed as the challenge data fails the 3-D Secure
+	// challenge of MockCard3DSecure. Any other data passes it.
+	MockActionFail = "fail"
+)
+
+// MockProvider is an in-process PaymentProvider for local development and CI.
+// Its results only depend on the card number and the order of calls.
+type MockProvider struct {
+	mu           sync.Mutex
+	seq          int
+	transactions map[string]*mockTransaction
+	// cards maps the CardID of a card to its number, so a saved card
+	// behaves like the test card it was saved from.
+	cards map[string]PAN
+}
+
+type mockTransaction struct {
+	id        string
+	invoiceID string
+	status    string
+	amount    Money
+	captured  Money
+	refunded  Money
+	cardID    string
+	capture   bool
+}
+
+func NewMockProvider() *MockProvider {
+	return &MockProvider{
+		transactions: make(map[string]*mockTransaction),
+		cards:        make(map[string]PAN),
+	}
+}
+
+func (p *MockProvider) Authorize(req ChargeRequest) (*ProviderResult, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	pan := req.Card.HPAN
+	cardID := fmt.Sprintf("mock-card-%s", last4(string(pan)))
+	if req.CardID != "" {
+		saved, ok := p.cards[req.CardID]
+		if !ok {
+			return nil, fmt.Errorf("unknown mock card %s", req.CardID)
+		}
+		pan, cardID = saved, req.CardID
+	}
+	if pan == MockCardTimeout {
+		return nil, fmt.Errorf("%w: mock card %s", ErrProviderTimeout, pan)
+	}
+	p.cards[cardID] = pan
+
+	p.seq++
+	tx := &mockTransaction{
+		id:        fmt.Sprintf("mock-%06d", p.seq),
+		invoiceID: req.InvoiceID,
+		amount:    req.Amount,
+		refunded:  Money{Currency: req.Amount.Currency},
+		cardID:    cardID,
+		capture:   req.Capture,
+	}
+	if tx.invoiceID == "" {
+		tx.invoiceID = tx.id
+	}
+
+	switch {
+	case pan == MockCardDeclined:
+		tx.status = ProviderStatusDeclined
+	case pan == MockCard3DSecure:
+		tx.status = ProviderStatusRequiresAction
+	case req.Capture:
+		tx.status = ProviderStatusCaptured
+		tx.captured = req.Amount
+	default:
+		tx.status = ProviderStatusAuthorized
+	}
+	p.transactions[tx.id] = tx
+
+	result := tx.result()
+	if tx.status == ProviderStatusDeclined {
+		result.Code = 1
+		result.Message = "declined by mock provider"
+	}
+	if tx.status == ProviderStatusRequiresAction {
+		result.Secure3D = mockChallengeHost + tx.id
+	}
+	return result, nil
+}
+
+func (p *MockProvider) CompleteAction(req ActionRequest) (*ProviderResult, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	tx, ok := p.transactions[req.TransactionID]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrTransactionNotFound, req.TransactionID)
+	}
+	if tx.status != ProviderStatusRequiresAction {
+		// Повторное подтверждение возвращает уже известный результат
+		return tx.result(), nil
+	}
+
+	switch {
+	case req.Data == MockActionFail:
+		tx.status = ProviderStatusDeclined
+	case tx.capture:
+		tx.status = ProviderStatusCaptured
+		tx.captured = tx.amount
+	default:
+		tx.status = ProviderStatusAuthorized
+	}
+
+	result := tx.result()
+	if tx.status == ProviderStatusDeclined {
+		result.Code = 1
+		result.Message = "3-D Secure challenge failed"
+	}
+	return result, nil
+}
+
+func (p *MockProvider) Capture(transactionID string, amount Money) (*ProviderResult, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	tx, ok := p.transactions[transactionID]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrTransactionNotFound, transactionID)
+	}
+	if tx.status != ProviderStatusAuthorized {
+		return nil, fmt.Errorf("cannot capture transaction in status %s", tx.status)
+	}
+	if amount.Amount == 0 {
+		amount = tx.amount
+	}
+	if !amount.SameCurrency(tx.amount) || amount.Amount > tx.amount.Amount {
+		return nil, fmt.Errorf("capture amount %s exceeds authorized amount %s", amount, tx.amount)
+	}
+	tx.status = ProviderStatusCaptured
+	tx.captured = amount
+	return tx.result(), nil
+}
+
+func (p *MockProvider) Void(transactionID string) (*ProviderResult, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	tx, ok := p.transactions[transactionID]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrTransactionNotFound, transactionID)
+	}
+	if tx.status != ProviderStatusAuthorized {
+		return nil, fmt.Errorf("cannot void transaction in status %s", tx.status)
+	}
+	tx.status = ProviderStatusVoided
+	return tx.result(), nil
+}
+
+func (p *MockProvider) Refund(transactionID string, amount Money) (*ProviderResult, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	tx, ok := p.transactions[transactionID]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrTransactionNotFound, transactionID)
+	}
+	if tx.status != ProviderStatusCaptured && tx.status != ProviderStatusRefunded {
+		return nil, fmt.Errorf("cannot refund transaction in status %s", tx.status)
+	}
+	if amount.Amount == 0 {
+		amount = tx.captured.Sub(tx.refunded)
+	}
+	if !amount.SameCurrency(tx.captured) || tx.refunded.Add(amount).Amount > tx.captured.Amount {
+		return nil, fmt.Errorf("refund amount %s exceeds captured amount %s", tx.refunded.Add(amount), tx.captured)
+	}
+	tx.refunded = tx.refunded.Add(amount)
+	if tx.refunded.Amount >= tx.captured.Amount {
+		tx.status = ProviderStatusRefunded
+	}
+	result := tx.result()
+	result.Amount = amount
+	return result, nil
+}
+
+func (p *MockProvider) Status(invoiceID string) (*ProviderResult, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, tx := range p.transactions {
+		if tx.invoiceID == invoiceID {
+			return tx.result(), nil
+		}
+	}
+	return nil, fmt.Errorf("%w: invoice %s", ErrTransactionNotFound, invoiceID)
+}
+
+func (tx *mockTransaction) result() *ProviderResult {
+	return &ProviderResult{
+		TransactionID: tx.id,
+		InvoiceID:     tx.invoiceID,
+		Status:        tx.status,
+		Amount:        tx.amount,
+		CardID:        tx.cardID,
+	}
+}
+
+func last4(pan string) string {
+	if len(pan) < 4 {
+		return pan
+	}
+	return pan[len(pan)-4:]
+}
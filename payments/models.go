@@ -1,244 +1,202 @@
 package main
 
 import (
-	"bytes"
-	"crypto/rand"
-	"crypto/rsa"
-	"crypto/x509"
-	"encoding/base64"
-	"encoding/json"
-	"encoding/pem"
-	"errors"
-	"fmt"
-	"io/ioutil"
-	"mime/multipart"
-	"net/http"
 	"time"
 )
 
 type PaymentRequest struct {
-	Amount     float64 `json:"amount" validate:"required" example:"100.00"`
-	OrderID    int     `json:"order_id" validate:"required" example:"1"`
-	UserID     int     `json:"user_id" validate:"required" example:"1"`
-	HPAN       string  `json:"hpan" validate:"required" example:"4003032704547597"`
-	ExpDate    string  `json:"expDate" validate:"required" example:"1022"`
-	CVC        string  `json:"cvc" validate:"required" example:"636"`
-	TerminalID string  `json:"terminalId" validate:"required" example:"67e34d63-102f-4bd1-898e-370781d0074d"`
+	Amount  Money `json:"amount" validate:"positive_money"`
+	OrderID int   `json:"order_id" validate:"required" example:"1"`
+	UserID  int   `json:"user_id" validate:"required" example:"1"`
+	// PaymentMethodID charges a saved card of the user instead of card data.
+	PaymentMethodID int    `json:"payment_method_id,omitempty" example:"0"`
+	HPAN            PAN    `json:"hpan,omitempty" validate:"required_without=PaymentMethodID,excluded_with=PaymentMethodID,omitempty,luhn" swaggertype:"string" example:"4003032704547597"`
+	ExpDate         string `json:"expDate,omitempty" validate:"required_without=PaymentMethodID,excluded_with=PaymentMethodID,omitempty,card_expiry" example:"1030"`
+	CVC             CVC    `json:"cvc,omitempty" validate:"required_without=PaymentMethodID,excluded_with=PaymentMethodID,omitempty,numeric,min=3,max=4" swaggertype:"string" example:"636"`
+	// SaveCard saves the card as a payment method of the user once it is accepted.
+	SaveCard bool `json:"save_card" example:"false"`
 }
 
 type Payment struct {
 	ID          int       `gorm:"primaryKey" json:"id" readonly:"true" example:"1"`
 	UserID      int       `json:"user_id" validate:"required" example:"1"`
 	OrderID     int       `json:"order_id" validate:"required" example:"1"`
-	Amount      float64   `json:"amount" validate:"required,gt=0" example:"100"`
+	Amount      Money     `gorm:"embedded;embeddedPrefix:amount_" json:"amount" validate:"positive_money"`
 	PaymentDate time.Time `json:"payment_date" readonly:"true" example:"2023-07-20T15:04:05Z"`
-	Status      string    `json:"status" validate:"required,oneof=successful unsuccessful" example:"successful"`
+	Status      string    `json:"status" validate:"required,oneof=pending requires_action authorized successful unsuccessful voided expired partially_refunded refunded" example:"successful"`
+	// InvoiceID identifies a single payment attempt at the provider.
+	InvoiceID     string `gorm:"uniqueIndex" json:"invoice_id" readonly:"true" example:"000100001"`
+	TransactionID string `json:"transaction_id" readonly:"true" example:"9a1f3c2e-5a6b-4c7d-8e9f-0a1b2c3d4e5f"`
+	// CapturedAmount is the part of Amount actually charged.
+	CapturedAmount Money `gorm:"embedded;embeddedPrefix:captured_amount_" json:"captured_amount" readonly:"true"`
+	// ExpiresAt is when an authorized payment is voided if it is not captured.
+	ExpiresAt *time.Time `json:"expires_at,omitempty" readonly:"true" example:"2023-07-27T15:04:05Z"`
+	// ActionURL is the 3-D Secure challenge of a payment in requires_action.
+	ActionURL string `json:"action_url,omitempty" readonly:"true" example:"https://mock-acs.local/challenge/mock-000001"`
+	// Only the masked card number, the brand and the provider's card token
+	// are stored; the full card number and CVC never reach the database.
+	CardMask  string `json:"card_mask" readonly:"true" example:"400303******7597"`
+	CardBrand string `json:"card_brand" readonly:"true" example:"visa"`
+	CardID    string `json:"card_id" readonly:"true" example:"b2c3d4e5-f6a7-4b8c-9d0e-1f2a3b4c5d6e"`
+	// AuthorizeOnly payments are captured separately after authorization.
+	AuthorizeOnly bool `gorm:"not null;default:false" json:"-"`
+}
+
+// PaymentMethod is a card saved at the payment provider. Only the provider's
+// card token and the masked card data are kept.
+type PaymentMethod struct {
+	ID        int       `gorm:"primaryKey" json:"id" readonly:"true" example:"1"`
+	UserID    int       `gorm:"uniqueIndex:idx_payment_methods_user_card" json:"user_id" example:"1"`
+	CardID    string    `gorm:"uniqueIndex:idx_payment_methods_user_card" json:"-"`
+	CardMask  string    `json:"card_mask" example:"400303******7597"`
+	CardBrand string    `json:"card_brand" example:"visa"`
+	CreatedAt time.Time `json:"created_at" readonly:"true" example:"2023-07-20T15:04:05Z"`
+}
+
+func (PaymentMethod) TableName() string {
+	return "payment_methods"
+}
+
+// SavePaymentMethodRequest saves the card of an accepted payment.
+type SavePaymentMethodRequest struct {
+	PaymentID int `json:"payment_id" validate:"required" example:"1"`
+}
+
+// PaymentDiscrepancy is a difference between a payment and its transaction
+// at the provider found by reconciliation. Differences that reconciliation
+// could fix on its own are stored as resolved.
+type PaymentDiscrepancy struct {
+	ID             int       `gorm:"primaryKey" json:"id" readonly:"true" example:"1"`
+	PaymentID      int       `gorm:"uniqueIndex:idx_payment_discrepancies_payment_kind" json:"payment_id" example:"1"`
+	InvoiceID      string    `json:"invoice_id" example:"000100001"`
+	Kind           string    `gorm:"uniqueIndex:idx_payment_discrepancies_payment_kind" json:"kind" example:"status_mismatch" enums:"stale_status,status_mismatch,amount_mismatch,missing_at_provider,pending_refund"`
+	LocalStatus    string    `json:"local_status" example:"successful"`
+	ProviderStatus string    `json:"provider_status" example:"declined"`
+	LocalAmount    Money     `gorm:"embedded;embeddedPrefix:local_amount_" json:"local_amount"`
+	ProviderAmount Money     `gorm:"embedded;embeddedPrefix:provider_amount_" json:"provider_amount"`
+	Resolved       bool      `json:"resolved" example:"false"`
+	Note           string    `json:"note" example:"payment is successful locally but declined at the provider"`
+	DetectedAt     time.Time `json:"detected_at" example:"2023-07-20T15:04:05Z"`
+}
+
+func (PaymentDiscrepancy) TableName() string {
+	return "payment_discrepancies"
+}
+
+// LedgerEntry is a balanced journal entry of the ledger. Amounts are integer
+// minor units of Currency, and the debits of Lines always equal the credits.
+type LedgerEntry struct {
+	ID          int          `gorm:"primaryKey" json:"id" readonly:"true" example:"1"`
+	Kind        string       `gorm:"index" json:"kind" example:"charge" enums:"charge,fee,refund,chargeback"`
+	PaymentID   int          `gorm:"index" json:"payment_id" example:"1"`
+	Reference   string       `gorm:"uniqueIndex" json:"reference" example:"payment:1:charge"`
+	Currency    string       `json:"currency" example:"KZT"`
+	Description string       `json:"description" example:"Payment 1 captured"`
+	CreatedAt   time.Time    `json:"created_at" example:"2023-07-20T15:04:05Z"`
+	Lines       []LedgerLine `gorm:"foreignKey:EntryID" json:"lines"`
+}
+
+func (LedgerEntry) TableName() string {
+	return "ledger_entries"
+}
+
+// LedgerLine debits or credits one account. Exactly one of Debit and Credit
+// is set.
+type LedgerLine struct {
+	ID       int    `gorm:"primaryKey" json:"-"`
+	EntryID  int    `gorm:"index" json:"-"`
+	Account  string `gorm:"index" json:"account" example:"assets:provider_clearing"`
+	Currency string `json:"currency" example:"KZT"`
+	Debit    int64  `gorm:"not null;check:chk_ledger_lines_amounts,debit >= 0 AND credit >= 0" json:"debit" example:"10000"`
+	Credit   int64  `gorm:"not null;check:chk_ledger_lines_one_side,(debit > 0) <> (credit > 0)" json:"credit" example:"0"`
+}
+
+func (LedgerLine) TableName() string {
+	return "ledger_lines"
+}
+
+// AccountBalance is the total of an account in one currency. Balance is
+// debits minus credits.
+type AccountBalance struct {
+	Account  string `json:"account" example:"assets:provider_clearing"`
+	Currency string `json:"currency" example:"KZT"`
+	Debit    int64  `json:"debit" example:"10000"`
+	Credit   int64  `json:"credit" example:"2500"`
+	Balance  int64  `json:"balance" example:"7500"`
+}
+
+// ChargebackRequest records a chargeback reported by the provider.
+type ChargebackRequest struct {
+	Amount Money `json:"amount" validate:"positive_money"`
+	// Reference is the provider's identifier of the chargeback; a chargeback
+	// is recorded once per reference.
+	Reference string `json:"reference" validate:"required,max=100" example:"CB-2023-0001"`
+	Reason    string `json:"reason" validate:"max=255" example:"Fraudulent transaction"`
+}
+
+// PaymentAction is the step the customer has to take before a payment can
+// be completed.
+type PaymentAction struct {
+	PaymentID int    `json:"payment_id" example:"1"`
+	Type      string `json:"type" example:"redirect_3ds"`
+	URL       string `json:"url" example:"https://mock-acs.local/challenge/mock-000001"`
+}
+
+// ActionCompletion finishes a payment after the 3-D Secure challenge.
+type ActionCompletion struct {
+	// Data is the challenge result returned by the card issuer, if any.
+	Data string `json:"data" example:"eyJ0cmFuc1N0YXR1cyI6IlkifQ"`
 }
 
 func (Payment) TableName() string {
 	return "payments_shop"
 }
 
-type TokenResponse struct {
-	AccessToken string `json:"access_token"`
-	ExpiresIn   string `json:"expires_in"`
-	Scope       string `json:"scope"`
-	TokenType   string `json:"token_type"`
-}
-
-func getToken() (string, error) {
-	tokenURL := "https://testoauth.homebank.kz/epay2/oauth2/token"
-
-	// Создаем буфер для тела запроса и writer для multipart формы
-	body := &bytes.Buffer{}
-	writer := multipart.NewWriter(body)
-
-	// Добавляем поля формы
-	writer.WriteField("grant_type", "client_credentials")
-	writer.WriteField("scope", "webapi usermanagement email_send verification statement statistics payment")
-	writer.WriteField("client_id", "test")
-	writer.WriteField("client_secret", "yF587AV9Ms94qN2QShFzVR3vFnWkhjbAK3sG")
-	writer.WriteField("invoiceId", "000000001")
-	writer.WriteField("amount", "100")
-	writer.WriteField("currency", "KZT")
-	writer.WriteField("terminalId", "67e34d63-102f-4bd1-898e-370781d0074d")
-
-	// Закрываем writer чтобы отправить все данные
-	err := writer.Close()
-	if err != nil {
-		return "", err
-	}
-
-	req, err := http.NewRequest("POST", tokenURL, body)
-	if err != nil {
-		return "", err
-	}
-
-	// Устанавливаем заголовок Content-Type включая boundary
-	req.Header.Set("Content-Type", writer.FormDataContentType())
-
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		return "", err
-	}
-	defer resp.Body.Close()
-
-	respBody, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return "", err
-	}
-
-	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("status: %s, body: %s ", resp.Status, respBody)
-	}
-
-	var token TokenResponse
-	err = json.Unmarshal(respBody, &token)
-	if err != nil {
-		return "", err
-	}
-	return token.AccessToken, nil
-}
-
-type PaymentRequestMake struct {
-	Amount          int    `json:"amount"`
-	Currency        string `json:"currency"`
-	Name            string `json:"name"`
-	Cryptogram      string `json:"cryptogram"`
-	InvoiceID       string `json:"invoiceId"`
-	InvoiceIDAlt    string `json:"invoiceIdAlt,omitempty"`
-	Description     string `json:"description"`
-	AccountID       string `json:"accountId,omitempty"`
-	Email           string `json:"email,omitempty"`
-	Phone           string `json:"phone,omitempty"`
-	PostLink        string `json:"postLink"`
-	FailurePostLink string `json:"failurePostLink,omitempty"`
-	CardSave        bool   `json:"cardSave"`
-	Data            string `json:"data,omitempty"`
-}
-
-type PaymentResponse struct {
-	ID           string `json:"id"`
-	Amount       int    `json:"amount"`
-	Currency     string `json:"currency"`
-	InvoiceID    string `json:"invoiceID"`
-	AccountID    string `json:"accountID"`
-	Phone        string `json:"phone"`
-	Email        string `json:"email"`
-	Description  string `json:"description"`
-	Reference    string `json:"reference"`
-	IntReference string `json:"intReference"`
-	Secure3D     string `json:"secure3D"`
-	CardID       string `json:"cardID"`
-	Fee          int    `json:"fee"`
-	Code         int    `json:"code"`
-	Status       string `json:"status"`
-}
-
-func makePayment(token, cryptogram string) (*PaymentResponse, error) {
-	url := "https://testepay.homebank.kz/api/payment/cryptopay"
-
-	paymentRequestMake := PaymentRequestMake{
-		Amount:          100,
-		Currency:        "KZT",
-		Name:            "JON JONSON",
-		Cryptogram:      cryptogram,
-		InvoiceID:       "000001",
-		InvoiceIDAlt:    "8564546",
-		Description:     "test payment",
-		AccountID:       "uuid000001",
-		Email:           "jj@example.com",
-		Phone:           "77777777777",
-		PostLink:        "https://testmerchant/order/1123",
-		FailurePostLink: "https://testmerchant/order/1123/fail",
-		CardSave:        true,
-		Data:            "{\"statement\":{\"name\":\"Arman Ali\",\"invoiceID\":\"80000016\"}}",
-	}
-
-	jsonData, err := json.Marshal(paymentRequestMake)
-	if err != nil {
-		return nil, fmt.Errorf("failed to marshal payment request: %w", err)
-	}
-
-	//fmt.Println("Sending data to server:", string(jsonData)) // Отладка отправляемых данных
-
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonData))
-	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
-	}
-
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
-
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("request failed: %w", err)
-	}
-	defer resp.Body.Close()
-
-	//if resp.StatusCode != http.StatusOK {
-	//	body, _ := ioutil.ReadAll(resp.Body)
-	//	return nil, fmt.Errorf("server returned non-200 status: %d, body: %s", resp.StatusCode, string(body))
-	//}
-
-	var paymentResponse PaymentResponse
-	if err := json.NewDecoder(resp.Body).Decode(&paymentResponse); err != nil {
-		return nil, fmt.Errorf("failed to decode response: %w", err)
-	}
-
-	return &paymentResponse, nil
-}
-
-func getRSAPublicKey() (*rsa.PublicKey, error) {
-	resp, err := http.Get("https://testepay.homebank.kz/api/public.rsa")
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-
-	pemData, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
-	}
-
-	block, _ := pem.Decode(pemData)
-	if block == nil || block.Type != "PUBLIC KEY" {
-		return nil, errors.New("failed to decode PEM block containing public key")
-	}
-
-	pub, err := x509.ParsePKIXPublicKey(block.Bytes)
-	if err != nil {
-		return nil, err
-	}
-
-	publicKey, ok := pub.(*rsa.PublicKey)
-	if !ok {
-		return nil, errors.New("not RSA public key")
-	}
-
-	return publicKey, nil
-}
-
-// "hpan":"4003032704547597","expDate":"1022","cvc":"636","terminalId":"67e34d63-102f-4bd1-898e-370781d0074d"
-type CardData struct {
-	HPAN       string `json:"hpan" validate:"required" example:"4003032704547597"`
-	ExpDate    string `json:"expDate" validate:"required" example:"1022"`
-	CVC        string `json:"cvc" validate:"required" example:"636"`
-	TerminalID string `json:"terminalId" validate:"required" example:"67e34d63-102f-4bd1-898e-370781d0074d"`
-}
-
-func createCryptogram(cardData CardData, publicKey *rsa.PublicKey) (string, error) {
-	cardDataJSON, err := json.Marshal(cardData)
-	if err != nil {
-		return "", err
-	}
-
-	encryptedData, err := rsa.EncryptPKCS1v15(rand.Reader, publicKey, cardDataJSON)
-	if err != nil {
-		return "", err
-	}
-
-	return base64.StdEncoding.EncodeToString(encryptedData), nil
+// CaptureRequest charges an authorized payment. Without an amount the whole
+// authorized amount is captured.
+type CaptureRequest struct {
+	Amount Money `json:"amount"`
+}
+
+// RefundRequest asks to return money of a payment. Without an amount the
+// rest of the captured amount is refunded.
+type RefundRequest struct {
+	Amount Money  `json:"amount"`
+	Reason string `json:"reason" validate:"max=255" example:"Item returned"`
+}
+
+// Refund is a single full or partial refund of a payment.
+type Refund struct {
+	ID            int       `gorm:"primaryKey" json:"id" readonly:"true" example:"1"`
+	PaymentID     int       `gorm:"index" json:"payment_id" example:"1"`
+	Amount        Money     `gorm:"embedded;embeddedPrefix:amount_" json:"amount"`
+	Reason        string    `json:"reason" example:"Item returned"`
+	Status        string    `json:"status" example:"successful" enums:"pending,successful,unsuccessful"`
+	TransactionID string    `json:"transaction_id" example:"9a1f3c2e-5a6b-4c7d-8e9f-0a1b2c3d4e5f"`
+	Message       string    `json:"message,omitempty" example:"insufficient funds on merchant account"`
+	CreatedAt     time.Time `json:"created_at" readonly:"true" example:"2023-07-20T15:04:05Z"`
+}
+
+func (Refund) TableName() string {
+	return "payment_refunds"
+}
+
+// EpayCallback is the payment result ePay posts to the postLink and
+// failurePostLink URLs.
+type EpayCallback struct {
+	ID          string  `json:"id" example:"a5f1c3e2-7b4d-4e8f-9a0b-1c2d3e4f5a6b"`
+	DateTime    string  `json:"dateTime" example:"2023-07-20T15:04:05+06:00"`
+	InvoiceID   string  `json:"invoiceId" example:"000100001"`
+	Amount      float64 `json:"amount" example:"100"`
+	Currency    string  `json:"currency" example:"KZT"`
+	Terminal    string  `json:"terminal" example:"67e34d63-102f-4bd1-898e-370781d0074d"`
+	AccountID   string  `json:"accountId" example:"1"`
+	Description string  `json:"description" example:"Order #1"`
+	CardMask    string  `json:"cardMask" example:"400303******7597"`
+	CardID      string  `json:"cardId" example:"b2c3d4e5-f6a7-4b8c-9d0e-1f2a3b4c5d6e"`
+	Reference   string  `json:"reference" example:"320415146527"`
+	Code        string  `json:"code" example:"ok"`
+	Reason      string  `json:"reason" example:"success"`
+	ReasonCode  int     `json:"reasonCode" example:"0"`
+	SecretHash  string  `json:"secret_hash" example:"3f2a..."`
 }
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"HL_online_shop/common/access"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+var (
+	ErrOrderNotPayable = errors.New("order cannot be paid in its current status")
+	ErrNotFound        = errors.New("not found")
+)
+
+var serviceClient = &http.Client{Timeout: 10 * time.Second, Transport: access.Transport{Base: http.DefaultTransport}}
+
+func ordersServiceURL() string {
+	if url := os.Getenv("ORDERS_SERVICE_URL"); url != "" {
+		return url
+	}
+	return "http://order-service:8083"
+}
+
+// Order is the subset of the orders service model the payments service needs.
+type Order struct {
+	ID         uint   `json:"id"`
+	UserID     uint   `json:"user_id"`
+	TotalPrice Money  `json:"total_price"`
+	Status     string `json:"status"`
+}
+
+func getOrder(orderID int) (*Order, error) {
+	resp, err := serviceClient.Get(fmt.Sprintf("%s/orders/%d", ordersServiceURL(), orderID))
+	if err != nil {
+		return nil, fmt.Errorf("orders service: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("%w: order %d", ErrNotFound, orderID)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("orders service: unexpected status %s", resp.Status)
+	}
+
+	var order Order
+	if err := json.NewDecoder(resp.Body).Decode(&order); err != nil {
+		return nil, fmt.Errorf("orders service: %w", err)
+	}
+	return &order, nil
+}
+
+type orderTransition struct {
+	Status    string `json:"status"`
+	ChangedBy string `json:"changed_by"`
+	Reason    string `json:"reason,omitempty"`
+}
+
+// transitionOrder asks the orders service to move an order to a new status.
+func transitionOrder(orderID int, status, reason string) error {
+	body, err := json.Marshal(orderTransition{Status: status, ChangedBy: "payments-service", Reason: reason})
+	if err != nil {
+		return err
+	}
+
+	url := fmt.Sprintf("%s/orders/%d/transitions", ordersServiceURL(), orderID)
+	resp, err := serviceClient.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("orders service: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+	msg, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode == http.StatusConflict {
+		return fmt.Errorf("%w: %s", ErrOrderNotPayable, strings.TrimSpace(string(msg)))
+	}
+	return fmt.Errorf("orders service: %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Provider-neutral statuses of a transaction.
+const (
+	ProviderStatusPending        = "pending"
+	ProviderStatusAuthorized     = "authorized"
+	ProviderStatusCaptured       = "captured"
+	ProviderStatusDeclined       = "declined"
+	ProviderStatusRequiresAction = "requires_action"
+	ProviderStatusRefunded       = "refunded"
+	ProviderStatusVoided         = "voided"
+)
+
+var (
+	ErrProviderTimeout     = errors.New("payment provider timed out")
+	ErrProviderFailed      = errors.New("payment provider error")
+	ErrTransactionNotFound = errors.New("transaction not found")
+)
+
+// PaymentProvider is a card payment gateway.
+type PaymentProvider interface {
+	// Authorize reserves the amount on the card. If req.Capture is set the
+	// amount is charged right away.
+	Authorize(req ChargeRequest) (*ProviderResult, error)
+	// Capture charges a previously authorized transaction. An amount below
+	// the authorized one captures part of it and releases the rest.
+	Capture(transactionID string, amount Money) (*ProviderResult, error)
+	// Void releases an authorization that has not been captured.
+	Void(transactionID string) (*ProviderResult, error)
+	// Refund returns the amount of a captured transaction to the card.
+	Refund(transactionID string, amount Money) (*ProviderResult, error)
+	// CompleteAction finishes a transaction after the customer passed the
+	// 3-D Secure challenge returned in ProviderResult.Secure3D.
+	CompleteAction(req ActionRequest) (*ProviderResult, error)
+	// Status looks up the current state of a transaction by invoice ID.
+	Status(invoiceID string) (*ProviderResult, error)
+}
+
+type ChargeRequest struct {
+	OrderID     int
+	UserID      int
+	InvoiceID   string
+	Amount      Money
+	Description string
+	Customer    Customer
+	Card        CardData
+	// CardID charges a card saved at the provider instead of Card.
+	CardID string
+	// SaveCard asks the provider to keep the card and return its CardID.
+	SaveCard bool
+	Capture  bool
+}
+
+// ActionRequest finishes a transaction that required a 3-D Secure challenge.
+type ActionRequest struct {
+	TransactionID string
+	InvoiceID     string
+	Amount        Money
+	// Data is the challenge result passed back by the client, if any.
+	Data    string
+	Capture bool
+}
+
+type Customer struct {
+	Name  string
+	Email string
+	Phone string
+}
+
+type ProviderResult struct {
+	TransactionID string
+	InvoiceID     string
+	Status        string
+	Amount        Money
+	Code          int
+	Message       string
+	CardID        string
+	Secure3D      string
+	Fee           Money
+}
+
+var provider PaymentProvider
+
+// newPaymentProvider returns the provider selected in the configuration.
+func newPaymentProvider(cfg *Config) (PaymentProvider, error) {
+	switch cfg.Provider {
+	case "epay":
+		return NewEpayProvider(cfg), nil
+	case "mock":
+		return NewMockProvider(), nil
+	}
+	return nil, fmt.Errorf("unknown payment provider %q", cfg.Provider)
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+// reconcileGrace keeps payments that may still be in progress out of
+// reconciliation.
+const reconcileGrace = 15 * time.Minute
+
+// ReconciliationReport sums up a reconciliation run.
+type ReconciliationReport struct {
+	Checked       int `json:"checked"`
+	Fixed         int `json:"fixed"`
+	Discrepancies int `json:"discrepancies"`
+	Errors        int `json:"errors"`
+}
+
+// reconcilePayments compares the payments of the lookback window with their
+// transactions at the provider. Payments stuck in pending, requires_action or
+// unsuccessful are updated to the final result of the provider, and so are
+// authorized payments the provider has captured or voided; every other
+// difference is recorded as a PaymentDiscrepancy for manual review.
+func reconcilePayments(now time.Time) (ReconciliationReport, error) {
+	var report ReconciliationReport
+
+	payments, err := GetPaymentsToReconcileRepo(now.Add(-time.Duration(config.ReconcileLookback)))
+	if err != nil {
+		return report, err
+	}
+
+	for i := range payments {
+		payment := &payments[i]
+		if now.Sub(payment.PaymentDate) < reconcileGrace {
+			continue
+		}
+		report.Checked++
+		if err := reconcilePayment(payment, now, &report); err != nil {
+			report.Errors++
+			log.Printf("failed to reconcile payment %d: %v", payment.ID, err)
+		}
+	}
+	reconcileRefunds(now, &report)
+	return report, nil
+}
+
+func reconcilePayment(payment *Payment, now time.Time, report *ReconciliationReport) error {
+	discrepancy := &PaymentDiscrepancy{
+		PaymentID:   payment.ID,
+		InvoiceID:   payment.InvoiceID,
+		LocalStatus: payment.Status,
+		LocalAmount: payment.CapturedAmount,
+		DetectedAt:  now,
+	}
+
+	result, err := provider.Status(payment.InvoiceID)
+	if errors.Is(err, ErrTransactionNotFound) {
+		switch payment.Status {
+		case "unsuccessful":
+			// Отклонённая до провайдера попытка, расхождения нет
+			return nil
+		case "pending":
+			// Запрос так и не дошёл до провайдера
+			payment.Status = "unsuccessful"
+			if _, err := SetPaymentResultRepo(payment); err != nil {
+				return err
+			}
+			discrepancy.Resolved = true
+			discrepancy.Note = "pending payment unknown to the provider was marked unsuccessful"
+			report.Fixed++
+		default:
+			discrepancy.Note = "payment is " + payment.Status + " locally but unknown to the provider"
+		}
+		discrepancy.Kind = "missing_at_provider"
+		report.Discrepancies++
+		return SaveDiscrepancyRepo(discrepancy)
+	}
+	if err != nil {
+		return err
+	}
+
+	discrepancy.ProviderStatus = result.Status
+	discrepancy.ProviderAmount = result.Amount
+
+	if providerStatusMatches(payment.Status, result.Status) {
+		if result.Status != ProviderStatusCaptured || result.Amount.Amount == 0 || result.Amount == payment.CapturedAmount {
+			// Расхождения, найденные раньше, больше не актуальны
+			return ResolveDiscrepanciesRepo(payment.ID)
+		}
+		discrepancy.Kind = "amount_mismatch"
+		discrepancy.Note = fmt.Sprintf("captured %s locally but %s at the provider", payment.CapturedAmount, result.Amount)
+		report.Discrepancies++
+		return SaveDiscrepancyRepo(discrepancy)
+	}
+
+	if stalePaymentStatus(payment.Status) && finalProviderStatus(result.Status) {
+		updated, err := applyProviderResult(payment, result)
+		if err != nil {
+			return err
+		}
+		discrepancy.Kind = "stale_status"
+		discrepancy.Resolved = true
+		discrepancy.Note = fmt.Sprintf("payment updated from %s to %s", discrepancy.LocalStatus, updated.Status)
+		report.Fixed++
+		report.Discrepancies++
+		return SaveDiscrepancyRepo(discrepancy)
+	}
+
+	if payment.Status == "authorized" && (result.Status == ProviderStatusCaptured || result.Status == ProviderStatusVoided) {
+		updated, err := settleAuthorization(payment, result)
+		if errors.Is(err, ErrPaymentNotAuthorized) {
+			// Платёж успели списать или отменить, следующий запуск сверит его снова
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		discrepancy.Kind = "stale_status"
+		discrepancy.Resolved = true
+		discrepancy.Note = fmt.Sprintf("payment updated from %s to %s", discrepancy.LocalStatus, updated.Status)
+		report.Fixed++
+		report.Discrepancies++
+		return SaveDiscrepancyRepo(discrepancy)
+	}
+
+	discrepancy.Kind = "status_mismatch"
+	discrepancy.Note = fmt.Sprintf("payment is %s locally but %s at the provider", payment.Status, result.Status)
+	report.Discrepancies++
+	return SaveDiscrepancyRepo(discrepancy)
+}
+
+// reconcileRefunds settles refunds left pending because the provider did not
+// answer. The provider only reports a transaction as refunded once it is
+// refunded in full, so then its pending refunds went through; a pending refund
+// of the rest of a transaction that is still captured did not. Any other
+// pending refund is recorded as a discrepancy and keeps counting towards the
+// refunded amount, so the money is never refunded twice.
+func reconcileRefunds(now time.Time, report *ReconciliationReport) {
+	refunds, err := GetPendingRefundsRepo(now.Add(-reconcileGrace))
+	if err != nil {
+		report.Errors++
+		log.Println("failed to load pending refunds:", err)
+		return
+	}
+	for i := range refunds {
+		refund := &refunds[i]
+		report.Checked++
+		if err := reconcileRefund(refund, now, report); err != nil {
+			report.Errors++
+			log.Printf("failed to reconcile refund %d: %v", refund.ID, err)
+		}
+	}
+}
+
+func reconcileRefund(refund *Refund, now time.Time, report *ReconciliationReport) error {
+	payment, err := GetPaymentByIDRepo(uint(refund.PaymentID))
+	if err != nil {
+		return err
+	}
+	result, err := provider.Status(payment.InvoiceID)
+	if err != nil {
+		return err
+	}
+	discrepancy := &PaymentDiscrepancy{
+		PaymentID:      payment.ID,
+		InvoiceID:      payment.InvoiceID,
+		Kind:           "pending_refund",
+		LocalStatus:    payment.Status,
+		ProviderStatus: result.Status,
+		LocalAmount:    payment.CapturedAmount,
+		ProviderAmount: result.Amount,
+		DetectedAt:     now,
+	}
+
+	switch result.Status {
+	case ProviderStatusRefunded:
+		refund.Status = "successful"
+	case ProviderStatusCaptured:
+		refunded, err := refundedAmount(db, payment, "successful")
+		if err != nil {
+			return err
+		}
+		if refunded.Add(refund.Amount).Amount < payment.CapturedAmount.Amount {
+			discrepancy.Note = fmt.Sprintf("refund %d of %s is pending and the provider does not show whether it went through", refund.ID, refund.Amount)
+			report.Discrepancies++
+			return SaveDiscrepancyRepo(discrepancy)
+		}
+		refund.Status = "unsuccessful"
+		refund.Message = "not refunded at the provider"
+	default:
+		discrepancy.Note = fmt.Sprintf("refund %d of %s is pending but the payment is %s at the provider", refund.ID, refund.Amount, result.Status)
+		report.Discrepancies++
+		return SaveDiscrepancyRepo(discrepancy)
+	}
+
+	if _, err := completeRefund(refund); err != nil {
+		return err
+	}
+	discrepancy.Resolved = true
+	discrepancy.Note = fmt.Sprintf("pending refund %d was marked %s", refund.ID, refund.Status)
+	report.Fixed++
+	report.Discrepancies++
+	return SaveDiscrepancyRepo(discrepancy)
+}
+
+// settleAuthorization saves a capture or void of an authorized payment that
+// went through at the provider but was never saved, because the provider
+// call timed out or the transaction around it failed.
+func settleAuthorization(payment *Payment, result *ProviderResult) (*Payment, error) {
+	updated, err := UpdateAuthorizationRepo(payment.ID, func(payment *Payment) error {
+		payment.ExpiresAt = nil
+		if result.Status == ProviderStatusVoided {
+			payment.Status = "voided"
+			return nil
+		}
+		payment.Status = "successful"
+		payment.CapturedAmount = payment.Amount
+		if result.Amount.Amount > 0 {
+			payment.CapturedAmount = result.Amount
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if updated.Status == "successful" {
+		recordCharge(updated, result.Fee)
+		return updated, nil
+	}
+	reason := fmt.Sprintf("Authorization of payment %d voided", updated.ID)
+	if err := transitionOrder(updated.OrderID, "refunded", reason); err != nil {
+		log.Printf("failed to mark order %d as refunded: %v", updated.OrderID, err)
+	}
+	return updated, nil
+}
+
+// providerStatusMatches reports whether a provider status agrees with the
+// status of a payment.
+func providerStatusMatches(local, remote string) bool {
+	switch local {
+	case "pending":
+		return remote == ProviderStatusPending
+	case "requires_action":
+		return remote == ProviderStatusRequiresAction
+	case "authorized":
+		return remote == ProviderStatusAuthorized
+	case "successful":
+		return remote == ProviderStatusCaptured
+	case "partially_refunded":
+		return remote == ProviderStatusCaptured || remote == ProviderStatusRefunded
+	case "refunded":
+		return remote == ProviderStatusRefunded
+	case "voided", "expired":
+		return remote == ProviderStatusVoided
+	case "unsuccessful":
+		return remote == ProviderStatusDeclined
+	}
+	return false
+}
+
+// stalePaymentStatus reports whether a payment status may be overwritten by a
+// later provider result.
+func stalePaymentStatus(status string) bool {
+	return status == "pending" || status == "requires_action" || status == "unsuccessful"
+}
+
+func finalProviderStatus(status string) bool {
+	return status == ProviderStatusCaptured || status == ProviderStatusAuthorized || status == ProviderStatusDeclined
+}
+
+// reconcileLoop runs reconciliation every interval.
+func reconcileLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		runReconciliation()
+	}
+}
+
+func runReconciliation() error {
+	report, err := reconcilePayments(time.Now())
+	if err != nil {
+		log.Println("payment reconciliation failed:", err)
+		return err
+	}
+	log.Printf("payment reconciliation: checked %d, fixed %d, discrepancies %d, errors %d",
+		report.Checked, report.Fixed, report.Discrepancies, report.Errors)
+	return nil
+}
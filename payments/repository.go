@@ -1,15 +1,26 @@
 package main
 
 import (
+	"HL_online_shop/common/idempotency"
+	"HL_online_shop/common/money"
+	"errors"
+	"fmt"
 	"github.com/joho/godotenv"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/logger"
 	"log"
 	"os"
+	"time"
 )
 
 var db *gorm.DB
 
+// idempotencyKeys stores the responses to requests sent with an
+// Idempotency-Key header.
+var idempotencyKeys *idempotency.Store
+
 func InitDB() {
 	if err := godotenv.Load(); err != nil {
 		log.Println("Error loading .env file")
@@ -17,12 +28,62 @@ func InitDB() {
 	url := os.Getenv("DATABASE_URL")
 	dsn := url
 	var err error
-	db, err = gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	db, err = gorm.Open(postgres.Open(dsn), &gorm.Config{Logger: logger.New(
+		log.New(log.Writer(), "\r\n", log.LstdFlags),
+		logger.Config{
+			SlowThreshold: 200 * time.Millisecond,
+			LogLevel:      logger.Warn,
+			// Значения параметров не попадают в лог
+			ParameterizedQueries:      true,
+			IgnoreRecordNotFoundError: true,
+			Colorful:                  true,
+		},
+	)})
 	if err != nil {
 		log.Fatal("failed to connect to the database:", err)
 	}
 
+	db.Exec("CREATE SEQUENCE IF NOT EXISTS payment_invoice_seq START 100000")
 	db.Table("payments_shop").AutoMigrate(&Payment{})
+	db.AutoMigrate(&Refund{})
+	db.AutoMigrate(&PaymentMethod{})
+	db.AutoMigrate(&PaymentDiscrepancy{})
+	for _, column := range []struct{ table, name, prefix string }{
+		{"payments_shop", "amount", "amount_"},
+		{"payments_shop", "captured_amount", "captured_amount_"},
+		{"payment_refunds", "amount", "amount_"},
+		{"payment_discrepancies", "local_amount", "local_amount_"},
+		{"payment_discrepancies", "provider_amount", "provider_amount_"},
+	} {
+		if err := money.MigrateColumn(db, column.table, column.name, column.prefix); err != nil {
+			log.Fatal("failed to migrate payment amounts:", err)
+		}
+	}
+	// Платежи до появления captured_amount списывались сразу на всю сумму
+	db.Exec("UPDATE payments_shop SET captured_amount_minor = amount_minor, captured_amount_currency = amount_currency WHERE captured_amount_minor = 0 AND status IN ('successful', 'partially_refunded', 'refunded')")
+	db.AutoMigrate(&LedgerEntry{}, &LedgerLine{})
+	idempotencyKeys = idempotency.NewStore(db, "payment_idempotency_keys")
+	idempotencyKeys.Migrate()
+}
+
+var (
+	ErrPaymentNotRefundable = errors.New("payment cannot be refunded in its current status")
+	ErrRefundTooLarge       = errors.New("refund exceeds the amount left to refund")
+	ErrPaymentNotAuthorized = errors.New("payment is not authorized")
+	ErrAuthorizationExpired = errors.New("authorization has expired")
+	ErrCaptureTooLarge      = errors.New("capture exceeds the authorized amount")
+	ErrCurrencyMismatch     = errors.New("amount is in a different currency than the payment")
+	ErrNothingCaptured      = errors.New("payment has no captured amount")
+	ErrChargebackTooLarge   = errors.New("chargeback exceeds the captured amount left after refunds and chargebacks")
+)
+
+// NextInvoiceIDRepo returns a new invoice ID. ePay expects 6 to 15 digits.
+func NextInvoiceIDRepo() (string, error) {
+	var next int64
+	if err := db.Raw("SELECT nextval('payment_invoice_seq')").Scan(&next).Error; err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%09d", next), nil
 }
 
 func GetAllPaymentsRepo() ([]Payment, error) {
@@ -37,6 +98,12 @@ func GetPaymentByIDRepo(id uint) (*Payment, error) {
 	return &payment, result.Error
 }
 
+func GetPaymentByInvoiceIDRepo(invoiceID string) (*Payment, error) {
+	var payment Payment
+	result := db.Where("invoice_id = ?", invoiceID).First(&payment)
+	return &payment, result.Error
+}
+
 func CreatePaymentRepo(payment *Payment) error {
 	result := db.Create(payment)
 	return result.Error
@@ -47,6 +114,24 @@ func UpdatePaymentRepo(payment *Payment) error {
 	return result.Error
 }
 
+// SetPaymentResultRepo stores the provider outcome of a payment unless the
+// payment has already succeeded or been refunded, so repeated or late results
+// cannot undo it. It reports whether the payment was changed.
+func SetPaymentResultRepo(payment *Payment) (bool, error) {
+	result := db.Model(&Payment{}).
+		Where("id = ? AND status IN ?", payment.ID, []string{"pending", "requires_action", "unsuccessful"}).
+		Updates(map[string]interface{}{
+			"status":                   payment.Status,
+			"transaction_id":           payment.TransactionID,
+			"captured_amount_minor":    payment.CapturedAmount.Amount,
+			"captured_amount_currency": payment.CapturedAmount.Currency,
+			"expires_at":               payment.ExpiresAt,
+			"action_url":               payment.ActionURL,
+			"card_id":                  payment.CardID,
+		})
+	return result.RowsAffected > 0, result.Error
+}
+
 func DeletePaymentRepo(id uint) error {
 	result := db.Delete(&Payment{}, id)
 	return result.Error
@@ -71,3 +156,335 @@ func SearchPaymentsRepo(userID, orderID uint, status string) ([]Payment, error)
 	result := query.Find(&payments)
 	return payments, result.Error
 }
+
+// CreateRefundRepo stores a pending refund of a payment. The payment row is
+// locked so concurrent refunds cannot together exceed the captured amount;
+// pending refunds count towards the total until they fail. An amount of zero
+// refunds everything that is left.
+func CreateRefundRepo(paymentID int, amount Money, reason string) (*Payment, *Refund, error) {
+	var payment Payment
+	var refund *Refund
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&payment, paymentID).Error; err != nil {
+			return err
+		}
+		if payment.Status != "successful" && payment.Status != "partially_refunded" {
+			return fmt.Errorf("%w: %s", ErrPaymentNotRefundable, payment.Status)
+		}
+
+		refunded, err := refundedAmount(tx, &payment, "pending", "successful")
+		if err != nil {
+			return err
+		}
+		left := payment.CapturedAmount.Sub(refunded)
+		if amount.Amount == 0 {
+			amount = left
+		}
+		if amount.Currency == "" {
+			amount.Currency = left.Currency
+		}
+		if !amount.SameCurrency(left) {
+			return fmt.Errorf("%w: requested %s, captured %s", ErrCurrencyMismatch, amount.Currency, left.Currency)
+		}
+		if amount.Amount <= 0 || amount.Amount > left.Amount {
+			return fmt.Errorf("%w: requested %s, left %s", ErrRefundTooLarge, amount, left)
+		}
+
+		refund = &Refund{PaymentID: paymentID, Amount: amount, Reason: reason, Status: "pending"}
+		return tx.Create(refund).Error
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return &payment, refund, nil
+}
+
+// CompleteRefundRepo saves the outcome of a refund and sets the payment status
+// from the total of its successful refunds.
+func CompleteRefundRepo(refund *Refund) (*Payment, error) {
+	var payment Payment
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(refund).Error; err != nil {
+			return err
+		}
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&payment, refund.PaymentID).Error; err != nil {
+			return err
+		}
+
+		refunded, err := refundedAmount(tx, &payment, "successful")
+		if err != nil {
+			return err
+		}
+		switch {
+		case refunded.Amount <= 0:
+			return nil
+		case refunded.Amount >= payment.CapturedAmount.Amount:
+			payment.Status = "refunded"
+		default:
+			payment.Status = "partially_refunded"
+		}
+		return tx.Model(&payment).Update("status", payment.Status).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &payment, nil
+}
+
+// UpdateAuthorizationRepo locks an authorized payment, runs effect on it and
+// saves the result. The lock is held while effect talks to the provider, so
+// the same authorization cannot be captured or voided twice. If the provider
+// call succeeds but the payment is not saved, reconciliation settles it.
+func UpdateAuthorizationRepo(id int, effect func(payment *Payment) error) (*Payment, error) {
+	var payment Payment
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&payment, id).Error; err != nil {
+			return err
+		}
+		if payment.Status != "authorized" {
+			return fmt.Errorf("%w: status is %s", ErrPaymentNotAuthorized, payment.Status)
+		}
+		if err := effect(&payment); err != nil {
+			return err
+		}
+		return tx.Save(&payment).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &payment, nil
+}
+
+// GetExpiredAuthorizationsRepo returns authorized payments whose capture
+// window closed before now.
+func GetExpiredAuthorizationsRepo(now time.Time) ([]Payment, error) {
+	var payments []Payment
+	result := db.Where("status = ? AND expires_at < ?", "authorized", now).Find(&payments)
+	return payments, result.Error
+}
+
+func GetRefundsRepo(paymentID int) ([]Refund, error) {
+	var refunds []Refund
+	result := db.Where("payment_id = ?", paymentID).Order("id").Find(&refunds)
+	return refunds, result.Error
+}
+
+// GetPendingRefundsRepo returns refunds still pending that were created
+// before the given time.
+func GetPendingRefundsRepo(before time.Time) ([]Refund, error) {
+	var refunds []Refund
+	result := db.Where("status = ? AND created_at < ?", "pending", before).Order("id").Find(&refunds)
+	return refunds, result.Error
+}
+
+// refundedAmount sums the refunds of a payment in the given statuses. Refunds
+// are always in the currency of the captured amount.
+func refundedAmount(tx *gorm.DB, payment *Payment, statuses ...string) (Money, error) {
+	total := Money{Currency: payment.CapturedAmount.Currency}
+	err := tx.Model(&Refund{}).
+		Where("payment_id = ? AND status IN ?", payment.ID, statuses).
+		Select("COALESCE(SUM(amount_minor), 0)").
+		Scan(&total.Amount).Error
+	return total, err
+}
+
+func GetPaymentMethodsRepo(userID int) ([]PaymentMethod, error) {
+	var methods []PaymentMethod
+	result := db.Where("user_id = ?", userID).Order("id").Find(&methods)
+	return methods, result.Error
+}
+
+// GetPaymentMethodRepo returns a payment method only if it belongs to the user.
+func GetPaymentMethodRepo(userID, id int) (*PaymentMethod, error) {
+	var method PaymentMethod
+	result := db.Where("user_id = ?", userID).First(&method, id)
+	return &method, result.Error
+}
+
+// SavePaymentMethodRepo stores a card of a user. A card that is already saved
+// is returned as it is.
+func SavePaymentMethodRepo(method *PaymentMethod) error {
+	result := db.Where(PaymentMethod{UserID: method.UserID, CardID: method.CardID}).FirstOrCreate(method)
+	return result.Error
+}
+
+func DeletePaymentMethodRepo(userID, id int) error {
+	result := db.Where("user_id = ?", userID).Delete(&PaymentMethod{}, id)
+	if result.Error == nil && result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return result.Error
+}
+
+// GetPaymentsToReconcileRepo returns the payments made after since, oldest first.
+func GetPaymentsToReconcileRepo(since time.Time) ([]Payment, error) {
+	var payments []Payment
+	result := db.Where("payment_date >= ?", since).Order("id").Find(&payments)
+	return payments, result.Error
+}
+
+// SaveDiscrepancyRepo records a discrepancy. A payment keeps one row per kind
+// of discrepancy, which is refreshed every time it is found again.
+func SaveDiscrepancyRepo(discrepancy *PaymentDiscrepancy) error {
+	result := db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "payment_id"}, {Name: "kind"}},
+		DoUpdates: clause.AssignmentColumns([]string{"local_status", "provider_status", "local_amount_minor", "local_amount_currency", "provider_amount_minor", "provider_amount_currency", "resolved", "note", "detected_at"}),
+	}).Create(discrepancy)
+	return result.Error
+}
+
+// ResolveDiscrepanciesRepo marks the open discrepancies of a payment resolved.
+func ResolveDiscrepanciesRepo(paymentID int) error {
+	result := db.Model(&PaymentDiscrepancy{}).
+		Where("payment_id = ? AND resolved = ?", paymentID, false).
+		Update("resolved", true)
+	return result.Error
+}
+
+func GetDiscrepanciesRepo(resolved *bool) ([]PaymentDiscrepancy, error) {
+	var discrepancies []PaymentDiscrepancy
+	query := db.Order("detected_at DESC")
+	if resolved != nil {
+		query = query.Where("resolved = ?", *resolved)
+	}
+	result := query.Find(&discrepancies)
+	return discrepancies, result.Error
+}
+
+// PostLedgerEntryRepo stores a balanced entry with its lines. An entry whose
+// reference is already in the ledger is not posted again; posted reports
+// whether the entry is new.
+func PostLedgerEntryRepo(entry *LedgerEntry) (posted bool, err error) {
+	err = db.Transaction(func(tx *gorm.DB) error {
+		posted, err = postLedgerEntry(tx, entry)
+		return err
+	})
+	return posted, err
+}
+
+func postLedgerEntry(tx *gorm.DB, entry *LedgerEntry) (bool, error) {
+	if err := entry.checkBalanced(); err != nil {
+		return false, err
+	}
+	result := tx.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "reference"}},
+		DoNothing: true,
+	}).Omit("Lines").Create(entry)
+	if result.Error != nil || result.RowsAffected == 0 {
+		return false, result.Error
+	}
+	for i := range entry.Lines {
+		entry.Lines[i].EntryID = entry.ID
+	}
+	if err := tx.Create(&entry.Lines).Error; err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// PostChargebackRepo posts a chargeback of a payment to the ledger. The
+// payment is locked, so chargebacks can never together exceed the captured
+// amount minus refunds and earlier chargebacks. A chargeback whose reference
+// is already in the ledger returns the posted entry with posted false.
+func PostChargebackRepo(paymentID int, request ChargebackRequest) (entry *LedgerEntry, posted bool, err error) {
+	err = db.Transaction(func(tx *gorm.DB) error {
+		var payment Payment
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&payment, paymentID).Error; err != nil {
+			return err
+		}
+		if payment.CapturedAmount.Amount <= 0 {
+			return ErrNothingCaptured
+		}
+		if request.Amount.Currency == "" {
+			request.Amount.Currency = payment.CapturedAmount.Currency
+		}
+		entry = chargebackEntry(&payment, request)
+
+		var existing LedgerEntry
+		err := tx.Preload("Lines").Where("reference = ?", entry.Reference).First(&existing).Error
+		if err == nil {
+			entry = &existing
+			return nil
+		}
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+
+		refunded, err := refundedAmount(tx, &payment, "pending", "successful")
+		if err != nil {
+			return err
+		}
+		chargedBack, err := chargedBackAmount(tx, &payment)
+		if err != nil {
+			return err
+		}
+		left := payment.CapturedAmount.Sub(refunded).Sub(chargedBack)
+		if !request.Amount.SameCurrency(left) {
+			return fmt.Errorf("%w: requested %s, captured %s", ErrCurrencyMismatch, request.Amount.Currency, left.Currency)
+		}
+		if request.Amount.Amount > left.Amount {
+			return fmt.Errorf("%w: requested %s, left %s", ErrChargebackTooLarge, request.Amount, left)
+		}
+		posted, err = postLedgerEntry(tx, entry)
+		return err
+	})
+	return entry, posted, err
+}
+
+// chargedBackAmount sums the chargebacks of a payment posted to the ledger.
+func chargedBackAmount(tx *gorm.DB, payment *Payment) (Money, error) {
+	total := Money{Currency: payment.CapturedAmount.Currency}
+	err := tx.Model(&LedgerLine{}).
+		Joins("JOIN ledger_entries ON ledger_entries.id = ledger_lines.entry_id").
+		Where("ledger_entries.payment_id = ? AND ledger_entries.kind = ? AND ledger_lines.account = ?",
+			payment.ID, "chargeback", AccountChargebacks).
+		Select("COALESCE(SUM(ledger_lines.debit), 0)").
+		Scan(&total.Amount).Error
+	return total, err
+}
+
+// GetAccountBalancesRepo sums the ledger lines per account and currency.
+// Empty filters match every account or currency.
+func GetAccountBalancesRepo(account, currency string) ([]AccountBalance, error) {
+	var balances []AccountBalance
+	query := db.Model(&LedgerLine{}).
+		Select("account, currency, SUM(debit) AS debit, SUM(credit) AS credit, SUM(debit) - SUM(credit) AS balance").
+		Group("account, currency").
+		Order("account, currency")
+	if account != "" {
+		query = query.Where("account = ?", account)
+	}
+	if currency != "" {
+		query = query.Where("currency = ?", currency)
+	}
+	result := query.Scan(&balances)
+	return balances, result.Error
+}
+
+// GetJournalRepo returns ledger entries with their lines, newest first.
+// Zero or empty filters match every payment or account.
+func GetJournalRepo(paymentID int, account string, limit int) ([]LedgerEntry, error) {
+	var entries []LedgerEntry
+	query := db.Preload("Lines").Order("id DESC").Limit(limit)
+	if paymentID != 0 {
+		query = query.Where("payment_id = ?", paymentID)
+	}
+	if account != "" {
+		query = query.Where("id IN (?)", db.Model(&LedgerLine{}).Select("entry_id").Where("account = ?", account))
+	}
+	result := query.Find(&entries)
+	return entries, result.Error
+}
+
+// GetCapturedPaymentsRepo returns the payments with captured money.
+func GetCapturedPaymentsRepo() ([]Payment, error) {
+	var payments []Payment
+	result := db.Where("captured_amount_minor > 0").Order("id").Find(&payments)
+	return payments, result.Error
+}
+
+func GetSuccessfulRefundsRepo() ([]Refund, error) {
+	var refunds []Refund
+	result := db.Where("status = ?", "successful").Order("id").Find(&refunds)
+	return refunds, result.Error
+}
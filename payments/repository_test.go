@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+func TestSaveDiscrepancyTwice(t *testing.T) {
+	table := useFakeUpsertTable(t, "payment_discrepancies", "payment_id", "kind")
+
+	first := &PaymentDiscrepancy{
+		PaymentID:      7,
+		InvoiceID:      "000100007",
+		Kind:           "amount_mismatch",
+		LocalStatus:    "successful",
+		ProviderStatus: "successful",
+		LocalAmount:    Money{Amount: 100050, Currency: "KZT"},
+		ProviderAmount: Money{Amount: 100000, Currency: "KZT"},
+		Note:           "amounts differ",
+		DetectedAt:     time.Unix(1700000000, 0),
+	}
+	if err := SaveDiscrepancyRepo(first); err != nil {
+		t.Fatal(err)
+	}
+	second := *first
+	second.ID = 0
+	second.ProviderAmount = Money{Amount: 90000, Currency: "USD"}
+	second.Resolved = true
+	second.Note = "amounts still differ"
+	second.DetectedAt = time.Unix(1700003600, 0)
+	if err := SaveDiscrepancyRepo(&second); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(table.rows) != 1 {
+		t.Fatalf("%d rows, want 1", len(table.rows))
+	}
+	row := table.rows["7/amount_mismatch"]
+	want := map[string]driver.Value{
+		"provider_amount_minor":    int64(90000),
+		"provider_amount_currency": "USD",
+		"local_amount_minor":       int64(100050),
+		"resolved":                 true,
+		"note":                     "amounts still differ",
+	}
+	for column, value := range want {
+		if row[column] != value {
+			t.Errorf("%s = %v, want %v", column, row[column], value)
+		}
+	}
+	if !row["detected_at"].(time.Time).Equal(second.DetectedAt) {
+		t.Errorf("detected_at = %v, want %v", row["detected_at"], second.DetectedAt)
+	}
+	if second.ID != first.ID {
+		t.Errorf("second save returned ID %d, want %d", second.ID, first.ID)
+	}
+}
+
+// fakeUpsertTable is a table that understands the INSERT ... ON CONFLICT ...
+// DO UPDATE statements GORM builds. Like PostgreSQL, it refuses to update a
+// column the table does not have.
+type fakeUpsertTable struct {
+	mu     sync.Mutex
+	name   string
+	key    []string
+	rows   map[string]map[string]driver.Value
+	nextID int64
+}
+
+var upsertPattern = regexp.MustCompile(`^INSERT INTO "(\w+)" \(([^)]*)\) VALUES \(([^)]*)\) ON CONFLICT \(([^)]*)\) DO UPDATE SET (.*) RETURNING "id"$`)
+
+func (t *fakeUpsertTable) upsert(query string, args []driver.NamedValue) (int64, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	m := upsertPattern.FindStringSubmatch(query)
+	if m == nil || m[1] != t.name {
+		return 0, fmt.Errorf("fake database: unexpected query %s", query)
+	}
+	columns := strings.Split(strings.ReplaceAll(m[2], `"`, ""), ",")
+	row := map[string]driver.Value{}
+	for i, column := range columns {
+		row[column] = args[i].Value
+	}
+	keyValues := make([]string, len(t.key))
+	for i, column := range t.key {
+		keyValues[i] = fmt.Sprint(row[column])
+	}
+	key := strings.Join(keyValues, "/")
+
+	existing, ok := t.rows[key]
+	if !ok {
+		t.nextID++
+		row["id"] = t.nextID
+		t.rows[key] = row
+		return t.nextID, nil
+	}
+	for _, assignment := range strings.Split(m[5], ",") {
+		column, _, _ := strings.Cut(assignment, "=")
+		column = strings.Trim(column, `"`)
+		value, ok := row[column]
+		if !ok {
+			return 0, fmt.Errorf(`fake database: column "%s" of relation "%s" does not exist`, column, t.name)
+		}
+		existing[column] = value
+	}
+	return existing["id"].(int64), nil
+}
+
+// useFakeUpsertTable points db at a fakeUpsertTable for the duration of a
+// test.
+func useFakeUpsertTable(t *testing.T, name string, key ...string) *fakeUpsertTable {
+	t.Helper()
+	table := &fakeUpsertTable{name: name, key: key, rows: map[string]map[string]driver.Value{}}
+	fake, err := gorm.Open(postgres.New(postgres.Config{Conn: sql.OpenDB(fakeConnector{table})}), &gorm.Config{
+		DisableAutomaticPing:   true,
+		SkipDefaultTransaction: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := db
+	t.Cleanup(func() { db = saved })
+	db = fake
+	return table
+}
+
+type fakeConnector struct{ table *fakeUpsertTable }
+
+func (c fakeConnector) Connect(context.Context) (driver.Conn, error) { return fakeConn(c), nil }
+func (c fakeConnector) Driver() driver.Driver                        { return nil }
+
+type fakeConn struct{ table *fakeUpsertTable }
+
+func (c fakeConn) QueryContext(_ context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	id, err := c.table.upsert(query, args)
+	if err != nil {
+		return nil, err
+	}
+	return &idRows{id: id}, nil
+}
+
+func (c fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return nil, fmt.Errorf("fake database: unexpected query %s", query)
+}
+func (c fakeConn) Close() error { return nil }
+func (c fakeConn) Begin() (driver.Tx, error) {
+	return nil, errors.New("fake database: no transactions")
+}
+
+// idRows is the result of RETURNING "id".
+type idRows struct {
+	id   int64
+	done bool
+}
+
+func (r *idRows) Columns() []string { return []string{"id"} }
+func (r *idRows) Close() error      { return nil }
+func (r *idRows) Next(dest []driver.Value) error {
+	if r.done {
+		return io.EOF
+	}
+	r.done = true
+	dest[0] = r.id
+	return nil
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+)
+
+// User is the subset of the users service model the payments service needs.
+type User struct {
+	ID    uint   `json:"id"`
+	Name  string `json:"name"`
+	Email string `json:"email"`
+	Phone string `json:"phone"`
+}
+
+func usersServiceURL() string {
+	if url := os.Getenv("USERS_SERVICE_URL"); url != "" {
+		return url
+	}
+	return "http://user-service:8081"
+}
+
+func getUser(userID int) (*User, error) {
+	resp, err := serviceClient.Get(fmt.Sprintf("%s/users/%d", usersServiceURL(), userID))
+	if err != nil {
+		return nil, fmt.Errorf("users service: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("%w: user %d", ErrNotFound, userID)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("users service: unexpected status %s", resp.Status)
+	}
+
+	var user User
+	if err := json.NewDecoder(resp.Body).Decode(&user); err != nil {
+		return nil, fmt.Errorf("users service: %w", err)
+	}
+	return &user, nil
+}
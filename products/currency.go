@@ -0,0 +1,165 @@
+package main
+
+import (
+	"HL_online_shop/common/money"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"regexp"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrNoRate          = errors.New("no exchange rate")
+	ErrNotBaseCurrency = errors.New("price must be in the base currency")
+)
+
+// ratePattern accepts plain decimals only, not the fractions and exponents
+// big.Rat would also parse.
+var ratePattern = regexp.MustCompile(`^\d{1,12}(\.\d{1,12})?$`)
+
+func init() {
+	validate.RegisterValidation("rate", func(fl validator.FieldLevel) bool {
+		_, err := parseRate(fl.Field().String())
+		return err == nil
+	})
+}
+
+// parseRate parses a positive decimal exchange rate exactly.
+func parseRate(s string) (*big.Rat, error) {
+	if !ratePattern.MatchString(s) {
+		return nil, fmt.Errorf("invalid rate %q", s)
+	}
+	rate, ok := new(big.Rat).SetString(s)
+	if !ok || rate.Sign() <= 0 {
+		return nil, fmt.Errorf("invalid rate %q, must be a positive decimal", s)
+	}
+	return rate, nil
+}
+
+// AfterFind drops the trailing zeros postgres pads numeric values with.
+func (r *CurrencyRate) AfterFind(tx *gorm.DB) error {
+	if strings.Contains(r.Rate, ".") {
+		r.Rate = strings.TrimSuffix(strings.TrimRight(r.Rate, "0"), ".")
+	}
+	return nil
+}
+
+// convertMoney converts an amount at rate, rounding half to even to the minor
+// units of currency.
+func convertMoney(m Money, rate *big.Rat, currency string) Money {
+	amount := new(big.Rat).SetInt64(m.Amount)
+	amount.Mul(amount, rate)
+
+	shift := money.Digits(currency) - money.Digits(m.Currency)
+	scale := new(big.Rat).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(abs(shift))), nil))
+	if shift >= 0 {
+		amount.Mul(amount, scale)
+	} else {
+		amount.Quo(amount, scale)
+	}
+	return Money{Amount: roundRatHalfEven(amount), Currency: currency}
+}
+
+func roundRatHalfEven(r *big.Rat) int64 {
+	quotient, remainder := new(big.Int).QuoRem(r.Num(), r.Denom(), new(big.Int))
+	twice := new(big.Int).Abs(remainder)
+	twice.Lsh(twice, 1)
+	if c := twice.Cmp(r.Denom()); c > 0 || c == 0 && quotient.Bit(0) == 1 {
+		quotient.Add(quotient, big.NewInt(int64(r.Sign())))
+	}
+	return quotient.Int64()
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// quotePrice prices a product in currency: the base price in the base
+// currency, otherwise the product's own price in the currency if it has one,
+// otherwise the base price converted at the current rate.
+func quotePrice(product *Product, currency string) (*PriceQuote, error) {
+	quote := &PriceQuote{ProductID: product.ID, BasePrice: product.Price}
+	if currency == product.Price.Currency {
+		quote.Price = product.Price
+		quote.Source = "base"
+		return quote, nil
+	}
+
+	override, err := GetProductPriceRepo(product.ID, currency)
+	if err == nil {
+		quote.Price = override.Price
+		quote.Source = "override"
+		return quote, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	rate, err := GetCurrencyRateRepo(currency)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, fmt.Errorf("%w for %s", ErrNoRate, currency)
+	}
+	if err != nil {
+		return nil, err
+	}
+	value, err := parseRate(rate.Rate)
+	if err != nil {
+		return nil, err
+	}
+	quote.Price = convertMoney(product.Price, value, currency)
+	quote.Source = "converted"
+	quote.Rate = rate.Rate
+	quote.RateUpdatedAt = &rate.UpdatedAt
+	return quote, nil
+}
+
+// parseRatesCSV reads currency,rate lines. A header line is skipped.
+func parseRatesCSV(r io.Reader) ([]CurrencyRate, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = 2
+	reader.TrimLeadingSpace = true
+
+	var rates []CurrencyRate
+	for line := 1; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if line == 1 && strings.EqualFold(record[0], "currency") {
+			continue
+		}
+		rate := CurrencyRate{Currency: strings.ToUpper(strings.TrimSpace(record[0])), Rate: strings.TrimSpace(record[1])}
+		if err := validateRate(rate); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		rates = append(rates, rate)
+	}
+	if len(rates) == 0 {
+		return nil, errors.New("no rates in the file")
+	}
+	return rates, nil
+}
+
+// validateRate checks a rate before it is saved. The base currency has no
+// rate, it is always 1.
+func validateRate(rate CurrencyRate) error {
+	if err := validate.Struct(rate); err != nil {
+		return err
+	}
+	if rate.Currency == money.ShopCurrency() {
+		return fmt.Errorf("%s is the base currency and has no rate", rate.Currency)
+	}
+	return nil
+}
@@ -19,6 +19,164 @@ const docTemplate = `{
     "host": "{{.Host}}",
     "basePath": "{{.BasePath}}",
     "paths": {
+        "/currency-rates": {
+            "get": {
+                "description": "Get how many units of each currency one unit of the base currency is worth",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "currency-rates"
+                ],
+                "summary": "Get the exchange rates",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/main.CurrencyRate"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/currency-rates/import": {
+            "post": {
+                "description": "Create or replace exchange rates from a CSV file with currency,rate lines, e.g. USD,0.0021. A header line is allowed. Either every rate of the file is saved or none is.",
+                "consumes": [
+                    "text/csv"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "currency-rates"
+                ],
+                "summary": "Import exchange rates from CSV",
+                "parameters": [
+                    {
+                        "description": "CSV file",
+                        "name": "rates",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/main.CurrencyRate"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid line",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "403": {
+                        "description": "Only admins",
+                        "schema": {
+                            "$ref": "#/definitions/access.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/currency-rates/{currency}": {
+            "put": {
+                "description": "Set how many units of a currency one unit of the base currency is worth. Orders placed before keep the rate they were priced with.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "currency-rates"
+                ],
+                "summary": "Set an exchange rate",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Currency code",
+                        "name": "currency",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Rate",
+                        "name": "rate",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.CurrencyRate"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.CurrencyRate"
+                        }
+                    },
+                    "403": {
+                        "description": "Only admins",
+                        "schema": {
+                            "$ref": "#/definitions/access.ErrorResponse"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "description": "Delete an exchange rate. Products without a price in the currency can no longer be ordered in it.",
+                "produces": [
+                    "text/plain"
+                ],
+                "tags": [
+                    "currency-rates"
+                ],
+                "summary": "Delete an exchange rate",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Currency code",
+                        "name": "currency",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Deleted",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "403": {
+                        "description": "Only admins",
+                        "schema": {
+                            "$ref": "#/definitions/access.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Rate not found",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
         "/health": {
             "get": {
                 "description": "Check the health of the service",
@@ -90,6 +248,12 @@ const docTemplate = `{
                         "schema": {
                             "$ref": "#/definitions/main.Product"
                         }
+                    },
+                    "403": {
+                        "description": "Only admins",
+                        "schema": {
+                            "$ref": "#/definitions/access.ErrorResponse"
+                        }
                     }
                 }
             }
@@ -158,6 +322,12 @@ const docTemplate = `{
                         "schema": {
                             "$ref": "#/definitions/main.Product"
                         }
+                    },
+                    "403": {
+                        "description": "Only admins",
+                        "schema": {
+                            "$ref": "#/definitions/access.ErrorResponse"
+                        }
                     }
                 }
             },
@@ -185,87 +355,688 @@ const docTemplate = `{
                         "schema": {
                             "type": "string"
                         }
+                    },
+                    "403": {
+                        "description": "Only admins",
+                        "schema": {
+                            "$ref": "#/definitions/access.ErrorResponse"
+                        }
                     }
                 }
             }
         },
-        "/search/products": {
+        "/products/{id}/price": {
             "get": {
-                "description": "Search products by name or category",
+                "description": "Get the price of a product in a currency: the product's own price in that currency if it has one, otherwise the base price converted at the current rate, rounded half to even.",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "products"
+                    "prices"
                 ],
-                "summary": "Search products by name or category",
+                "summary": "Get the price of a product in a currency",
                 "parameters": [
                     {
-                        "type": "string",
-                        "description": "Product Name",
-                        "name": "name",
-                        "in": "query"
+                        "type": "integer",
+                        "description": "Product ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
                     },
                     {
                         "type": "string",
-                        "description": "Product Category",
-                        "name": "category",
+                        "description": "Currency code, the base currency by default",
+                        "name": "currency",
                         "in": "query"
                     }
                 ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.PriceQuote"
+                        }
+                    },
+                    "404": {
+                        "description": "Product not found",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "422": {
+                        "description": "No exchange rate for the currency",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/products/{id}/prices": {
+            "get": {
+                "description": "Get the prices of a product set in currencies other than the base currency",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "prices"
+                ],
+                "summary": "Get the price overrides of a product",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Product ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
                             "type": "array",
                             "items": {
-                                "$ref": "#/definitions/main.Product"
+                                "$ref": "#/definitions/main.ProductPrice"
                             }
                         }
                     }
                 }
             }
-        }
-    },
-    "definitions": {
-        "main.Product": {
-            "type": "object",
-            "required": [
-                "category",
-                "name",
-                "price"
-            ],
-            "properties": {
-                "category": {
-                    "type": "string",
-                    "example": "Electronics"
-                },
-                "created_at": {
-                    "type": "string",
-                    "readOnly": true,
-                    "example": "2023-07-20T15:04:05Z"
-                },
-                "description": {
-                    "type": "string",
-                    "example": "A high-performance laptop"
-                },
-                "id": {
-                    "type": "integer",
-                    "readOnly": true,
-                    "example": 1
-                },
-                "name": {
-                    "type": "string",
-                    "example": "Laptop"
-                },
-                "price": {
-                    "type": "number",
-                    "example": 1000.5
-                },
-                "stock": {
-                    "type": "integer",
-                    "minimum": 0,
-                    "example": 50
+        },
+        "/products/{id}/prices/{currency}": {
+            "put": {
+                "description": "Set the price of a product in a currency other than the base currency. It is charged instead of the converted base price.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "prices"
+                ],
+                "summary": "Set the price of a product in a currency",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Product ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Currency code",
+                        "name": "currency",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Price in minor units",
+                        "name": "price",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.Money"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.ProductPrice"
+                        }
+                    },
+                    "403": {
+                        "description": "Only admins",
+                        "schema": {
+                            "$ref": "#/definitions/access.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Product not found",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "description": "Delete a price override, so the base price is converted again",
+                "produces": [
+                    "text/plain"
+                ],
+                "tags": [
+                    "prices"
+                ],
+                "summary": "Delete the price of a product in a currency",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Product ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Currency code",
+                        "name": "currency",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Deleted",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "403": {
+                        "description": "Only admins",
+                        "schema": {
+                            "$ref": "#/definitions/access.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Price not found",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/reservations": {
+            "post": {
+                "description": "Reserve stock for all items of an order. The reservation expires unless it is committed in time.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "reservations"
+                ],
+                "summary": "Reserve stock for an order",
+                "parameters": [
+                    {
+                        "description": "Reservation",
+                        "name": "reservation",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.ReservationRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/main.StockReservation"
+                            }
+                        }
+                    },
+                    "403": {
+                        "description": "Only services and admins",
+                        "schema": {
+                            "$ref": "#/definitions/access.ErrorResponse"
+                        }
+                    },
+                    "409": {
+                        "description": "Insufficient stock",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "422": {
+                        "description": "Product not found",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/reservations/{order_id}": {
+            "get": {
+                "description": "Get the stock reservations of an order",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "reservations"
+                ],
+                "summary": "Get the stock reservations of an order",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Order ID",
+                        "name": "order_id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/main.StockReservation"
+                            }
+                        }
+                    },
+                    "403": {
+                        "description": "Only services and admins",
+                        "schema": {
+                            "$ref": "#/definitions/access.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/reservations/{order_id}/commit": {
+            "post": {
+                "description": "Make the stock decrement of an order final, e.g. after a successful payment",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "reservations"
+                ],
+                "summary": "Commit the stock reservations of an order",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Order ID",
+                        "name": "order_id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/main.StockReservation"
+                            }
+                        }
+                    },
+                    "403": {
+                        "description": "Only services and admins",
+                        "schema": {
+                            "$ref": "#/definitions/access.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "No reservations",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "409": {
+                        "description": "Insufficient stock",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/reservations/{order_id}/release": {
+            "post": {
+                "description": "Return the reserved stock of an order, e.g. after it was cancelled",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "reservations"
+                ],
+                "summary": "Release the stock reservations of an order",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Order ID",
+                        "name": "order_id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/main.StockReservation"
+                            }
+                        }
+                    },
+                    "403": {
+                        "description": "Only services and admins",
+                        "schema": {
+                            "$ref": "#/definitions/access.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/reservations/{order_id}/return": {
+            "post": {
+                "description": "Put the stock of a paid order back, e.g. after it was refunded or its payment voided",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "reservations"
+                ],
+                "summary": "Return the committed stock of an order",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Order ID",
+                        "name": "order_id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/main.StockReservation"
+                            }
+                        }
+                    },
+                    "403": {
+                        "description": "Only services and admins",
+                        "schema": {
+                            "$ref": "#/definitions/access.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "No reservations",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/search/products": {
+            "get": {
+                "description": "Search products by name or category",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "products"
+                ],
+                "summary": "Search products by name or category",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Product Name",
+                        "name": "name",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Product Category",
+                        "name": "category",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/main.Product"
+                            }
+                        }
+                    }
+                }
+            }
+        }
+    },
+    "definitions": {
+        "access.ErrorResponse": {
+            "type": "object",
+            "properties": {
+                "error": {
+                    "type": "string",
+                    "example": "forbidden"
+                },
+                "message": {
+                    "type": "string",
+                    "example": "only admins may DELETE /products/1"
+                }
+            }
+        },
+        "main.CurrencyRate": {
+            "type": "object",
+            "required": [
+                "currency",
+                "rate"
+            ],
+            "properties": {
+                "currency": {
+                    "type": "string",
+                    "example": "USD"
+                },
+                "rate": {
+                    "type": "string",
+                    "example": "0.0021"
+                },
+                "updated_at": {
+                    "type": "string",
+                    "readOnly": true,
+                    "example": "2023-07-20T15:04:05Z"
+                }
+            }
+        },
+        "main.Money": {
+            "type": "object",
+            "properties": {
+                "amount": {
+                    "type": "integer",
+                    "minimum": 0,
+                    "example": 100050
+                },
+                "currency": {
+                    "type": "string",
+                    "example": "KZT"
+                }
+            }
+        },
+        "main.PriceQuote": {
+            "type": "object",
+            "properties": {
+                "base_price": {
+                    "$ref": "#/definitions/main.Money"
+                },
+                "price": {
+                    "$ref": "#/definitions/main.Money"
+                },
+                "product_id": {
+                    "type": "integer",
+                    "example": 1
+                },
+                "rate": {
+                    "type": "string",
+                    "example": "0.0021"
+                },
+                "rate_updated_at": {
+                    "type": "string",
+                    "example": "2023-07-20T15:04:05Z"
+                },
+                "source": {
+                    "description": "Source tells where the price comes from: the base price itself, a\nProductPrice override, or the base price converted at Rate.",
+                    "type": "string",
+                    "enum": [
+                        "base",
+                        "override",
+                        "converted"
+                    ],
+                    "example": "converted"
+                }
+            }
+        },
+        "main.Product": {
+            "type": "object",
+            "required": [
+                "category",
+                "name"
+            ],
+            "properties": {
+                "category": {
+                    "type": "string",
+                    "example": "Electronics"
+                },
+                "created_at": {
+                    "type": "string",
+                    "readOnly": true,
+                    "example": "2023-07-20T15:04:05Z"
+                },
+                "description": {
+                    "type": "string",
+                    "example": "A high-performance laptop"
+                },
+                "id": {
+                    "type": "integer",
+                    "readOnly": true,
+                    "example": 1
+                },
+                "name": {
+                    "type": "string",
+                    "example": "Laptop"
+                },
+                "price": {
+                    "$ref": "#/definitions/main.Money"
+                },
+                "stock": {
+                    "type": "integer",
+                    "minimum": 0,
+                    "example": 50
+                }
+            }
+        },
+        "main.ProductPrice": {
+            "type": "object",
+            "properties": {
+                "id": {
+                    "type": "integer",
+                    "readOnly": true,
+                    "example": 1
+                },
+                "price": {
+                    "$ref": "#/definitions/main.Money"
+                },
+                "product_id": {
+                    "type": "integer",
+                    "readOnly": true,
+                    "example": 1
+                },
+                "updated_at": {
+                    "type": "string",
+                    "readOnly": true,
+                    "example": "2023-07-20T15:04:05Z"
+                }
+            }
+        },
+        "main.ReservationItem": {
+            "type": "object",
+            "required": [
+                "product_id",
+                "quantity"
+            ],
+            "properties": {
+                "product_id": {
+                    "type": "integer",
+                    "example": 1
+                },
+                "quantity": {
+                    "type": "integer",
+                    "example": 2
+                }
+            }
+        },
+        "main.ReservationRequest": {
+            "type": "object",
+            "required": [
+                "items",
+                "order_id"
+            ],
+            "properties": {
+                "items": {
+                    "type": "array",
+                    "minItems": 1,
+                    "items": {
+                        "$ref": "#/definitions/main.ReservationItem"
+                    }
+                },
+                "order_id": {
+                    "type": "integer",
+                    "example": 1
+                }
+            }
+        },
+        "main.StockReservation": {
+            "type": "object",
+            "properties": {
+                "created_at": {
+                    "type": "string",
+                    "example": "2023-07-20T15:04:05Z"
+                },
+                "expires_at": {
+                    "type": "string",
+                    "example": "2023-07-20T15:19:05Z"
+                },
+                "id": {
+                    "type": "integer",
+                    "readOnly": true,
+                    "example": 1
+                },
+                "order_id": {
+                    "type": "integer",
+                    "example": 1
+                },
+                "product_id": {
+                    "type": "integer",
+                    "example": 1
+                },
+                "quantity": {
+                    "type": "integer",
+                    "example": 2
+                },
+                "status": {
+                    "type": "string",
+                    "enum": [
+                        "reserved",
+                        "committed",
+                        "released",
+                        "expired",
+                        "returned"
+                    ],
+                    "example": "reserved"
+                },
+                "updated_at": {
+                    "type": "string",
+                    "example": "2023-07-20T15:04:05Z"
                 }
             }
         }
@@ -3,9 +3,9 @@ module HL_online_shop
 go 1.21.6
 
 require (
+	HL_online_shop/common v0.0.0
 	github.com/go-playground/validator/v10 v10.22.0
 	github.com/gorilla/mux v1.8.1
-	github.com/joho/godotenv v1.5.1
 	github.com/swaggo/http-swagger v1.3.4
 	github.com/swaggo/swag v1.16.3
 	gorm.io/driver/postgres v1.5.9
@@ -40,3 +40,5 @@ require (
 	golang.org/x/tools v0.7.0 // indirect
 	gopkg.in/yaml.v2 v2.4.0 // indirect
 )
+
+replace HL_online_shop/common => ../common
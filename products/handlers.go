@@ -1,12 +1,17 @@
 package main
 
 import (
+	"HL_online_shop/common/access"
+	"HL_online_shop/common/money"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"github.com/go-playground/validator/v10"
 	"github.com/gorilla/mux"
 	"gorm.io/gorm"
 	"net/http"
 	"strconv"
+	"strings"
 )
 
 var validate = *validator.New()
@@ -51,19 +56,28 @@ func GetProducts(w http.ResponseWriter, r *http.Request) {
 // @Produce json
 // @Param product body Product true "Create product"
 // @Success 201 {object} Product
+// @Failure 403 {object} access.ErrorResponse "Only admins"
 // @Router /products [post]
 func CreateProduct(w http.ResponseWriter, r *http.Request) {
+	if !access.RequireAdmin(w, r) {
+		return
+	}
 	var product Product
 	if err := json.NewDecoder(r.Body).Decode(&product); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	product.Price = product.Price.WithDefaultCurrency()
 	err := validate.Struct(product)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 
 	}
+	if product.Price.Currency != money.ShopCurrency() {
+		http.Error(w, fmt.Sprintf("%v %s, set prices in other currencies with /products/{id}/prices", ErrNotBaseCurrency, money.ShopCurrency()), http.StatusBadRequest)
+		return
+	}
 	if err := CreateProductRepo(&product); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -108,8 +122,12 @@ func GetProduct(w http.ResponseWriter, r *http.Request) {
 // @Param id path int true "Product ID"
 // @Param product body Product true "Update product"
 // @Success 200 {object} Product
+// @Failure 403 {object} access.ErrorResponse "Only admins"
 // @Router /products/{id} [put]
 func UpdateProduct(w http.ResponseWriter, r *http.Request) {
+	if !access.RequireAdmin(w, r) {
+		return
+	}
 	params := mux.Vars(r)
 	id, err := strconv.Atoi(params["id"])
 	if err != nil {
@@ -122,12 +140,17 @@ func UpdateProduct(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	product.Price = product.Price.WithDefaultCurrency()
 	err = validate.Struct(product)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 
 	}
+	if product.Price.Currency != money.ShopCurrency() {
+		http.Error(w, fmt.Sprintf("%v %s, set prices in other currencies with /products/{id}/prices", ErrNotBaseCurrency, money.ShopCurrency()), http.StatusBadRequest)
+		return
+	}
 	product.ID = uint(id)
 	if err := UpdateProductRepo(&product); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -143,8 +166,12 @@ func UpdateProduct(w http.ResponseWriter, r *http.Request) {
 // @Produce plain
 // @Param id path int true "Product ID"
 // @Success 200 {string} string "Deleted"
+// @Failure 403 {object} access.ErrorResponse "Only admins"
 // @Router /products/{id} [delete]
 func DeleteProduct(w http.ResponseWriter, r *http.Request) {
+	if !access.RequireAdmin(w, r) {
+		return
+	}
 	params := mux.Vars(r)
 	id, err := strconv.Atoi(params["id"])
 	if err != nil {
@@ -182,3 +209,431 @@ func SearchProducts(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(products)
 	w.WriteHeader(http.StatusOK)
 }
+
+// GetProductPrice godoc
+// @Summary Get the price of a product in a currency
+// @Description Get the price of a product in a currency: the product's own price in that currency if it has one, otherwise the base price converted at the current rate, rounded half to even.
+// @Tags prices
+// @Produce json
+// @Param id path int true "Product ID"
+// @Param currency query string false "Currency code, the base currency by default"
+// @Success 200 {object} PriceQuote
+// @Failure 404 {string} string "Product not found"
+// @Failure 422 {string} string "No exchange rate for the currency"
+// @Router /products/{id}/price [get]
+func GetProductPrice(w http.ResponseWriter, r *http.Request) {
+	product, ok := productFromPath(w, r)
+	if !ok {
+		return
+	}
+	currency := strings.ToUpper(r.URL.Query().Get("currency"))
+	if currency == "" {
+		currency = product.Price.Currency
+	}
+	if err := validate.Var(currency, "iso4217"); err != nil {
+		http.Error(w, "Invalid currency", http.StatusBadRequest)
+		return
+	}
+
+	quote, err := quotePrice(product, currency)
+	if err != nil {
+		if errors.Is(err, ErrNoRate) {
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	json.NewEncoder(w).Encode(quote)
+}
+
+// GetProductPrices godoc
+// @Summary Get the price overrides of a product
+// @Description Get the prices of a product set in currencies other than the base currency
+// @Tags prices
+// @Produce json
+// @Param id path int true "Product ID"
+// @Success 200 {array} ProductPrice
+// @Router /products/{id}/prices [get]
+func GetProductPrices(w http.ResponseWriter, r *http.Request) {
+	product, ok := productFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	prices, err := GetProductPricesRepo(product.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(prices)
+}
+
+// SetProductPrice godoc
+// @Summary Set the price of a product in a currency
+// @Description Set the price of a product in a currency other than the base currency. It is charged instead of the converted base price.
+// @Tags prices
+// @Accept json
+// @Produce json
+// @Param id path int true "Product ID"
+// @Param currency path string true "Currency code"
+// @Param price body Money true "Price in minor units"
+// @Success 200 {object} ProductPrice
+// @Failure 404 {string} string "Product not found"
+// @Failure 403 {object} access.ErrorResponse "Only admins"
+// @Router /products/{id}/prices/{currency} [put]
+func SetProductPrice(w http.ResponseWriter, r *http.Request) {
+	if !access.RequireAdmin(w, r) {
+		return
+	}
+	product, ok := productFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	var price ProductPrice
+	if err := json.NewDecoder(r.Body).Decode(&price.Price); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	currency := strings.ToUpper(mux.Vars(r)["currency"])
+	if price.Price.Currency != "" && price.Price.Currency != currency {
+		http.Error(w, "Currency of the price does not match the URL", http.StatusBadRequest)
+		return
+	}
+	price.Price.Currency = currency
+	price.ProductID = product.ID
+	if err := validate.Struct(price); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if currency == product.Price.Currency {
+		http.Error(w, "The base price is set with PUT /products/{id}", http.StatusBadRequest)
+		return
+	}
+
+	if err := SetProductPriceRepo(&price); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(price)
+}
+
+// DeleteProductPrice godoc
+// @Summary Delete the price of a product in a currency
+// @Description Delete a price override, so the base price is converted again
+// @Tags prices
+// @Produce plain
+// @Param id path int true "Product ID"
+// @Param currency path string true "Currency code"
+// @Success 200 {string} string "Deleted"
+// @Failure 404 {string} string "Price not found"
+// @Failure 403 {object} access.ErrorResponse "Only admins"
+// @Router /products/{id}/prices/{currency} [delete]
+func DeleteProductPrice(w http.ResponseWriter, r *http.Request) {
+	if !access.RequireAdmin(w, r) {
+		return
+	}
+	product, ok := productFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	currency := strings.ToUpper(mux.Vars(r)["currency"])
+	if err := DeleteProductPriceRepo(product.ID, currency); err != nil {
+		if err == gorm.ErrRecordNotFound {
+			http.Error(w, "Price not found", http.StatusNotFound)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	json.NewEncoder(w).Encode("Deleted")
+}
+
+func productFromPath(w http.ResponseWriter, r *http.Request) (*Product, bool) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid product ID", http.StatusBadRequest)
+		return nil, false
+	}
+	product, err := GetProductByIDRepo(uint(id))
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			http.Error(w, "Product not found", http.StatusNotFound)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return nil, false
+	}
+	return product, true
+}
+
+// GetCurrencyRates godoc
+// @Summary Get the exchange rates
+// @Description Get how many units of each currency one unit of the base currency is worth
+// @Tags currency-rates
+// @Produce json
+// @Success 200 {array} CurrencyRate
+// @Router /currency-rates [get]
+func GetCurrencyRates(w http.ResponseWriter, r *http.Request) {
+	rates, err := GetCurrencyRatesRepo()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(rates)
+}
+
+// SetCurrencyRate godoc
+// @Summary Set an exchange rate
+// @Description Set how many units of a currency one unit of the base currency is worth. Orders placed before keep the rate they were priced with.
+// @Tags currency-rates
+// @Accept json
+// @Produce json
+// @Param currency path string true "Currency code"
+// @Param rate body CurrencyRate true "Rate"
+// @Success 200 {object} CurrencyRate
+// @Failure 403 {object} access.ErrorResponse "Only admins"
+// @Router /currency-rates/{currency} [put]
+func SetCurrencyRate(w http.ResponseWriter, r *http.Request) {
+	if !access.RequireAdmin(w, r) {
+		return
+	}
+	var rate CurrencyRate
+	if err := json.NewDecoder(r.Body).Decode(&rate); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	rate.Currency = strings.ToUpper(mux.Vars(r)["currency"])
+	if err := validateRate(rate); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	rates := []CurrencyRate{rate}
+	if err := SaveCurrencyRatesRepo(rates); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(rates[0])
+}
+
+// ImportCurrencyRates godoc
+// @Summary Import exchange rates from CSV
+// @Description Create or replace exchange rates from a CSV file with currency,rate lines, e.g. USD,0.0021. A header line is allowed. Either every rate of the file is saved or none is.
+// @Tags currency-rates
+// @Accept text/csv
+// @Produce json
+// @Param rates body string true "CSV file"
+// @Success 200 {array} CurrencyRate
+// @Failure 400 {string} string "Invalid line"
+// @Failure 403 {object} access.ErrorResponse "Only admins"
+// @Router /currency-rates/import [post]
+func ImportCurrencyRates(w http.ResponseWriter, r *http.Request) {
+	if !access.RequireAdmin(w, r) {
+		return
+	}
+	rates, err := parseRatesCSV(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := SaveCurrencyRatesRepo(rates); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(rates)
+}
+
+// DeleteCurrencyRate godoc
+// @Summary Delete an exchange rate
+// @Description Delete an exchange rate. Products without a price in the currency can no longer be ordered in it.
+// @Tags currency-rates
+// @Produce plain
+// @Param currency path string true "Currency code"
+// @Success 200 {string} string "Deleted"
+// @Failure 404 {string} string "Rate not found"
+// @Failure 403 {object} access.ErrorResponse "Only admins"
+// @Router /currency-rates/{currency} [delete]
+func DeleteCurrencyRate(w http.ResponseWriter, r *http.Request) {
+	if !access.RequireAdmin(w, r) {
+		return
+	}
+	currency := strings.ToUpper(mux.Vars(r)["currency"])
+	if err := DeleteCurrencyRateRepo(currency); err != nil {
+		if err == gorm.ErrRecordNotFound {
+			http.Error(w, "Rate not found", http.StatusNotFound)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	json.NewEncoder(w).Encode("Deleted")
+}
+
+// ReserveStock godoc
+// @Summary Reserve stock for an order
+// @Description Reserve stock for all items of an order. The reservation expires unless it is committed in time.
+// @Tags reservations
+// @Accept json
+// @Produce json
+// @Param reservation body ReservationRequest true "Reservation"
+// @Success 201 {array} StockReservation
+// @Failure 409 {string} string "Insufficient stock"
+// @Failure 422 {string} string "Product not found"
+// @Failure 403 {object} access.ErrorResponse "Only services and admins"
+// @Router /reservations [post]
+func ReserveStock(w http.ResponseWriter, r *http.Request) {
+	if !access.RequireAdmin(w, r) {
+		return
+	}
+	var req ReservationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	err := validate.Struct(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	reservations, err := ReserveStockRepo(req, reservationTTL())
+	if err != nil {
+		writeReservationError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(reservations)
+}
+
+// GetReservations godoc
+// @Summary Get the stock reservations of an order
+// @Description Get the stock reservations of an order
+// @Tags reservations
+// @Produce json
+// @Param order_id path int true "Order ID"
+// @Success 200 {array} StockReservation
+// @Failure 403 {object} access.ErrorResponse "Only services and admins"
+// @Router /reservations/{order_id} [get]
+func GetReservations(w http.ResponseWriter, r *http.Request) {
+	if !access.RequireAdmin(w, r) {
+		return
+	}
+	orderID, ok := reservationOrderID(w, r)
+	if !ok {
+		return
+	}
+
+	reservations, err := GetReservationsRepo(orderID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(reservations)
+}
+
+// CommitReservation godoc
+// @Summary Commit the stock reservations of an order
+// @Description Make the stock decrement of an order final, e.g. after a successful payment
+// @Tags reservations
+// @Produce json
+// @Param order_id path int true "Order ID"
+// @Success 200 {array} StockReservation
+// @Failure 404 {string} string "No reservations"
+// @Failure 409 {string} string "Insufficient stock"
+// @Failure 403 {object} access.ErrorResponse "Only services and admins"
+// @Router /reservations/{order_id}/commit [post]
+func CommitReservation(w http.ResponseWriter, r *http.Request) {
+	if !access.RequireAdmin(w, r) {
+		return
+	}
+	orderID, ok := reservationOrderID(w, r)
+	if !ok {
+		return
+	}
+
+	reservations, err := CommitStockRepo(orderID)
+	if err != nil {
+		writeReservationError(w, err)
+		return
+	}
+	json.NewEncoder(w).Encode(reservations)
+}
+
+// ReleaseReservation godoc
+// @Summary Release the stock reservations of an order
+// @Description Return the reserved stock of an order, e.g. after it was cancelled
+// @Tags reservations
+// @Produce json
+// @Param order_id path int true "Order ID"
+// @Success 200 {array} StockReservation
+// @Failure 403 {object} access.ErrorResponse "Only services and admins"
+// @Router /reservations/{order_id}/release [post]
+func ReleaseReservation(w http.ResponseWriter, r *http.Request) {
+	if !access.RequireAdmin(w, r) {
+		return
+	}
+	orderID, ok := reservationOrderID(w, r)
+	if !ok {
+		return
+	}
+
+	reservations, err := ReleaseStockRepo(orderID)
+	if err != nil {
+		writeReservationError(w, err)
+		return
+	}
+	json.NewEncoder(w).Encode(reservations)
+}
+
+// ReturnReservation godoc
+// @Summary Return the committed stock of an order
+// @Description Put the stock of a paid order back, e.g. after it was refunded or its payment voided
+// @Tags reservations
+// @Produce json
+// @Param order_id path int true "Order ID"
+// @Success 200 {array} StockReservation
+// @Failure 404 {string} string "No reservations"
+// @Failure 403 {object} access.ErrorResponse "Only services and admins"
+// @Router /reservations/{order_id}/return [post]
+func ReturnReservation(w http.ResponseWriter, r *http.Request) {
+	if !access.RequireAdmin(w, r) {
+		return
+	}
+	orderID, ok := reservationOrderID(w, r)
+	if !ok {
+		return
+	}
+
+	reservations, err := ReturnStockRepo(orderID)
+	if err != nil {
+		writeReservationError(w, err)
+		return
+	}
+	json.NewEncoder(w).Encode(reservations)
+}
+
+func reservationOrderID(w http.ResponseWriter, r *http.Request) (uint, bool) {
+	params := mux.Vars(r)
+	id, err := strconv.Atoi(params["order_id"])
+	if err != nil {
+		http.Error(w, "Invalid order ID", http.StatusBadRequest)
+		return 0, false
+	}
+	return uint(id), true
+}
+
+func writeReservationError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		http.Error(w, "Reservation not found", http.StatusNotFound)
+	case errors.Is(err, ErrInsufficientStock):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, ErrProductNotFound):
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
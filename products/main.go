@@ -1,9 +1,11 @@
 package main
 
 import (
+	"HL_online_shop/common/access"
 	_ "HL_online_shop/docs"
 	"log"
 	"net/http"
+	"os"
 	"time"
 
 	"github.com/gorilla/mux"
@@ -22,8 +24,13 @@ import (
 
 func main() {
 	InitDB()
+	if err := access.LoadInternalToken(); err != nil {
+		log.Fatal("invalid internal token: ", err)
+	}
+	go expireReservations(time.Minute)
 
 	r := mux.NewRouter()
+	r.Use(access.RequireInternalToken)
 	r.HandleFunc("/test", Test).Methods("GET")
 	r.HandleFunc("/health", HealthCheck).Methods("GET")
 	r.HandleFunc("/products", GetProducts).Methods("GET")
@@ -31,7 +38,20 @@ func main() {
 	r.HandleFunc("/products/{id}", GetProduct).Methods("GET")
 	r.HandleFunc("/products/{id}", UpdateProduct).Methods("PUT")
 	r.HandleFunc("/products/{id}", DeleteProduct).Methods("DELETE")
+	r.HandleFunc("/products/{id}/price", GetProductPrice).Methods("GET")
+	r.HandleFunc("/products/{id}/prices", GetProductPrices).Methods("GET")
+	r.HandleFunc("/products/{id}/prices/{currency}", SetProductPrice).Methods("PUT")
+	r.HandleFunc("/products/{id}/prices/{currency}", DeleteProductPrice).Methods("DELETE")
+	r.HandleFunc("/currency-rates", GetCurrencyRates).Methods("GET")
+	r.HandleFunc("/currency-rates/import", ImportCurrencyRates).Methods("POST")
+	r.HandleFunc("/currency-rates/{currency}", SetCurrencyRate).Methods("PUT")
+	r.HandleFunc("/currency-rates/{currency}", DeleteCurrencyRate).Methods("DELETE")
 	r.HandleFunc("/search/products", SearchProducts).Methods("GET")
+	r.HandleFunc("/reservations", ReserveStock).Methods("POST")
+	r.HandleFunc("/reservations/{order_id}", GetReservations).Methods("GET")
+	r.HandleFunc("/reservations/{order_id}/commit", CommitReservation).Methods("POST")
+	r.HandleFunc("/reservations/{order_id}/release", ReleaseReservation).Methods("POST")
+	r.HandleFunc("/reservations/{order_id}/return", ReturnReservation).Methods("POST")
 	r.PathPrefix("/swagger/").Handler(httpSwagger.WrapHandler)
 
 	srv := &http.Server{
@@ -44,3 +64,28 @@ func main() {
 	log.Println("Products service is running on port 8082")
 	log.Fatal(srv.ListenAndServe())
 }
+
+// reservationTTL is how long reserved stock is held for an unpaid order.
+// It can be changed with the RESERVATION_TTL environment variable.
+func reservationTTL() time.Duration {
+	if ttl, err := time.ParseDuration(os.Getenv("RESERVATION_TTL")); err == nil && ttl > 0 {
+		return ttl
+	}
+	return 15 * time.Minute
+}
+
+// expireReservations periodically returns the stock of expired reservations.
+func expireReservations(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		n, err := ExpireReservationsRepo(time.Now())
+		if err != nil {
+			log.Println("failed to expire stock reservations:", err)
+			continue
+		}
+		if n > 0 {
+			log.Printf("released %d expired stock reservations", n)
+		}
+	}
+}
@@ -8,7 +8,7 @@ type Product struct {
 	ID          uint      `gorm:"primaryKey" json:"id" readonly:"true" example:"1"`
 	Name        string    `json:"name" validate:"required" example:"Laptop"`
 	Description string    `json:"description" example:"A high-performance laptop"`
-	Price       float64   `json:"price" validate:"required,gt=0" example:"1000.50"`
+	Price       Money     `gorm:"embedded;embeddedPrefix:price_" json:"price" validate:"positive_money"`
 	Category    string    `json:"category" validate:"required" example:"Electronics"`
 	Stock       int       `json:"stock" validate:"gte=0" example:"50"`
 	CreatedAt   time.Time `json:"created_at" readonly:"true" example:"2023-07-20T15:04:05Z"`
@@ -17,3 +17,77 @@ type Product struct {
 func (Product) TableName() string {
 	return "products_shop"
 }
+
+// ProductPrice is the price of a product in a currency other than the base
+// currency. It is charged instead of the converted base price.
+type ProductPrice struct {
+	ID        uint      `gorm:"primaryKey" json:"id" readonly:"true" example:"1"`
+	ProductID uint      `gorm:"index;not null" json:"product_id" readonly:"true" example:"1"`
+	Price     Money     `gorm:"embedded;embeddedPrefix:price_" json:"price" validate:"positive_money"`
+	UpdatedAt time.Time `json:"updated_at" readonly:"true" example:"2023-07-20T15:04:05Z"`
+}
+
+func (ProductPrice) TableName() string {
+	return "product_prices"
+}
+
+// CurrencyRate is how many units of Currency one unit of the base currency
+// is worth. Rates are exact decimals, written as strings in JSON.
+type CurrencyRate struct {
+	Currency  string    `gorm:"primaryKey;size:3" json:"currency" validate:"required,iso4217" example:"USD"`
+	Rate      string    `gorm:"type:numeric(24,12);not null" json:"rate" validate:"required,rate" example:"0.0021"`
+	UpdatedAt time.Time `json:"updated_at" readonly:"true" example:"2023-07-20T15:04:05Z"`
+}
+
+func (CurrencyRate) TableName() string {
+	return "currency_rates"
+}
+
+// PriceQuote is the price of a product in one currency.
+type PriceQuote struct {
+	ProductID uint  `json:"product_id" example:"1"`
+	Price     Money `json:"price"`
+	BasePrice Money `json:"base_price"`
+	// Source tells where the price comes from: the base price itself, a
+	// ProductPrice override, or the base price converted at Rate.
+	Source        string     `json:"source" example:"converted" enums:"base,override,converted"`
+	Rate          string     `json:"rate,omitempty" example:"0.0021"`
+	RateUpdatedAt *time.Time `json:"rate_updated_at,omitempty" example:"2023-07-20T15:04:05Z"`
+}
+
+const (
+	ReservationReserved  = "reserved"
+	ReservationCommitted = "committed"
+	ReservationReleased  = "released"
+	ReservationExpired   = "expired"
+	ReservationReturned  = "returned"
+)
+
+// StockReservation holds stock of a product for an order. Reserved stock is
+// subtracted from Product.Stock immediately, committing a reservation makes
+// the decrement final and releasing it puts the stock back. Committed stock
+// is returned when the order is refunded.
+type StockReservation struct {
+	ID        uint      `gorm:"primaryKey" json:"id" readonly:"true" example:"1"`
+	OrderID   uint      `gorm:"index;not null" json:"order_id" example:"1"`
+	ProductID uint      `gorm:"not null" json:"product_id" example:"1"`
+	Quantity  int       `json:"quantity" example:"2"`
+	Status    string    `gorm:"index" json:"status" example:"reserved" enums:"reserved,committed,released,expired,returned"`
+	ExpiresAt time.Time `json:"expires_at" example:"2023-07-20T15:19:05Z"`
+	CreatedAt time.Time `json:"created_at" example:"2023-07-20T15:04:05Z"`
+	UpdatedAt time.Time `json:"updated_at" example:"2023-07-20T15:04:05Z"`
+}
+
+func (StockReservation) TableName() string {
+	return "stock_reservations"
+}
+
+type ReservationItem struct {
+	ProductID uint `json:"product_id" validate:"required" example:"1"`
+	Quantity  int  `json:"quantity" validate:"required,gt=0" example:"2"`
+}
+
+type ReservationRequest struct {
+	OrderID uint              `json:"order_id" validate:"required" example:"1"`
+	Items   []ReservationItem `json:"items" validate:"required,min=1,dive"`
+}
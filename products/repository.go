@@ -1,20 +1,40 @@
 package main
 
 import (
+	"HL_online_shop/common/money"
+	"errors"
+	"fmt"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 	"gorm.io/gorm/logger"
 	"log"
 	"os"
+	"time"
 )
 
 var db *gorm.DB
 
+var (
+	ErrInsufficientStock = errors.New("insufficient stock")
+	ErrProductNotFound   = errors.New("product not found")
+)
+
 func InitDB() {
 	url := os.Getenv("DATABASE_URL")
 	dsn := url
 	var err error
-	db, err = gorm.Open(postgres.Open(dsn), &gorm.Config{Logger: logger.Default.LogMode(logger.Info)})
+	db, err = gorm.Open(postgres.Open(dsn), &gorm.Config{Logger: logger.New(
+		log.New(os.Stdout, "\r\n", log.LstdFlags),
+		logger.Config{
+			SlowThreshold: 200 * time.Millisecond,
+			LogLevel:      logger.Warn,
+			// Значения параметров не попадают в лог
+			ParameterizedQueries:      true,
+			IgnoreRecordNotFoundError: true,
+			Colorful:                  true,
+		},
+	)})
 	if err != nil {
 		log.Fatal("failed to connect to the database:", err)
 	}
@@ -23,6 +43,17 @@ func InitDB() {
 	if err != nil {
 		log.Fatal("failed to migrate the database:", err)
 	}
+	if err := money.MigrateColumn(db, "products_shop", "price", "price_"); err != nil {
+		log.Fatal("failed to migrate product prices:", err)
+	}
+	if err := db.AutoMigrate(&ProductPrice{}, &CurrencyRate{}); err != nil {
+		log.Fatal("failed to migrate the database:", err)
+	}
+	db.Exec("CREATE UNIQUE INDEX IF NOT EXISTS idx_product_prices_product_currency ON product_prices (product_id, price_currency)")
+	err = db.Table("stock_reservations").AutoMigrate(&StockReservation{})
+	if err != nil {
+		log.Fatal("failed to migrate the database:", err)
+	}
 
 }
 
@@ -56,8 +87,12 @@ func UpdateProductRepo(product *Product) error {
 }
 
 func DeleteProductRepo(id uint) error {
-	result := db.Delete(&Product{}, id)
-	return result.Error
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("product_id = ?", id).Delete(&ProductPrice{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&Product{}, id).Error
+	})
 }
 
 func SearchProductsRepo(name, category string) ([]Product, error) {
@@ -75,3 +110,277 @@ func SearchProductsRepo(name, category string) ([]Product, error) {
 	result := query.Find(&products)
 	return products, result.Error
 }
+
+// ReserveStockRepo reserves stock for every item of an order. Either all
+// items are reserved or none are. Reserving an order that already holds
+// active reservations returns the existing reservations.
+func ReserveStockRepo(req ReservationRequest, ttl time.Duration) ([]StockReservation, error) {
+	var reservations []StockReservation
+	err := db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Where("order_id = ? AND status IN ?", req.OrderID, []string{ReservationReserved, ReservationCommitted}).
+			Find(&reservations).Error
+		if err != nil || len(reservations) > 0 {
+			return err
+		}
+
+		expiresAt := time.Now().Add(ttl)
+		for _, item := range req.Items {
+			if err := takeStock(tx, item.ProductID, item.Quantity); err != nil {
+				return err
+			}
+			reservations = append(reservations, StockReservation{
+				OrderID:   req.OrderID,
+				ProductID: item.ProductID,
+				Quantity:  item.Quantity,
+				Status:    ReservationReserved,
+				ExpiresAt: expiresAt,
+			})
+		}
+		return tx.Create(&reservations).Error
+	})
+	return reservations, err
+}
+
+// CommitStockRepo makes the reservations of an order final. Reservations that
+// have already expired are taken again if the stock is still available.
+func CommitStockRepo(orderID uint) ([]StockReservation, error) {
+	var reservations []StockReservation
+	err := db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("order_id = ? AND status <> ?", orderID, ReservationReleased).
+			Find(&reservations).Error
+		if err != nil {
+			return err
+		}
+		if len(reservations) == 0 {
+			return gorm.ErrRecordNotFound
+		}
+
+		for i := range reservations {
+			reservation := &reservations[i]
+			switch reservation.Status {
+			case ReservationCommitted:
+				continue
+			case ReservationExpired:
+				if err := takeStock(tx, reservation.ProductID, reservation.Quantity); err != nil {
+					return err
+				}
+			}
+			reservation.Status = ReservationCommitted
+			if err := tx.Model(reservation).Update("status", ReservationCommitted).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return reservations, err
+}
+
+// ReleaseStockRepo returns the stock of all active reservations of an order.
+// Committed reservations are not touched.
+func ReleaseStockRepo(orderID uint) ([]StockReservation, error) {
+	var reservations []StockReservation
+	err := db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("order_id = ?", orderID).
+			Find(&reservations).Error
+		if err != nil {
+			return err
+		}
+
+		for i := range reservations {
+			reservation := &reservations[i]
+			switch reservation.Status {
+			case ReservationReserved:
+				if err := returnStock(tx, reservation.ProductID, reservation.Quantity); err != nil {
+					return err
+				}
+			case ReservationExpired:
+			default:
+				continue
+			}
+			reservation.Status = ReservationReleased
+			if err := tx.Model(reservation).Update("status", ReservationReleased).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return reservations, err
+}
+
+// ReturnStockRepo puts the committed stock of a refunded order back.
+// Reservations that were already returned are not touched.
+func ReturnStockRepo(orderID uint) ([]StockReservation, error) {
+	var reservations []StockReservation
+	err := db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("order_id = ?", orderID).
+			Find(&reservations).Error
+		if err != nil {
+			return err
+		}
+		if len(reservations) == 0 {
+			return gorm.ErrRecordNotFound
+		}
+
+		for i := range reservations {
+			reservation := &reservations[i]
+			if reservation.Status != ReservationCommitted {
+				continue
+			}
+			if err := returnStock(tx, reservation.ProductID, reservation.Quantity); err != nil {
+				return err
+			}
+			reservation.Status = ReservationReturned
+			if err := tx.Model(reservation).Update("status", ReservationReturned).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return reservations, err
+}
+
+// ExpireReservationsRepo returns the stock of reservations that were neither
+// committed nor released before they expired.
+func ExpireReservationsRepo(now time.Time) (int, error) {
+	var reservations []StockReservation
+	err := db.Where("status = ? AND expires_at < ?", ReservationReserved, now).Find(&reservations).Error
+	if err != nil {
+		return 0, err
+	}
+
+	expired := 0
+	for _, reservation := range reservations {
+		var updated bool
+		err := db.Transaction(func(tx *gorm.DB) error {
+			result := tx.Model(&StockReservation{}).
+				Where("id = ? AND status = ?", reservation.ID, ReservationReserved).
+				Update("status", ReservationExpired)
+			if result.Error != nil || result.RowsAffected == 0 {
+				return result.Error
+			}
+			updated = true
+			return returnStock(tx, reservation.ProductID, reservation.Quantity)
+		})
+		if err != nil {
+			return expired, err
+		}
+		if updated {
+			expired++
+		}
+	}
+	return expired, nil
+}
+
+func GetReservationsRepo(orderID uint) ([]StockReservation, error) {
+	var reservations []StockReservation
+	result := db.Where("order_id = ?", orderID).Find(&reservations)
+	return reservations, result.Error
+}
+
+// takeStock decrements the stock of a product with a conditional update, so
+// concurrent reservations can never drive the stock below zero.
+func takeStock(tx *gorm.DB, productID uint, quantity int) error {
+	result := tx.Model(&Product{}).
+		Where("id = ? AND stock >= ?", productID, quantity).
+		UpdateColumn("stock", gorm.Expr("stock - ?", quantity))
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 1 {
+		return nil
+	}
+
+	var count int64
+	if err := tx.Model(&Product{}).Where("id = ?", productID).Count(&count).Error; err != nil {
+		return err
+	}
+	if count == 0 {
+		return fmt.Errorf("%w: %d", ErrProductNotFound, productID)
+	}
+	return fmt.Errorf("%w for product %d", ErrInsufficientStock, productID)
+}
+
+func returnStock(tx *gorm.DB, productID uint, quantity int) error {
+	return tx.Model(&Product{}).
+		Where("id = ?", productID).
+		UpdateColumn("stock", gorm.Expr("stock + ?", quantity)).Error
+}
+
+func GetProductPricesRepo(productID uint) ([]ProductPrice, error) {
+	var prices []ProductPrice
+	result := db.Where("product_id = ?", productID).Order("price_currency").Find(&prices)
+	return prices, result.Error
+}
+
+func GetProductPriceRepo(productID uint, currency string) (*ProductPrice, error) {
+	var price ProductPrice
+	result := db.Where("product_id = ? AND price_currency = ?", productID, currency).First(&price)
+	return &price, result.Error
+}
+
+// SetProductPriceRepo creates or replaces the price of a product in the
+// currency of price.Price.
+func SetProductPriceRepo(price *ProductPrice) error {
+	result := db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "product_id"}, {Name: "price_currency"}},
+		DoUpdates: clause.AssignmentColumns([]string{"price_minor", "updated_at"}),
+	}).Create(price)
+	if result.Error != nil {
+		return result.Error
+	}
+	// При обновлении ID не возвращается, поэтому строка перечитывается
+	stored, err := GetProductPriceRepo(price.ProductID, price.Price.Currency)
+	if err != nil {
+		return err
+	}
+	*price = *stored
+	return nil
+}
+
+func DeleteProductPriceRepo(productID uint, currency string) error {
+	result := db.Where("product_id = ? AND price_currency = ?", productID, currency).Delete(&ProductPrice{})
+	if result.Error == nil && result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return result.Error
+}
+
+func GetCurrencyRatesRepo() ([]CurrencyRate, error) {
+	var rates []CurrencyRate
+	result := db.Order("currency").Find(&rates)
+	return rates, result.Error
+}
+
+func GetCurrencyRateRepo(currency string) (*CurrencyRate, error) {
+	var rate CurrencyRate
+	result := db.Where("currency = ?", currency).First(&rate)
+	return &rate, result.Error
+}
+
+// SaveCurrencyRatesRepo creates or replaces rates. Either all of them are
+// saved or none are.
+func SaveCurrencyRatesRepo(rates []CurrencyRate) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		for i := range rates {
+			result := tx.Clauses(clause.OnConflict{
+				Columns:   []clause.Column{{Name: "currency"}},
+				DoUpdates: clause.AssignmentColumns([]string{"rate", "updated_at"}),
+			}).Create(&rates[i])
+			if result.Error != nil {
+				return result.Error
+			}
+		}
+		return nil
+	})
+}
+
+func DeleteCurrencyRateRepo(currency string) error {
+	result := db.Where("currency = ?", currency).Delete(&CurrencyRate{})
+	if result.Error == nil && result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return result.Error
+}
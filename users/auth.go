@@ -0,0 +1,222 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	ErrInvalidCredentials = errors.New("invalid email or password")
+	ErrInvalidRefresh     = errors.New("invalid or expired refresh token")
+)
+
+// AuthConfig holds the settings tokens are issued with. The secret, audience
+// and issuer are those the API gateway verifies tokens with.
+type AuthConfig struct {
+	HS256Secret     []byte
+	Audience        string
+	Issuer          string
+	AccessTokenTTL  time.Duration
+	RefreshTokenTTL time.Duration
+	// Login attempts that failed are limited per account and per IP address
+	// within LoginWindow.
+	LoginAccountLimit int
+	LoginIPLimit      int
+	LoginWindow       time.Duration
+	// AppBaseURL is the address of the shop the links in emails point to.
+	AppBaseURL           string
+	EmailVerificationTTL time.Duration
+	PasswordResetTTL     time.Duration
+	// TOTPEncryptionKey encrypts the TOTP secrets in the database. TOTPIssuer
+	// is the name authenticator apps show next to the codes.
+	TOTPEncryptionKey []byte
+	TOTPIssuer        string
+}
+
+var authConfig *AuthConfig
+
+// loadAuthConfig reads the token settings from the environment.
+func loadAuthConfig() (*AuthConfig, error) {
+	cfg := &AuthConfig{
+		HS256Secret:          []byte(os.Getenv("JWT_HS256_SECRET")),
+		Audience:             os.Getenv("JWT_AUDIENCE"),
+		Issuer:               os.Getenv("JWT_ISSUER"),
+		AccessTokenTTL:       15 * time.Minute,
+		RefreshTokenTTL:      30 * 24 * time.Hour,
+		LoginAccountLimit:    5,
+		LoginIPLimit:         20,
+		LoginWindow:          15 * time.Minute,
+		AppBaseURL:           strings.TrimSuffix(os.Getenv("APP_BASE_URL"), "/"),
+		EmailVerificationTTL: 24 * time.Hour,
+		PasswordResetTTL:     time.Hour,
+		TOTPEncryptionKey:    []byte(os.Getenv("TOTP_ENCRYPTION_KEY")),
+		TOTPIssuer:           os.Getenv("TOTP_ISSUER"),
+	}
+	if len(cfg.HS256Secret) < 32 {
+		return nil, errors.New("JWT_HS256_SECRET must be set to at least 32 bytes")
+	}
+	if len(cfg.TOTPEncryptionKey) < 32 {
+		return nil, errors.New("TOTP_ENCRYPTION_KEY must be set to at least 32 bytes")
+	}
+	if cfg.TOTPIssuer == "" {
+		cfg.TOTPIssuer = "HL Online Shop"
+	}
+	if cfg.Audience == "" {
+		cfg.Audience = "hl-online-shop"
+	}
+	if cfg.AppBaseURL == "" {
+		cfg.AppBaseURL = "http://localhost:8080"
+	}
+	durations := map[string]*time.Duration{
+		"ACCESS_TOKEN_TTL":       &cfg.AccessTokenTTL,
+		"REFRESH_TOKEN_TTL":      &cfg.RefreshTokenTTL,
+		"LOGIN_WINDOW":           &cfg.LoginWindow,
+		"EMAIL_VERIFICATION_TTL": &cfg.EmailVerificationTTL,
+		"PASSWORD_RESET_TTL":     &cfg.PasswordResetTTL,
+	}
+	for name, target := range durations {
+		if s := os.Getenv(name); s != "" {
+			d, err := time.ParseDuration(s)
+			if err != nil || d <= 0 {
+				return nil, fmt.Errorf("invalid %s %q", name, s)
+			}
+			*target = d
+		}
+	}
+	limits := map[string]*int{
+		"LOGIN_ACCOUNT_LIMIT": &cfg.LoginAccountLimit,
+		"LOGIN_IP_LIMIT":      &cfg.LoginIPLimit,
+	}
+	for name, target := range limits {
+		if s := os.Getenv(name); s != "" {
+			n, err := strconv.Atoi(s)
+			if err != nil || n <= 0 {
+				return nil, fmt.Errorf("invalid %s %q", name, s)
+			}
+			*target = n
+		}
+	}
+	return cfg, nil
+}
+
+// accessClaims are the claims of an access token.
+type accessClaims struct {
+	Subject   string `json:"sub"`
+	Role      string `json:"role"`
+	Issuer    string `json:"iss,omitempty"`
+	Audience  string `json:"aud"`
+	IssuedAt  int64  `json:"iat"`
+	ExpiresAt int64  `json:"exp"`
+	// MFA is set if the user signed in with a second factor. The gateway
+	// only grants admin rights to such tokens.
+	MFA bool `json:"mfa"`
+}
+
+// issueAccessToken signs a short-lived HS256 token for the user.
+func (cfg *AuthConfig) issueAccessToken(user *User, mfa bool, now time.Time) (string, error) {
+	header, err := json.Marshal(map[string]string{"alg": "HS256", "typ": "JWT"})
+	if err != nil {
+		return "", err
+	}
+	claims, err := json.Marshal(accessClaims{
+		Subject:   strconv.FormatUint(uint64(user.ID), 10),
+		Role:      user.Role,
+		Issuer:    cfg.Issuer,
+		Audience:  cfg.Audience,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(cfg.AccessTokenTTL).Unix(),
+		MFA:       mfa,
+	})
+	if err != nil {
+		return "", err
+	}
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	mac := hmac.New(sha256.New, cfg.HS256Secret)
+	mac.Write([]byte(signingInput))
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil)), nil
+}
+
+// newRandomToken returns a random token for a refresh token or an email link.
+// Only its hash is stored.
+func newRandomToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func hashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// dummyPasswordHash is compared against when there is no user with the email,
+// so that a login takes as long whether or not the account exists.
+var dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("dummy password"), bcrypt.DefaultCost)
+
+// checkPassword reports whether password matches the user's hash. A user
+// without a password cannot sign in.
+func checkPassword(user *User, password string) bool {
+	if user == nil || user.PasswordHash == "" {
+		bcrypt.CompareHashAndPassword(dummyPasswordHash, []byte(password))
+		return false
+	}
+	return bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)) == nil
+}
+
+// issueTokens creates an access token and a new refresh token for the user,
+// marked as passed two-factor authentication if mfa is set. The caller stores
+// the returned refresh token record.
+func issueTokens(user *User, mfa bool, now time.Time) (*TokenResponse, *RefreshToken, error) {
+	accessToken, err := authConfig.issueAccessToken(user, mfa, now)
+	if err != nil {
+		return nil, nil, err
+	}
+	refreshToken, err := newRandomToken()
+	if err != nil {
+		return nil, nil, err
+	}
+	stored := &RefreshToken{
+		UserID:    user.ID,
+		TokenHash: hashToken(refreshToken),
+		ExpiresAt: now.Add(authConfig.RefreshTokenTTL),
+		MFA:       mfa,
+	}
+	return &TokenResponse{
+		AccessToken:  accessToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(authConfig.AccessTokenTTL.Seconds()),
+		RefreshToken: refreshToken,
+	}, stored, nil
+}
+
+// expireRefreshTokens periodically deletes refresh tokens that have expired.
+func expireRefreshTokens(interval time.Duration) {
+	for range time.Tick(interval) {
+		if err := DeleteExpiredRefreshTokensRepo(time.Now()); err != nil {
+			log.Println("failed to expire refresh tokens:", err)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+)
+
+var ErrInvalidUserToken = errors.New("invalid, used or expired token")
+
+// mailLimiter limits the verification and reset emails sent to an address, so
+// the endpoints cannot be used to flood an inbox.
+var mailLimiter = newRateLimiter(3, time.Hour)
+
+// issueUserToken stores a new single-use token of the given purpose for the
+// user and returns it.
+func issueUserToken(user *User, purpose string, ttl time.Duration, now time.Time) (string, error) {
+	token, err := newRandomToken()
+	if err != nil {
+		return "", err
+	}
+	stored := UserToken{
+		UserID:    user.ID,
+		Purpose:   purpose,
+		TokenHash: hashToken(token),
+		Email:     user.Email,
+		ExpiresAt: now.Add(ttl),
+		CreatedAt: now,
+	}
+	if err := CreateUserTokenRepo(&stored); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// sendUserEmail sends a link with a new token unless the address got too
+// many emails lately.
+func sendUserEmail(user *User, purpose string, ttl time.Duration, build func(link string) Message) error {
+	now := time.Now()
+	address := strings.ToLower(user.Email)
+	if ok, _ := mailLimiter.allow(address, now); !ok {
+		log.Printf("not sending %s email to user %d: too many emails", purpose, user.ID)
+		return nil
+	}
+	token, err := issueUserToken(user, purpose, ttl, now)
+	if err != nil {
+		return err
+	}
+	mailLimiter.add(address, now)
+
+	page := "verify-email"
+	if purpose == TokenResetPassword {
+		page = "reset-password"
+	}
+	link := fmt.Sprintf("%s/%s?token=%s", authConfig.AppBaseURL, page, url.QueryEscape(token))
+	return mailer.Send(build(link))
+}
+
+func sendVerificationEmail(user *User) error {
+	ttl := authConfig.EmailVerificationTTL
+	return sendUserEmail(user, TokenVerifyEmail, ttl, func(link string) Message {
+		return Message{
+			To:      user.Email,
+			Subject: "Confirm your email address",
+			Body: fmt.Sprintf("Hello %s,\n\nconfirm your email address to start placing orders:\n\n%s\n\n"+
+				"The link works once and expires in %s. If you did not sign up, ignore this email.\n", user.Name, link, ttl),
+		}
+	})
+}
+
+func sendPasswordResetEmail(user *User) error {
+	ttl := authConfig.PasswordResetTTL
+	return sendUserEmail(user, TokenResetPassword, ttl, func(link string) Message {
+		return Message{
+			To:      user.Email,
+			Subject: "Reset your password",
+			Body: fmt.Sprintf("Hello %s,\n\nset a new password with this link:\n\n%s\n\n"+
+				"The link works once and expires in %s. If you did not ask for it, ignore this email; "+
+				"your password stays the same.\n", user.Name, link, ttl),
+		}
+	})
+}
+
+// expireUserTokens periodically deletes email tokens that have expired.
+func expireUserTokens(interval time.Duration) {
+	for range time.Tick(interval) {
+		if err := DeleteExpiredUserTokensRepo(time.Now()); err != nil {
+			log.Println("failed to expire email tokens:", err)
+		}
+	}
+}
@@ -1,12 +1,16 @@
 package main
 
 import (
+	"HL_online_shop/common/access"
 	"encoding/json"
+	"errors"
 	"github.com/go-playground/validator/v10"
 	"github.com/gorilla/mux"
 	"gorm.io/gorm"
+	"log"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -31,8 +35,12 @@ func HealthCheck(w http.ResponseWriter, r *http.Request) {
 // @Tags users
 // @Produce json
 // @Success 200 {array} User
+// @Failure 403 {object} access.ErrorResponse "Only admins"
 // @Router /users [get]
 func GetUsers(w http.ResponseWriter, r *http.Request) {
+	if !access.RequireAdmin(w, r) {
+		return
+	}
 	users, err := GetAllUsersRepo()
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -49,13 +57,19 @@ func GetUsers(w http.ResponseWriter, r *http.Request) {
 // @Produce json
 // @Param user body User true "Create user"
 // @Success 201 {object} User
+// @Failure 403 {object} access.ErrorResponse "Only admins"
+// @Failure 409 {string} string "Email already registered"
 // @Router /users [post]
 func CreateUser(w http.ResponseWriter, r *http.Request) {
+	if !access.RequireAdmin(w, r) {
+		return
+	}
 	var user User
 	if err := json.NewDecoder(r.Body).Decode(&user); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	user.Email = normalizeEmail(user.Email)
 	err := validate.Struct(user)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
@@ -63,9 +77,15 @@ func CreateUser(w http.ResponseWriter, r *http.Request) {
 	}
 
 	user.RegistrationAt = time.Now()
+	user.EmailVerifiedAt = nil
+	user.TOTPEnabledAt = nil
 
 	if err := CreateUserRepo(&user); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		if errors.Is(err, ErrEmailTaken) {
+			http.Error(w, "Email already registered", http.StatusConflict)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
 		return
 
 	}
@@ -80,6 +100,7 @@ func CreateUser(w http.ResponseWriter, r *http.Request) {
 // @Produce json
 // @Param id path int true "User ID"
 // @Success 200 {object} User
+// @Failure 403 {object} access.ErrorResponse "Not the caller's user"
 // @Router /users/{id} [get]
 func GetUser(w http.ResponseWriter, r *http.Request) {
 	params := mux.Vars(r)
@@ -89,6 +110,9 @@ func GetUser(w http.ResponseWriter, r *http.Request) {
 		return
 
 	}
+	if !access.RequireOwner(w, r, uint(id)) {
+		return
+	}
 	user, err := GetUserByIDRepo(uint(id))
 	if err != nil {
 		if err == gorm.ErrRecordNotFound {
@@ -103,13 +127,15 @@ func GetUser(w http.ResponseWriter, r *http.Request) {
 
 // UpdateUser godoc
 // @Summary Update a user by ID
-// @Description Update a user by ID
+// @Description Update a user by ID. Changing the email address makes it unverified and sends a verification email to the new address.
 // @Tags users
 // @Accept json
 // @Produce json
 // @Param id path int true "User ID"
 // @Param user body User true "Update user"
 // @Success 200 {object} User
+// @Failure 403 {object} access.ErrorResponse "Not the caller's user, or a client changing their role"
+// @Failure 409 {string} string "Email already registered"
 // @Router /users/{id} [put]
 func UpdateUser(w http.ResponseWriter, r *http.Request) {
 	params := mux.Vars(r)
@@ -119,23 +145,64 @@ func UpdateUser(w http.ResponseWriter, r *http.Request) {
 		return
 
 	}
+	if !access.RequireOwner(w, r, uint(id)) {
+		return
+	}
 
 	var user User
 	if err := json.NewDecoder(r.Body).Decode(&user); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	user.Email = normalizeEmail(user.Email)
 	err = validate.Struct(user)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 
 	}
+	existing, err := GetUserByIDRepo(uint(id))
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			http.Error(w, "User not found", http.StatusNotFound)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	if user.Role != existing.Role && !access.CallerFrom(r).Privileged() {
+		access.WriteForbidden(w, "only admins may change the role of a user")
+		return
+	}
+	if owner, err := GetUserByEmailRepo(user.Email); err == nil && owner.ID != existing.ID {
+		http.Error(w, "Email already registered", http.StatusConflict)
+		return
+	} else if err != nil && err != gorm.ErrRecordNotFound {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
 	user.ID = uint(id)
+	user.EmailVerifiedAt = existing.EmailVerifiedAt
+	user.TOTPEnabledAt = existing.TOTPEnabledAt
 	if err := UpdateUserRepo(&user); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		if errors.Is(err, ErrEmailTaken) {
+			http.Error(w, "Email already registered", http.StatusConflict)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
 		return
 	}
+	if !strings.EqualFold(user.Email, existing.Email) {
+		// Новый адрес нужно подтвердить заново
+		if err := ClearEmailVerifiedRepo(user.ID); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		user.EmailVerifiedAt = nil
+		if err := sendVerificationEmail(&user); err != nil {
+			log.Printf("failed to send verification email to user %d: %v", user.ID, err)
+		}
+	}
 
 	json.NewEncoder(w).Encode(user)
 }
@@ -147,6 +214,7 @@ func UpdateUser(w http.ResponseWriter, r *http.Request) {
 // @Produce json
 // @Param id path int true "User ID"
 // @Success 200 {string} string "Deleted"
+// @Failure 403 {object} access.ErrorResponse "Not the caller's user"
 // @Router /users/{id} [delete]
 func DeleteUser(w http.ResponseWriter, r *http.Request) {
 	params := mux.Vars(r)
@@ -155,6 +223,9 @@ func DeleteUser(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Invalid user ID", http.StatusBadRequest)
 		return
 	}
+	if !access.RequireOwner(w, r, uint(id)) {
+		return
+	}
 	if err := DeleteUserRepo(uint(id)); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -171,8 +242,12 @@ func DeleteUser(w http.ResponseWriter, r *http.Request) {
 // @Param name query string false "Name"
 // @Param role query string false "Role"
 // @Success 200 {array} User
+// @Failure 403 {object} access.ErrorResponse "Only admins"
 // @Router /search/users [get]
 func SearchUsers(w http.ResponseWriter, r *http.Request) {
+	if !access.RequireAdmin(w, r) {
+		return
+	}
 	name := r.URL.Query().Get("name")
 	email := r.URL.Query().Get("email")
 
@@ -187,3 +262,831 @@ func SearchUsers(w http.ResponseWriter, r *http.Request) {
 	return
 
 }
+
+// Register godoc
+// @Summary Register a customer
+// @Description Create a client account that can sign in with its email and password. Passwords are stored as bcrypt hashes. A verification email is sent to the address; orders can only be placed once it is verified.
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param user body RegisterRequest true "New account"
+// @Success 201 {object} User
+// @Failure 409 {string} string "Email already registered"
+// @Router /auth/register [post]
+func Register(w http.ResponseWriter, r *http.Request) {
+	var req RegisterRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	req.Email = normalizeEmail(req.Email)
+	if err := validate.Struct(req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if _, err := GetUserByEmailRepo(req.Email); err == nil {
+		http.Error(w, "Email already registered", http.StatusConflict)
+		return
+	} else if err != gorm.ErrRecordNotFound {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	hash, err := hashPassword(req.Password)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	user := User{
+		Name:         req.Name,
+		Email:        req.Email,
+		Address:      req.Address,
+		Phone:        req.Phone,
+		Role:         "client",
+		PasswordHash: hash,
+	}
+	// Проверка выше не защищает от одновременной регистрации, это делает уникальный индекс
+	if err := CreateUserRepo(&user); err != nil {
+		if errors.Is(err, ErrEmailTaken) {
+			http.Error(w, "Email already registered", http.StatusConflict)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	if err := sendVerificationEmail(&user); err != nil {
+		log.Printf("failed to send verification email to user %d: %v", user.ID, err)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(user)
+}
+
+// Login godoc
+// @Summary Sign in
+// @Description Sign in with email and password and get an access token and a refresh token. If the user has two-factor authentication, the response only has mfa_required and an mfa_token to finish signing in at /auth/login/mfa. Admins without two-factor authentication get tokens with mfa_enrollment_required, which only have the rights of a client until they enrol. Failed attempts are limited per account and per IP address; over the limit the response is 429 with Retry-After.
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param credentials body LoginRequest true "Credentials"
+// @Success 200 {object} TokenResponse
+// @Failure 401 {string} string "Invalid email or password"
+// @Failure 429 {string} string "Too many failed attempts"
+// @Router /auth/login [post]
+func Login(w http.ResponseWriter, r *http.Request) {
+	var req LoginRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := validate.Struct(req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	now := time.Now()
+	account := normalizeEmail(req.Email)
+	ip := clientIP(r)
+	if !allowAttempt(w, account, ip, now) {
+		return
+	}
+
+	user, err := GetUserByEmailRepo(account)
+	if err != nil && err != gorm.ErrRecordNotFound {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err != nil {
+		user = nil
+	}
+	if !checkPassword(user, req.Password) {
+		failedAttempt(account, ip, now)
+		http.Error(w, ErrInvalidCredentials.Error(), http.StatusUnauthorized)
+		return
+	}
+	if user.TOTPEnabledAt != nil {
+		// Счётчик неудачных попыток сбрасывается только после второго шага, иначе коды можно перебирать
+		mfaToken, err := issueUserToken(user, TokenMFALogin, mfaTokenTTL, now)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		json.NewEncoder(w).Encode(TokenResponse{MFARequired: true, MFAToken: mfaToken})
+		return
+	}
+	accountLimiter.reset(account)
+
+	tokens, refreshToken, err := issueTokens(user, false, now)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := CreateRefreshTokenRepo(refreshToken); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	tokens.MFAEnrollmentRequired = user.Role == access.RoleAdmin
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(tokens)
+}
+
+// LoginMFA godoc
+// @Summary Finish signing in with two-factor authentication
+// @Description Exchange the mfa_token from /auth/login and a code of the authenticator app, or a recovery code, for an access token and a refresh token. The mfa_token expires after 5 minutes and works once. Wrong codes count as failed logins of the account.
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param login body MFALoginRequest true "Token from the first step and a code"
+// @Success 200 {object} TokenResponse
+// @Failure 401 {string} string "Invalid code or expired token"
+// @Failure 429 {string} string "Too many failed attempts"
+// @Router /auth/login/mfa [post]
+func LoginMFA(w http.ResponseWriter, r *http.Request) {
+	var req MFALoginRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := validate.Struct(req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	now := time.Now()
+	tokenHash := hashToken(req.MFAToken)
+	token, err := GetUserTokenRepo(tokenHash, TokenMFALogin, now)
+	if err != nil {
+		if errors.Is(err, ErrInvalidUserToken) {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	user, err := GetUserByIDRepo(token.UserID)
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			http.Error(w, ErrInvalidUserToken.Error(), http.StatusUnauthorized)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	account := strings.ToLower(user.Email)
+	ip := clientIP(r)
+	if !allowAttempt(w, account, ip, now) {
+		return
+	}
+	if err := verifySecondFactor(user, req.Code, req.RecoveryCode, now); err != nil {
+		if errors.Is(err, ErrInvalidCode) || errors.Is(err, ErrCodeAlreadyUsed) || errors.Is(err, ErrTOTPNotEnabled) {
+			failedAttempt(account, ip, now)
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	if _, err := ConsumeUserTokenRepo(tokenHash, TokenMFALogin, now); err != nil {
+		if errors.Is(err, ErrInvalidUserToken) {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	accountLimiter.reset(account)
+
+	tokens, refreshToken, err := issueTokens(user, true, now)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := CreateRefreshTokenRepo(refreshToken); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(tokens)
+}
+
+// allowAttempt writes a 429 response if the account or the IP address has
+// too many failed attempts.
+func allowAttempt(w http.ResponseWriter, account, ip string, now time.Time) bool {
+	ok, wait := accountLimiter.allow(account, now)
+	if ok {
+		ok, wait = ipLimiter.allow(ip, now)
+	}
+	if ok {
+		return true
+	}
+	log.Printf("login of %s from %s is rate limited", account, ip)
+	w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+	http.Error(w, "Too many failed attempts, try again later", http.StatusTooManyRequests)
+	return false
+}
+
+func failedAttempt(account, ip string, now time.Time) {
+	accountLimiter.add(account, now)
+	ipLimiter.add(ip, now)
+}
+
+// RefreshTokens godoc
+// @Summary Refresh the access token
+// @Description Exchange a refresh token for a new access token and a new refresh token. Each refresh token can be used once; using a revoked one again revokes every session of the user, since the token was probably stolen.
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param token body RefreshRequest true "Refresh token"
+// @Success 200 {object} TokenResponse
+// @Failure 401 {string} string "Invalid or expired refresh token"
+// @Router /auth/refresh [post]
+func RefreshTokens(w http.ResponseWriter, r *http.Request) {
+	var req RefreshRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := validate.Struct(req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	now := time.Now()
+	stored, err := GetRefreshTokenRepo(hashToken(req.RefreshToken))
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			http.Error(w, ErrInvalidRefresh.Error(), http.StatusUnauthorized)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	if stored.RevokedAt != nil {
+		// Повторное использование отозванного токена: отзываем все сессии пользователя
+		log.Printf("revoked refresh token %d of user %d was reused", stored.ID, stored.UserID)
+		if err := RevokeUserRefreshTokensRepo(stored.UserID); err != nil {
+			log.Printf("failed to revoke refresh tokens of user %d: %v", stored.UserID, err)
+		}
+		http.Error(w, ErrInvalidRefresh.Error(), http.StatusUnauthorized)
+		return
+	}
+	if !now.Before(stored.ExpiresAt) {
+		http.Error(w, ErrInvalidRefresh.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	user, err := GetUserByIDRepo(stored.UserID)
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			http.Error(w, ErrInvalidRefresh.Error(), http.StatusUnauthorized)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	tokens, replacement, err := issueTokens(user, stored.MFA, now)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := RotateRefreshTokenRepo(stored.ID, replacement); err != nil {
+		if errors.Is(err, ErrInvalidRefresh) {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	tokens.MFAEnrollmentRequired = user.Role == access.RoleAdmin && !stored.MFA
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(tokens)
+}
+
+// Logout godoc
+// @Summary Sign out
+// @Description Revoke a refresh token. The access token stays valid until it expires, which is why it is short-lived.
+// @Tags auth
+// @Accept json
+// @Param token body RefreshRequest true "Refresh token"
+// @Success 204
+// @Router /auth/logout [post]
+func Logout(w http.ResponseWriter, r *http.Request) {
+	var req RefreshRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := validate.Struct(req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Неизвестный токен тоже даёт 204, чтобы по ответу нельзя было проверить токен
+	if err := RevokeRefreshTokenRepo(hashToken(req.RefreshToken)); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ChangePassword godoc
+// @Summary Change the password of a user
+// @Description Set a new password after checking the current one. Every refresh token of the user is revoked, so other sessions have to sign in again. Wrong current passwords count as failed logins of the account.
+// @Tags auth
+// @Accept json
+// @Param id path int true "User ID"
+// @Param password body ChangePasswordRequest true "Current and new password"
+// @Success 204
+// @Failure 401 {string} string "Current password is wrong"
+// @Failure 403 {object} access.ErrorResponse "Not the caller's user"
+// @Failure 429 {string} string "Too many failed attempts"
+// @Router /users/{id}/password [post]
+func ChangePassword(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	id, err := strconv.Atoi(params["id"])
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+	if !access.RequireOwner(w, r, uint(id)) {
+		return
+	}
+
+	var req ChangePasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := validate.Struct(req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	user, err := GetUserByIDRepo(uint(id))
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			http.Error(w, "User not found", http.StatusNotFound)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	now := time.Now()
+	account := strings.ToLower(user.Email)
+	ip := clientIP(r)
+	if !allowAttempt(w, account, ip, now) {
+		return
+	}
+	if !checkPassword(user, req.CurrentPassword) {
+		failedAttempt(account, ip, now)
+		http.Error(w, "Current password is wrong", http.StatusUnauthorized)
+		return
+	}
+
+	hash, err := hashPassword(req.NewPassword)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := SetPasswordRepo(user.ID, hash); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ResendVerification godoc
+// @Summary Send the verification email again
+// @Description Send a new verification link to an unverified address. Earlier links stop working. The response is the same whether or not the address is registered, and at most 3 emails an hour are sent to an address.
+// @Tags auth
+// @Accept json
+// @Param email body EmailRequest true "Address to verify"
+// @Success 202
+// @Router /auth/verify-email/resend [post]
+func ResendVerification(w http.ResponseWriter, r *http.Request) {
+	var req EmailRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := validate.Struct(req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	user, err := GetUserByEmailRepo(req.Email)
+	if err != nil && err != gorm.ErrRecordNotFound {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err == nil && user.EmailVerifiedAt == nil {
+		if err := sendVerificationEmail(user); err != nil {
+			log.Printf("failed to send verification email to user %d: %v", user.ID, err)
+		}
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// VerifyEmail godoc
+// @Summary Verify an email address
+// @Description Confirm the address with the token from the verification email. A token works once, expires after EMAIL_VERIFICATION_TTL and does not verify an address the user changed to afterwards.
+// @Tags auth
+// @Accept json
+// @Param token body VerifyEmailRequest true "Token from the email"
+// @Success 204
+// @Failure 400 {string} string "Invalid, used or expired token"
+// @Router /auth/verify-email [post]
+func VerifyEmail(w http.ResponseWriter, r *http.Request) {
+	var req VerifyEmailRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := validate.Struct(req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := VerifyEmailRepo(hashToken(req.Token), time.Now()); err != nil {
+		if errors.Is(err, ErrInvalidUserToken) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RequestPasswordReset godoc
+// @Summary Request a password reset
+// @Description Email a link to set a new password. Earlier links stop working. The response is the same whether or not the address is registered, and at most 3 emails an hour are sent to an address.
+// @Tags auth
+// @Accept json
+// @Param email body EmailRequest true "Address of the account"
+// @Success 202
+// @Router /auth/password-reset/request [post]
+func RequestPasswordReset(w http.ResponseWriter, r *http.Request) {
+	var req EmailRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := validate.Struct(req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	user, err := GetUserByEmailRepo(req.Email)
+	if err != nil && err != gorm.ErrRecordNotFound {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err == nil {
+		if err := sendPasswordResetEmail(user); err != nil {
+			log.Printf("failed to send password reset email to user %d: %v", user.ID, err)
+		}
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// ResetPassword godoc
+// @Summary Reset the password
+// @Description Set a new password with the token from the reset email. The token works once and expires after PASSWORD_RESET_TTL. Every refresh token of the user is revoked, and the address is verified since the user received the email.
+// @Tags auth
+// @Accept json
+// @Param reset body ResetPasswordRequest true "Token from the email and new password"
+// @Success 204
+// @Failure 400 {string} string "Invalid, used or expired token"
+// @Router /auth/password-reset [post]
+func ResetPassword(w http.ResponseWriter, r *http.Request) {
+	var req ResetPasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := validate.Struct(req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	hash, err := hashPassword(req.NewPassword)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	userID, err := ResetPasswordRepo(hashToken(req.Token), hash, time.Now())
+	if err != nil {
+		if errors.Is(err, ErrInvalidUserToken) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	log.Printf("password of user %d was reset", userID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// StartTOTPEnrollment godoc
+// @Summary Start enrolling in two-factor authentication
+// @Description Create a new TOTP secret for the caller, as text and as an otpauth URI to show as a QR code. Two-factor authentication is turned on once a code is confirmed; starting again replaces an unconfirmed secret. It is mandatory for admins and optional for clients.
+// @Tags two-factor
+// @Produce json
+// @Param id path int true "User ID"
+// @Success 200 {object} TOTPEnrollment
+// @Failure 403 {object} access.ErrorResponse "Not the caller's own user"
+// @Failure 409 {string} string "Two-factor authentication is already enabled"
+// @Router /users/{id}/2fa [post]
+func StartTOTPEnrollment(w http.ResponseWriter, r *http.Request) {
+	user, ok := totpUser(w, r)
+	if !ok {
+		return
+	}
+	if user.TOTPEnabledAt != nil {
+		http.Error(w, ErrTOTPEnabled.Error(), http.StatusConflict)
+		return
+	}
+
+	secret, err := newTOTPSecret()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	sealed, err := encryptTOTPSecret(authConfig.TOTPEncryptionKey, secret)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := SetTOTPSecretRepo(user.ID, sealed); err != nil {
+		if errors.Is(err, ErrTOTPEnabled) {
+			http.Error(w, err.Error(), http.StatusConflict)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(TOTPEnrollment{
+		Secret:     base32NoPadding.EncodeToString(secret),
+		OTPAuthURI: otpauthURI(authConfig.TOTPIssuer, user.Email, secret),
+	})
+}
+
+// ConfirmTOTPEnrollment godoc
+// @Summary Turn on two-factor authentication
+// @Description Confirm the enrolment with a code of the authenticator app. The response has the recovery codes, shown only once, and new tokens of a session that passed two-factor authentication. Other sessions of the user are signed out.
+// @Tags two-factor
+// @Accept json
+// @Produce json
+// @Param id path int true "User ID"
+// @Param code body TOTPCodeRequest true "Code of the authenticator app"
+// @Success 200 {object} RecoveryCodes
+// @Failure 401 {string} string "Invalid code"
+// @Failure 403 {object} access.ErrorResponse "Not the caller's own user"
+// @Failure 409 {string} string "Already enabled or not started"
+// @Failure 429 {string} string "Too many failed attempts"
+// @Router /users/{id}/2fa/confirm [post]
+func ConfirmTOTPEnrollment(w http.ResponseWriter, r *http.Request) {
+	user, ok := totpUser(w, r)
+	if !ok {
+		return
+	}
+	var req TOTPCodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := validate.Struct(req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if user.TOTPEnabledAt != nil {
+		http.Error(w, ErrTOTPEnabled.Error(), http.StatusConflict)
+		return
+	}
+	if user.TOTPSecret == "" {
+		http.Error(w, ErrTOTPNotStarted.Error(), http.StatusConflict)
+		return
+	}
+
+	now := time.Now()
+	account := strings.ToLower(user.Email)
+	ip := clientIP(r)
+	if !allowAttempt(w, account, ip, now) {
+		return
+	}
+	secret, err := decryptTOTPSecret(authConfig.TOTPEncryptionKey, user.TOTPSecret)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	step, ok := matchTOTP(secret, req.Code, now)
+	if !ok {
+		failedAttempt(account, ip, now)
+		http.Error(w, ErrInvalidCode.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	codes, hashes, err := newRecoveryCodeSet()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	tokens, session, err := issueTokens(user, true, now)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := EnableTOTPRepo(user.ID, step, hashes, session, now); err != nil {
+		if errors.Is(err, ErrTOTPEnabled) {
+			http.Error(w, err.Error(), http.StatusConflict)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	log.Printf("two-factor authentication of user %d was enabled", user.ID)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(RecoveryCodes{RecoveryCodes: codes, Tokens: tokens})
+}
+
+// RegenerateRecoveryCodes godoc
+// @Summary Get new recovery codes
+// @Description Replace the recovery codes of the caller after checking a code of the authenticator app. The old recovery codes stop working.
+// @Tags two-factor
+// @Accept json
+// @Produce json
+// @Param id path int true "User ID"
+// @Param code body TOTPCodeRequest true "Code of the authenticator app"
+// @Success 200 {object} RecoveryCodes
+// @Failure 401 {string} string "Invalid code"
+// @Failure 403 {object} access.ErrorResponse "Not the caller's own user"
+// @Failure 409 {string} string "Two-factor authentication is not enabled"
+// @Failure 429 {string} string "Too many failed attempts"
+// @Router /users/{id}/2fa/recovery-codes [post]
+func RegenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	user, req, ok := totpCodeRequest(w, r)
+	if !ok {
+		return
+	}
+	now := time.Now()
+	if !checkTOTPCode(w, r, user, req.Code, now) {
+		return
+	}
+
+	codes, hashes, err := newRecoveryCodeSet()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := ReplaceRecoveryCodesRepo(user.ID, hashes, now); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(RecoveryCodes{RecoveryCodes: codes})
+}
+
+// DisableTOTP godoc
+// @Summary Turn off two-factor authentication
+// @Description Turn off two-factor authentication of the caller after checking a code of the authenticator app. Admins cannot turn it off, since it is mandatory for them.
+// @Tags two-factor
+// @Accept json
+// @Param id path int true "User ID"
+// @Param code body TOTPCodeRequest true "Code of the authenticator app"
+// @Success 204
+// @Failure 401 {string} string "Invalid code"
+// @Failure 403 {object} access.ErrorResponse "Not the caller's own user, or an admin"
+// @Failure 409 {string} string "Two-factor authentication is not enabled"
+// @Failure 429 {string} string "Too many failed attempts"
+// @Router /users/{id}/2fa/disable [post]
+func DisableTOTP(w http.ResponseWriter, r *http.Request) {
+	user, req, ok := totpCodeRequest(w, r)
+	if !ok {
+		return
+	}
+	if user.Role == access.RoleAdmin {
+		access.WriteForbidden(w, ErrTOTPRequired.Error())
+		return
+	}
+	if !checkTOTPCode(w, r, user, req.Code, time.Now()) {
+		return
+	}
+	if err := DisableTOTPRepo(user.ID); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	log.Printf("two-factor authentication of user %d was disabled", user.ID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ResetTOTP godoc
+// @Summary Reset two-factor authentication of a user
+// @Description Turn off two-factor authentication of a user who lost the authenticator and the recovery codes, and sign out all their sessions. Admins have to enrol again on their next sign in.
+// @Tags two-factor
+// @Param id path int true "User ID"
+// @Success 204
+// @Failure 403 {object} access.ErrorResponse "Only admins"
+// @Failure 404 {string} string "User not found"
+// @Router /users/{id}/2fa/reset [post]
+func ResetTOTP(w http.ResponseWriter, r *http.Request) {
+	if !access.RequireAdmin(w, r) {
+		return
+	}
+	params := mux.Vars(r)
+	id, err := strconv.Atoi(params["id"])
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+	if _, err := GetUserByIDRepo(uint(id)); err != nil {
+		if err == gorm.ErrRecordNotFound {
+			http.Error(w, "User not found", http.StatusNotFound)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	if err := ResetTOTPRepo(uint(id)); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	log.Printf("two-factor authentication of user %d was reset", id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// totpUser loads the user of the path, who must be the caller.
+func totpUser(w http.ResponseWriter, r *http.Request) (*User, bool) {
+	params := mux.Vars(r)
+	id, err := strconv.Atoi(params["id"])
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return nil, false
+	}
+	if !access.RequireSelf(w, r, uint(id)) {
+		return nil, false
+	}
+	user, err := GetUserByIDRepo(uint(id))
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			http.Error(w, "User not found", http.StatusNotFound)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return nil, false
+	}
+	return user, true
+}
+
+// totpCodeRequest loads the user of the path like totpUser and reads a code
+// from the body. The user must have two-factor authentication.
+func totpCodeRequest(w http.ResponseWriter, r *http.Request) (*User, *TOTPCodeRequest, bool) {
+	user, ok := totpUser(w, r)
+	if !ok {
+		return nil, nil, false
+	}
+	var req TOTPCodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil, nil, false
+	}
+	if err := validate.Struct(req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil, nil, false
+	}
+	if user.TOTPEnabledAt == nil {
+		http.Error(w, ErrTOTPNotEnabled.Error(), http.StatusConflict)
+		return nil, nil, false
+	}
+	return user, &req, true
+}
+
+// checkTOTPCode writes an error response unless code is a valid, unused code
+// of the user. Wrong codes count as failed logins of the account.
+func checkTOTPCode(w http.ResponseWriter, r *http.Request, user *User, code string, now time.Time) bool {
+	account := strings.ToLower(user.Email)
+	ip := clientIP(r)
+	if !allowAttempt(w, account, ip, now) {
+		return false
+	}
+	if err := verifyTOTP(user, code, now); err != nil {
+		if errors.Is(err, ErrInvalidCode) || errors.Is(err, ErrCodeAlreadyUsed) {
+			failedAttempt(account, ip, now)
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return false
+	}
+	return true
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"HL_online_shop/common/access"
 	_ "HL_online_shop/docs"
 	"github.com/gorilla/handlers"
 	"github.com/gorilla/mux"
@@ -22,15 +23,48 @@ import (
 
 func main() {
 	InitDB()
+	var err error
+	if err = access.LoadInternalToken(); err != nil {
+		log.Fatal("invalid internal token: ", err)
+	}
+	authConfig, err = loadAuthConfig()
+	if err != nil {
+		log.Fatal("invalid auth config: ", err)
+	}
+	accountLimiter = newRateLimiter(authConfig.LoginAccountLimit, authConfig.LoginWindow)
+	ipLimiter = newRateLimiter(authConfig.LoginIPLimit, authConfig.LoginWindow)
+	mailer, err = newMailer()
+	if err != nil {
+		log.Fatal("invalid mailer config: ", err)
+	}
+	go pruneRateLimiters(authConfig.LoginWindow, accountLimiter, ipLimiter, mailLimiter)
+	go expireRefreshTokens(time.Hour)
+	go expireUserTokens(time.Hour)
 
 	r := mux.NewRouter()
+	r.Use(access.RequireInternalToken)
 	r.HandleFunc("/health", HealthCheck).Methods("GET")
 	r.HandleFunc("/users", GetUsers).Methods("GET")
 	r.HandleFunc("/users", CreateUser).Methods("POST")
 	r.HandleFunc("/users/{id}", GetUser).Methods("GET")
 	r.HandleFunc("/users/{id}", UpdateUser).Methods("PUT")
 	r.HandleFunc("/users/{id}", DeleteUser).Methods("DELETE")
+	r.HandleFunc("/users/{id}/password", ChangePassword).Methods("POST")
+	r.HandleFunc("/users/{id}/2fa", StartTOTPEnrollment).Methods("POST")
+	r.HandleFunc("/users/{id}/2fa/confirm", ConfirmTOTPEnrollment).Methods("POST")
+	r.HandleFunc("/users/{id}/2fa/recovery-codes", RegenerateRecoveryCodes).Methods("POST")
+	r.HandleFunc("/users/{id}/2fa/disable", DisableTOTP).Methods("POST")
+	r.HandleFunc("/users/{id}/2fa/reset", ResetTOTP).Methods("POST")
 	r.HandleFunc("/search/users", SearchUsers).Methods("GET")
+	r.HandleFunc("/auth/register", Register).Methods("POST")
+	r.HandleFunc("/auth/login", Login).Methods("POST")
+	r.HandleFunc("/auth/login/mfa", LoginMFA).Methods("POST")
+	r.HandleFunc("/auth/refresh", RefreshTokens).Methods("POST")
+	r.HandleFunc("/auth/logout", Logout).Methods("POST")
+	r.HandleFunc("/auth/verify-email", VerifyEmail).Methods("POST")
+	r.HandleFunc("/auth/verify-email/resend", ResendVerification).Methods("POST")
+	r.HandleFunc("/auth/password-reset", ResetPassword).Methods("POST")
+	r.HandleFunc("/auth/password-reset/request", RequestPasswordReset).Methods("POST")
 	r.PathPrefix("/swagger/").Handler(httpSwagger.WrapHandler)
 
 	corsHandler := handlers.CORS(
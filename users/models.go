@@ -2,6 +2,7 @@ package main
 
 import (
 	"gorm.io/gorm"
+	"strings"
 	"time"
 )
 
@@ -10,14 +11,168 @@ type User struct {
 	Name           string    `json:"name" validate:"required" example:"John Doe"`
 	Email          string    `json:"email" validate:"required,email" example:"john.doe@example.com"`
 	Address        string    `json:"address" example:"123 Main St"`
+	Phone          string    `json:"phone" validate:"omitempty,numeric,min=10,max=15" example:"77771234567"`
 	RegistrationAt time.Time `json:"registrationAt" readonly:"true" example:"2023-07-20T15:04:05Z"`
 	Role           string    `json:"role" validate:"required,oneof=admin client" example:"client"`
+	// EmailVerifiedAt is when the user confirmed the email address, nil until
+	// then. Unverified users cannot place orders.
+	EmailVerifiedAt *time.Time `json:"email_verified_at" readonly:"true" example:"2023-07-20T15:10:00Z"`
+	// PasswordHash is the bcrypt hash of the password, empty for users who
+	// cannot sign in.
+	PasswordHash string `json:"-"`
+	// TOTPEnabledAt is when two-factor authentication was turned on, nil
+	// while it is off. It is mandatory for admins.
+	TOTPEnabledAt *time.Time `json:"totp_enabled_at" readonly:"true" example:"2023-07-20T15:20:00Z"`
+	// TOTPSecret is the encrypted TOTP secret, set once enrolment starts.
+	TOTPSecret string `json:"-"`
+	// TOTPLastStep is the time step of the last accepted code, so a code
+	// cannot be used twice.
+	TOTPLastStep int64 `json:"-"`
 }
 
 func (u *User) BeforeCreate(tx *gorm.DB) (err error) {
 	u.RegistrationAt = time.Now()
 	return
 }
+
+// BeforeSave stores the email address in the form it is looked up in.
+func (u *User) BeforeSave(tx *gorm.DB) (err error) {
+	u.Email = normalizeEmail(u.Email)
+	return
+}
+
+// normalizeEmail trims and lowercases an email address. Addresses are stored
+// this way, so one address cannot be registered twice in different case.
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
 func (User) TableName() string {
 	return "users_shop"
 }
+
+// RefreshToken is a refresh token issued at login. Only a SHA-256 hash of the
+// token is stored. A token is revoked when it is used, at logout and when the
+// password changes.
+type RefreshToken struct {
+	ID        uint   `gorm:"primaryKey"`
+	UserID    uint   `gorm:"index"`
+	TokenHash string `gorm:"uniqueIndex"`
+	ExpiresAt time.Time
+	RevokedAt *time.Time
+	CreatedAt time.Time
+	// MFA is set if the session was started with a second factor.
+	MFA bool
+}
+
+// Purposes of a UserToken.
+const (
+	TokenVerifyEmail   = "verify_email"
+	TokenResetPassword = "reset_password"
+	// TokenMFALogin links the two steps of a login with two-factor
+	// authentication. It is returned to the client instead of sent by email.
+	TokenMFALogin = "mfa_login"
+)
+
+// UserToken is a single-use token sent by email to verify the address or to
+// reset the password. Only a SHA-256 hash of the token is stored.
+type UserToken struct {
+	ID        uint   `gorm:"primaryKey"`
+	UserID    uint   `gorm:"index"`
+	Purpose   string `gorm:"size:32"`
+	TokenHash string `gorm:"uniqueIndex"`
+	// Email is the address a verification token was sent to. A token for an
+	// address the user has since changed does not verify the new one.
+	Email     string
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+	CreatedAt time.Time
+}
+
+// RecoveryCode is a one-time code that replaces a TOTP code when the
+// authenticator is lost. Only a SHA-256 hash of the code is stored.
+type RecoveryCode struct {
+	ID        uint   `gorm:"primaryKey"`
+	UserID    uint   `gorm:"index"`
+	CodeHash  string `gorm:"index"`
+	UsedAt    *time.Time
+	CreatedAt time.Time
+}
+
+type RegisterRequest struct {
+	Name     string `json:"name" validate:"required" example:"John Doe"`
+	Email    string `json:"email" validate:"required,email" example:"john.doe@example.com"`
+	Password string `json:"password" validate:"required,min=8,max=72" example:"correct horse battery"`
+	Address  string `json:"address" example:"123 Main St"`
+	Phone    string `json:"phone" validate:"omitempty,numeric,min=10,max=15" example:"77771234567"`
+}
+
+type LoginRequest struct {
+	Email    string `json:"email" validate:"required" example:"john.doe@example.com"`
+	Password string `json:"password" validate:"required" example:"correct horse battery"`
+}
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token" validate:"required" example:"b3BhcXVlLXJhbmRvbS10b2tlbg"`
+}
+
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password" validate:"required" example:"correct horse battery"`
+	NewPassword     string `json:"new_password" validate:"required,min=8,max=72" example:"staple battery horse"`
+}
+
+// TokenResponse is returned by login and refresh. The access token is sent as
+// a bearer token; the refresh token gets a new pair once the access token
+// expires. If the user has two-factor authentication, login returns only
+// MFAToken, which is exchanged for the tokens at /auth/login/mfa.
+type TokenResponse struct {
+	AccessToken  string `json:"access_token,omitempty" example:"eyJhbGciOiJIUzI1NiIsInR5cCI6IkpXVCJ9..."`
+	TokenType    string `json:"token_type,omitempty" example:"Bearer"`
+	ExpiresIn    int    `json:"expires_in,omitempty" example:"900"`
+	RefreshToken string `json:"refresh_token,omitempty" example:"b3BhcXVlLXJhbmRvbS10b2tlbg"`
+	MFARequired  bool   `json:"mfa_required,omitempty" example:"false"`
+	MFAToken     string `json:"mfa_token,omitempty" example:"bWZhLWxvZ2luLXRva2Vu"`
+	// MFAEnrollmentRequired is set for an admin without two-factor
+	// authentication, whose tokens only have the rights of a client until
+	// they enrol.
+	MFAEnrollmentRequired bool `json:"mfa_enrollment_required,omitempty" example:"false"`
+}
+
+type EmailRequest struct {
+	Email string `json:"email" validate:"required,email" example:"john.doe@example.com"`
+}
+
+type VerifyEmailRequest struct {
+	Token string `json:"token" validate:"required" example:"b3BhcXVlLXJhbmRvbS10b2tlbg"`
+}
+
+type ResetPasswordRequest struct {
+	Token       string `json:"token" validate:"required" example:"b3BhcXVlLXJhbmRvbS10b2tlbg"`
+	NewPassword string `json:"new_password" validate:"required,min=8,max=72" example:"staple battery horse"`
+}
+
+type MFALoginRequest struct {
+	MFAToken string `json:"mfa_token" validate:"required" example:"bWZhLWxvZ2luLXRva2Vu"`
+	// Code is the current code of the authenticator app. RecoveryCode can be
+	// given instead.
+	Code         string `json:"code" validate:"required_without=RecoveryCode" example:"123456"`
+	RecoveryCode string `json:"recovery_code" validate:"required_without=Code" example:"ABCDE-FGHIJ"`
+}
+
+type TOTPCodeRequest struct {
+	Code string `json:"code" validate:"required,numeric,len=6" example:"123456"`
+}
+
+// TOTPEnrollment is the secret to add to an authenticator app, as text and as
+// an otpauth URI for a QR code.
+type TOTPEnrollment struct {
+	Secret     string `json:"secret" example:"JBSWY3DPEHPK3PXPJBSWY3DPEHPK3PXP"`
+	OTPAuthURI string `json:"otpauth_uri" example:"otpauth://totp/HL%20Online%20Shop:john.doe@example.com?algorithm=SHA1&digits=6&issuer=HL+Online+Shop&period=30&secret=JBSWY3DPEHPK3PXPJBSWY3DPEHPK3PXP"`
+}
+
+// RecoveryCodes are shown once; each can be used once instead of a TOTP code.
+// Tokens are returned when two-factor authentication is turned on, for a
+// session that has passed it.
+type RecoveryCodes struct {
+	RecoveryCodes []string       `json:"recovery_codes" example:"ABCDE-FGHIJ,KLMNO-PQRST"`
+	Tokens        *TokenResponse `json:"tokens,omitempty"`
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rateLimiter counts events per key in a sliding window, e.g. failed logins
+// of an account.
+type rateLimiter struct {
+	mu     sync.Mutex
+	limit  int
+	window time.Duration
+	events map[string][]time.Time
+}
+
+func newRateLimiter(limit int, window time.Duration) *rateLimiter {
+	return &rateLimiter{limit: limit, window: window, events: make(map[string][]time.Time)}
+}
+
+// Счётчики хранятся в памяти, так что при нескольких экземплярах сервиса лимит действует в каждом отдельно
+var (
+	accountLimiter *rateLimiter
+	ipLimiter      *rateLimiter
+)
+
+// allow reports whether key is below the limit at now. If it is not, it also
+// returns how long until the oldest event leaves the window.
+func (l *rateLimiter) allow(key string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	events := l.recent(key, now)
+	if len(events) < l.limit {
+		return true, 0
+	}
+	return false, events[0].Add(l.window).Sub(now)
+}
+
+func (l *rateLimiter) add(key string, now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.events[key] = append(l.recent(key, now), now)
+}
+
+func (l *rateLimiter) reset(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.events, key)
+}
+
+// recent drops the events of key that left the window. l.mu must be held.
+func (l *rateLimiter) recent(key string, now time.Time) []time.Time {
+	events := l.events[key]
+	i := 0
+	for i < len(events) && !events[i].After(now.Add(-l.window)) {
+		i++
+	}
+	events = events[i:]
+	if len(events) == 0 {
+		delete(l.events, key)
+		return nil
+	}
+	l.events[key] = events
+	return events
+}
+
+// prune forgets the keys without events in the window.
+func (l *rateLimiter) prune(now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for key := range l.events {
+		l.recent(key, now)
+	}
+}
+
+// pruneRateLimiters periodically frees the memory of keys that are no longer
+// limited.
+func pruneRateLimiters(interval time.Duration, limiters ...*rateLimiter) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		for _, l := range limiters {
+			l.prune(time.Now())
+		}
+	}
+}
+
+// clientIP returns the address of the client. Requests come through the API
+// gateway, which puts the client's address in X-Forwarded-For.
+func clientIP(r *http.Request) string {
+	if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+		first, _, _ := strings.Cut(forwarded, ",")
+		return strings.TrimSpace(first)
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
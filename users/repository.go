@@ -1,16 +1,22 @@
 package main
 
 import (
+	"errors"
 	"github.com/joho/godotenv"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 	"gorm.io/gorm/logger"
 	"log"
 	"os"
+	"strings"
+	"time"
 )
 
 var db *gorm.DB
 
+// ErrEmailTaken is returned when another user has the email address.
+var ErrEmailTaken = errors.New("email already registered")
+
 func InitDB() {
 	if err := godotenv.Load(); err != nil {
 		log.Println("Error loading .env file")
@@ -18,12 +24,29 @@ func InitDB() {
 	url := os.Getenv("DATABASE_URL")
 	dsn := url
 	var err error
-	db, err = gorm.Open(postgres.Open(dsn), &gorm.Config{Logger: logger.Default.LogMode(logger.Info)})
+	// TranslateError turns unique violations into gorm.ErrDuplicatedKey
+	db, err = gorm.Open(postgres.Open(dsn), &gorm.Config{TranslateError: true, Logger: logger.New(
+		log.New(os.Stdout, "\r\n", log.LstdFlags),
+		logger.Config{
+			SlowThreshold: 200 * time.Millisecond,
+			LogLevel:      logger.Warn,
+			// Значения параметров не попадают в лог
+			ParameterizedQueries:      true,
+			IgnoreRecordNotFoundError: true,
+			Colorful:                  true,
+		},
+	)})
 	if err != nil {
 		log.Fatal("failed to connect to the database:", err)
 	}
 
 	db.Table("users_shop").AutoMigrate(&User{})
+	db.AutoMigrate(&RefreshToken{}, &UserToken{}, &RecoveryCode{})
+	// Адреса хранятся в нижнем регистре, индекс не даёт зарегистрировать один адрес дважды
+	db.Exec("UPDATE users_shop SET email = LOWER(TRIM(email)) WHERE email <> LOWER(TRIM(email))")
+	if err := db.Exec("CREATE UNIQUE INDEX IF NOT EXISTS idx_users_shop_email ON users_shop (LOWER(email))").Error; err != nil {
+		log.Fatal("failed to add the unique email index, users with the same email must be merged first:", err)
+	}
 
 }
 
@@ -41,12 +64,23 @@ func GetUserByIDRepo(id uint) (*User, error) {
 
 func CreateUserRepo(user *User) error {
 	result := db.Create(user)
-	return result.Error
+	return emailTaken(result.Error)
 }
 
+// UpdateUserRepo saves the profile of a user. The password, the email
+// verification and two-factor authentication are only changed by their own
+// functions.
 func UpdateUserRepo(user *User) error {
-	result := db.Save(user)
-	return result.Error
+	result := db.Omit("PasswordHash", "EmailVerifiedAt", "TOTPEnabledAt", "TOTPSecret", "TOTPLastStep").Save(user)
+	return emailTaken(result.Error)
+}
+
+// emailTaken maps a violation of the unique email index to ErrEmailTaken.
+func emailTaken(err error) error {
+	if errors.Is(err, gorm.ErrDuplicatedKey) {
+		return ErrEmailTaken
+	}
+	return err
 }
 
 func DeleteUserRepo(id uint) error {
@@ -69,3 +103,301 @@ func SearchUsersRepo(name, email string) ([]User, error) {
 	result := query.Find(&users)
 	return users, result.Error
 }
+
+// GetUserByEmailRepo finds a user by email, ignoring case and surrounding
+// spaces.
+func GetUserByEmailRepo(email string) (*User, error) {
+	var user User
+	result := db.Where("LOWER(email) = ?", normalizeEmail(email)).First(&user)
+	return &user, result.Error
+}
+
+// SetPasswordRepo stores a new password hash and revokes every refresh token
+// of the user, so other sessions have to sign in again.
+func SetPasswordRepo(userID uint, passwordHash string) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&User{}).Where("id = ?", userID).Update("password_hash", passwordHash).Error; err != nil {
+			return err
+		}
+		return revokeRefreshTokens(tx, userID)
+	})
+}
+
+func CreateRefreshTokenRepo(token *RefreshToken) error {
+	return db.Create(token).Error
+}
+
+func GetRefreshTokenRepo(tokenHash string) (*RefreshToken, error) {
+	var token RefreshToken
+	result := db.Where("token_hash = ?", tokenHash).First(&token)
+	return &token, result.Error
+}
+
+// RotateRefreshTokenRepo revokes a refresh token and stores the one replacing
+// it. It fails with ErrInvalidRefresh if the token was revoked or expired in
+// the meantime, e.g. by a concurrent refresh.
+func RotateRefreshTokenRepo(id uint, replacement *RefreshToken) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		result := tx.Model(&RefreshToken{}).
+			Where("id = ? AND revoked_at IS NULL AND expires_at > ?", id, now).
+			Update("revoked_at", now)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrInvalidRefresh
+		}
+		return tx.Create(replacement).Error
+	})
+}
+
+func RevokeRefreshTokenRepo(tokenHash string) error {
+	return db.Model(&RefreshToken{}).
+		Where("token_hash = ? AND revoked_at IS NULL", tokenHash).
+		Update("revoked_at", time.Now()).Error
+}
+
+func RevokeUserRefreshTokensRepo(userID uint) error {
+	return revokeRefreshTokens(db, userID)
+}
+
+func revokeRefreshTokens(tx *gorm.DB, userID uint) error {
+	return tx.Model(&RefreshToken{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Update("revoked_at", time.Now()).Error
+}
+
+// DeleteExpiredRefreshTokensRepo removes refresh tokens that expired before
+// the given time.
+func DeleteExpiredRefreshTokensRepo(before time.Time) error {
+	return db.Where("expires_at < ?", before).Delete(&RefreshToken{}).Error
+}
+
+// ClearEmailVerifiedRepo marks the email address of a user as unverified,
+// e.g. after it changed.
+func ClearEmailVerifiedRepo(userID uint) error {
+	return db.Model(&User{}).Where("id = ?", userID).Update("email_verified_at", nil).Error
+}
+
+// CreateUserTokenRepo stores an email token. Unused tokens of the user for the
+// same purpose stop working, so only the latest email is valid.
+func CreateUserTokenRepo(token *UserToken) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&UserToken{}).
+			Where("user_id = ? AND purpose = ? AND used_at IS NULL", token.UserID, token.Purpose).
+			Update("used_at", token.CreatedAt).Error; err != nil {
+			return err
+		}
+		return tx.Create(token).Error
+	})
+}
+
+// GetUserTokenRepo finds an unused token that has not expired, without using
+// it. It fails with ErrInvalidUserToken otherwise.
+func GetUserTokenRepo(tokenHash, purpose string, now time.Time) (*UserToken, error) {
+	var token UserToken
+	err := db.Where("token_hash = ? AND purpose = ? AND used_at IS NULL AND expires_at > ?", tokenHash, purpose, now).
+		First(&token).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, ErrInvalidUserToken
+	}
+	return &token, err
+}
+
+func ConsumeUserTokenRepo(tokenHash, purpose string, now time.Time) (*UserToken, error) {
+	return consumeUserToken(db, tokenHash, purpose, now)
+}
+
+// consumeUserToken marks a token as used. It fails with ErrInvalidUserToken
+// if there is no such token, or it was used or expired.
+func consumeUserToken(tx *gorm.DB, tokenHash, purpose string, now time.Time) (*UserToken, error) {
+	var token UserToken
+	if err := tx.Where("token_hash = ? AND purpose = ?", tokenHash, purpose).First(&token).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, ErrInvalidUserToken
+		}
+		return nil, err
+	}
+	result := tx.Model(&UserToken{}).
+		Where("id = ? AND used_at IS NULL AND expires_at > ?", token.ID, now).
+		Update("used_at", now)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, ErrInvalidUserToken
+	}
+	return &token, nil
+}
+
+// markEmailVerified verifies the address of a user if it is still the one the
+// token was sent to.
+func markEmailVerified(tx *gorm.DB, token *UserToken, now time.Time) error {
+	return tx.Model(&User{}).
+		Where("id = ? AND LOWER(email) = LOWER(?) AND email_verified_at IS NULL", token.UserID, token.Email).
+		Update("email_verified_at", now).Error
+}
+
+// VerifyEmailRepo uses a verification token and marks the address it was sent
+// to as verified.
+func VerifyEmailRepo(tokenHash string, now time.Time) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		token, err := consumeUserToken(tx, tokenHash, TokenVerifyEmail, now)
+		if err != nil {
+			return err
+		}
+		var user User
+		if err := tx.First(&user, token.UserID).Error; err != nil {
+			return err
+		}
+		if !strings.EqualFold(user.Email, token.Email) {
+			return ErrInvalidUserToken
+		}
+		return markEmailVerified(tx, token, now)
+	})
+}
+
+// ResetPasswordRepo uses a password reset token to set a new password hash,
+// revokes every refresh token of the user and, since the user got the email,
+// verifies the address. It returns the ID of the user.
+func ResetPasswordRepo(tokenHash, passwordHash string, now time.Time) (uint, error) {
+	var userID uint
+	err := db.Transaction(func(tx *gorm.DB) error {
+		token, err := consumeUserToken(tx, tokenHash, TokenResetPassword, now)
+		if err != nil {
+			return err
+		}
+		userID = token.UserID
+		if err := tx.Model(&User{}).Where("id = ?", token.UserID).Update("password_hash", passwordHash).Error; err != nil {
+			return err
+		}
+		if err := revokeRefreshTokens(tx, token.UserID); err != nil {
+			return err
+		}
+		return markEmailVerified(tx, token, now)
+	})
+	return userID, err
+}
+
+// DeleteExpiredUserTokensRepo removes email tokens that expired before the
+// given time.
+func DeleteExpiredUserTokensRepo(before time.Time) error {
+	return db.Where("expires_at < ?", before).Delete(&UserToken{}).Error
+}
+
+// SetTOTPSecretRepo stores the secret of a started enrolment. It fails with
+// ErrTOTPEnabled if two-factor authentication is already on.
+func SetTOTPSecretRepo(userID uint, sealedSecret string) error {
+	result := db.Model(&User{}).
+		Where("id = ? AND totp_enabled_at IS NULL", userID).
+		Updates(map[string]interface{}{"totp_secret": sealedSecret, "totp_last_step": 0})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrTOTPEnabled
+	}
+	return nil
+}
+
+// EnableTOTPRepo turns on two-factor authentication once the first code was
+// checked, stores the recovery codes and replaces the other sessions of the
+// user, which did not pass it, with session.
+func EnableTOTPRepo(userID uint, step int64, codeHashes []string, session *RefreshToken, now time.Time) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&User{}).
+			Where("id = ? AND totp_enabled_at IS NULL AND totp_secret <> ''", userID).
+			Updates(map[string]interface{}{"totp_enabled_at": now, "totp_last_step": step})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrTOTPEnabled
+		}
+		if err := replaceRecoveryCodes(tx, userID, codeHashes, now); err != nil {
+			return err
+		}
+		if err := revokeRefreshTokens(tx, userID); err != nil {
+			return err
+		}
+		return tx.Create(session).Error
+	})
+}
+
+// UseTOTPStepRepo records that the code of a time step was used. It fails
+// with ErrCodeAlreadyUsed if a code of this or a later step was used before.
+func UseTOTPStepRepo(userID uint, step int64) error {
+	result := db.Model(&User{}).
+		Where("id = ? AND totp_last_step < ?", userID, step).
+		Update("totp_last_step", step)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrCodeAlreadyUsed
+	}
+	return nil
+}
+
+// UseRecoveryCodeRepo marks a recovery code of the user as used. It fails
+// with ErrInvalidCode if the user has no such unused code.
+func UseRecoveryCodeRepo(userID uint, codeHash string, now time.Time) error {
+	result := db.Model(&RecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", userID, codeHash).
+		Update("used_at", now)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrInvalidCode
+	}
+	return nil
+}
+
+// ReplaceRecoveryCodesRepo stores new recovery codes of a user; the old ones
+// stop working.
+func ReplaceRecoveryCodesRepo(userID uint, codeHashes []string, now time.Time) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		return replaceRecoveryCodes(tx, userID, codeHashes, now)
+	})
+}
+
+func replaceRecoveryCodes(tx *gorm.DB, userID uint, codeHashes []string, now time.Time) error {
+	if err := tx.Where("user_id = ?", userID).Delete(&RecoveryCode{}).Error; err != nil {
+		return err
+	}
+	codes := make([]RecoveryCode, len(codeHashes))
+	for i, hash := range codeHashes {
+		codes[i] = RecoveryCode{UserID: userID, CodeHash: hash, CreatedAt: now}
+	}
+	return tx.Create(&codes).Error
+}
+
+// DisableTOTPRepo turns off two-factor authentication of a user and deletes
+// the secret and the recovery codes.
+func DisableTOTPRepo(userID uint) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		return clearTOTP(tx, userID)
+	})
+}
+
+// ResetTOTPRepo turns off two-factor authentication like DisableTOTPRepo and
+// also revokes every refresh token of the user, for when the authenticator
+// was lost or the account may be compromised.
+func ResetTOTPRepo(userID uint) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := clearTOTP(tx, userID); err != nil {
+			return err
+		}
+		return revokeRefreshTokens(tx, userID)
+	})
+}
+
+func clearTOTP(tx *gorm.DB, userID uint) error {
+	if err := tx.Model(&User{}).Where("id = ?", userID).
+		Updates(map[string]interface{}{"totp_enabled_at": nil, "totp_secret": "", "totp_last_step": 0}).Error; err != nil {
+		return err
+	}
+	return tx.Where("user_id = ?", userID).Delete(&RecoveryCode{}).Error
+}
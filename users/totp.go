@@ -0,0 +1,199 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// TOTP parameters of RFC 6238 that authenticator apps use by default.
+const (
+	totpDigits = 6
+	totpPeriod = 30 * time.Second
+	// totpSkew is how many periods a code may be early or late, for clock
+	// drift and slow typing.
+	totpSkew = 1
+
+	recoveryCodeCount = 10
+	// mfaTokenTTL is how long the second login step may take.
+	mfaTokenTTL = 5 * time.Minute
+)
+
+var (
+	ErrInvalidCode     = errors.New("invalid two-factor code")
+	ErrTOTPNotEnabled  = errors.New("two-factor authentication is not enabled")
+	ErrTOTPEnabled     = errors.New("two-factor authentication is already enabled")
+	ErrTOTPNotStarted  = errors.New("two-factor enrolment has not been started")
+	ErrTOTPRequired    = errors.New("two-factor authentication is mandatory for admins")
+	ErrCodeAlreadyUsed = errors.New("two-factor code was already used")
+)
+
+var base32NoPadding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// newTOTPSecret returns a random 160-bit secret, the size RFC 4226 recommends.
+func newTOTPSecret() ([]byte, error) {
+	secret := make([]byte, 20)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+	return secret, nil
+}
+
+// totpCode computes the code of a time step as in RFC 4226 section 5.3.
+func totpCode(secret []byte, step int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, value%1000000)
+}
+
+func totpStep(t time.Time) int64 {
+	return t.Unix() / int64(totpPeriod/time.Second)
+}
+
+// matchTOTP returns the time step a code is valid for at now, or false if it
+// matches none of the steps within the allowed skew.
+func matchTOTP(secret []byte, code string, now time.Time) (int64, bool) {
+	code = strings.TrimSpace(code)
+	if len(code) != totpDigits {
+		return 0, false
+	}
+	current := totpStep(now)
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if subtle.ConstantTimeCompare([]byte(totpCode(secret, step)), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// otpauthURI returns the key URI authenticator apps read from a QR code.
+func otpauthURI(issuer, account string, secret []byte) string {
+	label := url.PathEscape(issuer) + ":" + url.PathEscape(account)
+	query := url.Values{}
+	query.Set("secret", base32NoPadding.EncodeToString(secret))
+	query.Set("issuer", issuer)
+	query.Set("algorithm", "SHA1")
+	query.Set("digits", fmt.Sprint(totpDigits))
+	query.Set("period", fmt.Sprint(int(totpPeriod.Seconds())))
+	return "otpauth://totp/" + label + "?" + query.Encode()
+}
+
+// encryptTOTPSecret seals a secret with AES-GCM, so a copy of the database
+// alone is not enough to generate codes.
+func encryptTOTPSecret(key, secret []byte) (string, error) {
+	gcm, err := totpCipher(key)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(gcm.Seal(nonce, nonce, secret, nil)), nil
+}
+
+func decryptTOTPSecret(key []byte, sealed string) ([]byte, error) {
+	gcm, err := totpCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	data, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < gcm.NonceSize() {
+		return nil, errors.New("sealed TOTP secret is too short")
+	}
+	return gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], nil)
+}
+
+func totpCipher(key []byte) (cipher.AEAD, error) {
+	// Ключ любой длины приводится к 256 битам
+	sum := sha256.Sum256(key)
+	block, err := aes.NewCipher(sum[:])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// newRecoveryCodes returns random one-time codes of 10 base32 characters
+// (50 bits), formatted as XXXXX-XXXXX.
+func newRecoveryCodes() ([]string, error) {
+	codes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		b := make([]byte, 10)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		code := base32NoPadding.EncodeToString(b)[:10]
+		codes[i] = code[:5] + "-" + code[5:]
+	}
+	return codes, nil
+}
+
+// hashRecoveryCode hashes a code the way it was printed or typed, ignoring
+// case, spaces and dashes.
+func hashRecoveryCode(code string) string {
+	normalized := strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(code))
+	return hashToken(normalized)
+}
+
+// verifyTOTP checks a code of the authenticator app of the user and records
+// its time step, so the code cannot be used again.
+func verifyTOTP(user *User, code string, now time.Time) error {
+	if user.TOTPSecret == "" {
+		return ErrTOTPNotStarted
+	}
+	secret, err := decryptTOTPSecret(authConfig.TOTPEncryptionKey, user.TOTPSecret)
+	if err != nil {
+		return err
+	}
+	step, ok := matchTOTP(secret, code, now)
+	if !ok {
+		return ErrInvalidCode
+	}
+	return UseTOTPStepRepo(user.ID, step)
+}
+
+// verifySecondFactor checks a TOTP code, or the recovery code if no code is
+// given, and uses it up.
+func verifySecondFactor(user *User, code, recoveryCode string, now time.Time) error {
+	if user.TOTPEnabledAt == nil {
+		return ErrTOTPNotEnabled
+	}
+	if code == "" {
+		return UseRecoveryCodeRepo(user.ID, hashRecoveryCode(recoveryCode), now)
+	}
+	return verifyTOTP(user, code, now)
+}
+
+// newRecoveryCodeSet returns new recovery codes and their hashes to store.
+func newRecoveryCodeSet() ([]string, []string, error) {
+	codes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, nil, err
+	}
+	hashes := make([]string, len(codes))
+	for i, code := range codes {
+		hashes[i] = hashRecoveryCode(code)
+	}
+	return codes, hashes, nil
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+// The secret and times of the SHA-1 test vectors in RFC 6238 appendix B. The
+// RFC lists 8-digit codes; these are their last 6 digits.
+var rfc6238Secret = []byte("12345678901234567890")
+
+func TestTOTPCodeRFC6238(t *testing.T) {
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, tt := range tests {
+		if got := totpCode(rfc6238Secret, totpStep(time.Unix(tt.unix, 0))); got != tt.code {
+			t.Errorf("code at %d = %s, want %s", tt.unix, got, tt.code)
+		}
+	}
+}
+
+func TestMatchTOTPWindow(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	current := totpStep(now)
+	tests := []struct {
+		name string
+		code string
+		step int64
+		ok   bool
+	}{
+		{"current step", totpCode(rfc6238Secret, current), current, true},
+		{"previous step", totpCode(rfc6238Secret, current-1), current - 1, true},
+		{"next step", totpCode(rfc6238Secret, current+1), current + 1, true},
+		{"two steps back", totpCode(rfc6238Secret, current-2), 0, false},
+		{"two steps ahead", totpCode(rfc6238Secret, current+2), 0, false},
+		{"surrounding spaces", " " + totpCode(rfc6238Secret, current) + " ", current, true},
+		{"8 digits", "07081804", 0, false},
+		{"5 digits", "50471", 0, false},
+		{"empty", "", 0, false},
+		{"wrong code", "000000", 0, false},
+	}
+	for _, tt := range tests {
+		step, ok := matchTOTP(rfc6238Secret, tt.code, now)
+		if ok != tt.ok || step != tt.step {
+			t.Errorf("%s: got step %d, %v, want %d, %v", tt.name, step, ok, tt.step, tt.ok)
+		}
+	}
+}
+
+func TestTOTPSecretEncryption(t *testing.T) {
+	key := []byte("0123456789abcdef0123456789abcdef")
+	sealed, err := encryptTOTPSecret(key, rfc6238Secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(sealed, string(rfc6238Secret)) {
+		t.Fatal("secret is stored in the clear")
+	}
+	secret, err := decryptTOTPSecret(key, sealed)
+	if err != nil || string(secret) != string(rfc6238Secret) {
+		t.Fatalf("got %q, %v", secret, err)
+	}
+	if _, err := decryptTOTPSecret([]byte("another key of 32 bytes or more.."), sealed); err == nil {
+		t.Error("decrypted with another key")
+	}
+}
+
+func TestTOTPReplayGuard(t *testing.T) {
+	store := useFakeDB(t)
+	user := enrolledUser(t, 7)
+	store.lastStep[7] = 0
+
+	now := time.Unix(1111111111, 0)
+	current := totpStep(now)
+	code := totpCode(rfc6238Secret, current)
+	steps := []struct {
+		name string
+		code string
+		at   time.Time
+		want error
+	}{
+		{"first use", code, now, nil},
+		{"same code again", code, now, ErrCodeAlreadyUsed},
+		{"same code in the next step", code, now.Add(totpPeriod), ErrCodeAlreadyUsed},
+		{"code of the previous step", totpCode(rfc6238Secret, current-1), now, ErrCodeAlreadyUsed},
+		{"wrong code", "000000", now, ErrInvalidCode},
+		{"code of the next step", totpCode(rfc6238Secret, current+1), now, nil},
+		{"code of the next step again", totpCode(rfc6238Secret, current+1), now.Add(totpPeriod), ErrCodeAlreadyUsed},
+		{"later code", totpCode(rfc6238Secret, current+10), now.Add(10 * totpPeriod), nil},
+	}
+	for _, step := range steps {
+		if err := verifySecondFactor(user, step.code, "", step.at); !errors.Is(err, step.want) {
+			t.Fatalf("%s: got %v, want %v", step.name, err, step.want)
+		}
+	}
+	if store.lastStep[7] != current+10 {
+		t.Errorf("last step %d, want %d", store.lastStep[7], current+10)
+	}
+}
+
+func TestRecoveryCodeOneTimeUse(t *testing.T) {
+	store := useFakeDB(t)
+	user := enrolledUser(t, 7)
+	codes, hashes, err := newRecoveryCodeSet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(codes) != recoveryCodeCount {
+		t.Fatalf("got %d codes, want %d", len(codes), recoveryCodeCount)
+	}
+	for _, hash := range hashes {
+		store.recoveryCodes[recoveryKey(7, hash)] = false
+	}
+	otherCodes, otherHashes, err := newRecoveryCodeSet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.recoveryCodes[recoveryKey(8, otherHashes[0])] = false
+
+	now := time.Now()
+	steps := []struct {
+		name string
+		code string
+		want error
+	}{
+		{"first use", codes[0], nil},
+		{"same code again", codes[0], ErrInvalidCode},
+		{"typed in lower case without the dash", strings.ToLower(strings.Replace(codes[1], "-", "", 1)), nil},
+		{"typed with spaces", " " + strings.Replace(codes[2], "-", " ", 1), nil},
+		{"code of another user", otherCodes[0], ErrInvalidCode},
+		{"unknown code", "AAAAA-AAAAA", ErrInvalidCode},
+		{"empty", "", ErrInvalidCode},
+	}
+	for _, step := range steps {
+		if err := verifySecondFactor(user, "", step.code, now); !errors.Is(err, step.want) {
+			t.Fatalf("%s: got %v, want %v", step.name, err, step.want)
+		}
+	}
+	used := 0
+	for _, isUsed := range store.recoveryCodes {
+		if isUsed {
+			used++
+		}
+	}
+	if used != 3 {
+		t.Errorf("%d codes used, want 3", used)
+	}
+}
+
+func TestSecondFactorNotEnabled(t *testing.T) {
+	user := enrolledUser(t, 7)
+	user.TOTPEnabledAt = nil
+	if err := verifySecondFactor(user, "287082", "", time.Unix(59, 0)); !errors.Is(err, ErrTOTPNotEnabled) {
+		t.Errorf("got %v, want ErrTOTPNotEnabled", err)
+	}
+}
+
+// enrolledUser returns a user with two-factor authentication on and the
+// RFC 6238 secret, encrypted with a test key.
+func enrolledUser(t *testing.T, id uint) *User {
+	t.Helper()
+	saved := authConfig
+	t.Cleanup(func() { authConfig = saved })
+	authConfig = &AuthConfig{TOTPEncryptionKey: []byte("0123456789abcdef0123456789abcdef")}
+
+	sealed, err := encryptTOTPSecret(authConfig.TOTPEncryptionKey, rfc6238Secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	enabledAt := time.Unix(0, 0)
+	return &User{ID: id, TOTPSecret: sealed, TOTPEnabledAt: &enabledAt}
+}
+
+// fakeStore stands in for the two tables the one-time checks update. It only
+// understands the UPDATE statements of UseTOTPStepRepo and
+// UseRecoveryCodeRepo, and reports the rows they change like PostgreSQL.
+type fakeStore struct {
+	mu sync.Mutex
+	// lastStep is totp_last_step by user ID.
+	lastStep map[int64]int64
+	// recoveryCodes tells by user ID and code hash whether a code is used.
+	recoveryCodes map[string]bool
+}
+
+func recoveryKey(userID int64, hash string) string {
+	return fmt.Sprintf("%d/%s", userID, hash)
+}
+
+func (s *fakeStore) exec(query string, args []driver.NamedValue) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch query {
+	case `UPDATE "users_shop" SET "totp_last_step"=$1 WHERE id = $2 AND totp_last_step < $3`:
+		id, step := args[1].Value.(int64), args[2].Value.(int64)
+		last, ok := s.lastStep[id]
+		if !ok || last >= step {
+			return 0, nil
+		}
+		s.lastStep[id] = args[0].Value.(int64)
+		return 1, nil
+	case `UPDATE "recovery_codes" SET "used_at"=$1 WHERE user_id = $2 AND code_hash = $3 AND used_at IS NULL`:
+		key := recoveryKey(args[1].Value.(int64), args[2].Value.(string))
+		used, ok := s.recoveryCodes[key]
+		if !ok || used {
+			return 0, nil
+		}
+		s.recoveryCodes[key] = true
+		return 1, nil
+	}
+	return 0, fmt.Errorf("fake database: unexpected query %s", query)
+}
+
+// useFakeDB points db at a fakeStore for the duration of a test.
+func useFakeDB(t *testing.T) *fakeStore {
+	t.Helper()
+	store := &fakeStore{lastStep: map[int64]int64{}, recoveryCodes: map[string]bool{}}
+	fake, err := gorm.Open(postgres.New(postgres.Config{Conn: sql.OpenDB(fakeConnector{store})}), &gorm.Config{
+		DisableAutomaticPing:   true,
+		SkipDefaultTransaction: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := db
+	t.Cleanup(func() { db = saved })
+	db = fake
+	return store
+}
+
+type fakeConnector struct{ store *fakeStore }
+
+func (c fakeConnector) Connect(context.Context) (driver.Conn, error) { return fakeConn(c), nil }
+func (c fakeConnector) Driver() driver.Driver                        { return nil }
+
+type fakeConn struct{ store *fakeStore }
+
+func (c fakeConn) ExecContext(_ context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	rows, err := c.store.exec(query, args)
+	if err != nil {
+		return nil, err
+	}
+	return driver.RowsAffected(rows), nil
+}
+
+func (c fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return nil, fmt.Errorf("fake database: unexpected query %s", query)
+}
+func (c fakeConn) Close() error { return nil }
+func (c fakeConn) Begin() (driver.Tx, error) {
+	return nil, errors.New("fake database: no transactions")
+}